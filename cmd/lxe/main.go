@@ -1,18 +1,26 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/automaticserver/lxe/cri"
 	"github.com/automaticserver/lxe/network"
 	"github.com/automaticserver/lxe/shared"
+	"github.com/ghodss/yaml"
 	"github.com/lxc/lxd/shared/logger"
 	"github.com/lxc/lxd/shared/logging"
 	"github.com/spf13/cobra"
 )
 
+var errInvalidConfigFile = errors.New("invalid config file")
+
 // Initialize the random number generator
 func init() {
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -25,11 +33,20 @@ type cmdGlobal struct {
 	flagLogSyslog  bool
 	flagLogVerbose bool
 	flagLogFile    string
+	flagConfig     string
 
 	cri cri.Config
 }
 
 func (c *cmdGlobal) Run(cmd *cobra.Command, args []string) error {
+	// Apply the config file before anything else uses the flags
+	if c.flagConfig != "" {
+		err := loadConfigFile(cmd, c.flagConfig)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Setup logger
 	syslog := ""
 	if c.flagLogSyslog {
@@ -48,52 +65,220 @@ func (c *cmdGlobal) Run(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func main() {
-	// daemon command (main)
-	daemonCmd := cmdDaemon{}
-	app := daemonCmd.Command()
-
-	// Workaround for main command
-	app.Args = cobra.ArbitraryArgs
-	app.Version = cri.Version
+// addFlags registers all flags of lxe on the command
+func (c *cmdGlobal) addFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
 
-	// Global flags
-	globalCmd := cmdGlobal{}
-	daemonCmd.global = &globalCmd
-	app.PersistentPreRunE = globalCmd.Run
-	app.PersistentFlags().BoolVar(&globalCmd.flagVersion, "version", false, "Print version number.")
-	app.PersistentFlags().BoolVarP(&globalCmd.flagHelp, "help", "h", false, "Print help.")
-	app.PersistentFlags().StringVar(&globalCmd.flagLogFile, "logfile", "/var/log/lxe.log", "Path to the log file."+"``")
-	app.PersistentFlags().BoolVarP(&globalCmd.flagLogDebug, "debug", "d", false, "Show all debug messages.")
-	app.PersistentFlags().BoolVarP(&globalCmd.flagLogVerbose, "verbose", "v", false, "Show all information messages.")
+	flags.BoolVar(&c.flagVersion, "version", false, "Print version number.")
+	flags.BoolVarP(&c.flagHelp, "help", "h", false, "Print help.")
+	flags.StringVar(&c.flagLogFile, "logfile", "/var/log/lxe.log", "Path to the log file."+"``")
+	flags.BoolVarP(&c.flagLogDebug, "debug", "d", false, "Show all debug messages.")
+	flags.BoolVarP(&c.flagLogVerbose, "verbose", "v", false, "Show all information messages.")
+	flags.StringVar(&c.flagConfig, "config", "", "Path to a yaml file with flag names as keys, e.g. 'network-retries: 5'. Flags given on the command line take precedence. It's reloaded on SIGHUP. (none by default)")
 
 	// lxd / lxe specific flags
-	app.PersistentFlags().StringVar(&globalCmd.cri.UnixSocket, "socket",
+	flags.StringVar(&c.cri.UnixSocket, "socket",
 		"/var/run/lxe.sock", "The unix socket under which LXE will expose its service to Kubernetes.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXDSocket, "lxd-socket",
+	flags.StringVar(&c.cri.LXDSocket, "lxd-socket",
 		"/var/lib/lxd/unix.socket", "LXD's unix socket.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXDRemoteConfig, "lxd-remote-config",
+	flags.StringVar(&c.cri.LXDRemoteConfig, "lxd-remote-config",
 		"", "Path to the LXD remote config. (guessed by default)")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXDImageRemote, "lxd-image-remote",
+	flags.StringVar(&c.cri.LXDImageRemote, "lxd-image-remote",
 		"local", "Use this remote when ImageSpec doesn't provide an explicit remote.")
-	app.PersistentFlags().StringSliceVar(&globalCmd.cri.LXDProfiles, "lxd-profiles",
+	flags.StringSliceVar(&c.cri.LXDProfiles, "lxd-profiles",
 		[]string{"default"}, "Set these additional profiles when creating containers.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXEStreamingServerEndpoint, "streaming-endpoint",
+	flags.StringVar(&c.cri.LXDStoragePool, "lxd-storage-pool",
+		"", "Storage pool of readonly root disks. (guessed by default)")
+	flags.DurationVar(&c.cri.LXDOperationTimeout, "lxd-operation-timeout",
+		2*time.Minute, "Fail quick lxd operations like creating, starting or updating containers if they don't complete within this duration. Stopping gets the grace period in addition. (0 waits without limit)")
+	flags.DurationVar(&c.cri.LXDLongOperationTimeout, "lxd-long-operation-timeout",
+		15*time.Minute, "Fail long lxd operations like pulling images or creating checkpoints if they don't complete within this duration. (0 waits without limit)")
+	flags.StringVar(&c.cri.LXEStreamingServerEndpoint, "streaming-endpoint",
 		"", "IP or Interface for Streaming Server. (guessed by default)")
-	app.PersistentFlags().IntVar(&globalCmd.cri.LXEStreamingPort, "streaming-port",
+	flags.IntVar(&c.cri.LXEStreamingPort, "streaming-port",
 		44124, "Port where LXE's Streaming HTTP Server will listen.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXEHostnetworkFile, "hostnetwork-file",
+	flags.DurationVar(&c.cri.LXEStreamingIdleTimeout, "streaming-idle-timeout",
+		4*time.Hour, "Close exec, attach and port-forward connections of the Streaming HTTP Server after being idle this long.")
+	flags.DurationVar(&c.cri.LXEStreamingCreationTimeout, "streaming-creation-timeout",
+		30*time.Second, "Time clients have to create their streams after connecting to the Streaming HTTP Server.")
+	flags.StringVar(&c.cri.LXEHostnetworkFile, "hostnetwork-file",
 		"/var/lib/lxe/hostnetwork.conf", "Path to the hostnetwork file for lxc raw include")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXENetworkPlugin, "network-plugin",
+	flags.StringVar(&c.cri.LXENetworkPlugin, "network-plugin",
 		"", "The network plugin to use. '' is the standard network plugin and manages a lxd bridge 'lxebr0'. 'cni' uses kubernetes cni tools to attach interfaces.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXEBridgeName, "bridge-name",
+	flags.StringVar(&c.cri.LXEBridgeName, "bridge-name",
 		network.DefaultLXDBridge, "When using network-plugin '', which bridge to create and use.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.LXEBridgeDHCPRange, "bridge-dhcp-range",
+	flags.StringVar(&c.cri.LXEBridgeDHCPRange, "bridge-dhcp-range",
 		"", "When using network-plugin '', which DHCP range to configure the lxd bridge. If empty, uses random range provided by lxd. Not needed, if kubernetes will publish the range using CRI UpdateRuntimeconfig.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.CNIConfDir, "cni-conf-dir",
+	flags.StringVar(&c.cri.CNIConfDir, "cni-conf-dir",
 		network.DefaultCNIconfPath, "When using network-plugin cni, dir in which to search for CNI configuration files.")
-	app.PersistentFlags().StringVar(&globalCmd.cri.CNIBinDir, "cni-bin-dir",
+	flags.StringVar(&c.cri.CNIBinDir, "cni-bin-dir",
 		network.DefaultCNIbinPath, "When using network-plugin cni, dir in which to search for CNI plugin binaries.")
+	flags.BoolVar(&c.cri.LXEAllowUnconfinedSeccomp, "allow-unconfined-seccomp",
+		false, "Allow containers to request the seccomp profile 'unconfined', which disables seccomp filtering for them.")
+	flags.BoolVar(&c.cri.LXEAllowNesting, "allow-nesting",
+		false, "Allow pods to run nested containers with the annotation 'x-lxe-nesting', which weakens the isolation from the host.")
+	flags.BoolVar(&c.cri.LXEAllowPrivileged, "allow-privileged",
+		true, "Allow privileged pods and containers, which run as root of the host. Disable it on nodes shared by untrusted tenants.")
+	flags.BoolVar(&c.cri.LXEAllowSandboxExec, "allow-sandbox-exec",
+		false, "Allow exec with a pod id to debug its network, which runs commands of the host as root in the network namespace of the pod.")
+	flags.BoolVar(&c.cri.LXEAllowPodMounts, "allow-pod-mounts",
+		false, "Allow pods to mount host paths into all their containers with the annotation 'x-lxe-pod-mounts', which bypasses policies on hostPath volumes.")
+	flags.StringVar(&c.cri.LXEAllowRawIdmap, "allow-raw-idmap",
+		"", "Allow pods to map host ids of this range (e.g. 100000-165535) into their containers with the annotation 'x-lxe-raw-idmap'. Root of the host is always refused. (disabled by default)")
+	flags.BoolVar(&c.cri.LXEAllowPostCreate, "allow-post-create",
+		false, "Allow pods to run a shell command as root in their containers when created with the annotation 'x-lxe-postcreate', which isn't subject to --exec-allow and --exec-deny.")
+	flags.StringVar(&c.cri.LXEAllowRestore, "allow-restore",
+		"", "Allow pods to restore containers from checkpoint archives in this directory with the annotation 'x-lxe-restore-from.<container name>'. (disabled by default)")
+	flags.StringSliceVar(&c.cri.LXEAllowLXDNetworks, "allow-lxd-networks",
+		[]string{}, "When using network-plugin '', allow pods to attach to these LXD managed networks with the annotation 'x-lxe-lxd-network'. (none by default)")
+	flags.StringSliceVar(&c.cri.LXEAllowNicParents, "allow-nic-parents",
+		[]string{}, "When using network-plugin '', allow pods to attach macvlan or physical nics to these host interfaces with the annotation 'x-lxe-nic-parent'. (none by default)")
+	flags.DurationVar(&c.cri.LXEExecSyncCacheTTL, "exec-sync-cache-ttl",
+		0, "Reuse results of identical synchronous execs (e.g. probes) for this long. Results may be outdated by up to this duration. (disabled by default)")
+	flags.IntVar(&c.cri.LXENetworkTeardownRetries, "network-teardown-retries",
+		3, "Retry a failed network teardown this often when stopping or removing pods, before giving up.")
+	flags.IntVar(&c.cri.LXENetworkRetries, "network-retries",
+		3, "Retry a transiently failing network plugin call this often when creating or starting pods, before giving up.")
+	flags.DurationVar(&c.cri.LXENetworkRetryBackoff, "network-retry-backoff",
+		500*time.Millisecond, "Delay before the first retry of a network plugin call, doubled for each further retry up to 10s, with random jitter.")
+	flags.DurationVar(&c.cri.LXEStartWaitTimeout, "start-wait-timeout",
+		0, "Wait up to this long after starting a container until its init process runs, so immediate execs don't fail. (disabled by default)")
+	flags.StringSliceVar(&c.cri.LXEExecAllow, "exec-allow",
+		[]string{}, "Only allow these commands to be executed in containers, e.g. for probes. A command is matched by its path, or by its name if no path is given. Empty allows all commands which aren't denied.")
+	flags.StringSliceVar(&c.cri.LXEExecDeny, "exec-deny",
+		[]string{}, "Refuse to execute these commands in containers, e.g. 'sh,bash' to forbid shells. A command is matched by its path, or by its name if no path is given. Takes precedence over exec-allow.")
+	flags.StringSliceVar(&c.cri.LXEInetInterfaces, "inet-interfaces",
+		[]string{network.DefaultInterface}, "Container interfaces in order of priority whose ip is reported as pod ip. If none has one, the first non-loopback interface with a global ip is used.")
+	flags.StringVar(&c.cri.LXEContainerNameTemplate, "container-name-template",
+		"", "Go template for a recognisable lxd name of new containers, e.g. '{{.Namespace}}-{{.Pod}}-{{.Container}}'. A short hash is appended. (opaque ids if empty)")
+	flags.DurationVar(&c.cri.LXESandboxVerifyInterval, "sandbox-verify-interval",
+		0, "Check all pods this often for changes made in lxd without LXE, and log them. (disabled by default)")
+	flags.IntVar(&c.cri.LXEMaxContainersPerPod, "max-containers-per-pod",
+		256, "Refuse to create more containers than this in a pod, including exited ones not yet removed. (0 for no limit)")
+	flags.IntVar(&c.cri.LXEDefaultProcessLimit, "default-process-limit",
+		0, "Maximum number of processes of each container, unless its pod sets the annotation 'x-lxe-process-limit'. (0 for no limit)")
+	flags.DurationVar(&c.cri.LXEPruneInterval, "prune-interval",
+		0, "Prune the leftovers of pods which aren't ready anymore this often: exited containers, pods without containers and proxy devices. (0 to disable)")
+	flags.DurationVar(&c.cri.LXEPruneRetention, "prune-retention",
+		24*time.Hour, "Keep exited containers and pods without containers this long before pruning them.")
+	flags.BoolVar(&c.cri.LXEPruneDryRun, "prune-dry-run",
+		false, "Only log what pruning would remove.")
+	flags.StringVar(&c.cri.LXEMountCollision, "mount-collision",
+		cri.MountCollisionOverlay, "What to do with mounts of a directory onto a file of the image or of a file onto a directory of the image: "+
+			"'overlay' mounts over it like LXD, 'error' refuses the container and 'skip' leaves the mount out. "+
+			"Collisions are logged as warnings.")
+	flags.IntVar(&c.cri.LXEImagePullConcurrency, "image-pull-concurrency",
+		0, "Pull at most this many images at the same time, further pulls wait until one finished. (0 for no limit)")
+	flags.StringVar(&c.cri.LXEWebhookURL, "webhook-url",
+		"", "Post the lifecycle events of containers (created, started, stopped, deleted) as JSON to this url, best effort. (disabled if empty)")
+	flags.IntVar(&c.cri.LXEWebhookRetries, "webhook-retries",
+		3, "Retry posting an event to the webhook this often before dropping it.")
+	flags.DurationVar(&c.cri.LXEWebhookRetryBackoff, "webhook-retry-backoff",
+		time.Second, "Delay before the first retry of posting an event to the webhook, doubled for each further retry up to 10s, with random jitter.")
+	flags.StringToStringVar(&c.cri.LXEDefaultLabels, "default-labels",
+		map[string]string{}, "Labels added to all pods and containers which don't have them, e.g. 'team=infra,cost-center=42'. They are saved in the LXD config as 'user.labels.<key>'.")
+	flags.StringToStringVar(&c.cri.LXEDefaultAnnotations, "default-annotations",
+		map[string]string{}, "Annotations added to all pods and containers which don't have them. They are saved in the LXD config as 'user.annotations.<key>'. Keys starting with 'x-lxe-' are refused.")
+	flags.StringVar(&c.cri.LXEConsoleBufferSize, "console-buffer-size",
+		"", "Size of the in-memory console log buffer of each container, e.g. 4MiB. Between 4KiB and 128MiB. (lxc's default if empty)")
+}
+
+// reloadConfig parses the command line and the config file again into a new config
+func (c *cmdGlobal) reloadConfig() (*cri.Config, error) {
+	next := cmdGlobal{}
+	cmd := &cobra.Command{}
+	next.addFlags(cmd)
+
+	err := cmd.ParseFlags(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if next.flagConfig != "" {
+		err = loadConfigFile(cmd, next.flagConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &next.cri, nil
+}
+
+// loadConfigFile sets the flags of the command from the yaml file, which maps flag names to values. Lists are given as
+// yaml sequences and key value pairs as yaml maps. Flags given on the command line are kept.
+func loadConfigFile(cmd *cobra.Command, path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+
+	err = yaml.Unmarshal(content, &values)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidConfigFile, err)
+	}
+
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || name == "config" {
+			return fmt.Errorf("%w: unknown flag %v", errInvalidConfigFile, name)
+		}
+
+		if flag.Changed {
+			continue
+		}
+
+		err = cmd.Flags().Set(name, configFileValue(value))
+		if err != nil {
+			return fmt.Errorf("%w: flag %v: %v", errInvalidConfigFile, name, err)
+		}
+	}
+
+	return nil
+}
+
+// configFileValue formats a yaml value as flag value, with the elements of sequences separated by commas and the
+// entries of maps as key=value separated by commas
+func configFileValue(value interface{}) string {
+	if m, is := value.(map[string]interface{}); is {
+		entries := make([]string, 0, len(m))
+		for k, v := range m {
+			entries = append(entries, k+"="+fmt.Sprint(v))
+		}
+
+		sort.Strings(entries)
+
+		return strings.Join(entries, ",")
+	}
+
+	list, is := value.([]interface{})
+	if !is {
+		return fmt.Sprint(value)
+	}
+
+	elems := make([]string, 0, len(list))
+	for _, elem := range list {
+		elems = append(elems, fmt.Sprint(elem))
+	}
+
+	return strings.Join(elems, ",")
+}
+
+func main() {
+	// daemon command (main)
+	daemonCmd := cmdDaemon{}
+	app := daemonCmd.Command()
+
+	// Workaround for main command
+	app.Args = cobra.ArbitraryArgs
+	app.Version = cri.Version
+
+	// Global flags
+	globalCmd := cmdGlobal{}
+	daemonCmd.global = &globalCmd
+	app.PersistentPreRunE = globalCmd.Run
+	globalCmd.addFlags(app)
 
 	// Run the main command and handle errors
 	err := app.Execute()
@@ -43,6 +43,8 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 	signal.Notify(ch, syscall.SIGPWR)
 	signal.Notify(ch, syscall.SIGINT)
 	signal.Notify(ch, syscall.SIGTERM)
+	signal.Notify(ch, syscall.SIGHUP)
+	signal.Notify(ch, syscall.SIGUSR1)
 	signal.Notify(ch, syscall.SIGUSR2)
 
 	for sig := range ch {
@@ -52,6 +54,17 @@ func (c *cmdDaemon) Run(cmd *cobra.Command, args []string) error {
 		case syscall.SIGPWR, syscall.SIGINT, syscall.SIGTERM:
 			logger.Warn("shutting down")
 			return d.Stop()
+		case syscall.SIGHUP:
+			next, err := c.global.reloadConfig()
+			if err != nil {
+				logger.Errorf("Unable to reload config, keeping the current one: %v", err)
+				continue
+			}
+
+			d.Reload(next)
+		case syscall.SIGUSR1:
+			// toggle draining the node for maintenance
+			d.ToggleDrain()
 		case syscall.SIGUSR2:
 			// Allow manual dump of goroutines until pprof is implemented
 			err := dumpGoroutines()
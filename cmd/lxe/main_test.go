@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfigFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "lxe-config")
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "lxe.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func testGlobalCommand(t *testing.T, args ...string) (*cmdGlobal, *cobra.Command) {
+	c := &cmdGlobal{}
+	cmd := &cobra.Command{}
+	c.addFlags(cmd)
+
+	assert.NoError(t, cmd.ParseFlags(args))
+
+	return c, cmd
+}
+
+func TestConfigFileValue(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "5", configFileValue(5))
+	assert.Equal(t, "true", configFileValue(true))
+	assert.Equal(t, "ssd", configFileValue("ssd"))
+	assert.Equal(t, "default,gpu", configFileValue([]interface{}{"default", "gpu"}))
+	assert.Equal(t, "", configFileValue([]interface{}{}))
+	assert.Equal(t, "cost-center=42,team=infra", configFileValue(map[string]interface{}{"team": "infra", "cost-center": 42}))
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Parallel()
+
+	path, cleanup := writeTestConfigFile(t, `
+network-retries: 5
+lxd-profiles: [default, gpu]
+default-labels: {team: infra}
+network-retry-backoff: 2s
+allow-nesting: true
+`)
+	defer cleanup()
+
+	c, cmd := testGlobalCommand(t, "--network-retries=3")
+
+	assert.NoError(t, loadConfigFile(cmd, path))
+
+	// given on the command line, so the file doesn't override it
+	assert.Equal(t, 3, c.cri.LXENetworkRetries)
+	assert.Equal(t, []string{"default", "gpu"}, c.cri.LXDProfiles)
+	assert.Equal(t, map[string]string{"team": "infra"}, c.cri.LXEDefaultLabels)
+	assert.Equal(t, 2*time.Second, c.cri.LXENetworkRetryBackoff)
+	assert.True(t, c.cri.LXEAllowNesting)
+}
+
+func TestLoadConfigFile_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for name, content := range map[string]string{
+		"unknown flag":  "no-such-flag: 1",
+		"config itself": "config: /etc/other.yaml",
+		"invalid value": "network-retries: many",
+		"invalid yaml":  "network-retries: [5",
+	} {
+		path, cleanup := writeTestConfigFile(t, content)
+
+		_, cmd := testGlobalCommand(t)
+		err := loadConfigFile(cmd, path)
+		assert.True(t, errors.Is(err, errInvalidConfigFile), name)
+
+		cleanup()
+	}
+
+	_, cmd := testGlobalCommand(t)
+	err := loadConfigFile(cmd, "/nonexistent/lxe.yaml")
+	assert.True(t, os.IsNotExist(err))
+}
+
+// not parallel, as it reads the global command line
+func TestCmdGlobal_reloadConfig(t *testing.T) {
+	path, cleanup := writeTestConfigFile(t, "lxd-storage-pool: ssd\nmax-containers-per-pod: 4\n")
+	defer cleanup()
+
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	os.Args = []string{"lxe", "--config", path, "--max-containers-per-pod=2"}
+
+	c := &cmdGlobal{}
+
+	next, err := c.reloadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "ssd", next.LXDStoragePool)
+	assert.Equal(t, 2, next.LXEMaxContainersPerPod)
+	// defaults of flags in neither are set as well
+	assert.Equal(t, []string{"default"}, next.LXDProfiles)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("lxd-storage-pool: [ssd"), 0600))
+
+	_, err = c.reloadConfig()
+	assert.True(t, errors.Is(err, errInvalidConfigFile))
+
+	os.Args = []string{"lxe", "--no-such-flag"}
+
+	_, err = c.reloadConfig()
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package cri
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/shared"
+	"github.com/lxc/lxd/shared/logger"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// containerStatusCacheTTL is how long a listing of all containers is used to answer status requests. Kubelet relists
+// every second and then requests the status of each container, so this covers one sync cycle.
+const containerStatusCacheTTL = time.Second
+
+// containerCache answers container lookups from a single recent listing of all containers, so a sync cycle of kubelet
+// doesn't cause a round trip to lxd for each container. Any change made through the runtime invalidates the cache.
+type containerCache struct {
+	mu         sync.Mutex
+	lxf        lxf.Client
+	ttl        time.Duration
+	fetched    time.Time
+	containers map[string]*lxf.Container
+}
+
+func newContainerCache(client lxf.Client, ttl time.Duration) *containerCache {
+	return &containerCache{
+		lxf: client,
+		ttl: ttl,
+	}
+}
+
+// Get returns the container identified by id. The container is fetched directly if it is not part of the listing,
+// e.g. when it was just created.
+func (cc *containerCache) Get(id string) (*lxf.Container, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.containers == nil || time.Since(cc.fetched) > cc.ttl {
+		cl, err := cc.lxf.ListContainers()
+		if err != nil {
+			return nil, err
+		}
+
+		cc.set(cl)
+	}
+
+	if c, has := cc.containers[id]; has {
+		return c, nil
+	}
+
+	return cc.lxf.GetContainer(id)
+}
+
+// Set replaces the cached listing with an already fetched list of all containers
+func (cc *containerCache) Set(cl []*lxf.Container) {
+	if cc == nil {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.set(cl)
+}
+
+func (cc *containerCache) set(cl []*lxf.Container) {
+	cc.containers = make(map[string]*lxf.Container, len(cl))
+	for _, c := range cl {
+		cc.containers[c.ID] = c
+	}
+
+	cc.fetched = time.Now()
+}
+
+// Invalidate drops the cached listing, so the next lookup reflects the current state
+func (cc *containerCache) Invalidate() {
+	if cc == nil {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.containers = nil
+}
+
+// execSyncCache reuses the results of identical synchronous execs in the same container for a short time. This saves
+// load in the container when probes overlap, at the cost of results being outdated by up to the ttl.
+type execSyncCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]execSyncCacheEntry
+}
+
+type execSyncCacheEntry struct {
+	fetched time.Time
+	resp    *rtApi.ExecSyncResponse
+}
+
+func newExecSyncCache(ttl time.Duration) *execSyncCache {
+	return &execSyncCache{
+		ttl:     ttl,
+		entries: make(map[string]execSyncCacheEntry),
+	}
+}
+
+func execSyncCacheKey(containerID string, cmd []string) string {
+	return containerID + "\x00" + strings.Join(cmd, "\x00")
+}
+
+// Get returns the result of an identical exec which is not older than the ttl. The cache is disabled with a ttl of 0.
+func (ec *execSyncCache) Get(containerID string, cmd []string) (*rtApi.ExecSyncResponse, bool) {
+	if ec == nil || ec.ttl <= 0 {
+		return nil, false
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	e, has := ec.entries[execSyncCacheKey(containerID, cmd)]
+	if !has || time.Since(e.fetched) > ec.ttl {
+		return nil, false
+	}
+
+	return e.resp, true
+}
+
+// Set stores the result of an exec and drops all expired results
+func (ec *execSyncCache) Set(containerID string, cmd []string, resp *rtApi.ExecSyncResponse) {
+	if ec == nil || ec.ttl <= 0 {
+		return
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	for k, e := range ec.entries {
+		if time.Since(e.fetched) > ec.ttl {
+			delete(ec.entries, k)
+		}
+	}
+
+	ec.entries[execSyncCacheKey(containerID, cmd)] = execSyncCacheEntry{
+		fetched: time.Now(),
+		resp:    resp,
+	}
+}
+
+// imageSizeCache remembers the size of images by their fingerprint, as only the image of a fingerprint never changes
+type imageSizeCache struct {
+	mu    sync.Mutex
+	lxf   lxf.Client
+	sizes map[string]int64
+}
+
+func newImageSizeCache(client lxf.Client) *imageSizeCache {
+	return &imageSizeCache{
+		lxf:   client,
+		sizes: make(map[string]int64),
+	}
+}
+
+// Get returns the size of the image in bytes. The image is usually given by its fingerprint, other names are resolved
+// each time, as they may point to another image meanwhile. If the size can't be determined, e.g. because the image was
+// deleted, false is returned.
+func (ic *imageSizeCache) Get(image string) (int64, bool) {
+	if ic == nil || image == "" {
+		return 0, false
+	}
+
+	ic.mu.Lock()
+	size, has := ic.sizes[image]
+	ic.mu.Unlock()
+
+	if has {
+		return size, true
+	}
+
+	// not holding the lock, so a slow lxd doesn't hold up the lookups of other images
+	img, err := ic.lxf.GetImage(image)
+	if err != nil {
+		if !shared.IsErrNotFound(err) {
+			logger.Warnf("unable to get size of image %v: %v", image, err)
+		}
+
+		return 0, false
+	}
+
+	ic.mu.Lock()
+	ic.sizes[img.Hash] = img.Size
+	ic.mu.Unlock()
+
+	return img.Size, true
+}
@@ -0,0 +1,173 @@
+package cri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/automaticserver/lxe/cri/crifakes"
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/shared"
+	"github.com/stretchr/testify/assert"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestContainerCache_Get(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.ListContainersReturns([]*lxf.Container{{LXDObject: lxf.LXDObject{ID: "foo"}}}, nil)
+	fake.GetContainerReturns(&lxf.Container{LXDObject: lxf.LXDObject{ID: "bar"}}, nil)
+
+	cc := newContainerCache(fake, time.Minute)
+
+	c, err := cc.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", c.ID)
+
+	_, err = cc.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.ListContainersCallCount())
+
+	c, err = cc.Get("bar")
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", c.ID)
+	assert.Equal(t, 1, fake.ListContainersCallCount())
+	assert.Equal(t, 1, fake.GetContainerCallCount())
+
+	cc.Invalidate()
+
+	_, err = cc.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.ListContainersCallCount())
+}
+
+func TestContainerCache_Expired(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	cc := newContainerCache(fake, 0)
+
+	cc.Set([]*lxf.Container{{LXDObject: lxf.LXDObject{ID: "foo"}}})
+	time.Sleep(time.Millisecond)
+
+	_, err := cc.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.ListContainersCallCount())
+}
+
+func TestExecSyncCache(t *testing.T) {
+	t.Parallel()
+
+	ec := newExecSyncCache(time.Minute)
+	resp := &rtApi.ExecSyncResponse{ExitCode: 1}
+
+	_, has := ec.Get("foo", []string{"true"})
+	assert.False(t, has)
+
+	ec.Set("foo", []string{"true"}, resp)
+
+	cached, has := ec.Get("foo", []string{"true"})
+	assert.True(t, has)
+	assert.Equal(t, resp, cached)
+
+	_, has = ec.Get("bar", []string{"true"})
+	assert.False(t, has)
+
+	_, has = ec.Get("foo", []string{"tr", "ue"})
+	assert.False(t, has)
+}
+
+func TestExecSyncCache_Disabled(t *testing.T) {
+	t.Parallel()
+
+	ec := newExecSyncCache(0)
+	ec.Set("foo", []string{"true"}, &rtApi.ExecSyncResponse{})
+
+	_, has := ec.Get("foo", []string{"true"})
+	assert.False(t, has)
+}
+
+func TestImageSizeCache_Get(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetImageReturns(&lxf.Image{Hash: "abc123", Size: 1234}, nil)
+
+	ic := newImageSizeCache(fake)
+
+	size, ok := ic.Get("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1234), size)
+
+	size, ok = ic.Get("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1234), size)
+	assert.Equal(t, 1, fake.GetImageCallCount())
+}
+
+func TestImageSizeCache_GetAlias(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetImageReturns(&lxf.Image{Hash: "abc123", Size: 1234}, nil)
+
+	ic := newImageSizeCache(fake)
+
+	size, ok := ic.Get("busybox")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1234), size)
+
+	// the alias is moved to another image
+	fake.GetImageReturns(&lxf.Image{Hash: "def456", Size: 5678}, nil)
+
+	size, ok = ic.Get("busybox")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5678), size)
+	assert.Equal(t, 2, fake.GetImageCallCount())
+
+	// but the fingerprints are cached
+	size, ok = ic.Get("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1234), size)
+	assert.Equal(t, 2, fake.GetImageCallCount())
+}
+
+func TestImageSizeCache_GetConcurrent(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	slow := make(chan struct{})
+	fake.GetImageCalls(func(name string) (*lxf.Image, error) {
+		if name == "slow" {
+			<-slow
+		}
+
+		return &lxf.Image{Hash: name, Size: 1}, nil
+	})
+
+	ic := newImageSizeCache(fake)
+
+	go ic.Get("slow")
+
+	assert.Eventually(t, func() bool { return fake.GetImageCallCount() == 1 }, 5*time.Second, time.Millisecond)
+
+	// a slow lxd for one image doesn't block the others
+	_, ok := ic.Get("fast")
+	assert.True(t, ok)
+
+	close(slow)
+}
+
+func TestImageSizeCache_GetDeleted(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetImageReturns(nil, shared.NewErrNotFound())
+
+	ic := newImageSizeCache(fake)
+
+	_, ok := ic.Get("foo")
+	assert.False(t, ok)
+	assert.Equal(t, "unknown", imageSizeInfo(ic.Get("foo")))
+	assert.Equal(t, 2, fake.GetImageCallCount())
+}
@@ -0,0 +1,84 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/automaticserver/lxe/shared"
+	"github.com/lxc/lxd/shared/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clusterUnavailableBackoff is how long requests are refused after lxd reported its cluster as unavailable
+const clusterUnavailableBackoff = 10 * time.Second
+
+// reasonClusterUnavailable is reported in the runtime status while requests are refused
+const reasonClusterUnavailable = "LXDClusterUnavailable"
+
+// clusterGuard refuses requests for a while after lxd reported its cluster as unavailable (e.g. no quorum), so kubelet
+// backs off instead of adding load to the degraded cluster. It only trips if lxd is clustered, as a degraded cluster
+// can't report that anymore, it's determined once when LXE starts.
+type clusterGuard struct {
+	mu        sync.Mutex
+	backoff   time.Duration
+	clustered bool
+	until     time.Time
+	lastErr   error
+}
+
+func newClusterGuard(backoff time.Duration, clustered bool) *clusterGuard {
+	return &clusterGuard{
+		backoff:   backoff,
+		clustered: clustered,
+	}
+}
+
+// Unavailable returns the error which made the cluster unavailable, nil if the cluster is considered available
+func (g *clusterGuard) Unavailable() error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if time.Now().After(g.until) {
+		return nil
+	}
+
+	return g.lastErr
+}
+
+// Intercept is a grpc interceptor which converts cluster errors into a clearly labelled unavailable status and
+// refuses all requests but the runtime status during the backoff.
+func (g *clusterGuard) Intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	// Status must still report the runtime as not ready
+	if info.FullMethod != "/runtime.v1alpha2.RuntimeService/Status" {
+		if err := g.Unavailable(); err != nil {
+			return nil, g.status(err)
+		}
+	}
+
+	resp, err := handler(ctx, req)
+	if !g.clustered || !shared.IsErrClusterUnavailable(err) {
+		return resp, err
+	}
+
+	g.mu.Lock()
+	g.until = time.Now().Add(g.backoff)
+	g.lastErr = err
+	g.mu.Unlock()
+
+	metricClusterUnavailable.Add(1)
+	logger.Errorf("LXD cluster unavailable, refusing requests for %v: %v", g.backoff, err)
+
+	return nil, g.status(err)
+}
+
+func (g *clusterGuard) status(err error) error {
+	return status.Error(codes.Unavailable, fmt.Sprintf("%s: retry after %v: %v", reasonClusterUnavailable, g.backoff, err))
+}
@@ -0,0 +1,114 @@
+package cri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/automaticserver/lxe/shared"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+var errTestCluster = errors.New("failed to begin transaction: no available dqlite leader server found")
+
+func TestClusterGuard_Intercept(t *testing.T) {
+	t.Parallel()
+
+	g := newClusterGuard(time.Minute, true)
+	info := &grpc.UnaryServerInfo{FullMethod: "/runtime.v1alpha2.RuntimeService/ListContainers"}
+	calls := 0
+	stub := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return nil, errTestCluster
+	}
+
+	_, err := g.Intercept(context.Background(), nil, info, stub)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), reasonClusterUnavailable)
+	assert.Equal(t, errTestCluster, g.Unavailable())
+
+	// refused without calling lxd during the backoff
+	_, err = g.Intercept(context.Background(), nil, info, stub)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestClusterGuard_InterceptOtherError(t *testing.T) {
+	t.Parallel()
+
+	g := newClusterGuard(time.Minute, true)
+	info := &grpc.UnaryServerInfo{FullMethod: "/runtime.v1alpha2.RuntimeService/ListContainers"}
+	stub := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, ErrNotImplemented
+	}
+
+	_, err := g.Intercept(context.Background(), nil, info, stub)
+	assert.Equal(t, ErrNotImplemented, err)
+	assert.NoError(t, g.Unavailable())
+}
+
+func TestClusterGuard_InterceptNotClustered(t *testing.T) {
+	t.Parallel()
+
+	// a single lxd reports transaction errors e.g. if its disk is full, which mustn't refuse all requests
+	g := newClusterGuard(time.Minute, false)
+	info := &grpc.UnaryServerInfo{FullMethod: "/runtime.v1alpha2.RuntimeService/ListContainers"}
+	stub := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errTestCluster
+	}
+
+	_, err := g.Intercept(context.Background(), nil, info, stub)
+	assert.Equal(t, errTestCluster, err)
+	assert.NoError(t, g.Unavailable())
+}
+
+func TestIsErrClusterUnavailable(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []error{
+		errTestCluster,
+		errors.New("503 not leader"),
+		errors.New("failed to query instances: 503 not leader"),
+		errors.New("cluster database is unavailable"),
+	} {
+		assert.True(t, shared.IsErrClusterUnavailable(err), err.Error())
+	}
+
+	for _, err := range []error{
+		nil,
+		errors.New("not found"),
+		errors.New("image is not leader of its alias group"),
+		errors.New("503 not leader anymore, retrying"),
+	} {
+		assert.False(t, shared.IsErrClusterUnavailable(err), fmt.Sprint(err))
+	}
+}
+
+func TestRuntimeServer_StatusClusterUnavailable(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	s.cluster = newClusterGuard(time.Minute, true)
+	info := &grpc.UnaryServerInfo{FullMethod: "/runtime.v1alpha2.RuntimeService/Status"}
+	stub := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errTestCluster
+	}
+
+	_, _ = s.cluster.Intercept(context.Background(), nil, info, stub)
+
+	// status still passes the guard and reports not ready
+	resp, err := s.cluster.Intercept(context.Background(), &rtApi.StatusRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.Status(ctx, req.(*rtApi.StatusRequest))
+	})
+	assert.NoError(t, err)
+
+	cond := resp.(*rtApi.StatusResponse).GetStatus().GetConditions()[0]
+	assert.False(t, cond.GetStatus())
+	assert.Equal(t, reasonClusterUnavailable, cond.GetReason())
+}
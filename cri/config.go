@@ -1,5 +1,12 @@
 package cri
 
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
 // Config options that LXE will need to interface with LXD
 type Config struct {
 	// UnixSocket this LXE will be reachable under
@@ -12,10 +19,20 @@ type Config struct {
 	LXDImageRemote string
 	// LXDProfiles which all cri containers inherit
 	LXDProfiles []string
+	// LXDStoragePool of readonly root disks, if empty the pool of the root disk in the default profile is used
+	LXDStoragePool string
+	// LXDOperationTimeout limits waiting for quick lxd operations like starting containers, 0 waits without limit
+	LXDOperationTimeout time.Duration
+	// LXDLongOperationTimeout limits waiting for long lxd operations like pulling images, 0 waits without limit
+	LXDLongOperationTimeout time.Duration
 	// LXEStreamingServerEndpoint contains the listen address for the streaming server
 	LXEStreamingServerEndpoint string
 	// LXEStreamingPort is the port for the streaming server
 	LXEStreamingPort int
+	// LXEStreamingIdleTimeout is how long idle streaming connections are kept open, 0 uses the default
+	LXEStreamingIdleTimeout time.Duration
+	// LXEStreamingCreationTimeout is how long clients have to create streams after connecting, 0 uses the default
+	LXEStreamingCreationTimeout time.Duration
 	// LXEHostnetworkFile file path to use for lxc's raw.include
 	LXEHostnetworkFile string
 	// Which LXENetworkPlugin to use
@@ -28,4 +45,150 @@ type Config struct {
 	CNIConfDir string
 	// CNIBinDir is the path where the cni plugins are
 	CNIBinDir string
+	// LXEAllowUnconfinedSeccomp allows containers to disable seccomp filtering with the profile unconfined
+	LXEAllowUnconfinedSeccomp bool
+	// LXEAllowNesting allows containers to enable nested containers with the nesting annotation
+	LXEAllowNesting bool
+	// LXEAllowPrivileged allows pods and containers to be privileged
+	LXEAllowPrivileged bool
+	// LXEAllowSandboxExec allows exec with a sandbox id to run host commands in the network namespace of the pod
+	LXEAllowSandboxExec bool
+	// LXEAllowPodMounts allows pods to mount host paths into all their containers with the pod mounts annotation
+	LXEAllowPodMounts bool
+	// LXEAllowRawIdmap is the range of host ids pods may map into their containers with the raw idmap annotation, empty
+	// disallows it
+	LXEAllowRawIdmap string
+	// LXEAllowPostCreate allows pods to run a command in their containers when created with the post-create annotation
+	LXEAllowPostCreate bool
+	// LXEAllowRestore is the directory pods may restore containers from checkpoint archives in, empty disallows restores
+	LXEAllowRestore string
+	// LXEAllowLXDNetworks are the lxd managed networks pods may be attached to with the lxd network annotation
+	LXEAllowLXDNetworks []string
+	// LXEAllowNicParents are the host interfaces pods may attach nics to with the nic parent annotation
+	LXEAllowNicParents []string
+	// LXEExecAllow are the commands which may be executed in containers, empty allows all which aren't denied
+	LXEExecAllow []string
+	// LXEExecDeny are the commands which must not be executed in containers
+	LXEExecDeny []string
+	// LXEExecSyncCacheTTL is how long results of identical synchronous execs are reused, 0 disables caching
+	LXEExecSyncCacheTTL time.Duration
+	// LXENetworkTeardownRetries is how often a failed network teardown is retried
+	LXENetworkTeardownRetries int
+	// LXENetworkRetries is how often a network plugin call failing transiently is retried when creating or starting
+	LXENetworkRetries int
+	// LXENetworkRetryBackoff is the delay before the first retry of a network plugin call, doubled for each further one
+	LXENetworkRetryBackoff time.Duration
+	// LXEStartWaitTimeout is how long starting a container waits for its init process to run, 0 disables waiting
+	LXEStartWaitTimeout time.Duration
+	// LXEInetInterfaces are the container interfaces in order of priority whose address is reported as the pod ip
+	LXEInetInterfaces []string
+	// LXEContainerNameTemplate renders a recognisable prefix of the lxd name of new containers, empty keeps opaque ids
+	LXEContainerNameTemplate string
+	// LXESandboxVerifyInterval is how often sandboxes are checked for drift from lxd, 0 disables it
+	LXESandboxVerifyInterval time.Duration
+	// LXEMaxContainersPerPod is how many containers a sandbox may have, 0 disables the limit
+	LXEMaxContainersPerPod int
+	// LXEDefaultProcessLimit is the maximum number of processes of containers whose pod sets none, 0 disables the limit
+	LXEDefaultProcessLimit int
+	// LXEPruneInterval is how often the leftovers of pods which aren't ready are pruned, 0 disables it
+	LXEPruneInterval time.Duration
+	// LXEPruneRetention is how long exited containers and sandboxes without containers are kept before being pruned
+	LXEPruneRetention time.Duration
+	// LXEPruneDryRun only logs what would be pruned
+	LXEPruneDryRun bool
+	// LXEMountCollision is what happens to mounts whose source and container path in the image differ in type
+	LXEMountCollision string
+	// LXEImagePullConcurrency is the maximum number of images pulled at the same time, 0 for no limit
+	LXEImagePullConcurrency int
+	// LXEWebhookURL is where the lifecycle events of containers are posted to, empty disables it
+	LXEWebhookURL string
+	// LXEWebhookRetries is how often posting an event is retried before it's dropped
+	LXEWebhookRetries int
+	// LXEWebhookRetryBackoff is the delay before the first retry of posting an event, doubled for each further retry
+	LXEWebhookRetryBackoff time.Duration
+	// LXEDefaultLabels are added to the labels of all sandboxes and containers which don't have them
+	LXEDefaultLabels map[string]string
+	// LXEDefaultAnnotations are added to the annotations of all sandboxes and containers which don't have them
+	LXEDefaultAnnotations map[string]string
+	// LXEConsoleBufferSize is the size of the console log ring buffer of containers, empty keeps lxc's default
+	LXEConsoleBufferSize string
+}
+
+// reloadableConfig are the fields of the config which can be changed without restart, as they are read whenever they
+// are needed. Other fields are used to set up servers, clients and caches once.
+var reloadableConfig = map[string]bool{
+	"LXDProfiles":               true,
+	"LXDStoragePool":            true,
+	"LXEAllowUnconfinedSeccomp": true,
+	"LXEAllowNesting":           true,
+	"LXEAllowPrivileged":        true,
+	"LXEAllowPodMounts":         true,
+	"LXEAllowSandboxExec":       true,
+	"LXEAllowRawIdmap":          true,
+	"LXEAllowPostCreate":        true,
+	"LXEAllowRestore":           true,
+	"LXEExecAllow":              true,
+	"LXEExecDeny":               true,
+	"LXENetworkTeardownRetries": true,
+	"LXENetworkRetries":         true,
+	"LXENetworkRetryBackoff":    true,
+	"LXEStartWaitTimeout":       true,
+	"LXEInetInterfaces":         true,
+	"LXEMaxContainersPerPod":    true,
+	"LXEDefaultProcessLimit":    true,
+	"LXEPruneRetention":         true,
+	"LXEPruneDryRun":            true,
+}
+
+// configHolder holds the current config. A reload replaces it as a whole, so a config once loaded is a consistent
+// snapshot which doesn't change anymore.
+type configHolder struct {
+	v atomic.Value
+}
+
+func newConfigHolder(criConfig *Config) *configHolder {
+	h := &configHolder{}
+	h.v.Store(criConfig)
+
+	return h
+}
+
+// Load returns the current config, which must not be modified
+func (h *configHolder) Load() *Config {
+	return h.v.Load().(*Config)
+}
+
+// Reload replaces the current config with next, but keeps the fields which can't be changed without restart. It returns
+// the names of those fields which differ in next.
+func (h *configHolder) Reload(next *Config) []string {
+	merged, ignored := mergeReload(h.Load(), next)
+	h.v.Store(merged)
+
+	return ignored
+}
+
+// mergeReload returns a copy of next with the fields which can't be reloaded taken from cur, and the sorted names of
+// those fields which differ
+func mergeReload(cur, next *Config) (*Config, []string) {
+	merged := *next
+	ignored := []string{}
+
+	mv, cv := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(cur).Elem()
+
+	for i := 0; i < mv.NumField(); i++ {
+		name := mv.Type().Field(i).Name
+		if reloadableConfig[name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(mv.Field(i).Interface(), cv.Field(i).Interface()) {
+			ignored = append(ignored, name)
+		}
+
+		mv.Field(i).Set(cv.Field(i))
+	}
+
+	sort.Strings(ignored)
+
+	return &merged, ignored
 }
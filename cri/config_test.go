@@ -0,0 +1,38 @@
+package cri
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHolder_Reload(t *testing.T) {
+	t.Parallel()
+
+	cur := &Config{
+		UnixSocket:        "/var/run/lxe.sock",
+		LXENetworkRetries: 3,
+		LXDProfiles:       []string{"default"},
+	}
+	h := newConfigHolder(cur)
+
+	ignored := h.Reload(&Config{
+		UnixSocket:             "/run/other.sock",
+		LXENetworkRetries:      5,
+		LXENetworkRetryBackoff: time.Second,
+		LXDProfiles:            []string{"default", "extra"},
+		LXENetworkPlugin:       NetworkPluginCNI,
+	})
+	assert.Equal(t, []string{"LXENetworkPlugin", "UnixSocket"}, ignored)
+
+	next := h.Load()
+	assert.Equal(t, 5, next.LXENetworkRetries)
+	assert.Equal(t, time.Second, next.LXENetworkRetryBackoff)
+	assert.Equal(t, []string{"default", "extra"}, next.LXDProfiles)
+	assert.Equal(t, "/var/run/lxe.sock", next.UnixSocket)
+	assert.Equal(t, NetworkPluginDefault, next.LXENetworkPlugin)
+
+	// the previous snapshot is left unchanged for requests still using it
+	assert.Equal(t, 3, cur.LXENetworkRetries)
+}
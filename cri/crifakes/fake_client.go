@@ -11,18 +11,21 @@ import (
 )
 
 type FakeClient struct {
-	ExecStub        func(string, []string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) (int32, error)
+	ExecStub        func(string, []string, map[string]string, *lxf.ExecUser, string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) (int32, error)
 	execMutex       sync.RWMutex
 	execArgsForCall []struct {
-		arg1 string
-		arg2 []string
-		arg3 io.ReadCloser
-		arg4 io.WriteCloser
-		arg5 io.WriteCloser
-		arg6 bool
-		arg7 bool
-		arg8 int64
-		arg9 <-chan remotecommand.TerminalSize
+		arg1  string
+		arg2  []string
+		arg3  map[string]string
+		arg4  *lxf.ExecUser
+		arg5  string
+		arg6  io.ReadCloser
+		arg7  io.WriteCloser
+		arg8  io.WriteCloser
+		arg9  bool
+		arg10 bool
+		arg11 int64
+		arg12 <-chan remotecommand.TerminalSize
 	}
 	execReturns struct {
 		result1 int32
@@ -70,6 +73,18 @@ type FakeClient struct {
 		result1 *lxf.Image
 		result2 error
 	}
+	GetRootPoolStub        func() (string, error)
+	getRootPoolMutex       sync.RWMutex
+	getRootPoolArgsForCall []struct {
+	}
+	getRootPoolReturns struct {
+		result1 string
+		result2 error
+	}
+	getRootPoolReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
 	GetRuntimeInfoStub        func() (*lxf.RuntimeInfo, error)
 	getRuntimeInfoMutex       sync.RWMutex
 	getRuntimeInfoArgsForCall []struct {
@@ -164,10 +179,11 @@ type FakeClient struct {
 	newSandboxReturnsOnCall map[int]struct {
 		result1 *lxf.Sandbox
 	}
-	PullImageStub        func(string) (string, error)
+	PullImageStub        func(string, func(string)) (string, error)
 	pullImageMutex       sync.RWMutex
 	pullImageArgsForCall []struct {
 		arg1 string
+		arg2 func(string)
 	}
 	pullImageReturns struct {
 		result1 string
@@ -197,7 +213,7 @@ type FakeClient struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeClient) Exec(arg1 string, arg2 []string, arg3 io.ReadCloser, arg4 io.WriteCloser, arg5 io.WriteCloser, arg6 bool, arg7 bool, arg8 int64, arg9 <-chan remotecommand.TerminalSize) (int32, error) {
+func (fake *FakeClient) Exec(arg1 string, arg2 []string, arg3 map[string]string, arg4 *lxf.ExecUser, arg5 string, arg6 io.ReadCloser, arg7 io.WriteCloser, arg8 io.WriteCloser, arg9 bool, arg10 bool, arg11 int64, arg12 <-chan remotecommand.TerminalSize) (int32, error) {
 	var arg2Copy []string
 	if arg2 != nil {
 		arg2Copy = make([]string, len(arg2))
@@ -206,20 +222,23 @@ func (fake *FakeClient) Exec(arg1 string, arg2 []string, arg3 io.ReadCloser, arg
 	fake.execMutex.Lock()
 	ret, specificReturn := fake.execReturnsOnCall[len(fake.execArgsForCall)]
 	fake.execArgsForCall = append(fake.execArgsForCall, struct {
-		arg1 string
-		arg2 []string
-		arg3 io.ReadCloser
-		arg4 io.WriteCloser
-		arg5 io.WriteCloser
-		arg6 bool
-		arg7 bool
-		arg8 int64
-		arg9 <-chan remotecommand.TerminalSize
-	}{arg1, arg2Copy, arg3, arg4, arg5, arg6, arg7, arg8, arg9})
-	fake.recordInvocation("Exec", []interface{}{arg1, arg2Copy, arg3, arg4, arg5, arg6, arg7, arg8, arg9})
+		arg1  string
+		arg2  []string
+		arg3  map[string]string
+		arg4  *lxf.ExecUser
+		arg5  string
+		arg6  io.ReadCloser
+		arg7  io.WriteCloser
+		arg8  io.WriteCloser
+		arg9  bool
+		arg10 bool
+		arg11 int64
+		arg12 <-chan remotecommand.TerminalSize
+	}{arg1, arg2Copy, arg3, arg4, arg5, arg6, arg7, arg8, arg9, arg10, arg11, arg12})
+	fake.recordInvocation("Exec", []interface{}{arg1, arg2Copy, arg3, arg4, arg5, arg6, arg7, arg8, arg9, arg10, arg11, arg12})
 	fake.execMutex.Unlock()
 	if fake.ExecStub != nil {
-		return fake.ExecStub(arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8, arg9)
+		return fake.ExecStub(arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8, arg9, arg10, arg11, arg12)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -234,17 +253,17 @@ func (fake *FakeClient) ExecCallCount() int {
 	return len(fake.execArgsForCall)
 }
 
-func (fake *FakeClient) ExecCalls(stub func(string, []string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) (int32, error)) {
+func (fake *FakeClient) ExecCalls(stub func(string, []string, map[string]string, *lxf.ExecUser, string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) (int32, error)) {
 	fake.execMutex.Lock()
 	defer fake.execMutex.Unlock()
 	fake.ExecStub = stub
 }
 
-func (fake *FakeClient) ExecArgsForCall(i int) (string, []string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) {
+func (fake *FakeClient) ExecArgsForCall(i int) (string, []string, map[string]string, *lxf.ExecUser, string, io.ReadCloser, io.WriteCloser, io.WriteCloser, bool, bool, int64, <-chan remotecommand.TerminalSize) {
 	fake.execMutex.RLock()
 	defer fake.execMutex.RUnlock()
 	argsForCall := fake.execArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5, argsForCall.arg6, argsForCall.arg7, argsForCall.arg8, argsForCall.arg9
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4, argsForCall.arg5, argsForCall.arg6, argsForCall.arg7, argsForCall.arg8, argsForCall.arg9, argsForCall.arg10, argsForCall.arg11, argsForCall.arg12
 }
 
 func (fake *FakeClient) ExecReturns(result1 int32, result2 error) {
@@ -454,6 +473,61 @@ func (fake *FakeClient) GetImageReturnsOnCall(i int, result1 *lxf.Image, result2
 	}{result1, result2}
 }
 
+func (fake *FakeClient) GetRootPool() (string, error) {
+	fake.getRootPoolMutex.Lock()
+	ret, specificReturn := fake.getRootPoolReturnsOnCall[len(fake.getRootPoolArgsForCall)]
+	fake.getRootPoolArgsForCall = append(fake.getRootPoolArgsForCall, struct {
+	}{})
+	fake.recordInvocation("GetRootPool", []interface{}{})
+	fake.getRootPoolMutex.Unlock()
+	if fake.GetRootPoolStub != nil {
+		return fake.GetRootPoolStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.getRootPoolReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) GetRootPoolCallCount() int {
+	fake.getRootPoolMutex.RLock()
+	defer fake.getRootPoolMutex.RUnlock()
+	return len(fake.getRootPoolArgsForCall)
+}
+
+func (fake *FakeClient) GetRootPoolCalls(stub func() (string, error)) {
+	fake.getRootPoolMutex.Lock()
+	defer fake.getRootPoolMutex.Unlock()
+	fake.GetRootPoolStub = stub
+}
+
+func (fake *FakeClient) GetRootPoolReturns(result1 string, result2 error) {
+	fake.getRootPoolMutex.Lock()
+	defer fake.getRootPoolMutex.Unlock()
+	fake.GetRootPoolStub = nil
+	fake.getRootPoolReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) GetRootPoolReturnsOnCall(i int, result1 string, result2 error) {
+	fake.getRootPoolMutex.Lock()
+	defer fake.getRootPoolMutex.Unlock()
+	fake.GetRootPoolStub = nil
+	if fake.getRootPoolReturnsOnCall == nil {
+		fake.getRootPoolReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.getRootPoolReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) GetRuntimeInfo() (*lxf.RuntimeInfo, error) {
 	fake.getRuntimeInfoMutex.Lock()
 	ret, specificReturn := fake.getRuntimeInfoReturnsOnCall[len(fake.getRuntimeInfoArgsForCall)]
@@ -910,16 +984,17 @@ func (fake *FakeClient) NewSandboxReturnsOnCall(i int, result1 *lxf.Sandbox) {
 	}{result1}
 }
 
-func (fake *FakeClient) PullImage(arg1 string) (string, error) {
+func (fake *FakeClient) PullImage(arg1 string, arg2 func(string)) (string, error) {
 	fake.pullImageMutex.Lock()
 	ret, specificReturn := fake.pullImageReturnsOnCall[len(fake.pullImageArgsForCall)]
 	fake.pullImageArgsForCall = append(fake.pullImageArgsForCall, struct {
 		arg1 string
-	}{arg1})
-	fake.recordInvocation("PullImage", []interface{}{arg1})
+		arg2 func(string)
+	}{arg1, arg2})
+	fake.recordInvocation("PullImage", []interface{}{arg1, arg2})
 	fake.pullImageMutex.Unlock()
 	if fake.PullImageStub != nil {
-		return fake.PullImageStub(arg1)
+		return fake.PullImageStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -934,17 +1009,17 @@ func (fake *FakeClient) PullImageCallCount() int {
 	return len(fake.pullImageArgsForCall)
 }
 
-func (fake *FakeClient) PullImageCalls(stub func(string) (string, error)) {
+func (fake *FakeClient) PullImageCalls(stub func(string, func(string)) (string, error)) {
 	fake.pullImageMutex.Lock()
 	defer fake.pullImageMutex.Unlock()
 	fake.PullImageStub = stub
 }
 
-func (fake *FakeClient) PullImageArgsForCall(i int) string {
+func (fake *FakeClient) PullImageArgsForCall(i int) (string, func(string)) {
 	fake.pullImageMutex.RLock()
 	defer fake.pullImageMutex.RUnlock()
 	argsForCall := fake.pullImageArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeClient) PullImageReturns(result1 string, result2 error) {
@@ -1075,6 +1150,8 @@ func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	defer fake.getFSPoolUsageMutex.RUnlock()
 	fake.getImageMutex.RLock()
 	defer fake.getImageMutex.RUnlock()
+	fake.getRootPoolMutex.RLock()
+	defer fake.getRootPoolMutex.RUnlock()
 	fake.getRuntimeInfoMutex.RLock()
 	defer fake.getRuntimeInfoMutex.RUnlock()
 	fake.getSandboxMutex.RLock()
@@ -52,6 +52,17 @@ func (d *Daemon) init() error {
 	return nil
 }
 
+// ToggleDrain switches the drain mode, where new pods and containers are refused while the existing ones keep running,
+// and returns whether it's draining now
+func (d *Daemon) ToggleDrain() bool {
+	return d.cri.ToggleDrain()
+}
+
+// Reload applies the config next as far as possible without restart
+func (d *Daemon) Reload(next *Config) {
+	d.cri.Reload(next)
+}
+
 // Kill signals the daemon that we want to shutdown, and that any work
 // initiated from this point (e.g. database queries over gRPC) should not be
 // retried in case of failure.
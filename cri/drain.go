@@ -0,0 +1,60 @@
+package cri
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// reasonDraining is reported in the runtime status while the node is drained
+const reasonDraining = "Draining"
+
+// drainMode refuses new workloads during node maintenance, while the existing ones keep running
+type drainMode struct {
+	mu       sync.Mutex
+	draining bool
+	since    time.Time
+}
+
+// Set enables or disables the drain mode
+func (d *drainMode) Set(draining bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining == draining {
+		return
+	}
+
+	d.draining = draining
+	d.since = time.Now()
+
+	if draining {
+		logger.Warn("draining, refusing new pods and containers")
+	} else {
+		logger.Info("stopped draining, accepting new pods and containers")
+	}
+}
+
+// Toggle switches the drain mode and returns whether it's draining now
+func (d *drainMode) Toggle() bool {
+	d.mu.Lock()
+	draining := !d.draining
+	d.mu.Unlock()
+
+	d.Set(draining)
+
+	return draining
+}
+
+// Draining returns whether new workloads are refused and since when
+func (d *drainMode) Draining() (bool, time.Time) {
+	if d == nil {
+		return false, time.Time{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining, d.since
+}
@@ -0,0 +1,56 @@
+package cri
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestDrainMode_Toggle(t *testing.T) {
+	t.Parallel()
+
+	d := &drainMode{}
+
+	draining, _ := d.Draining()
+	assert.False(t, draining)
+
+	assert.True(t, d.Toggle())
+
+	draining, since := d.Draining()
+	assert.True(t, draining)
+	assert.False(t, since.IsZero())
+
+	assert.False(t, d.Toggle())
+
+	draining, _ = d.Draining()
+	assert.False(t, draining)
+}
+
+func TestRuntimeServer_Draining(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	s.drain = &drainMode{}
+	s.drain.Set(true)
+
+	_, err := s.RunPodSandbox(context.Background(), &rtApi.RunPodSandboxRequest{})
+	assert.True(t, errors.Is(err, ErrDraining))
+
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{})
+	assert.True(t, errors.Is(err, ErrDraining))
+
+	resp, err := s.Status(context.Background(), &rtApi.StatusRequest{})
+	assert.NoError(t, err)
+	assert.False(t, resp.GetStatus().GetConditions()[0].GetStatus())
+	assert.Equal(t, reasonDraining, resp.GetStatus().GetConditions()[0].GetReason())
+
+	// leaving drain mode restores normal operation
+	s.drain.Set(false)
+
+	resp, err = s.Status(context.Background(), &rtApi.StatusRequest{})
+	assert.NoError(t, err)
+	assert.True(t, resp.GetStatus().GetConditions()[0].GetStatus())
+}
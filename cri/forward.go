@@ -0,0 +1,68 @@
+package cri
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// forwardCloseGrace is how long a port forwarding process may take to exit after its stream closed, before it's killed
+const forwardCloseGrace = 5 * time.Second
+
+// forwardProcesses tracks the running port forwarding processes, so none outlives its stream or LXE
+type forwardProcesses struct {
+	mu    sync.Mutex
+	procs map[*exec.Cmd]struct{}
+}
+
+// Add tracks the started process
+func (f *forwardProcesses) Add(cmd *exec.Cmd) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.procs == nil {
+		f.procs = map[*exec.Cmd]struct{}{}
+	}
+
+	f.procs[cmd] = struct{}{}
+}
+
+// Remove stops tracking the process after it was waited for
+func (f *forwardProcesses) Remove(cmd *exec.Cmd) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.procs, cmd)
+}
+
+// KillAfter kills the process if it's still tracked after the grace period
+func (f *forwardProcesses) KillAfter(cmd *exec.Cmd, grace time.Duration) {
+	time.AfterFunc(grace, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if _, has := f.procs[cmd]; has {
+			logger.Warnf("Killing port forwarding process %v, it didn't exit within %v after its stream closed", cmd.Process.Pid, grace)
+			f.kill(cmd)
+		}
+	})
+}
+
+// KillAll kills all tracked processes
+func (f *forwardProcesses) KillAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for cmd := range f.procs {
+		f.kill(cmd)
+	}
+}
+
+func (f *forwardProcesses) kill(cmd *exec.Cmd) {
+	err := cmd.Process.Kill()
+	if err != nil {
+		logger.Debugf("Couldn't kill port forwarding process %v: %v", cmd.Process.Pid, err)
+	}
+}
@@ -0,0 +1,70 @@
+package cri
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startSleep(t *testing.T) *exec.Cmd {
+	cmd := exec.Command("sleep", "60")
+	assert.NoError(t, cmd.Start())
+
+	return cmd
+}
+
+func TestForwardProcesses_KillAfter(t *testing.T) {
+	t.Parallel()
+
+	f := &forwardProcesses{}
+
+	cmd := startSleep(t)
+	f.Add(cmd)
+	assert.Equal(t, 1, len(f.procs))
+
+	f.KillAfter(cmd, time.Millisecond)
+	assert.Error(t, cmd.Wait())
+
+	f.Remove(cmd)
+	assert.Equal(t, 0, len(f.procs))
+}
+
+func TestForwardProcesses_KillAfterRemoved(t *testing.T) {
+	t.Parallel()
+
+	f := &forwardProcesses{}
+
+	cmd := startSleep(t)
+	f.Add(cmd)
+	f.Remove(cmd)
+
+	// not tracked anymore, so it's left alone
+	f.KillAfter(cmd, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, cmd.ProcessState)
+
+	assert.NoError(t, cmd.Process.Kill())
+	assert.Error(t, cmd.Wait())
+}
+
+func TestForwardProcesses_KillAll(t *testing.T) {
+	t.Parallel()
+
+	f := &forwardProcesses{}
+
+	cmds := []*exec.Cmd{startSleep(t), startSleep(t)}
+	for _, cmd := range cmds {
+		f.Add(cmd)
+	}
+
+	f.KillAll()
+
+	for _, cmd := range cmds {
+		assert.Error(t, cmd.Wait())
+		f.Remove(cmd)
+	}
+
+	assert.Equal(t, 0, len(f.procs))
+}
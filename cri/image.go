@@ -1,6 +1,7 @@
 package cri
 
 import (
+	"expvar"
 	"time"
 
 	"github.com/automaticserver/lxe/lxf"
@@ -19,6 +20,8 @@ type ImageServer struct {
 	criConfig     *Config
 	runtimeRemote string
 	lxf           lxf.Client
+	// pulls has a slot for each image pull which may run at the same time, nil if they aren't limited
+	pulls chan struct{}
 }
 
 // NewImageServer returns a new ImageServer backed by LXD
@@ -26,12 +29,16 @@ type ImageServer struct {
 func NewImageServer(s *RuntimeServer, lxf lxf.Client) (*ImageServer, error) {
 	i := ImageServer{
 		lxdConfig: s.lxdConfig,
-		criConfig: s.criConfig,
+		criConfig: s.criConfig(),
 		lxf:       lxf,
 	}
 	// apply default image remote
 	i.runtimeRemote = i.lxdConfig.DefaultRemote
 
+	if i.criConfig.LXEImagePullConcurrency > 0 {
+		i.pulls = make(chan struct{}, i.criConfig.LXEImagePullConcurrency)
+	}
+
 	configPath, err := getLXDConfigPath(i.criConfig)
 	if err != nil {
 		return nil, err
@@ -42,7 +49,7 @@ func NewImageServer(s *RuntimeServer, lxf lxf.Client) (*ImageServer, error) {
 		return nil, err
 	}
 
-	i.lxdConfig.DefaultRemote = s.criConfig.LXDImageRemote
+	i.lxdConfig.DefaultRemote = s.criConfig().LXDImageRemote
 
 	return &i, nil
 }
@@ -116,7 +123,17 @@ func (s ImageServer) ImageStatus(ctx context.Context, req *rtApi.ImageStatusRequ
 func (s ImageServer) PullImage(ctx context.Context, req *rtApi.PullImageRequest) (*rtApi.PullImageResponse, error) {
 	logger.Debugf("PullImage(%v) triggered", req)
 
-	hash, err := s.lxf.PullImage(req.GetImage().GetImage())
+	release, err := s.acquirePull(ctx, req.GetImage().GetImage())
+	if err != nil {
+		logger.Errorf("failed to pull image %v while waiting for other pulls, %v", req.GetImage().GetImage(), err)
+		return nil, err
+	}
+	defer release()
+
+	progress := newPullProgress(req.GetImage().GetImage())
+	defer progress.done()
+
+	hash, err := s.lxf.PullImage(req.GetImage().GetImage(), progress.update)
 	if err != nil {
 		logger.Errorf("failed to pull image %v, %v", req.GetImage().GetImage(), err)
 		return nil, err
@@ -131,6 +148,78 @@ func (s ImageServer) PullImage(ctx context.Context, req *rtApi.PullImageRequest)
 	return response, nil
 }
 
+// acquirePull waits until the image may be pulled if the concurrent pulls are limited. The waiting pulls are counted in
+// the metrics. It returns the function to call once the pull is done.
+func (s ImageServer) acquirePull(ctx context.Context, image string) (func(), error) {
+	if s.pulls == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-s.pulls }
+
+	select {
+	case s.pulls <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	logger.Infof("PullImage: image %v waits, %v images are being pulled already", image, cap(s.pulls))
+
+	metricImagePullQueue.Add(1)
+	defer metricImagePullQueue.Add(-1)
+
+	select {
+	case s.pulls <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pullProgressLogInterval is the minimum time between two progress logs of an image pull
+const pullProgressLogInterval = 10 * time.Second
+
+// pullProgress reports the progress of an image pull in the logs and the metrics, so slow pulls can be told apart
+// from hanging ones
+type pullProgress struct {
+	image   string
+	started time.Time
+	logged  time.Time
+	metric  *expvar.String
+}
+
+func newPullProgress(image string) *pullProgress {
+	p := &pullProgress{
+		image:   image,
+		started: time.Now(),
+		metric:  new(expvar.String),
+	}
+
+	p.metric.Set("started")
+	metricImagePulls.Set(image, p.metric)
+	logger.Infof("PullImage: pulling image %v", image)
+
+	return p
+}
+
+// update records the download progress as reported by lxd
+func (p *pullProgress) update(progress string) {
+	p.metric.Set(progress)
+
+	if time.Since(p.logged) < pullProgressLogInterval {
+		return
+	}
+
+	p.logged = time.Now()
+	logger.Infof("PullImage: pulling image %v, downloaded %v after %v", p.image, progress, time.Since(p.started).Round(time.Second))
+}
+
+// done removes the pull from the metrics
+func (p *pullProgress) done() {
+	metricImagePulls.Delete(p.image)
+	logger.Infof("PullImage: pulling image %v finished after %v", p.image, time.Since(p.started).Round(time.Second))
+}
+
 // RemoveImage removes the image.
 // This call is idempotent, and must not return an error if the image has
 // already been removed.
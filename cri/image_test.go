@@ -2,7 +2,12 @@ package cri
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/automaticserver/lxe/cri/crifakes"
 	"github.com/stretchr/testify/assert"
@@ -34,6 +39,94 @@ func TestImageServer_PullImage(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, fake.PullImageCallCount())
-	assert.Equal(t, "an/image", fake.PullImageArgsForCall(0))
+	image, _ := fake.PullImageArgsForCall(0)
+	assert.Equal(t, "an/image", image)
 	assert.Equal(t, "something", resp.ImageRef)
 }
+
+func TestImageServer_PullImage_Progress(t *testing.T) {
+	s, fake := testImageServer()
+
+	fake.PullImageStub = func(name string, progress func(string)) (string, error) {
+		progress("45% (12.3MB/s)")
+		assert.Equal(t, "45% (12.3MB/s)", metricImagePulls.Get("progress/image").(*expvar.String).Value())
+
+		return "something", nil
+	}
+
+	_, err := s.PullImage(ctx, &rtApi.PullImageRequest{
+		Image: &rtApi.ImageSpec{
+			Image: "progress/image",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Nil(t, metricImagePulls.Get("progress/image"))
+}
+
+func TestImageServer_PullImage_Concurrency(t *testing.T) {
+	s, fake := testImageServer()
+	s.pulls = make(chan struct{}, 2)
+
+	var (
+		mu            sync.Mutex
+		active, most  int
+		finishPulling = make(chan struct{})
+	)
+
+	fake.PullImageStub = func(name string, progress func(string)) (string, error) {
+		mu.Lock()
+		active++
+		if active > most {
+			most = active
+		}
+		mu.Unlock()
+
+		<-finishPulling
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		return "something", nil
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := s.PullImage(ctx, &rtApi.PullImageRequest{Image: &rtApi.ImageSpec{Image: fmt.Sprintf("concurrent/image%v", i)}})
+			assert.NoError(t, err)
+		}(i)
+	}
+
+	// two pull, three wait
+	assert.Eventually(t, func() bool {
+		return fake.PullImageCallCount() == 2 && metricImagePullQueue.Value() == 3
+	}, 5*time.Second, time.Millisecond)
+
+	close(finishPulling)
+	wg.Wait()
+
+	assert.Equal(t, 5, fake.PullImageCallCount())
+	assert.Equal(t, 2, most)
+	assert.Equal(t, int64(0), metricImagePullQueue.Value())
+}
+
+func TestImageServer_PullImage_CanceledWhileWaiting(t *testing.T) {
+	s, fake := testImageServer()
+	s.pulls = make(chan struct{}, 1)
+	s.pulls <- struct{}{}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.PullImage(canceled, &rtApi.PullImageRequest{Image: &rtApi.ImageSpec{Image: "waiting/image"}})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, fake.PullImageCallCount())
+	assert.Equal(t, int64(0), metricImagePullQueue.Value())
+}
@@ -0,0 +1,106 @@
+package cri
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxNetworkLeaks is how many failed network teardowns are kept, the oldest are forgotten first
+const maxNetworkLeaks = 1000
+
+// networkLeak is a network teardown of a pod or container which failed after all retries, so its ip allocation may
+// have leaked
+type networkLeak struct {
+	ID     string    `json:"id"`
+	Action string    `json:"action"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// networkLeaks keeps track of the failed network teardowns, so operators can release what they left behind. A later
+// successful teardown of the same pod or container resolves it.
+type networkLeaks struct {
+	mu    sync.Mutex
+	leaks map[string]networkLeak
+}
+
+func newNetworkLeaks() *networkLeaks {
+	return &networkLeaks{leaks: map[string]networkLeak{}}
+}
+
+// Record remembers the failed teardown of the pod or container with the id
+func (l *networkLeaks) Record(id, action string, err error) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, has := l.leaks[id]; !has && len(l.leaks) >= maxNetworkLeaks {
+		delete(l.leaks, l.oldest())
+	}
+
+	l.leaks[id] = networkLeak{ID: id, Action: action, Error: err.Error(), Time: time.Now()}
+}
+
+// Resolve forgets a failed teardown of the pod or container with the id, as it was torn down now
+func (l *networkLeaks) Resolve(id string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.leaks, id)
+}
+
+// List returns the failed teardowns, the oldest first
+func (l *networkLeaks) List() []networkLeak {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]networkLeak, 0, len(l.leaks))
+	for _, leak := range l.leaks {
+		list = append(list, leak)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+
+	return list
+}
+
+// Info formats the failed teardowns for the status info, empty if there are none
+func (l *networkLeaks) Info() string {
+	list := l.List()
+	if len(list) == 0 {
+		return ""
+	}
+
+	// marshalling strings and times can't fail
+	b, _ := json.Marshal(list)
+
+	return string(b)
+}
+
+// oldest returns the id of the oldest failed teardown
+func (l *networkLeaks) oldest() string {
+	id := ""
+
+	var at time.Time
+
+	for _, leak := range l.leaks {
+		if id == "" || leak.Time.Before(at) {
+			id, at = leak.ID, leak.Time
+		}
+	}
+
+	return id
+}
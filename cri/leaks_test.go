@@ -0,0 +1,53 @@
+package cri
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkLeaks(t *testing.T) {
+	t.Parallel()
+
+	l := newNetworkLeaks()
+	assert.Empty(t, l.Info())
+
+	l.Record("pod", "stop", errors.New("cni failed"))
+	l.Record("container", "delete", errors.New("cni failed"))
+	l.Record("pod", "delete", errors.New("cni timed out"))
+
+	list := l.List()
+	assert.Len(t, list, 2)
+	assert.Equal(t, "container", list[0].ID)
+	assert.Equal(t, "pod", list[1].ID)
+	assert.Equal(t, "delete", list[1].Action)
+	assert.Equal(t, "cni timed out", list[1].Error)
+	assert.Contains(t, l.Info(), `"id":"pod"`)
+
+	l.Resolve("pod")
+	l.Resolve("unknown")
+	assert.Len(t, l.List(), 1)
+
+	// does nothing if not tracking
+	var none *networkLeaks
+	none.Record("pod", "delete", errors.New("cni failed"))
+	none.Resolve("pod")
+	assert.Empty(t, none.Info())
+}
+
+func TestNetworkLeaks_Max(t *testing.T) {
+	t.Parallel()
+
+	l := newNetworkLeaks()
+
+	for i := 0; i <= maxNetworkLeaks; i++ {
+		l.Record(fmt.Sprintf("pod%v", i), "delete", errors.New("cni failed"))
+	}
+
+	list := l.List()
+	assert.Len(t, list, maxNetworkLeaks)
+	assert.NotContains(t, l.Info(), `"id":"pod0"`)
+	assert.Equal(t, fmt.Sprintf("pod%v", maxNetworkLeaks), list[len(list)-1].ID)
+}
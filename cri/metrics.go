@@ -0,0 +1,51 @@
+package cri
+
+import (
+	"expvar"
+)
+
+// Metrics of LXE. They are published by expvar and reported in the verbose runtime status.
+var (
+	metrics = expvar.NewMap("lxe")
+	// metricNetworkTeardownFailures counts network teardowns which failed after all retries
+	metricNetworkTeardownFailures = newMetricInt("network_teardown_failures")
+	// metricClusterUnavailable counts requests which failed because the lxd cluster was unavailable
+	metricClusterUnavailable = newMetricInt("cluster_unavailable")
+	// metricPodIPChanges counts pods whose reported ip changed
+	metricPodIPChanges = newMetricInt("pod_ip_changes")
+	// metricSandboxDrifts counts differences found between sandboxes and lxd by the periodic verification
+	metricSandboxDrifts = newMetricInt("sandbox_drifts")
+	// metricPrunedItems counts the containers, sandboxes and proxy devices removed by pruning
+	metricPrunedItems = newMetricInt("pruned_items")
+	// metricImagePulls holds the download progress of each image being pulled
+	metricImagePulls = newMetricMap("image_pulls")
+	// metricImagePullQueue is the number of image pulls waiting for others to finish
+	metricImagePullQueue = newMetricInt("image_pull_queue")
+	// metricWebhookDropped counts container events which couldn't be posted to the webhook
+	metricWebhookDropped = newMetricInt("webhook_dropped")
+)
+
+func newMetricInt(name string) *expvar.Int {
+	v := new(expvar.Int)
+	metrics.Set(name, v)
+
+	return v
+}
+
+func newMetricMap(name string) *expvar.Map {
+	v := new(expvar.Map).Init()
+	metrics.Set(name, v)
+
+	return v
+}
+
+// metricsInfo returns all metrics as flat map
+func metricsInfo() map[string]string {
+	info := map[string]string{}
+
+	metrics.Do(func(kv expvar.KeyValue) {
+		info["metrics."+kv.Key] = kv.Value.String()
+	})
+
+	return info
+}
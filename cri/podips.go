@@ -0,0 +1,67 @@
+package cri
+
+import (
+	"sync"
+
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// podIPs remembers the ip last reported for each sandbox, so the status requests of kubelet can tell ip changes without
+// writing to lxd. The ip is saved in the sandbox when one of its containers is started, to tell changes after a restart
+// of LXE too.
+type podIPs struct {
+	mu  sync.Mutex
+	ips map[string]string
+}
+
+func newPodIPs() *podIPs {
+	return &podIPs{ips: map[string]string{}}
+}
+
+// Observe records ip as the ip of the sandbox. A change of the previously reported or saved ip is logged and counted,
+// as it may leave services with a stale ip.
+func (p *podIPs) Observe(sb *lxf.Sandbox, ip string) {
+	if p == nil || ip == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last, has := p.ips[sb.ID]
+	if !has {
+		last = sb.LastIP
+	}
+
+	if last != "" && last != ip {
+		metricPodIPChanges.Add(1)
+		logger.Warnf("PodSandboxStatus: SandboxID %v changed ip from %v to %v", sb.ID, last, ip)
+	}
+
+	p.ips[sb.ID] = ip
+}
+
+// Last returns the ip last reported for the sandbox, empty if there is none
+func (p *podIPs) Last(id string) string {
+	if p == nil {
+		return ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ips[id]
+}
+
+// Forget removes the ip of a removed sandbox
+func (p *podIPs) Forget(id string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.ips, id)
+}
@@ -0,0 +1,39 @@
+package cri
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPodIPs(t *testing.T) {
+	// not parallel, as it reads a global metric
+	p := newPodIPs()
+	sb := testSandbox()
+	sb.ID = "sb"
+	changes := metricPodIPChanges.Value()
+
+	p.Observe(sb, "10.0.0.5")
+	p.Observe(sb, "10.0.0.5")
+	p.Observe(sb, "")
+	assert.Equal(t, "10.0.0.5", p.Last("sb"))
+	assert.Equal(t, changes, metricPodIPChanges.Value())
+
+	p.Observe(sb, "10.0.0.6")
+	assert.Equal(t, "10.0.0.6", p.Last("sb"))
+	assert.Equal(t, changes+1, metricPodIPChanges.Value())
+
+	// after a restart of LXE, the ip saved in the sandbox is compared
+	p.Forget("sb")
+	assert.Equal(t, "", p.Last("sb"))
+
+	sb.LastIP = "10.0.0.6"
+	p.Observe(sb, "10.0.0.7")
+	assert.Equal(t, changes+2, metricPodIPChanges.Value())
+
+	// does nothing if not tracking
+	var none *podIPs
+	none.Observe(sb, "10.0.0.8")
+	none.Forget("sb")
+	assert.Equal(t, "", none.Last("sb"))
+}
@@ -0,0 +1,145 @@
+package cri
+
+import (
+	"context"
+	"time"
+
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/lxf/device"
+	"github.com/lxc/lxd/shared/logger"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// pruneSandboxes periodically prunes the resources of pods which kubelet left behind
+func (s RuntimeServer) pruneSandboxes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.prune(context.Background(), time.Now())
+	}
+}
+
+// prune removes what's left of pods which aren't ready anymore: their containers which exited longer than the
+// retention ago, the pods themselves once they have no containers and were created longer than the retention ago, and
+// the proxy devices of the pods which remain, so they don't take host ports if a container is started manually. Ready
+// pods are left alone, as kubelet still uses their exited containers, e.g. for the restart count. Every pruned item is
+// logged, and with dry run only logged. It returns the number of pruned items.
+func (s RuntimeServer) prune(ctx context.Context, now time.Time) int {
+	cfg := s.criConfig()
+
+	sbs, err := s.lxf.ListSandboxes()
+	if err != nil {
+		logger.Errorf("prune: trying to list sandboxes: %v", err)
+		return 0
+	}
+
+	pruned := 0
+
+	for _, sb := range sbs {
+		if sb.State == lxf.SandboxReady {
+			continue
+		}
+
+		cl, err := sb.Containers()
+		if err != nil {
+			logger.Errorf("prune: SandboxID %v trying to list containers: %v", sb.ID, err)
+			continue
+		}
+
+		remaining := 0
+
+		for _, c := range cl {
+			if c.StateName != lxf.ContainerStateExited || c.FinishedAt.IsZero() || now.Sub(c.FinishedAt) < cfg.LXEPruneRetention {
+				remaining++
+				continue
+			}
+
+			if s.pruneItem(cfg.LXEPruneDryRun, "container %v of sandbox %v, exited at %v", []interface{}{c.ID, sb.ID, c.FinishedAt}, func() error {
+				return s.deleteContainer(ctx, c)
+			}) {
+				pruned++
+			} else {
+				remaining++
+			}
+		}
+
+		if remaining == 0 && now.Sub(sb.CreatedAt) >= cfg.LXEPruneRetention {
+			if s.pruneItem(cfg.LXEPruneDryRun, "sandbox %v without containers, created at %v", []interface{}{sb.ID, sb.CreatedAt}, func() error {
+				_, err := s.RemovePodSandbox(ctx, &rtApi.RemovePodSandboxRequest{PodSandboxId: sb.ID})
+				return err
+			}) {
+				pruned++
+			}
+
+			continue
+		}
+
+		pruned += s.pruneProxyDevices(cfg.LXEPruneDryRun, sb)
+	}
+
+	if !cfg.LXEPruneDryRun {
+		metricPrunedItems.Add(int64(pruned))
+	}
+
+	return pruned
+}
+
+// pruneProxyDevices removes the proxy devices of a sandbox which isn't ready and returns how many were pruned
+func (s RuntimeServer) pruneProxyDevices(dryRun bool, sb *lxf.Sandbox) int {
+	proxies := []string{}
+
+	for _, d := range sb.Devices {
+		if _, is := d.(*device.Proxy); is {
+			name, _ := d.ToMap()
+			proxies = append(proxies, name)
+		}
+	}
+
+	if len(proxies) == 0 {
+		return 0
+	}
+
+	if !s.pruneItem(dryRun, "proxy devices %v of sandbox %v", []interface{}{proxies, sb.ID}, func() error {
+		// listed sandboxes have no etag, so it's loaded again to be updated
+		sb, err := s.lxf.GetSandbox(sb.ID)
+		if err != nil {
+			return err
+		}
+
+		kept := device.Devices{}
+
+		for _, d := range sb.Devices {
+			if _, is := d.(*device.Proxy); !is {
+				kept = append(kept, d)
+			}
+		}
+
+		sb.Devices = kept
+
+		return sb.Apply()
+	}) {
+		return 0
+	}
+
+	return len(proxies)
+}
+
+// pruneItem logs the item and removes it unless it's a dry run. It returns whether the item was, or with dry run would
+// have been, pruned.
+func (s RuntimeServer) pruneItem(dryRun bool, format string, args []interface{}, remove func() error) bool {
+	if dryRun {
+		logger.Infof("prune (dry run): would remove "+format, args...)
+		return true
+	}
+
+	err := remove()
+	if err != nil {
+		logger.Errorf("prune: trying to remove "+format+": %v", append(args, err)...)
+		return false
+	}
+
+	logger.Infof("prune: removed "+format, args...)
+
+	return true
+}
@@ -7,9 +7,10 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os/exec"
-	"path"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/automaticserver/lxe/lxf"
 	"github.com/automaticserver/lxe/lxf/device"
@@ -34,8 +35,34 @@ const (
 )
 
 var (
-	ErrNotImplemented       = errors.New("not implemented")
-	ErrUnknownNetworkPlugin = errors.New("unknown network plugin")
+	ErrNotImplemented        = errors.New("not implemented")
+	ErrUnknownNetworkPlugin  = errors.New("unknown network plugin")
+	ErrUnknownSeccompProfile = errors.New("unknown seccomp profile")
+	ErrPolicy                = network.ErrPolicy // the same, so refusals of the network plugin match it too
+	ErrInvalidIdmap          = errors.New("invalid idmap")
+	ErrUnsupportedUnified    = errors.New("unsupported unified cgroup key")
+	ErrDuplicateMount        = errors.New("duplicate mount path")
+	ErrMountSourceMissing    = errors.New("mount source doesn't exist")
+	ErrInvalidConsoleBuffer  = errors.New("invalid console buffer size")
+	ErrUnsupportedIntercept  = errors.New("unsupported syscall intercept")
+	ErrInvalidTimeout        = errors.New("invalid timeout")
+	ErrInvalidMount          = errors.New("invalid mount")
+	ErrInvalidNameTemplate   = errors.New("invalid container name template")
+	ErrInvalidUlimit         = errors.New("invalid ulimit")
+	ErrDraining              = errors.New("node is draining")
+	ErrSandboxNotRunning     = errors.New("sandbox has no running container")
+	ErrTooManyContainers     = errors.New("too many containers in sandbox")
+	ErrInvalidProcessLimit   = errors.New("invalid process limit")
+	ErrHostPortInUse         = errors.New("host port already in use")
+	ErrInvalidIOLimit        = errors.New("invalid io limit")
+	ErrContainerCreating     = errors.New("container is still being created")
+	ErrContainerFrozen       = errors.New("container is frozen")
+	ErrMountCollision        = errors.New("mount source and path in image differ in type")
+	ErrUnknownMountCollision = errors.New("unknown mount collision policy")
+	ErrEmptyCommand          = errors.New("empty command")
+	ErrInvalidWebhookURL     = errors.New("invalid webhook url")
+	ErrWebhookRejected       = errors.New("webhook rejected the event")
+	ErrInvalidDefaultKey     = errors.New("invalid default label or annotation key")
 )
 
 // streamService implements streaming.Runtime.
@@ -44,6 +71,8 @@ type streamService struct {
 	runtimeServer       *RuntimeServer // needed by Exec() endpoint
 	streamServer        streaming.Server
 	streamServerCloseCh chan struct{}
+	// forwards tracks the port forwarding processes
+	forwards *forwardProcesses
 }
 
 // RuntimeServer is the PoC implementation of the CRI RuntimeServer
@@ -52,8 +81,40 @@ type RuntimeServer struct {
 	lxf       lxf.Client
 	stream    streamService
 	lxdConfig *config.Config
-	criConfig *Config
+	config    *configHolder
 	network   network.Plugin
+	// containers caches the container listing for status requests
+	containers *containerCache
+	// execSyncs caches the results of synchronous execs
+	execSyncs *execSyncCache
+	// imageSizes caches the size of the images of containers
+	imageSizes *imageSizeCache
+	// consoleBufferSize of containers in bytes, 0 keeps lxc's default
+	consoleBufferSize int64
+	// containerName renders the name prefix of new containers, nil keeps opaque ids
+	containerName *template.Template
+	// cluster refuses requests while the lxd cluster is unavailable
+	cluster *clusterGuard
+	// drain refuses new workloads during node maintenance
+	drain *drainMode
+	// webhook posts the lifecycle events of containers, nil if no webhook is configured
+	webhook *webhookNotifier
+	// networkLeaks keeps the network teardowns which failed
+	networkLeaks *networkLeaks
+	// podIPs remembers the ips last reported for the sandboxes
+	podIPs *podIPs
+}
+
+// criConfig returns the current config. Load it once for settings which belong together, as it may be reloaded
+// meanwhile.
+func (s RuntimeServer) criConfig() *Config {
+	return s.config.Load()
+}
+
+// Reload applies the settings of next which can be changed at runtime, and returns the names of the ones which need a
+// restart but differ
+func (s RuntimeServer) Reload(next *Config) []string {
+	return s.config.Reload(next)
 }
 
 // NewRuntimeServer returns a new RuntimeServer backed by LXD
@@ -61,8 +122,8 @@ func NewRuntimeServer(criConfig *Config, lxf lxf.Client, network network.Plugin)
 	var err error
 
 	runtime := RuntimeServer{
-		criConfig: criConfig,
-		network:   network,
+		config:  newConfigHolder(criConfig),
+		network: network,
 	}
 
 	configPath, err := getLXDConfigPath(criConfig)
@@ -75,7 +136,54 @@ func NewRuntimeServer(criConfig *Config, lxf lxf.Client, network network.Plugin)
 		return nil, err
 	}
 
+	runtime.consoleBufferSize, err = parseConsoleBufferSize(criConfig.LXEConsoleBufferSize)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.containerName, err = parseContainerNameTemplate(criConfig.LXEContainerNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateMountCollision(criConfig.LXEMountCollision)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateAllowRawIdmap(criConfig.LXEAllowRawIdmap)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateDefaultKeys(criConfig.LXEDefaultLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateDefaultKeys(criConfig.LXEDefaultAnnotations)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.webhook, err = newWebhookNotifier(criConfig.LXEWebhookURL, criConfig.LXEWebhookRetries, criConfig.LXEWebhookRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := lxf.GetRuntimeInfo()
+	if err != nil {
+		return nil, err
+	}
+
 	runtime.lxf = lxf
+	runtime.cluster = newClusterGuard(clusterUnavailableBackoff, info.Clustered)
+	runtime.drain = &drainMode{}
+	runtime.networkLeaks = newNetworkLeaks()
+	runtime.podIPs = newPodIPs()
+	runtime.containers = newContainerCache(lxf, containerStatusCacheTTL)
+	runtime.execSyncs = newExecSyncCache(criConfig.LXEExecSyncCacheTTL)
+	runtime.imageSizes = newImageSizeCache(lxf)
 	streamServerAddr := criConfig.LXEStreamingServerEndpoint + ":" + strconv.Itoa(criConfig.LXEStreamingPort)
 
 	outboundIP, err := utilNet.ChooseHostInterface()
@@ -85,13 +193,18 @@ func NewRuntimeServer(criConfig *Config, lxf lxf.Client, network network.Plugin)
 	}
 
 	// Prepare streaming server
-	streamServerConfig := streaming.DefaultConfig
+	streamServerConfig, err := streamingConfig(criConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	streamServerConfig.Addr = streamServerAddr
 	streamServerConfig.BaseURL = &url.URL{
 		Scheme: "http",
 		Host:   outboundIP.String() + ":" + strconv.Itoa(criConfig.LXEStreamingPort),
 	}
 	runtime.stream.runtimeServer = &runtime
+	runtime.stream.forwards = &forwardProcesses{}
 
 	runtime.stream.streamServer, err = streaming.NewServer(streamServerConfig, runtime.stream)
 	if err != nil {
@@ -111,6 +224,14 @@ func NewRuntimeServer(criConfig *Config, lxf lxf.Client, network network.Plugin)
 		}
 	}()
 
+	if criConfig.LXESandboxVerifyInterval > 0 {
+		go runtime.verifySandboxes(criConfig.LXESandboxVerifyInterval)
+	}
+
+	if criConfig.LXEPruneInterval > 0 {
+		go runtime.pruneSandboxes(criConfig.LXEPruneInterval)
+	}
+
 	return &runtime, nil
 }
 
@@ -147,9 +268,61 @@ func (s RuntimeServer) RunPodSandbox(ctx context.Context, req *rtApi.RunPodSandb
 
 	var err error
 
+	if draining, since := s.drain.Draining(); draining {
+		logger.Errorf("RunPodSandbox: SandboxName %v refused: %v since %v", req.GetConfig().GetMetadata().GetName(), ErrDraining, since)
+		return nil, fmt.Errorf("RunPodSandbox: %w", ErrDraining)
+	}
+
+	// load the config once, so the request doesn't see a mix of settings if it's reloaded meanwhile
+	cfg := s.criConfig()
+
+	// kubelet retries if the sandbox wasn't created within its timeout, so it may exist already
+	existing, err := s.existingSandbox(req.GetConfig().GetMetadata())
+	if err != nil {
+		logger.Errorf("RunPodSandbox: SandboxName %v trying to look up existing sandbox: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	if existing != nil {
+		if existing.State == lxf.SandboxReady {
+			logger.Infof("RunPodSandbox successful: SandboxID %v already exists for SandboxUID %v", existing.ID, req.GetConfig().GetMetadata().GetUid())
+			return &rtApi.RunPodSandboxResponse{PodSandboxId: existing.ID}, nil
+		}
+
+		logger.Warnf("RunPodSandbox: SandboxID %v already exists for SandboxUID %v but isn't ready, recreating it", existing.ID, req.GetConfig().GetMetadata().GetUid())
+
+		_, err = s.RemovePodSandbox(ctx, &rtApi.RemovePodSandboxRequest{PodSandboxId: existing.ID})
+		if err != nil {
+			logger.Errorf("RunPodSandbox: SandboxName %v trying to remove existing sandbox: %v", req.GetConfig().GetMetadata().GetName(), err)
+			return nil, err
+		}
+	}
+
+	// validate pod mounts early, they are applied to the containers later
+	_, err = s.podMounts(cfg, req.GetConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("RunPodSandbox: SandboxName %v trying to parse pod mounts: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.checkPrivileged(cfg, req.GetConfig().GetLinux().GetSecurityContext().GetPrivileged(), "pod")
+	if err != nil {
+		logger.Errorf("RunPodSandbox: SandboxName %v refused: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
 	sb := s.lxf.NewSandbox()
 
 	sb.Hostname = req.GetConfig().GetHostname()
+	if sb.Hostname == "" {
+		sb.Hostname = sanitizeHostname(req.GetConfig().GetMetadata().GetName())
+	}
+
+	sb.RuntimeHandler = req.GetRuntimeHandler()
+	if sb.RuntimeHandler == "" {
+		sb.RuntimeHandler = lxf.DefaultRuntimeHandler
+	}
+
 	sb.LogDirectory = req.GetConfig().GetLogDirectory()
 	meta := req.GetConfig().GetMetadata()
 	sb.Metadata = lxf.SandboxMetadata{
@@ -158,75 +331,68 @@ func (s RuntimeServer) RunPodSandbox(ctx context.Context, req *rtApi.RunPodSandb
 		Namespace: meta.GetNamespace(),
 		UID:       meta.GetUid(),
 	}
-	sb.Labels = req.GetConfig().GetLabels()
-	sb.Annotations = req.GetConfig().GetAnnotations()
-
-	if req.GetConfig().GetDnsConfig() != nil {
-		sb.NetworkConfig.Nameservers = req.GetConfig().GetDnsConfig().GetServers()
-		sb.NetworkConfig.Searches = req.GetConfig().GetDnsConfig().GetSearches()
-	}
+	sb.Labels = withDefaults(req.GetConfig().GetLabels(), cfg.LXEDefaultLabels)
+	sb.Annotations = withDefaults(req.GetConfig().GetAnnotations(), cfg.LXEDefaultAnnotations)
 
 	// Find out which network mode should be used
 	if strings.ToLower(req.GetConfig().GetLinux().GetSecurityContext().GetNamespaceOptions().GetNetwork().String()) == string(lxf.NetworkHost) {
 		// host network explicitly requested
 		sb.NetworkConfig.Mode = lxf.NetworkHost
-		lxf.AppendIfSet(&sb.Config, "raw.lxc", "lxc.include = "+s.criConfig.LXEHostnetworkFile)
+		lxf.AppendIfSet(&sb.Config, "raw.lxc", "lxc.include = "+cfg.LXEHostnetworkFile)
 	} else {
 		// manage network according to selected network plugin
 		// TODO: we could omit these since we use network plugin, but we still need to remember if it is HostNetwork
-		switch s.criConfig.LXENetworkPlugin {
+		switch cfg.LXENetworkPlugin {
 		case NetworkPluginDefault:
 			sb.NetworkConfig.Mode = lxf.NetworkBridged
 		case NetworkPluginCNI:
 			sb.NetworkConfig.Mode = lxf.NetworkCNI
 		default:
 			// unknown plugin name provided
-			err := fmt.Errorf("%w: %v", ErrUnknownNetworkPlugin, s.criConfig.LXENetworkPlugin)
+			err := fmt.Errorf("%w: %v", ErrUnknownNetworkPlugin, cfg.LXENetworkPlugin)
 			logger.Error(err.Error())
 			return nil, err
 		}
 	}
 
+	// kubelet resolves the dnsPolicy of the pod into the dns config: the cluster dns for ClusterFirst, the resolv.conf of
+	// the host for Default and only the dnsConfig of the pod for None. It's applied as is, without merging.
+	if dns := req.GetConfig().GetDnsConfig(); dns != nil {
+		sb.NetworkConfig.Nameservers = podNameservers(sb, dns.GetServers())
+		sb.NetworkConfig.Searches = dns.GetSearches()
+	}
+
 	// If HostPort is defined, set forwardings from that port to the container. In lxd, we can use proxy devices for that.
-	// This can be applied to all NetworkModes except HostNetwork.
-	if sb.NetworkConfig.Mode != lxf.NetworkHost {
+	// This can be applied to all NetworkModes except HostNetwork, where the ports are bound directly and only checked
+	// for conflicts.
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		ports, err := s.checkHostPorts(req.GetConfig().GetPortMappings())
+		if err != nil {
+			logger.Errorf("RunPodSandbox: SandboxName %v trying to check host ports: %v", req.GetConfig().GetMetadata().GetName(), err)
+			return nil, err
+		}
+
+		strs := make([]string, 0, len(ports))
+		for _, port := range ports {
+			strs = append(strs, port.String())
+		}
+
+		lxf.SetIfSet(&sb.Config, cfgSandboxHostPorts, strings.Join(strs, ","))
+	} else {
 		for _, portMap := range req.Config.PortMappings {
 			// both HostPort and ContainerPort must be defined, otherwise invalid
 			if portMap.GetHostPort() == 0 || portMap.GetContainerPort() == 0 {
 				continue
 			}
 
-			hostPort := int(portMap.GetHostPort())
-			containerPort := int(portMap.GetContainerPort())
-
-			var protocol device.Protocol
-
-			switch portMap.GetProtocol() { // nolint: exhaustive
-			case rtApi.Protocol_UDP:
-				protocol = device.ProtocolUDP
-			case rtApi.Protocol_TCP:
-				fallthrough
-			default:
-				protocol = device.ProtocolTCP
-			}
-
-			hostIP := portMap.GetHostIp()
-			if hostIP == "" {
-				hostIP = "0.0.0.0"
-			}
-
-			containerIP := "127.0.0.1"
+			listen := hostPortEndpoint(portMap)
 
 			sb.Devices.Upsert(&device.Proxy{
-				Listen: &device.ProxyEndpoint{
-					Protocol: protocol,
-					Address:  hostIP,
-					Port:     hostPort,
-				},
+				Listen: listen,
 				Destination: &device.ProxyEndpoint{
-					Protocol: protocol,
-					Address:  containerIP,
-					Port:     containerPort,
+					Protocol: listen.Protocol,
+					Address:  "127.0.0.1",
+					Port:     int(portMap.GetContainerPort()),
 				},
 			})
 		}
@@ -255,12 +421,13 @@ func (s RuntimeServer) RunPodSandbox(ctx context.Context, req *rtApi.RunPodSandb
 			}
 
 			if req.Config.Linux.SecurityContext.ReadonlyRootfs {
-				sb.Devices.Upsert(&device.Disk{
-					Path:     "/",
-					Readonly: true,
-					// TODO magic constant, and also, is it always default?
-					Pool: "default",
-				})
+				disk, err := s.readonlyRootDisk(cfg)
+				if err != nil {
+					logger.Errorf("RunPodSandbox: SandboxName %v trying to add readonly root disk: %v", req.GetConfig().GetMetadata().GetName(), err)
+					return nil, err
+				}
+
+				sb.Devices.Upsert(disk)
 			}
 
 			if req.Config.Linux.SecurityContext.RunAsUser != nil {
@@ -268,7 +435,7 @@ func (s RuntimeServer) RunPodSandbox(ctx context.Context, req *rtApi.RunPodSandb
 					strconv.FormatInt(req.Config.Linux.SecurityContext.RunAsUser.Value, 10)
 			}
 
-			lxf.SetIfSet(&sb.Config, "user.linux.security_context.seccomp_profile_path",
+			lxf.SetIfSet(&sb.Config, cfgSandboxSeccompProfilePath,
 				req.Config.Linux.SecurityContext.SeccompProfilePath)
 
 			if req.Config.Linux.SecurityContext.SelinuxOptions != nil {
@@ -288,53 +455,14 @@ func (s RuntimeServer) RunPodSandbox(ctx context.Context, req *rtApi.RunPodSandb
 		return nil, err
 	}
 
-	// create network
-	if sb.NetworkConfig.Mode != lxf.NetworkHost { // nolint: nestif
-		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
-		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("can't enter sandbox %v network context", sb.ID))
-			logger.Error(err.Error())
-
-			return nil, err
-		}
-
-		res, err := podNet.WhenCreated(ctx, &network.Properties{})
-		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("can't create sandbox %v network context", sb.ID))
-			logger.Error(err.Error())
-
-			return nil, err
-		}
-
-		err = s.handleNetworkResult(sb, res)
-		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("can't save create sandbox %v network result", sb.ID))
-			logger.Error(err.Error())
-
-			return nil, err
-		}
-
-		// Since a PodSandbox is created "started", also fire started network
-		res, err = podNet.WhenStarted(ctx, &network.PropertiesRunning{
-			Properties: network.Properties{
-				Data: sb.NetworkConfig.ModeData,
-			},
-			Pid: 0, // if we had real 1:n pod:container we would add here the pid of the pod process
-		})
-		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("can't start sandbox %v network context", sb.ID))
-			logger.Error(err.Error())
-
-			return nil, err
+	err = s.createSandboxNetwork(ctx, sb)
+	if err != nil {
+		// kubelet doesn't know the sandbox yet, so it can't remove it
+		if _, rmErr := s.RemovePodSandbox(ctx, &rtApi.RemovePodSandboxRequest{PodSandboxId: sb.ID}); rmErr != nil {
+			logger.Errorf("unable to remove sandbox %v after failed network creation: %v", sb.ID, rmErr)
 		}
 
-		err = s.handleNetworkResult(sb, res)
-		if err != nil {
-			err := errors.Wrap(err, fmt.Sprintf("can't save start sandbox %v network result", sb.ID))
-			logger.Error(err.Error())
-
-			return nil, err
-		}
+		return nil, err
 	}
 
 	logger.Infof("RunPodSandbox successful: Created SandboxID %v for SandboxUID %v", sb.ID, req.GetConfig().GetMetadata().GetUid())
@@ -369,7 +497,7 @@ func (s RuntimeServer) StopPodSandbox(ctx context.Context, req *rtApi.StopPodSan
 		return nil, err
 	}
 
-	err = s.stopContainers(sb)
+	err = s.stopContainers(sb, podGracePeriod(sb))
 	if err != nil {
 		logger.Errorf("StopPodSandbox: SandboxID %v Trying to stop containers: %v", req.GetPodSandboxId(), err)
 		return nil, err
@@ -381,12 +509,16 @@ func (s RuntimeServer) StopPodSandbox(ctx context.Context, req *rtApi.StopPodSan
 		return nil, err
 	}
 
-	// Stop networking
+	// Stop networking, failures must not fail the stop
 	if sb.NetworkConfig.Mode != lxf.NetworkHost {
-		netw, err := s.network.PodNetwork(sb.ID, sb.Annotations)
-		if err == nil { // force cleanup, we don't care about error, but only enter if there's no error
-			_ = netw.WhenStopped(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
-		}
+		s.retryNetworkTeardown(ctx, "stop", sb.ID, func(ctx context.Context) error {
+			netw, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+			if err != nil {
+				return err
+			}
+
+			return netw.WhenStopped(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
+		})
 	}
 
 	logger.Infof("StopPodSandbox successful: SandboxID %v", req.GetPodSandboxId())
@@ -416,7 +548,7 @@ func (s RuntimeServer) RemovePodSandbox(ctx context.Context, req *rtApi.RemovePo
 		return nil, err
 	}
 
-	err = s.stopContainers(sb)
+	err = s.stopContainers(sb, podGracePeriod(sb))
 	if err != nil {
 		logger.Errorf("RemovePodSandbox: SandboxID %v Trying to stop containers: %v", req.GetPodSandboxId(), err)
 		return nil, err
@@ -434,14 +566,20 @@ func (s RuntimeServer) RemovePodSandbox(ctx context.Context, req *rtApi.RemovePo
 		return nil, err
 	}
 
-	// Delete networking
+	// Delete networking, failures must not fail the removal
 	if sb.NetworkConfig.Mode != lxf.NetworkHost {
-		netw, err := s.network.PodNetwork(sb.ID, sb.Annotations)
-		if err == nil { // we don't care about error, but only enter if there's no error
-			_ = netw.WhenDeleted(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
-		}
+		s.retryNetworkTeardown(ctx, "delete", sb.ID, func(ctx context.Context) error {
+			netw, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+			if err != nil {
+				return err
+			}
+
+			return netw.WhenDeleted(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
+		})
 	}
 
+	s.podIPs.Forget(sb.ID)
+
 	logger.Infof("RemovePodSandbox successful: SandboxID %v", req.GetPodSandboxId())
 
 	response := &rtApi.RemovePodSandboxResponse{}
@@ -479,6 +617,7 @@ func (s RuntimeServer) PodSandboxStatus(ctx context.Context, req *rtApi.PodSandb
 			Network: &rtApi.PodSandboxNetworkStatus{
 				Ip: "",
 			},
+			RuntimeHandler: sb.RuntimeHandler,
 		},
 	}
 
@@ -504,6 +643,41 @@ func (s RuntimeServer) PodSandboxStatus(ctx context.Context, req *rtApi.PodSandb
 	ip := s.getInetAddress(ctx, sb)
 	if ip != "" {
 		response.Status.Network.Ip = ip
+
+		s.podIPs.Observe(sb, ip)
+	}
+
+	if req.GetVerbose() {
+		response.Info = map[string]string{}
+
+		response.Info["networkMode"] = networkModeInfo(sb.NetworkConfig.Mode)
+
+		netns := getNetNSPath(sb)
+		if netns != "" {
+			response.Info["netns"] = netns
+		}
+
+		if locations := sandboxLocations(sb); locations != "" {
+			response.Info["lxd.locations"] = locations
+		}
+
+		if qos := sandboxQOSClass(sb); qos != "" {
+			response.Info["qosClass"] = qos
+		}
+
+		drifts, err := sb.Verify()
+		if err != nil {
+			logger.Errorf("PodSandboxStatus: SandboxID %v trying to verify sandbox: %v", req.GetPodSandboxId(), err)
+		} else {
+			response.Info["drift"] = driftInfo(drifts)
+		}
+
+		profile, err := sb.Profile()
+		if err != nil {
+			logger.Errorf("PodSandboxStatus: SandboxID %v trying to get profile: %v", req.GetPodSandboxId(), err)
+		} else {
+			response.Info["profile"] = profileInfo(profile)
+		}
 	}
 
 	logger.Debugf("PodSandboxStatus responded: %v", response)
@@ -511,6 +685,16 @@ func (s RuntimeServer) PodSandboxStatus(ctx context.Context, req *rtApi.PodSandb
 	return response, nil
 }
 
+// inetInterfaces returns the container interfaces to look up the ip address of the sandbox in order of priority
+func (s RuntimeServer) inetInterfaces() []string {
+	interfaces := s.criConfig().LXEInetInterfaces
+	if len(interfaces) == 0 {
+		return []string{network.DefaultInterface}
+	}
+
+	return interfaces
+}
+
 // getInetAddress returns the ip address of the sandbox. empty string if nothing was found
 func (s RuntimeServer) getInetAddress(ctx context.Context, sb *lxf.Sandbox) string {
 	switch sb.NetworkConfig.Mode {
@@ -533,7 +717,12 @@ func (s RuntimeServer) getInetAddress(ctx context.Context, sb *lxf.Sandbox) stri
 			return ""
 		}
 
-		status, err := podNet.Status(ctx, &network.PropertiesRunning{Properties: network.Properties{Data: sb.NetworkConfig.ModeData}, Pid: 0})
+		var status *network.Status
+
+		err = s.retryNetwork(ctx, "status", sb.ID, func(ctx context.Context) error {
+			status, err = podNet.Status(ctx, &network.PropertiesRunning{Properties: network.Properties{Data: sb.NetworkConfig.ModeData}, Pid: 0})
+			return err
+		})
 		if err != nil {
 			logger.Errorf("Couldn't get status of cni pod network: %v", err)
 			return ""
@@ -557,8 +746,8 @@ func (s RuntimeServer) getInetAddress(ctx context.Context, sb *lxf.Sandbox) stri
 			continue
 		}
 
-		// get the ipv4 address of eth0
-		ip := c.GetInetAddress([]string{network.DefaultInterface})
+		// get the ipv4 address of the configured interfaces
+		ip := c.GetInetAddress(s.inetInterfaces())
 		if ip != "" {
 			return ip
 		}
@@ -571,13 +760,15 @@ func (s RuntimeServer) getInetAddress(ctx context.Context, sb *lxf.Sandbox) stri
 func (s RuntimeServer) ListPodSandbox(ctx context.Context, req *rtApi.ListPodSandboxRequest) (*rtApi.ListPodSandboxResponse, error) {
 	logger.Debugf("ListPodSandbox triggered: %v", req)
 
-	sandboxes, err := s.lxf.ListSandboxes()
+	sandboxes, err := s.listSandboxes(req.GetFilter().GetId())
 	if err != nil {
 		logger.Errorf("ListPodSandbox: Trying to list sandbox: %v", err)
 		return nil, err
 	}
 
-	response := &rtApi.ListPodSandboxResponse{}
+	response := &rtApi.ListPodSandboxResponse{
+		Items: make([]*rtApi.PodSandbox, 0, len(sandboxes)),
+	}
 
 	for _, sb := range sandboxes {
 		if req.GetFilter() != nil {
@@ -605,14 +796,16 @@ func (s RuntimeServer) ListPodSandbox(ctx context.Context, req *rtApi.ListPodSan
 				Namespace: sb.Metadata.Namespace,
 				Uid:       sb.Metadata.UID,
 			},
-			State:       stateSandboxAsCri(sb.State),
-			Labels:      sb.Labels,
-			Annotations: sb.Annotations,
+			State:          stateSandboxAsCri(sb.State),
+			Labels:         sb.Labels,
+			Annotations:    sb.Annotations,
+			RuntimeHandler: sb.RuntimeHandler,
 		}
 		response.Items = append(response.Items, &pod)
 	}
 
-	logger.Debugf("ListPodSandbox responded: %v", response)
+	// the whole response would be formatted even if debug messages are discarded
+	logger.Debugf("ListPodSandbox responded: %d sandboxes", len(response.Items))
 
 	return response, nil
 }
@@ -622,12 +815,21 @@ func (s RuntimeServer) CreateContainer(ctx context.Context, req *rtApi.CreateCon
 	logger.Infof("CreateContainer called: ContainerName %v for SandboxID %v", req.GetConfig().GetMetadata().GetName(), req.GetPodSandboxId())
 	logger.Debugf("CreateContainer triggered: %v", req)
 
+	if draining, since := s.drain.Draining(); draining {
+		logger.Errorf("CreateContainer: ContainerName %v refused: %v since %v", req.GetConfig().GetMetadata().GetName(), ErrDraining, since)
+		return nil, fmt.Errorf("CreateContainer: %w", ErrDraining)
+	}
+
+	cfg := s.criConfig()
+
+	defer s.containers.Invalidate()
+
 	var err error
 
-	c := s.lxf.NewContainer(req.GetPodSandboxId(), s.criConfig.LXDProfiles...)
+	c := s.lxf.NewContainer(req.GetPodSandboxId(), cfg.LXDProfiles...)
 
-	c.Labels = req.GetConfig().GetLabels()
-	c.Annotations = req.GetConfig().GetAnnotations()
+	c.Labels = withDefaults(req.GetConfig().GetLabels(), cfg.LXEDefaultLabels)
+	c.Annotations = withDefaults(req.GetConfig().GetAnnotations(), cfg.LXEDefaultAnnotations)
 	meta := req.GetConfig().GetMetadata()
 	c.Metadata = lxf.ContainerMetadata{
 		Attempt: meta.GetAttempt(),
@@ -635,25 +837,30 @@ func (s RuntimeServer) CreateContainer(ctx context.Context, req *rtApi.CreateCon
 	}
 	c.LogPath = req.GetConfig().GetLogPath()
 	c.Image = req.GetConfig().GetImage().GetImage()
+	c.NamePrefix = s.containerNamePrefix(req.GetSandboxConfig().GetMetadata(), meta.GetName())
 
-	for _, mnt := range req.GetConfig().GetMounts() {
-		hostPath := mnt.GetHostPath()
-		containerPath := mnt.GetContainerPath()
-		// cannot use /var/run as most distros symlink that to /run and lxd doesn't like mounts there because of that
-		if strings.HasPrefix(containerPath, "/var/run") {
-			containerPath = path.Join("/run", strings.TrimPrefix(containerPath, "/var/run"))
-		}
-		// cannot use /run as most distros mount a tmpfs on top of that so mounts from lxd are not visible in the container
-		if strings.HasPrefix(containerPath, "/run") {
-			containerPath = path.Join("/mnt", strings.TrimPrefix(containerPath, "/run"))
-		}
+	podMounts, err := s.podMounts(cfg, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to parse pod mounts: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
 
-		c.Devices.Upsert(&device.Disk{
-			Path:     containerPath,
-			Source:   hostPath,
-			Readonly: mnt.GetReadonly(),
-			Optional: false,
-		})
+	mounts := mergePodMounts(req.GetConfig().GetMounts(), podMounts, req.GetSandboxConfig().GetAnnotations())
+
+	err = ensureMountSources(mounts, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to check mount sources: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	disks, err := toDiskDevices(mounts, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to add mounts: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	for _, d := range disks {
+		c.Devices.Upsert(d)
 	}
 
 	for _, dev := range req.GetConfig().GetDevices() {
@@ -663,8 +870,51 @@ func (s RuntimeServer) CreateContainer(ctx context.Context, req *rtApi.CreateCon
 		})
 	}
 
+	if req.GetConfig().GetLinux().GetSecurityContext().GetReadonlyRootfs() {
+		disk, err := s.readonlyRootDisk(cfg)
+		if err != nil {
+			logger.Errorf("CreateContainer: ContainerName %v trying to add readonly root disk: %v", req.GetConfig().GetMetadata().GetName(), err)
+			return nil, err
+		}
+
+		c.Devices.Upsert(disk)
+	}
+
 	c.Privileged = req.GetConfig().GetLinux().GetSecurityContext().GetPrivileged()
 
+	sb, err := c.Sandbox()
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to get sandbox: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	// a pod which was created privileged before a reload disallowed it doesn't get further containers either
+	sbPrivileged, _ := strconv.ParseBool(sb.Config["security.privileged"])
+
+	err = s.checkPrivileged(cfg, c.Privileged || sbPrivileged, "container")
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v refused: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.checkContainerLimit(cfg, sb)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v refused: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.checkPostCreateHook(cfg, sb.Annotations)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v refused: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.applySeccompProfile(cfg, c, sb, req.GetConfig().GetLinux().GetSecurityContext().GetSeccompProfilePath())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply seccomp profile: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
 	// get metadata & cloud-init if defined
 	for _, env := range req.GetConfig().GetEnvs() {
 		switch {
@@ -674,6 +924,8 @@ func (s RuntimeServer) CreateContainer(ctx context.Context, req *rtApi.CreateCon
 			c.CloudInitMetaData = env.GetValue()
 		case env.GetKey() == "network-config":
 			c.CloudInitNetworkConfig = env.GetValue()
+		case env.GetKey() == "vendor-data":
+			c.CloudInitVendorData = env.GetValue()
 		default:
 			c.Environment[env.GetKey()] = env.GetValue()
 		}
@@ -698,41 +950,149 @@ func (s RuntimeServer) CreateContainer(ctx context.Context, req *rtApi.CreateCon
 		c.Resources.Memory.Limit = &resrc.MemoryLimitInBytes
 	}
 
-	err = c.Apply()
+	c.InstanceType = req.GetSandboxConfig().GetAnnotations()[annotationInstanceType]
+	c.Architecture = req.GetSandboxConfig().GetAnnotations()[annotationArchitecture]
+
+	if archive := req.GetSandboxConfig().GetAnnotations()[annotationRestoreFromPrefix+c.Metadata.Name]; archive != "" {
+		c.RestoreFrom, err = resolveRestoreFrom(archive, cfg.LXEAllowRestore)
+		if err != nil {
+			logger.Errorf("CreateContainer: ContainerName %v trying to restore from %v: %v", req.GetConfig().GetMetadata().GetName(), archive, err)
+			return nil, err
+		}
+	}
+
+	err = applyAutostart(c, req.GetSandboxConfig().GetAnnotations())
 	if err != nil {
-		logger.Errorf("CreateContainer: ContainerName %v trying to create container: %v", req.GetConfig().GetMetadata().GetName(), err)
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply autostart: %v", req.GetConfig().GetMetadata().GetName(), err)
 		return nil, err
 	}
 
-	sb, err := c.Sandbox()
+	err = s.applyNesting(cfg, c, req.GetSandboxConfig().GetAnnotations())
 	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply nesting: %v", req.GetConfig().GetMetadata().GetName(), err)
 		return nil, err
 	}
 
-	// create network
-	if sb.NetworkConfig.Mode != lxf.NetworkHost {
-		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+	err = applySyscallIntercepts(c, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply syscall intercepts: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applyNoNewPrivs(c, req.GetConfig().GetLinux().GetSecurityContext().GetNoNewPrivs())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to set no_new_privs: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.applyProcessLimit(cfg, c, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to limit processes: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applyIOLimits(c, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to limit io: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applyUlimits(c, req.GetSandboxConfig().GetAnnotations())
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply ulimits: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applyHostNamespaces(c, sb)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to share host namespaces: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applySharedPIDNamespace(c, sb)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to share pid namespace: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	applyOOMScoreAdj(c, sb, resrc.GetOomScoreAdj())
+
+	err = applyConsoleLog(c, req.GetSandboxConfig().GetAnnotations(), s.consoleBufferSize)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to configure console log: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = applyUnifiedResources(c, unifiedFromAnnotations(req.GetSandboxConfig().GetAnnotations()))
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply unified resources: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.applyRawIdmap(cfg, c, sb)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to apply idmap: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	if c.RestoreFrom != "" {
+		err = checkRestore(c, sb)
 		if err != nil {
+			logger.Errorf("CreateContainer: ContainerName %v trying to restore from %v: %v", req.GetConfig().GetMetadata().GetName(), c.RestoreFrom, err)
 			return nil, err
 		}
+	}
 
-		contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
-		if err != nil {
-			return nil, err
+	err = c.Apply()
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to create container: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = s.handleMountCollisions(cfg, c)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to check mount paths: %v", req.GetConfig().GetMetadata().GetName(), err)
+
+		// kubelet doesn't know the container yet, so it can't remove it
+		if delErr := s.deleteContainer(ctx, c); delErr != nil {
+			logger.Errorf("unable to remove container %v after failed mount check: %v", c.ID, delErr)
 		}
 
-		res, err := contNet.WhenCreated(ctx, &network.Properties{})
-		if err != nil {
-			return nil, err
+		return nil, err
+	}
+
+	err = s.createContainerNetwork(ctx, sb, c)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to create network: %v", req.GetConfig().GetMetadata().GetName(), err)
+
+		// kubelet doesn't know the container yet, so it can't remove it
+		if delErr := s.deleteContainer(ctx, c); delErr != nil {
+			logger.Errorf("unable to remove container %v after failed network creation: %v", c.ID, delErr)
 		}
 
-		err = s.handleNetworkResult(sb, res)
-		if err != nil {
-			return nil, err
+		return nil, err
+	}
+
+	err = s.runPostCreateHook(ctx, c, sb.Annotations)
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to run post-create hook: %v", req.GetConfig().GetMetadata().GetName(), err)
+		return nil, err
+	}
+
+	err = c.FinishCreate()
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to mark container as created: %v", req.GetConfig().GetMetadata().GetName(), err)
+
+		// kubelet doesn't know the container yet, so it can't remove it
+		if delErr := s.deleteContainer(ctx, c); delErr != nil {
+			logger.Errorf("unable to remove container %v after failed creation: %v", c.ID, delErr)
 		}
+
+		return nil, err
 	}
 
 	logger.Infof("CreateContainer successful: Created ContainerID %v for SandboxID %v", c.ID, req.GetPodSandboxId())
+	s.webhook.notify(webhookEventCreated, c)
 
 	response := &rtApi.CreateContainerResponse{
 		ContainerId: c.ID,
@@ -749,19 +1109,37 @@ func (s RuntimeServer) StartContainer(ctx context.Context, req *rtApi.StartConta
 	logger.Infof("StartContainer called: ContainerID %v", req.GetContainerId())
 	logger.Debugf("StartContainer triggered: %v", req)
 
+	defer s.containers.Invalidate()
+
 	c, err := s.lxf.GetContainer(req.GetContainerId())
 	if err != nil {
 		logger.Errorf("StartContainer: ContainerID %v trying to get container: %v", req.GetContainerId(), err)
 		return nil, err
 	}
 
+	if c.StateName == lxf.ContainerStateCreating {
+		logger.Errorf("StartContainer: ContainerID %v refused: %v", req.GetContainerId(), ErrContainerCreating)
+		return nil, fmt.Errorf("%w: %v", ErrContainerCreating, c.ID)
+	}
+
 	err = c.Start()
 	if err != nil {
 		logger.Errorf("StartContainer: ContainerID %v trying to start container: %v", req.GetContainerId(), err)
 		return nil, err
 	}
 
+	if timeout := s.criConfig().LXEStartWaitTimeout; timeout > 0 {
+		err = c.WaitRunning(timeout)
+		if err != nil {
+			// lxd reported the container as started, so it's up to the following requests whether it's usable
+			logger.Warnf("StartContainer: ContainerID %v trying to wait for container to run: %v", req.GetContainerId(), err)
+		}
+	}
+
+	s.savePodIP(c)
+
 	logger.Infof("StartContainer successful: ContainerID %v", c.ID)
+	s.webhook.notify(webhookEventStarted, c)
 
 	response := &rtApi.StartContainerResponse{}
 
@@ -838,14 +1216,16 @@ func (s RuntimeServer) RemoveContainer(ctx context.Context, req *rtApi.RemoveCon
 func (s RuntimeServer) ListContainers(ctx context.Context, req *rtApi.ListContainersRequest) (*rtApi.ListContainersResponse, error) {
 	logger.Debugf("ListContainers triggered: %v", req)
 
-	response := &rtApi.ListContainersResponse{}
-
-	cl, err := s.lxf.ListContainers()
+	cl, err := s.listContainers(req.GetFilter().GetId())
 	if err != nil {
 		logger.Errorf("ListContainers: trying to get container list: %v", err)
 		return nil, err
 	}
 
+	response := &rtApi.ListContainersResponse{
+		Containers: make([]*rtApi.Container, 0, len(cl)),
+	}
+
 	for _, c := range cl {
 		if req.GetFilter() != nil {
 			filter := req.GetFilter()
@@ -869,7 +1249,8 @@ func (s RuntimeServer) ListContainers(ctx context.Context, req *rtApi.ListContai
 		response.Containers = append(response.Containers, toCriContainer(c))
 	}
 
-	logger.Debugf("ListContainers responded: %v", response)
+	// the whole response would be formatted even if debug messages are discarded
+	logger.Debugf("ListContainers responded: %d containers", len(response.Containers))
 
 	return response, nil
 }
@@ -879,13 +1260,48 @@ func (s RuntimeServer) ContainerStatus(ctx context.Context, req *rtApi.Container
 	//logger.Infof("ContainerStatus called: ContainerID %v", req.GetContainerId())
 	logger.Debugf("ContainerStatus triggered: %v", req)
 
-	ct, err := s.lxf.GetContainer(req.GetContainerId())
+	ct, err := s.containers.Get(req.GetContainerId())
 	if err != nil {
 		logger.Errorf("ContainerStatus: ContainerID %v trying to get container: %v", req.GetContainerId(), err)
 		return nil, err
 	}
 
-	response := toCriStatusResponse(ct)
+	response := toCriStatusResponse(ct, req.GetVerbose())
+
+	if req.GetVerbose() {
+		response.Info["image.size"] = imageSizeInfo(s.imageSizes.Get(ct.Image))
+
+		// CRI has no field for swap usage yet
+		if swap := swapUsage(ct); swap != nil {
+			response.Info["memory.swap"] = strconv.FormatUint(*swap, 10)
+		}
+
+		// nor for the memory breakdown besides the working set
+		if memory := memoryInfo(ct); memory != "" {
+			response.Info["memory"] = memory
+		}
+
+		// nor for hugepages
+		if hugepages := hugepagesInfo(ct); hugepages != "" {
+			response.Info["hugepages"] = hugepages
+		}
+
+		// nor for disk io
+		if diskIO := diskIOInfo(ct); diskIO != "" {
+			response.Info["diskio"] = diskIO
+		}
+
+		// for collectors reading the metrics from the cgroup
+		if cg := containerCgroup(ct); cg != nil {
+			response.Info["cgroup.path"] = cg.Path
+			response.Info["cgroup.version"] = strconv.Itoa(cg.Version)
+		}
+
+		// to debug stuck containers without exec
+		if processes := processesInfo(ct); processes != "" {
+			response.Info["processes"] = processes
+		}
+	}
 
 	logger.Debugf("ContainerStatus responded: %v", response)
 
@@ -906,10 +1322,47 @@ func (s RuntimeServer) ReopenContainerLog(ctx context.Context, req *rtApi.Reopen
 	return nil, fmt.Errorf("ReopenContainerLog: %w", ErrNotImplemented)
 }
 
+// CheckpointContainer checkpoints a running container with criu into a stateful lxd snapshot. If location is set, the
+// container including the checkpoint is exported as archive to this path. The CRI version implemented doesn't define this
+// call yet, so it is only reachable once the CRI API is upgraded.
+func (s RuntimeServer) CheckpointContainer(ctx context.Context, containerID, location string) error {
+	logger.Infof("CheckpointContainer called: ContainerID %v", containerID)
+
+	c, err := s.lxf.GetContainer(containerID)
+	if err != nil {
+		logger.Errorf("CheckpointContainer: ContainerID %v trying to get container: %v", containerID, err)
+		return err
+	}
+
+	name, err := c.Checkpoint(location)
+	if err != nil {
+		logger.Errorf("CheckpointContainer: ContainerID %v trying to checkpoint container: %v", containerID, err)
+		return err
+	}
+
+	logger.Infof("CheckpointContainer successful: ContainerID %v Snapshot %v", containerID, name)
+
+	return nil
+}
+
 // ExecSync runs a command in a container synchronously.
 func (s RuntimeServer) ExecSync(ctx context.Context, req *rtApi.ExecSyncRequest) (*rtApi.ExecSyncResponse, error) {
 	logger.Debugf("ExecSync triggered: %v", req)
 
+	cfg := s.criConfig()
+
+	// before the cache, which may hold results from before the policy was reloaded
+	err := s.checkExecCommand(cfg, req.GetCmd())
+	if err != nil {
+		logger.Errorf("ExecSync: ContainerID %v refused: %v", req.GetContainerId(), err)
+		return nil, err
+	}
+
+	if resp, has := s.execSyncs.Get(req.GetContainerId(), req.GetCmd()); has {
+		logger.Debugf("reusing exit code %v for exec %v on container %v", resp.GetExitCode(), req.GetCmd(), req.GetContainerId())
+		return resp, nil
+	}
+
 	stdin := bytes.NewReader(nil)
 	stdinR := ioutil.NopCloser(stdin)
 	stdout := bytes.NewBuffer(nil)
@@ -917,21 +1370,52 @@ func (s RuntimeServer) ExecSync(ctx context.Context, req *rtApi.ExecSyncRequest)
 	stderr := bytes.NewBuffer(nil)
 	stderrW := ioutils.WriteCloserWrapper(stderr)
 
-	code, err := s.lxf.Exec(req.GetContainerId(), req.GetCmd(), stdinR, stdoutW, stderrW, false, false, req.GetTimeout(), nil)
+	var code int32
+
+	pid, err := s.sandboxExecPid(cfg, req.GetContainerId())
+	if err != nil {
+		logger.Errorf("ExecSync: ContainerID %v trying to find sandbox: %v", req.GetContainerId(), err)
+		return nil, err
+	}
+
+	if pid > 0 {
+		code, err = sandboxExec(pid, req.GetCmd(), stdinR, stdoutW, stderrW, req.GetTimeout())
+	} else {
+		env, user, cwd, prepErr := s.prepareExec(req.GetContainerId())
+		if prepErr != nil {
+			logger.Errorf("ExecSync: ContainerID %v trying to prepare command: %v", req.GetContainerId(), prepErr)
+			return nil, prepErr
+		}
+
+		code, err = s.lxf.Exec(req.GetContainerId(), req.GetCmd(), env, user, cwd, stdinR, stdoutW, stderrW, false, false, req.GetTimeout(), nil)
+	}
 
 	logger.Debugf("received exit code %v for exec %v on container %v", code, req.GetCmd(), req.GetContainerId())
 
-	return &rtApi.ExecSyncResponse{
+	response := &rtApi.ExecSyncResponse{
 		Stdout:   stdout.Bytes(),
 		Stderr:   stderr.Bytes(),
 		ExitCode: code,
-	}, err
+	}
+
+	if err == nil {
+		s.execSyncs.Set(req.GetContainerId(), req.GetCmd(), response)
+	}
+
+	return response, err
 }
 
 // Exec prepares a streaming endpoint to execute a command in the container.
 func (s RuntimeServer) Exec(ctx context.Context, req *rtApi.ExecRequest) (*rtApi.ExecResponse, error) {
 	logger.Debugf("Exec triggered: %v", req)
 
+	// refused here already, so the client gets the error instead of a failing stream
+	err := s.checkExecCommand(s.criConfig(), req.GetCmd())
+	if err != nil {
+		logger.Errorf("Exec: ContainerID %v refused: %v", req.GetContainerId(), err)
+		return nil, err
+	}
+
 	resp, err := s.stream.streamServer.GetExec(req)
 	if err != nil {
 		logger.Errorf("Exec: ContainerID %v preparing exec endpoint: %v", req.GetContainerId(), err)
@@ -955,7 +1439,35 @@ func (ss streamService) Exec(containerID string, cmd []string, stdinR io.Reader,
 
 	interactive := (stdinR != nil)
 
-	code, err := ss.runtimeServer.lxf.Exec(containerID, cmd, stdin, stdout, stderr, interactive, tty, 0, resize)
+	var code int32
+
+	// the policy may have been reloaded since the exec was prepared
+	cfg := ss.runtimeServer.criConfig()
+
+	err := ss.runtimeServer.checkExecCommand(cfg, cmd)
+	if err != nil {
+		logger.Errorf("StreamService Exec: ContainerID %v refused: %v", containerID, err)
+		return err
+	}
+
+	pid, err := ss.runtimeServer.sandboxExecPid(cfg, containerID)
+	if err != nil {
+		logger.Errorf("StreamService Exec: ContainerID %v trying to find sandbox: %v", containerID, err)
+		return err
+	}
+
+	if pid > 0 {
+		// without a tty, as the command runs on the host
+		code, err = sandboxExec(pid, cmd, stdin, stdout, stderr, 0)
+	} else {
+		env, user, cwd, prepErr := ss.runtimeServer.prepareExec(containerID)
+		if prepErr != nil {
+			logger.Errorf("StreamService Exec: ContainerID %v trying to prepare command: %v", containerID, prepErr)
+			return prepErr
+		}
+
+		code, err = ss.runtimeServer.lxf.Exec(containerID, cmd, env, user, cwd, stdin, stdout, stderr, interactive, tty, 0, resize)
+	}
 
 	logger.Debugf("received exit code %v for exec %v on container %v", code, cmd, containerID)
 
@@ -1013,7 +1525,7 @@ func (ss streamService) PortForward(podSandboxID string, port int32, stream io.R
 		return err
 	}
 
-	args := []string{"-", fmt.Sprintf("TCP4:%s:%d,keepalive", podIP, port)}
+	args := []string{"-", portForwardTarget(podIP, port)}
 
 	commandString := fmt.Sprintf("socat %s", strings.Join(args, " "))
 	logger.Debugf("executing port forwarding command: %s", commandString)
@@ -1036,19 +1548,31 @@ func (ss streamService) PortForward(podSandboxID string, port int32, stream io.R
 		return err
 	}
 
+	err = command.Start()
+	if err != nil {
+		logger.Errorf("PortForward: unable to do port forwarding: %v", err)
+		return err
+	}
+
+	ss.forwards.Add(command)
+	defer ss.forwards.Remove(command)
+
 	go func() {
-		_, err = pools.Copy(inPipe, stream)
-		if err != nil {
-			logger.Errorf("pipe copy errored: %v", err)
+		_, copyErr := pools.Copy(inPipe, stream)
+		if copyErr != nil {
+			logger.Errorf("pipe copy errored: %v", copyErr)
 		}
 
-		err = inPipe.Close()
-		if err != nil {
-			logger.Errorf("pipe close errored: %v", err)
+		copyErr = inPipe.Close()
+		if copyErr != nil {
+			logger.Errorf("pipe close errored: %v", copyErr)
 		}
+
+		// socat should exit now that its input is closed, but mustn't linger if the connection to the pod hangs
+		ss.forwards.KillAfter(command, forwardCloseGrace)
 	}()
 
-	if err := command.Run(); err != nil {
+	if err := command.Wait(); err != nil {
 		return fmt.Errorf("%w: %s", err, stderr.String())
 	}
 
@@ -1091,6 +1615,10 @@ func (s RuntimeServer) ListContainerStats(ctx context.Context, req *rtApi.ListCo
 			return nil, err
 		}
 
+		if !matchContainerStatsFilter(c, req.GetFilter()) {
+			return response, nil
+		}
+
 		st, err := toCriStats(c)
 		if err != nil {
 			logger.Errorf("ListContainerStats: ContainerID %v trying to get stats: %v", req.GetFilter().GetId(), err)
@@ -1109,6 +1637,10 @@ func (s RuntimeServer) ListContainerStats(ctx context.Context, req *rtApi.ListCo
 	}
 
 	for _, c := range cts {
+		if !matchContainerStatsFilter(c, req.GetFilter()) {
+			continue
+		}
+
 		st, err := toCriStats(c)
 		if err != nil {
 			logger.Errorf("ListContainerStats: ContainerID %v trying to get stats: %v", c.ID, err)
@@ -1118,7 +1650,8 @@ func (s RuntimeServer) ListContainerStats(ctx context.Context, req *rtApi.ListCo
 		response.Stats = append(response.Stats, st)
 	}
 
-	logger.Debugf("ListContainerStats responded: %v", response)
+	// the whole response would be formatted even if debug messages are discarded
+	logger.Debugf("ListContainerStats responded: %d stats", len(response.Stats))
 
 	return response, nil
 }
@@ -1161,6 +1694,24 @@ func (s RuntimeServer) Status(ctx context.Context, req *rtApi.StatusRequest) (*r
 		},
 	}
 
+	if err := s.cluster.Unavailable(); err != nil {
+		response.Status.Conditions[0].Status = false
+		response.Status.Conditions[0].Reason = reasonClusterUnavailable
+		response.Status.Conditions[0].Message = err.Error()
+	} else if draining, since := s.drain.Draining(); draining {
+		response.Status.Conditions[0].Status = false
+		response.Status.Conditions[0].Reason = reasonDraining
+		response.Status.Conditions[0].Message = fmt.Sprintf("%v since %v", ErrDraining, since.Format(time.RFC3339))
+	}
+
+	if req.GetVerbose() {
+		response.Info = metricsInfo()
+
+		if leaks := s.networkLeaks.Info(); leaks != "" {
+			response.Info["network.leaks"] = leaks
+		}
+	}
+
 	logger.Debugf("Status responded: %v", response)
 
 	return response, nil
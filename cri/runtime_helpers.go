@@ -1,11 +1,24 @@
 package cri
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"os"
+	"os/exec"
 	"os/user"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/automaticserver/lxe/lxf"
@@ -13,28 +26,210 @@ import (
 	"github.com/automaticserver/lxe/network"
 	"github.com/automaticserver/lxe/shared"
 	sharedLXD "github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
+	opencontainers "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/server/streaming"
 )
 
-func toCriStatusResponse(c *lxf.Container) *rtApi.ContainerStatusResponse {
+// Seccomp profile values as provided by kubelet
+const (
+	cfgSandboxSeccompProfilePath  = "user.linux.security_context.seccomp_profile_path"
+	seccompProfileUnconfined      = "unconfined"
+	seccompProfileRuntimeDefault  = "runtime/default"
+	seccompProfileDockerDefault   = "docker/default"
+	seccompProfileLocalhostPrefix = "localhost/"
+)
+
+// cfgSandboxNamespacePID holds the pid namespace mode of the sandbox
+const cfgSandboxNamespacePID = "user.linux.security_context.namespace_options.pid"
+
+// cfgSandboxNamespaceIPC holds the ipc namespace mode of the sandbox
+const cfgSandboxNamespaceIPC = "user.linux.security_context.namespace_options.ipc"
+
+// cfgSandboxHostPorts holds the comma separated host ports of host network sandboxes, which bind them directly instead
+// of through proxy devices
+const cfgSandboxHostPorts = "user.host_ports"
+
+// hostNamespacePID is the pid whose namespaces containers join to share them with the host
+const hostNamespacePID = "1"
+
+// Annotations understood by LXE
+const (
+	// annotationMountRemapExempt on the pod holds a comma separated list of container paths which bypass the mount remapping of
+	// /var/run and /run
+	annotationMountRemapExempt = "x-lxe-mount-remap-exempt"
+	// annotationMountDirectoryOrCreate on the pod holds a comma separated list of mount host paths which are created as
+	// directories if missing, like hostPath volumes of type DirectoryOrCreate
+	annotationMountDirectoryOrCreate = "x-lxe-mount-directory-or-create"
+	// annotationMountFileOrCreate on the pod holds a comma separated list of mount host paths which are created as empty
+	// files if missing, like hostPath volumes of type FileOrCreate
+	annotationMountFileOrCreate = "x-lxe-mount-file-or-create"
+	// annotationQOSClass on the pod allows to derive the oom score adjustment if kubelet doesn't provide one
+	annotationQOSClass = "x-lxe-qos-class"
+	// annotationExecUser on the pod defines the uid and optionally the gid commands are executed as, as uid[:gid]
+	annotationExecUser = "x-lxe-exec-user"
+	// annotationExecCwd on the pod defines the absolute directory commands are executed in
+	annotationExecCwd = "x-lxe-exec-cwd"
+	// annotationExecEnvPrefix followed by a variable name on the pod sets this environment variable for execs
+	annotationExecEnvPrefix = "x-lxe-exec-env."
+	// annotationInstanceType on the pod defines the lxd instance type preset of limits for its containers
+	annotationInstanceType = "x-lxe-instance-type"
+	// annotationArchitecture on the pod defines the architecture the images of its containers must be built for
+	annotationArchitecture = "x-lxe-architecture"
+	// annotationRawIdmap on the pod defines the lxd raw.idmap of its containers
+	annotationRawIdmap = "x-lxe-raw-idmap"
+	// annotationConsoleLogPrefix followed by the container name on the pod disables the lxd console log of the container
+	// if false
+	annotationConsoleLogPrefix = "x-lxe-console-log."
+	// annotationRestoreFromPrefix followed by the container name on the pod holds the path of a checkpoint archive the
+	// container is restored from
+	annotationRestoreFromPrefix = "x-lxe-restore-from."
+	// annotationUnifiedPrefix followed by a cgroup v2 key on the pod sets this key for its containers
+	annotationUnifiedPrefix = "x-lxe-unified."
+	// annotationAutostart on the pod lets lxd start its containers again when lxd restarts
+	annotationAutostart = "x-lxe-autostart"
+	// annotationNesting on the pod enables lxd security.nesting for its containers
+	annotationNesting = "x-lxe-nesting"
+	// annotationPostCreate on the pod holds a shell command which is run once in each of its containers after they are
+	// created
+	annotationPostCreate = "x-lxe-postcreate"
+	// annotationPodMounts on the pod holds a comma separated list of host paths mounted into each of its containers, in
+	// the form hostPath:containerPath[:ro]
+	annotationPodMounts = "x-lxe-pod-mounts"
+	// annotationSyscallsInterceptPrefix followed by a syscall on the pod enables lxd's interception of this syscall for
+	// its containers
+	annotationSyscallsInterceptPrefix = "x-lxe-syscalls-intercept."
+	// annotationProcessLimit on the pod sets the maximum number of processes of each of its containers, 0 disables the
+	// default limit
+	annotationProcessLimit = "x-lxe-process-limit"
+	// annotationUlimitPrefix followed by a resource name on the pod sets this ulimit for its containers in the form
+	// soft[:hard]
+	annotationUlimitPrefix = "x-lxe-ulimit."
+	// annotationIOPriority on the pod sets the disk io priority of its containers from 0 to 10
+	annotationIOPriority = "x-lxe-io-priority"
+	// annotationIOMax on the pod throttles the io of its containers per block device, comma separated entries in the
+	// form of cgroup v2 io.max, e.g. "8:0 rbps=1048576 wiops=100"
+	annotationIOMax = "x-lxe-io-max"
+	// annotationStopOrder on the pod holds a comma separated list of its container names, which are stopped first and in
+	// this order when the pod is stopped
+	annotationStopOrder = "x-lxe-stop-order"
+)
+
+// annotationTerminationGracePeriod is set by kubelet on each container to the termination grace period of its pod in
+// seconds
+const annotationTerminationGracePeriod = "io.kubernetes.pod.terminationGracePeriod"
+
+// defaultTerminationGracePeriod is used for pods whose containers don't tell their grace period, as in kubernetes
+const defaultTerminationGracePeriod = 30 * time.Second
+
+// Reasons of containers in states CRI doesn't know
+const (
+	reasonContainerCreating = "ContainerCreating"
+	reasonContainerUnknown  = "ContainerUnknown"
+	reasonContainerFrozen   = "ContainerFrozen"
+)
+
+// timeout in seconds for the post-create hook
+const postCreateTimeout = 60
+
+// OOM score adjustments as computed by kubelet for the QoS classes
+const (
+	oomScoreAdjMin        = -1000
+	oomScoreAdjMax        = 1000
+	oomScoreAdjGuaranteed = -998
+	oomScoreAdjBestEffort = 1000
+	qosClassGuaranteed    = "guaranteed"
+	qosClassBestEffort    = "besteffort"
+)
+
+// QoS classes of pods as kubernetes names them
+const (
+	podQOSGuaranteed = "Guaranteed"
+	podQOSBurstable  = "Burstable"
+	podQOSBestEffort = "BestEffort"
+)
+
+// minCPUShares is what kubelet sets for containers without cpu request and limit
+const minCPUShares = 2
+
+// Bounds of the console log ring buffer size in bytes
+const (
+	consoleBufferSizeMin = 4 * 1024
+	consoleBufferSizeMax = 128 * 1024 * 1024
+)
+
+// maxStatusProcesses is the most processes of a container listed in its verbose status
+const maxStatusProcesses = 50
+
+// exitCodeCannotRun is reported for containers which couldn't be started, like other runtimes do
+const exitCodeCannotRun = 128
+
+// Prefix of the lxd config keys that hold the effective resource limits of a container
+const cfgLimitsPrefix = "limits."
+
+// cfgLimitProcesses is the lxd config key of the maximum number of processes in a container
+const cfgLimitProcesses = cfgLimitsPrefix + "processes"
+
+// cfgLimitDiskPriority is the lxd config key of the disk io priority of a container
+const cfgLimitDiskPriority = cfgLimitsPrefix + "disk.priority"
+
+// podNameservers returns the nameservers the pod can reach. Loopback nameservers of the host, like the stub resolver of
+// systemd-resolved, are only reachable with host network, which kubelet passes with dnsPolicy Default if it uses the
+// resolv.conf of the host.
+func podNameservers(sb *lxf.Sandbox, servers []string) []string {
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		return servers
+	}
+
+	reachable := []string{}
+
+	for _, server := range servers {
+		if ip := net.ParseIP(server); ip != nil && ip.IsLoopback() {
+			logger.Warnf("Sandbox %v: skipping nameserver %v, which isn't reachable outside the host network. Point kubelet's --resolv-conf to the upstream nameservers instead", sb.Metadata.Name, server)
+			continue
+		}
+
+		reachable = append(reachable, server)
+	}
+
+	return reachable
+}
+
+// toCriTimestamp returns the time in nanoseconds since the epoch, 0 for the zero time as CRI expects for unset times
+func toCriTimestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func toCriStatusResponse(c *lxf.Container, verbose bool) *rtApi.ContainerStatusResponse {
 	status := rtApi.ContainerStatus{
 		Metadata: &rtApi.ContainerMetadata{
 			Name:    c.Metadata.Name,
 			Attempt: c.Metadata.Attempt,
 		},
 		State:       stateContainerAsCri(c.StateName),
-		CreatedAt:   c.CreatedAt.UnixNano(),
-		StartedAt:   c.StartedAt.UnixNano(),
-		FinishedAt:  c.FinishedAt.UnixNano(),
+		CreatedAt:   toCriTimestamp(c.CreatedAt),
+		StartedAt:   toCriTimestamp(c.StartedAt),
+		FinishedAt:  toCriTimestamp(c.FinishedAt),
 		Id:          c.ID,
 		Labels:      c.Labels,
 		Annotations: c.Annotations,
 		Image:       &rtApi.ImageSpec{Image: c.Image},
 		ImageRef:    c.Image,
 		Mounts:      []*rtApi.Mount{},
+		Reason:      stateReason(c),
+		Message:     c.ExitMessage,
+	}
+
+	if c.ExitReason == lxf.ReasonCannotRun {
+		status.ExitCode = exitCodeCannotRun
 	}
 
 	for _, dev := range c.Devices {
@@ -58,10 +253,66 @@ func toCriStatusResponse(c *lxf.Container) *rtApi.ContainerStatusResponse {
 		}
 	}
 
+	info := map[string]string{}
+	if verbose {
+		info = toCriStatusInfo(c)
+	}
+
 	return &rtApi.ContainerStatusResponse{
 		Status: &status,
-		Info:   map[string]string{},
+		Info:   info,
+	}
+}
+
+// toCriStatusInfo lists the requested resources of the container next to the limits lxd has effectively applied, so
+// discrepancies between them become visible
+func toCriStatusInfo(c *lxf.Container) map[string]string {
+	info := map[string]string{}
+
+	// CRI only knows the latest start, which doesn't tell whether the container was restarted
+	if !c.RestartedAt.IsZero() {
+		info["restart.last"] = c.RestartedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	for k, v := range c.Config {
+		if strings.HasPrefix(k, cfgLimitsPrefix) {
+			info[k] = v
+		}
+	}
+
+	// to find the logs of the lxd cluster member running the container
+	if location := c.Location(); location != "" {
+		info["lxd.location"] = location
+	}
+
+	// to find out who owns files the container wrote to shared storage
+	if len(c.Idmap) > 0 {
+		info["idmap"] = idmapInfo(c.Idmap)
+	}
+
+	if c.Resources == nil {
+		return info
+	}
+
+	if cpu := c.Resources.CPU; cpu != nil {
+		if cpu.Shares != nil {
+			info["resources.cpu.shares"] = strconv.FormatUint(*cpu.Shares, 10)
+		}
+
+		if cpu.Quota != nil {
+			info["resources.cpu.quota"] = strconv.FormatInt(*cpu.Quota, 10)
+		}
+
+		if cpu.Period != nil {
+			info["resources.cpu.period"] = strconv.FormatUint(*cpu.Period, 10)
+		}
+	}
+
+	if mem := c.Resources.Memory; mem != nil && mem.Limit != nil {
+		info["resources.memory.limit"] = strconv.FormatInt(*mem.Limit, 10)
 	}
+
+	return info
 }
 
 func toCriStats(c *lxf.Container) (*rtApi.ContainerStats, error) {
@@ -78,7 +329,7 @@ func toCriStats(c *lxf.Container) (*rtApi.ContainerStats, error) {
 	}
 	memory := rtApi.MemoryUsage{
 		Timestamp:       now,
-		WorkingSetBytes: &rtApi.UInt64Value{Value: st.Stats.MemoryUsage},
+		WorkingSetBytes: &rtApi.UInt64Value{Value: workingSet(c, st.Stats.MemoryUsage)},
 	}
 	disk := rtApi.FilesystemUsage{
 		Timestamp: now,
@@ -108,13 +359,58 @@ func toCriStats(c *lxf.Container) (*rtApi.ContainerStats, error) {
 	return &response, nil
 }
 
+// listSandboxes returns all sandboxes, or only the one with the given id if set. Kubelet often lists a single
+// sandbox, which then doesn't require to fetch and convert all of them.
+func (s RuntimeServer) listSandboxes(id string) ([]*lxf.Sandbox, error) {
+	if id == "" {
+		return s.lxf.ListSandboxes()
+	}
+
+	sb, err := s.lxf.GetSandbox(id)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return []*lxf.Sandbox{sb}, nil
+}
+
+// listContainers returns all containers, or only the one with the given id if set. A listing of all containers is
+// kept in the container cache for the status requests which follow.
+func (s RuntimeServer) listContainers(id string) ([]*lxf.Container, error) {
+	if id == "" {
+		cl, err := s.lxf.ListContainers()
+		if err != nil {
+			return nil, err
+		}
+
+		s.containers.Set(cl)
+
+		return cl, nil
+	}
+
+	c, err := s.lxf.GetContainer(id)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return []*lxf.Container{c}, nil
+}
+
 func toCriContainer(c *lxf.Container) *rtApi.Container {
 	return &rtApi.Container{
 		Id:           c.ID,
 		PodSandboxId: c.SandboxID(),
 		Image:        &rtApi.ImageSpec{Image: c.Image},
 		ImageRef:     c.Image,
-		CreatedAt:    c.CreatedAt.UnixNano(),
+		CreatedAt:    toCriTimestamp(c.CreatedAt),
 		State:        stateContainerAsCri(c.StateName),
 		Metadata: &rtApi.ContainerMetadata{
 			Name:    c.Metadata.Name,
@@ -125,14 +421,56 @@ func toCriContainer(c *lxf.Container) *rtApi.Container {
 	}
 }
 
+// stateContainerAsCri maps the state of the container. CRI knows no state for containers still being set up, they are
+// created but can't be started yet. Any state not known is mapped to unknown, never to a state kubelet acts upon.
 func stateContainerAsCri(s lxf.ContainerStateName) rtApi.ContainerState {
-	return rtApi.ContainerState(
-		rtApi.ContainerState_value["CONTAINER_"+strings.ToUpper(s.String())])
+	switch s { // nolint: exhaustive
+	case lxf.ContainerStateCreating, lxf.ContainerStateCreated:
+		return rtApi.ContainerState_CONTAINER_CREATED
+	case lxf.ContainerStateRunning:
+		return rtApi.ContainerState_CONTAINER_RUNNING
+	case lxf.ContainerStateExited:
+		return rtApi.ContainerState_CONTAINER_EXITED
+	case lxf.ContainerStateUnknown:
+		return rtApi.ContainerState_CONTAINER_UNKNOWN
+	default:
+		logger.Warnf("Unexpected container state %q, reporting it as unknown", s)
+		return rtApi.ContainerState_CONTAINER_UNKNOWN
+	}
+}
+
+// stateReason returns the reason shown for containers whose state alone is misleading
+func stateReason(c *lxf.Container) string {
+	if c.ExitReason != "" {
+		return c.ExitReason
+	}
+
+	if c.Frozen {
+		return reasonContainerFrozen
+	}
+
+	switch c.StateName { // nolint: exhaustive
+	case lxf.ContainerStateCreating:
+		return reasonContainerCreating
+	case lxf.ContainerStateUnknown:
+		return reasonContainerUnknown
+	default:
+		return ""
+	}
 }
 
+// stateSandboxAsCri maps the state of the sandbox. CRI knows no unknown state for sandboxes, so any state not known is
+// mapped to not ready, never to ready.
 func stateSandboxAsCri(s lxf.SandboxState) rtApi.PodSandboxState {
-	return rtApi.PodSandboxState(
-		rtApi.PodSandboxState_value["SANDBOX_"+strings.ToUpper(s.String())])
+	switch s {
+	case lxf.SandboxReady:
+		return rtApi.PodSandboxState_SANDBOX_READY
+	case lxf.SandboxNotReady:
+		return rtApi.PodSandboxState_SANDBOX_NOTREADY
+	default:
+		logger.Warnf("Unexpected sandbox state %q, reporting it as not ready", s)
+		return rtApi.PodSandboxState_SANDBOX_NOTREADY
+	}
 }
 
 func nameSpaceOptionToString(no rtApi.NamespaceMode) string {
@@ -143,6 +481,24 @@ func stringToNamespaceOption(s string) rtApi.NamespaceMode {
 	return rtApi.NamespaceMode(rtApi.NamespaceMode_value[strings.ToUpper(s)])
 }
 
+// matchContainerStatsFilter checks whether the container matches the id, sandbox and labels of the filter. A nil filter
+// matches all containers.
+func matchContainerStatsFilter(c *lxf.Container, filter *rtApi.ContainerStatsFilter) bool {
+	if filter == nil {
+		return true
+	}
+
+	if filter.GetId() != "" && filter.GetId() != c.ID {
+		return false
+	}
+
+	if filter.GetPodSandboxId() != "" && filter.GetPodSandboxId() != c.SandboxID() {
+		return false
+	}
+
+	return CompareFilterMap(c.Labels, filter.GetLabelSelector())
+}
+
 // CompareFilterMap allows comparing two string maps
 func CompareFilterMap(base map[string]string, filter map[string]string) bool {
 	if filter == nil { // filter can be nil
@@ -186,147 +542,2569 @@ func getLXDConfigPath(cfg *Config) (string, error) {
 	return configPath, nil
 }
 
-func (s RuntimeServer) stopContainers(sb *lxf.Sandbox) error {
-	cl, err := sb.Containers()
-	if err != nil {
-		return err
+// hostPortEndpoint returns the host side of the port mapping, listening on all addresses if it has no host ip
+func hostPortEndpoint(portMap *rtApi.PortMapping) *device.ProxyEndpoint {
+	protocol := device.ProtocolTCP
+	if portMap.GetProtocol() == rtApi.Protocol_UDP {
+		protocol = device.ProtocolUDP
 	}
 
-	for _, c := range cl {
-		err := s.stopContainer(c, 30)
-		if err != nil {
-			return err
-		}
+	hostIP := portMap.GetHostIp()
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
 	}
 
-	return nil
+	return &device.ProxyEndpoint{
+		Protocol: protocol,
+		Address:  hostIP,
+		Port:     int(portMap.GetHostPort()),
+	}
 }
 
-func (s RuntimeServer) stopContainer(c *lxf.Container, timeout int) error {
-	// if container is not running, no stopping needed
-	if c.StateName != lxf.ContainerStateRunning {
-		return nil
+// sandboxHostPorts returns the host ports the sandbox uses, either directly in the host network or by proxy devices
+func sandboxHostPorts(sb *lxf.Sandbox) []*device.ProxyEndpoint {
+	ports := []*device.ProxyEndpoint{}
+
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		for _, str := range strings.Split(sb.Config[cfgSandboxHostPorts], ",") {
+			if str == "" {
+				continue
+			}
+
+			port, err := device.NewProxyEndpoint(str)
+			if err != nil {
+				logger.Warnf("Sandbox %v has an invalid host port %v: %v", sb.ID, str, err)
+				continue
+			}
+
+			ports = append(ports, port)
+		}
+
+		return ports
 	}
 
-	err := c.Stop(timeout)
-	if err != nil {
-		if shared.IsErrNotFound(err) {
-			return nil
+	for _, d := range sb.Devices {
+		if proxy, is := d.(*device.Proxy); is {
+			ports = append(ports, proxy.Listen)
 		}
+	}
 
-		return err
+	return ports
+}
+
+// hostPortsConflict returns whether both host ports can't be bound at the same time
+func hostPortsConflict(a, b *device.ProxyEndpoint) bool {
+	if a.Protocol != b.Protocol || a.Port != b.Port {
+		return false
 	}
 
-	return nil
+	return a.Address == b.Address || isWildcardIP(a.Address) || isWildcardIP(b.Address)
 }
 
-func (s RuntimeServer) deleteContainers(ctx context.Context, sb *lxf.Sandbox) error {
-	cl, err := sb.Containers()
+func isWildcardIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	return parsed == nil || parsed.IsUnspecified()
+}
+
+// existingSandbox returns the sandbox of the same attempt of the pod, nil if there is none
+func (s RuntimeServer) existingSandbox(meta *rtApi.PodSandboxMetadata) (*lxf.Sandbox, error) {
+	if meta.GetUid() == "" {
+		return nil, nil
+	}
+
+	sbs, err := s.lxf.ListSandboxes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, c := range cl {
-		err = s.deleteContainer(ctx, c)
-		if err != nil {
-			return err
+	for _, sb := range sbs {
+		if sb.Metadata.UID == meta.GetUid() && sb.Metadata.Namespace == meta.GetNamespace() &&
+			sb.Metadata.Name == meta.GetName() && sb.Metadata.Attempt == meta.GetAttempt() {
+			return sb, nil
 		}
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (s RuntimeServer) deleteContainer(ctx context.Context, c *lxf.Container) error {
-	err := c.Delete()
-	if err != nil {
-		if shared.IsErrNotFound(err) {
-			return nil
+// checkHostPorts returns the host ports of the port mappings of a host network sandbox, and an error if one of them is
+// already used by another ready sandbox
+func (s RuntimeServer) checkHostPorts(mappings []*rtApi.PortMapping) ([]*device.ProxyEndpoint, error) {
+	ports := []*device.ProxyEndpoint{}
+
+	for _, portMap := range mappings {
+		if portMap.GetHostPort() == 0 {
+			continue
 		}
 
-		return err
+		ports = append(ports, hostPortEndpoint(portMap))
 	}
 
-	sb, err := c.Sandbox()
+	if len(ports) == 0 {
+		return ports, nil
+	}
+
+	sbs, err := s.lxf.ListSandboxes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// remove network
-	if sb.NetworkConfig.Mode != lxf.NetworkHost {
-		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
-		if err == nil { // force cleanup, we don't care about error, but only enter if there's no error
-			contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
-			if err == nil { // dito
-				_ = contNet.WhenDeleted(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
+	for _, sb := range sbs {
+		if sb.State != lxf.SandboxReady {
+			continue
+		}
+
+		for _, used := range sandboxHostPorts(sb) {
+			for _, port := range ports {
+				if hostPortsConflict(port, used) {
+					return nil, fmt.Errorf("%w: %v is used by sandbox %v", ErrHostPortInUse, port, sb.ID)
+				}
 			}
 		}
 	}
 
-	return nil
+	return ports, nil
 }
 
-// ContainerStarted implements lxf.EventHandler interface
-func (s RuntimeServer) ContainerStarted(ctx context.Context, c *lxf.Container) error {
-	logger.Infof("ContainerStarted called: ContainerName %v", c.ID)
+// getNetNSPath returns the path to the network namespace of the sandbox, which is the one of its first running container.
+// Sandboxes in the host network point to the network namespace of the host's init process. Empty string if no container
+// is running.
+func getNetNSPath(sb *lxf.Sandbox) string {
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		return netNSPath(1)
+	}
 
-	sb, err := c.Sandbox()
+	cl, err := sb.Containers()
 	if err != nil {
-		return err
+		logger.Errorf("Couldn't list containers while trying to get network namespace: %v", err)
+		return ""
 	}
 
-	if sb.NetworkConfig.Mode != lxf.NetworkHost { // nolint: nestif
-		st, err := c.State()
-		if err != nil {
-			return err
+	for _, c := range cl {
+		if c.StateName != lxf.ContainerStateRunning {
+			continue
 		}
 
-		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+		st, err := c.State()
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("can't enter sandbox %v network context", sb.ID))
+			logger.Errorf("Couldn't get state of container %v while trying to get network namespace: %v", c.ID, err)
+			continue
 		}
 
-		contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("can't enter container %v network context", c.ID))
+		if st.Pid > 0 {
+			return netNSPath(st.Pid)
 		}
+	}
 
-		res, err := contNet.WhenStarted(ctx, &network.PropertiesRunning{
-			Properties: network.Properties{
-				Data: sb.NetworkConfig.ModeData,
-			},
-			Pid: st.Pid,
-		})
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("can't start container %v network", c.ID))
-		}
+	return ""
+}
 
-		err = s.handleNetworkResult(sb, res)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("can't save start container %v network result", c.ID))
+// sandboxLocations formats the cluster members the containers of the sandbox are on for the status info, empty if lxd
+// isn't clustered
+func sandboxLocations(sb *lxf.Sandbox) string {
+	cl, err := sb.Containers()
+	if err != nil {
+		logger.Errorf("PodSandboxStatus: SandboxID %v trying to list containers: %v", sb.ID, err)
+		return ""
+	}
+
+	seen := map[string]bool{}
+	locations := []string{}
+
+	for _, c := range cl {
+		if location := c.Location(); location != "" && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
 		}
 	}
 
-	return nil
+	if len(locations) == 0 {
+		return ""
+	}
+
+	sort.Strings(locations)
+
+	// marshalling strings can't fail
+	b, _ := json.Marshal(locations)
+
+	return string(b)
 }
 
-// ContainerStopped implements lxf.EventHandler interface
-func (s *RuntimeServer) ContainerStopped(ctx context.Context, c *lxf.Container) error {
-	sb, err := c.Sandbox()
+// networkModeInfo names the network mode of the sandbox for the status info. LXE calls the host network mode "node"
+// internally, it's reported as "host" like the pod spec calls it.
+func networkModeInfo(mode lxf.NetworkMode) string {
+	if mode == lxf.NetworkHost {
+		return "host"
+	}
+
+	return mode.String()
+}
+
+// sandboxQOSClass derives the QoS class of the sandbox from the resources of its containers for the status info, empty
+// if they can't be listed
+func sandboxQOSClass(sb *lxf.Sandbox) string {
+	cl, err := sb.Containers()
 	if err != nil {
-		return err
+		logger.Errorf("PodSandboxStatus: SandboxID %v trying to list containers: %v", sb.ID, err)
+		return ""
 	}
 
-	// stop network
-	if sb.NetworkConfig.Mode != lxf.NetworkHost {
-		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
-		if err == nil { // force cleanup, we don't care about error, but only enter if there's no error
-			contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
-			if err == nil { // dito
-				_ = contNet.WhenStopped(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
-			}
+	return podQOSClass(cl)
+}
+
+// podQOSClass derives the QoS class like kubernetes does: guaranteed if all containers are, best-effort if all are,
+// otherwise burstable. Pods without containers are best-effort.
+func podQOSClass(cl []*lxf.Container) string {
+	guaranteed, bestEffort := 0, 0
+
+	for _, c := range cl {
+		switch containerQOSClass(c.Resources) {
+		case podQOSGuaranteed:
+			guaranteed++
+		case podQOSBestEffort:
+			bestEffort++
+		}
+	}
+
+	switch {
+	case bestEffort == len(cl):
+		return podQOSBestEffort
+	case guaranteed == len(cl):
+		return podQOSGuaranteed
+	default:
+		return podQOSBurstable
+	}
+}
+
+// containerQOSClass derives the QoS class of a container from the resources kubelet provided. Kubelet translates the
+// cpu request into shares and the cpu limit into quota and period, so a container whose shares equal what its cpu limit
+// translates to and which has a memory limit is guaranteed. Without limits and with the minimal shares of kubelet it's
+// best-effort. CRI doesn't pass the memory request, so it's assumed to equal the memory limit.
+func containerQOSClass(r *opencontainers.LinuxResources) string {
+	var (
+		shares, period uint64
+		quota, memory  int64
+	)
+
+	if r != nil && r.CPU != nil {
+		if r.CPU.Shares != nil {
+			shares = *r.CPU.Shares
+		}
+
+		if r.CPU.Quota != nil {
+			quota = *r.CPU.Quota
+		}
+
+		if r.CPU.Period != nil {
+			period = *r.CPU.Period
 		}
 	}
 
+	if r != nil && r.Memory != nil && r.Memory.Limit != nil {
+		memory = *r.Memory.Limit
+	}
+
+	if quota <= 0 && memory <= 0 {
+		if shares <= minCPUShares {
+			return podQOSBestEffort
+		}
+
+		return podQOSBurstable
+	}
+
+	if quota <= 0 || memory <= 0 || period == 0 {
+		return podQOSBurstable
+	}
+
+	// the reverse of kubelet's translation of the cpu limit in millicores
+	limitShares := uint64(quota) * 1000 / period * 1024 / 1000
+	if limitShares < minCPUShares {
+		limitShares = minCPUShares
+	}
+
+	if shares != limitShares {
+		return podQOSBurstable
+	}
+
+	return podQOSGuaranteed
+}
+
+// netNSPath returns the path to the network namespace of the process pid
+func netNSPath(pid int64) string {
+	return fmt.Sprintf("/proc/%d/ns/net", pid)
+}
+
+// portForwardTarget returns the socat address to forward to the port of the pod ip. IPv6 addresses are put in brackets.
+func portForwardTarget(podIP string, port int32) string {
+	family := "TCP4"
+	if ip := net.ParseIP(podIP); ip != nil && ip.To4() == nil {
+		family = "TCP6"
+	}
+
+	return fmt.Sprintf("%s:%s,keepalive", family, net.JoinHostPort(podIP, strconv.Itoa(int(port))))
+}
+
+// maxHostnameLength is the maximum length of a RFC1123 label
+const maxHostnameLength = 63
+
+// sanitizeHostname makes a valid RFC1123 hostname label out of name. It is lowercased, invalid characters are replaced
+// by dashes and it is truncated to the maximum length.
+func sanitizeHostname(name string) string {
+	b := strings.Builder{}
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+
+	hostname := strings.Trim(b.String(), "-")
+	if len(hostname) > maxHostnameLength {
+		hostname = strings.TrimRight(hostname[:maxHostnameLength], "-")
+	}
+
+	return hostname
+}
+
+// swapUsage returns the swap usage of a running container in bytes, nil if it's not running or swap isn't enabled
+func swapUsage(c *lxf.Container) *uint64 {
+	if c.StateName != lxf.ContainerStateRunning {
+		return nil
+	}
+
+	st, err := c.State()
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to get state: %v", c.ID, err)
+		return nil
+	}
+
+	return st.Stats.SwapUsage
+}
+
+// workingSet returns the working set of a running container, which excludes the reclaimable file cache unlike the usage
+// LXD reports. It falls back to the usage if the container isn't on this host or its cgroup can't be read.
+func workingSet(c *lxf.Container, usage uint64) uint64 {
+	if c.StateName != lxf.ContainerStateRunning {
+		return usage
+	}
+
+	mem, err := c.Memory()
+	if err != nil {
+		logger.Warnf("ContainerStats: ContainerID %v trying to get memory: %v", c.ID, err)
+		return usage
+	}
+
+	if mem == nil {
+		return usage
+	}
+
+	return mem.WorkingSet
+}
+
+// containerCgroup returns the cgroup of a running container, nil if it's not running or not on this host
+func containerCgroup(c *lxf.Container) *lxf.ContainerCgroup {
+	if c.StateName != lxf.ContainerStateRunning {
+		return nil
+	}
+
+	cg, err := c.Cgroup()
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to get cgroup: %v", c.ID, err)
+		return nil
+	}
+
+	return cg
+}
+
+// processesInfoEntry is a process of the container in the status info
+type processesInfoEntry struct {
+	PID     int64  `json:"pid"`
+	Command string `json:"cmd"`
+}
+
+// processesInfo formats the processes of a running container for the status info, with the total number in case the
+// list was cut off. Empty if it isn't running or its processes can't be read.
+func processesInfo(c *lxf.Container) string {
+	if c.StateName != lxf.ContainerStateRunning {
+		return ""
+	}
+
+	procs, total, err := c.Processes(maxStatusProcesses)
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to list processes: %v", c.ID, err)
+		return ""
+	}
+
+	if total == 0 {
+		return ""
+	}
+
+	info := struct {
+		Total     int                  `json:"total"`
+		Processes []processesInfoEntry `json:"processes"`
+	}{Total: total}
+
+	for _, p := range procs {
+		info.Processes = append(info.Processes, processesInfoEntry{PID: p.PID, Command: p.Command})
+	}
+
+	// marshalling numbers and strings can't fail
+	b, _ := json.Marshal(info)
+
+	return string(b)
+}
+
+// hugepagesInfoEntry is the hugepages usage of one page size in the status info
+type hugepagesInfoEntry struct {
+	Usage uint64 `json:"usage"`
+	Limit uint64 `json:"limit,omitempty"`
+}
+
+// hugepagesInfo formats the hugepages usage of a running container for the status info, empty if it uses none
+func hugepagesInfo(c *lxf.Container) string {
+	if c.StateName != lxf.ContainerStateRunning {
+		return ""
+	}
+
+	hugepages, err := c.Hugepages()
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to get hugepages: %v", c.ID, err)
+		return ""
+	}
+
+	if len(hugepages) == 0 {
+		return ""
+	}
+
+	entries := make(map[string]hugepagesInfoEntry, len(hugepages))
+	for size, h := range hugepages {
+		entries[size] = hugepagesInfoEntry{Usage: h.Usage, Limit: h.Limit}
+	}
+
+	// marshalling numbers can't fail
+	b, _ := json.Marshal(entries)
+
+	return string(b)
+}
+
+// memoryInfoEntry is the memory breakdown in the status info, fields the cgroup doesn't provide are omitted
+type memoryInfoEntry struct {
+	Usage           uint64  `json:"usage"`
+	WorkingSet      uint64  `json:"working_set"`
+	RSS             *uint64 `json:"rss,omitempty"`
+	Cache           *uint64 `json:"cache,omitempty"`
+	PageFaults      *uint64 `json:"page_faults,omitempty"`
+	MajorPageFaults *uint64 `json:"major_page_faults,omitempty"`
+	Available       *uint64 `json:"available,omitempty"`
+}
+
+// memoryInfo formats the memory breakdown of a running container for the status info, empty if it's not on this host
+func memoryInfo(c *lxf.Container) string {
+	if c.StateName != lxf.ContainerStateRunning {
+		return ""
+	}
+
+	mem, err := c.Memory()
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to get memory: %v", c.ID, err)
+		return ""
+	}
+
+	if mem == nil {
+		return ""
+	}
+
+	// marshalling numbers can't fail
+	b, _ := json.Marshal(memoryInfoEntry{
+		Usage:           mem.Usage,
+		WorkingSet:      mem.WorkingSet,
+		RSS:             mem.RSS,
+		Cache:           mem.Cache,
+		PageFaults:      mem.PageFaults,
+		MajorPageFaults: mem.MajorPageFaults,
+		Available:       mem.Available,
+	})
+
+	return string(b)
+}
+
+// diskIOInfoEntry is the disk io of one block device in the status info
+type diskIOInfoEntry struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadOps    uint64 `json:"read_ops"`
+	WriteOps   uint64 `json:"write_ops"`
+}
+
+// diskIOInfo formats the disk io of a running container for the status info, empty if it did none
+func diskIOInfo(c *lxf.Container) string {
+	if c.StateName != lxf.ContainerStateRunning {
+		return ""
+	}
+
+	diskIO, err := c.DiskIO()
+	if err != nil {
+		logger.Errorf("ContainerStatus: ContainerID %v trying to get disk io: %v", c.ID, err)
+		return ""
+	}
+
+	if len(diskIO) == 0 {
+		return ""
+	}
+
+	entries := make(map[string]diskIOInfoEntry, len(diskIO))
+	for device, d := range diskIO {
+		entries[device] = diskIOInfoEntry{ReadBytes: d.ReadBytes, WriteBytes: d.WriteBytes, ReadOps: d.ReadOps, WriteOps: d.WriteOps}
+	}
+
+	// marshalling numbers can't fail
+	b, _ := json.Marshal(entries)
+
+	return string(b)
+}
+
+// idmapInfoEntry is an id range of the container mapped to the host in the status info
+type idmapInfoEntry struct {
+	Type        string `json:"type"`
+	HostID      int64  `json:"host_id"`
+	ContainerID int64  `json:"container_id"`
+	Range       int64  `json:"range"`
+}
+
+// idmapInfo formats the idmap of a container for the status info, with the type "uid", "gid" or "both" like raw.idmap
+func idmapInfo(idmap []lxf.IdmapEntry) string {
+	entries := make([]idmapInfoEntry, 0, len(idmap))
+
+	for _, e := range idmap {
+		entry := idmapInfoEntry{HostID: e.HostID, ContainerID: e.ContainerID, Range: e.Range}
+
+		switch {
+		case e.UID && e.GID:
+			entry.Type = "both"
+		case e.UID:
+			entry.Type = "uid"
+		default:
+			entry.Type = "gid"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	// marshalling numbers and strings can't fail
+	b, _ := json.Marshal(entries)
+
+	return string(b)
+}
+
+// driftInfo formats the drifts of a sandbox for the status info
+func driftInfo(drifts []lxf.Drift) string {
+	lines := make([]string, 0, len(drifts))
+	for _, d := range drifts {
+		lines = append(lines, d.String())
+	}
+
+	// marshalling strings can't fail
+	b, _ := json.Marshal(lines)
+
+	return string(b)
+}
+
+// redactedValue replaces config values which may contain credentials in status info
+const redactedValue = "REDACTED"
+
+// redactedConfigKeys and redactedConfigPrefixes are the config keys whose values may contain credentials, like
+// environment variables, those of execs given by annotations, cloud-init data or the last applied manifest kubectl saves
+// in an annotation
+var (
+	redactedConfigKeys = []string{
+		"user.user-data",
+		"user.vendor-data",
+	}
+	redactedConfigPrefixes = []string{
+		"environment.",
+		"user.annotations." + annotationExecEnvPrefix,
+		"user.annotations.kubectl.kubernetes.io/last-applied-configuration",
+	}
+)
+
+// isRedactedConfigKey returns whether the value of the config key is hidden in status info
+func isRedactedConfigKey(key string) bool {
+	for _, k := range redactedConfigKeys {
+		if key == k {
+			return true
+		}
+	}
+
+	for _, prefix := range redactedConfigPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// profileInfo serializes the config and devices of the sandbox profile for the verbose status, without values which
+// may contain credentials
+func profileInfo(p *api.ProfilePut) string {
+	config := make(map[string]string, len(p.Config))
+
+	for k, v := range p.Config {
+		if isRedactedConfigKey(k) {
+			v = redactedValue
+		}
+
+		config[k] = v
+	}
+
+	// marshalling maps of strings can't fail
+	b, _ := json.Marshal(struct {
+		Config  map[string]string            `json:"config"`
+		Devices map[string]map[string]string `json:"devices"`
+	}{config, p.Devices})
+
+	return string(b)
+}
+
+// verifySandboxes checks all sandboxes for drift from lxd every interval, and logs and counts the differences
+func (s RuntimeServer) verifySandboxes(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sbs, err := s.lxf.ListSandboxes()
+		if err != nil {
+			logger.Errorf("verifySandboxes: trying to list sandboxes: %v", err)
+			continue
+		}
+
+		for _, sb := range sbs {
+			drifts, err := sb.Verify()
+			if err != nil {
+				logger.Errorf("verifySandboxes: SandboxID %v trying to verify sandbox: %v", sb.ID, err)
+				continue
+			}
+
+			for _, d := range drifts {
+				logger.Warnf("verifySandboxes: SandboxID %v drifted: %v", sb.ID, d)
+			}
+
+			metricSandboxDrifts.Add(int64(len(drifts)))
+		}
+	}
+}
+
+// imageSizeInfo formats the image size for the status info
+func imageSizeInfo(size int64, known bool) string {
+	if !known {
+		return "unknown"
+	}
+
+	return strconv.FormatInt(size, 10)
+}
+
+// streamingConfig returns the default streaming server config with the configured timeouts applied. Timeouts of 0
+// keep the defaults.
+func streamingConfig(criConfig *Config) (streaming.Config, error) {
+	cfg := streaming.DefaultConfig
+
+	if criConfig.LXEStreamingIdleTimeout < 0 {
+		return cfg, fmt.Errorf("%w: streaming idle timeout %v", ErrInvalidTimeout, criConfig.LXEStreamingIdleTimeout)
+	}
+
+	if criConfig.LXEStreamingCreationTimeout < 0 {
+		return cfg, fmt.Errorf("%w: streaming creation timeout %v", ErrInvalidTimeout, criConfig.LXEStreamingCreationTimeout)
+	}
+
+	if criConfig.LXEStreamingIdleTimeout > 0 {
+		cfg.StreamIdleTimeout = criConfig.LXEStreamingIdleTimeout
+	}
+
+	if criConfig.LXEStreamingCreationTimeout > 0 {
+		cfg.StreamCreationTimeout = criConfig.LXEStreamingCreationTimeout
+	}
+
+	return cfg, nil
+}
+
+// podMounts parses the mounts of the pod annotation, if the operator allows them
+func (s RuntimeServer) podMounts(cfg *Config, annotations map[string]string) ([]*rtApi.Mount, error) {
+	value := annotations[annotationPodMounts]
+	if value == "" {
+		return nil, nil
+	}
+
+	if !cfg.LXEAllowPodMounts {
+		return nil, fmt.Errorf("%w: annotation %v", ErrPolicy, annotationPodMounts)
+	}
+
+	return parsePodMounts(value)
+}
+
+// parsePodMounts parses a comma separated list of mounts in the form hostPath:containerPath[:ro]. Both paths must be
+// absolute.
+func parsePodMounts(value string) ([]*rtApi.Mount, error) {
+	mounts := []*rtApi.Mount{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "ro") {
+			return nil, fmt.Errorf("%w: %q is not hostPath:containerPath[:ro]", ErrInvalidMount, entry)
+		}
+
+		if !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("%w: %q must use absolute paths", ErrInvalidMount, entry)
+		}
+
+		mounts = append(mounts, &rtApi.Mount{
+			HostPath:      parts[0],
+			ContainerPath: parts[1],
+			Readonly:      len(parts) == 3,
+		})
+	}
+
+	return mounts, nil
+}
+
+// mergePodMounts adds the pod mounts to the mounts of the container. Mounts of the container take precedence, so pod
+// mounts ending up on the same container path are left out. Pod mounts conflicting with each other are kept, so they
+// are refused like any other duplicate.
+func mergePodMounts(mounts, podMounts []*rtApi.Mount, annotations map[string]string) []*rtApi.Mount {
+	merged := append([]*rtApi.Mount{}, mounts...)
+	used := map[string]bool{}
+
+	for _, mnt := range mounts {
+		used[remapMountPath(mnt.GetContainerPath(), annotations)] = true
+	}
+
+	for _, mnt := range podMounts {
+		containerPath := remapMountPath(mnt.GetContainerPath(), annotations)
+		if used[containerPath] {
+			logger.Infof("Pod mount of %v to %v is overridden by a mount of the container", mnt.GetHostPath(), containerPath)
+			continue
+		}
+
+		merged = append(merged, mnt)
+	}
+
+	return merged
+}
+
+// Permissions of mount sources created on demand, as kubelet creates them for hostPath volumes
+const (
+	mountSourceDirMode  = 0755
+	mountSourceFileMode = 0644
+)
+
+// mountPathSet returns the cleaned paths of the comma separated list
+func mountPathSet(list string) map[string]bool {
+	paths := map[string]bool{}
+
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths[path.Clean(p)] = true
+		}
+	}
+
+	return paths
+}
+
+// ensureMountSources checks the host paths of the mounts exist, so a missing one fails with a clear error instead of
+// deep in lxd. Paths listed in the create annotations of the pod are created if missing.
+func ensureMountSources(mounts []*rtApi.Mount, annotations map[string]string) error {
+	dirs := mountPathSet(annotations[annotationMountDirectoryOrCreate])
+	files := mountPathSet(annotations[annotationMountFileOrCreate])
+
+	for _, mnt := range mounts {
+		source := path.Clean(mnt.GetHostPath())
+
+		_, err := os.Stat(source)
+		if err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		switch {
+		case dirs[source]:
+			err = os.MkdirAll(source, mountSourceDirMode)
+		case files[source]:
+			err = createMountSourceFile(source)
+		default:
+			return fmt.Errorf("%w: %v", ErrMountSourceMissing, source)
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to create mount source %v: %w", source, err)
+		}
+
+		logger.Infof("Created missing mount source %v", source)
+	}
+
+	return nil
+}
+
+// createMountSourceFile creates an empty file including its parent directories
+func createMountSourceFile(source string) error {
+	err := os.MkdirAll(path.Dir(source), mountSourceDirMode)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(source, os.O_CREATE|os.O_RDONLY, mountSourceFileMode)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// toDiskDevices converts the mounts into disk devices. Since different mounts can end up on the same container path after
+// remapping, which would silently replace each other, this is refused.
+func toDiskDevices(mounts []*rtApi.Mount, annotations map[string]string) ([]*device.Disk, error) {
+	disks := []*device.Disk{}
+	sources := map[string]string{}
+
+	for _, mnt := range mounts {
+		hostPath := mnt.GetHostPath()
+		containerPath := remapMountPath(mnt.GetContainerPath(), annotations)
+
+		if source, has := sources[containerPath]; has {
+			return nil, fmt.Errorf("%w: %v and %v are both mounted to %v", ErrDuplicateMount, source, hostPath, containerPath)
+		}
+
+		sources[containerPath] = hostPath
+
+		disks = append(disks, &device.Disk{
+			Path:     containerPath,
+			Source:   hostPath,
+			Readonly: mnt.GetReadonly(),
+			Optional: false,
+		})
+	}
+
+	return disks, nil
+}
+
+// readonlyRootDisk returns a readonly root disk on the configured storage pool, or the one lxd uses for root disks if
+// none is configured. Volumes are separate disk devices mounted on top of it, so they stay writable unless they are
+// readonly themselves.
+func (s RuntimeServer) readonlyRootDisk(cfg *Config) (*device.Disk, error) {
+	pool := cfg.LXDStoragePool
+	if pool == "" {
+		var err error
+
+		pool, err = s.lxf.GetRootPool()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &device.Disk{
+		Path:     "/",
+		Readonly: true,
+		Pool:     pool,
+	}, nil
+}
+
+// remapMountPath moves container paths away from /var/run and /run, unless the path is listed in the exempt
+// annotation. Most distros symlink /var/run to /run, which lxd doesn't like for mounts, and mount a tmpfs on top of /run
+// which hides mounts from lxd. Exempt paths are kept verbatim, but the user is warned they likely won't be visible.
+func remapMountPath(containerPath string, annotations map[string]string) string {
+	for _, exempt := range strings.Split(annotations[annotationMountRemapExempt], ",") {
+		if path.Clean(strings.TrimSpace(exempt)) != path.Clean(containerPath) {
+			continue
+		}
+
+		if strings.HasPrefix(containerPath, "/var/run") || strings.HasPrefix(containerPath, "/run") {
+			logger.Warnf("Mount %v is exempt from remapping, it is likely not visible if the distro mounts a tmpfs on /run or symlinks /var/run to it", containerPath)
+		}
+
+		return containerPath
+	}
+
+	// cannot use /var/run as most distros symlink that to /run and lxd doesn't like mounts there because of that
+	if strings.HasPrefix(containerPath, "/var/run") {
+		containerPath = path.Join("/run", strings.TrimPrefix(containerPath, "/var/run"))
+	}
+	// cannot use /run as most distros mount a tmpfs on top of that so mounts from lxd are not visible in the container
+	if strings.HasPrefix(containerPath, "/run") {
+		containerPath = path.Join("/mnt", strings.TrimPrefix(containerPath, "/run"))
+	}
+
+	return containerPath
+}
+
+// checkExecCommand refuses empty commands, and commands which are denied or not allowed by the exec policy. The
+// command is matched by its first argument, which is the binary.
+func (s RuntimeServer) checkExecCommand(cfg *Config, cmd []string) error {
+	if len(cmd) == 0 || cmd[0] == "" {
+		return ErrEmptyCommand
+	}
+
+	if matchExecCommand(cfg.LXEExecDeny, cmd[0]) {
+		return fmt.Errorf("%w: command %v is denied", ErrPolicy, cmd[0])
+	}
+
+	if len(cfg.LXEExecAllow) > 0 && !matchExecCommand(cfg.LXEExecAllow, cmd[0]) {
+		return fmt.Errorf("%w: command %v isn't allowed", ErrPolicy, cmd[0])
+	}
+
+	return nil
+}
+
+// matchExecCommand returns whether the binary is in the list of commands. Commands with a path must match the binary
+// exactly, commands without only need to match its name, so "sh" matches "/bin/sh" too.
+func matchExecCommand(commands []string, binary string) bool {
+	for _, command := range commands {
+		if strings.Contains(command, "/") {
+			if path.Clean(command) == path.Clean(binary) {
+				return true
+			}
+
+			continue
+		}
+
+		if command == path.Base(binary) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prepareExec returns the additional environment variables, the user the pod defines for execs, nil for root, and the
+// directory it defines for execs, empty for the default.
+func (s RuntimeServer) prepareExec(containerID string) (map[string]string, *lxf.ExecUser, string, error) {
+	c, err := s.containers.Get(containerID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	// the exec would hang until the container is thawed
+	if c.Frozen {
+		return nil, nil, "", fmt.Errorf("%w, thaw it to exec: %v", ErrContainerFrozen, containerID)
+	}
+
+	sb, err := c.Sandbox()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	env := execEnvFromAnnotations(sb.Annotations)
+
+	cwd := sb.Annotations[annotationExecCwd]
+	if cwd != "" && !path.IsAbs(cwd) {
+		return nil, nil, "", fmt.Errorf("%w: exec directory %v of container %v isn't absolute", lxf.ErrUsage, cwd, containerID)
+	}
+
+	user := sb.Annotations[annotationExecUser]
+	if user == "" {
+		return env, nil, cwd, nil
+	}
+
+	execUser, err := parseExecUser(user)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return env, execUser, cwd, nil
+}
+
+// parseExecUser parses the exec user of the form uid[:gid]. The gid defaults to 0, like container runtimes do for a
+// numeric uid.
+func parseExecUser(user string) (*lxf.ExecUser, error) {
+	uid, gid := user, "0"
+	if i := strings.Index(user, ":"); i >= 0 {
+		uid, gid = user[:i], user[i+1:]
+	}
+
+	parsedUID, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: exec user %q isn't of the form uid[:gid]", lxf.ErrUsage, user)
+	}
+
+	parsedGID, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: exec user %q isn't of the form uid[:gid]", lxf.ErrUsage, user)
+	}
+
+	return &lxf.ExecUser{UID: uint32(parsedUID), GID: uint32(parsedGID)}, nil
+}
+
+// execEnvFromAnnotations collects the environment variables for execs from the annotations. CRI doesn't pass an
+// environment for exec, so they are taken from the pod annotations.
+func execEnvFromAnnotations(annotations map[string]string) map[string]string {
+	env := map[string]string{}
+
+	for k, v := range annotations {
+		if strings.HasPrefix(k, annotationExecEnvPrefix) {
+			env[strings.TrimPrefix(k, annotationExecEnvPrefix)] = v
+		}
+	}
+
+	return env
+}
+
+// applyRawIdmap sets the raw.idmap of the container from the pod annotation, which maps host uid and gid ranges into the
+// container. The host ids must be within the range the operator allows, which never includes root of the host.
+// Privileged containers don't use an idmap, so it's refused for them.
+func (s RuntimeServer) applyRawIdmap(cfg *Config, c *lxf.Container, sb *lxf.Sandbox) error {
+	idmap := sb.Annotations[annotationRawIdmap]
+	if idmap == "" {
+		return nil
+	}
+
+	if cfg.LXEAllowRawIdmap == "" {
+		return fmt.Errorf("%w: annotation %v", ErrPolicy, annotationRawIdmap)
+	}
+
+	err := validateRawIdmap(idmap)
+	if err != nil {
+		return err
+	}
+
+	sbPrivileged, _ := strconv.ParseBool(sb.Config["security.privileged"])
+	if c.Privileged || sbPrivileged {
+		return fmt.Errorf("%w: privileged containers don't use an idmap", ErrInvalidIdmap)
+	}
+
+	err = checkRawIdmapHosts(idmap, cfg.LXEAllowRawIdmap)
+	if err != nil {
+		return err
+	}
+
+	c.Config["raw.idmap"] = idmap
+
+	return nil
+}
+
+// validateRawIdmap checks the raw.idmap syntax, which consists of lines like "both 1000 1000" or
+// "uid 50-60 500-510". Ranges on the host and in the container must be of the same size.
+func validateRawIdmap(idmap string) error {
+	for _, line := range strings.Split(idmap, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 { // nolint: gomnd
+			return fmt.Errorf("%w: line %q must consist of type, host id and container id", ErrInvalidIdmap, line)
+		}
+
+		switch fields[0] {
+		case "uid", "gid", "both":
+		default:
+			return fmt.Errorf("%w: line %q has unknown type %v", ErrInvalidIdmap, line, fields[0])
+		}
+
+		hostStart, hostEnd, err := idmapRange(fields[1])
+		if err != nil {
+			return fmt.Errorf("%w: line %q: %v", ErrInvalidIdmap, line, err)
+		}
+
+		contStart, contEnd, err := idmapRange(fields[2])
+		if err != nil {
+			return fmt.Errorf("%w: line %q: %v", ErrInvalidIdmap, line, err)
+		}
+
+		if hostEnd-hostStart != contEnd-contStart {
+			return fmt.Errorf("%w: line %q maps ranges of different size", ErrInvalidIdmap, line)
+		}
+	}
+
+	return nil
+}
+
+// checkRawIdmapHosts refuses a valid raw.idmap whose host ids aren't all within the allowed range or include root
+func checkRawIdmapHosts(idmap, allowed string) error {
+	allowedStart, allowedEnd, err := idmapRange(allowed)
+	if err != nil {
+		return fmt.Errorf("%w: allowed host ids %v: %v", ErrInvalidIdmap, allowed, err)
+	}
+
+	for _, line := range strings.Split(idmap, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		start, end, err := idmapRange(fields[1])
+		if err != nil {
+			return fmt.Errorf("%w: line %q: %v", ErrInvalidIdmap, line, err)
+		}
+
+		if start == 0 {
+			return fmt.Errorf("%w: idmap maps root of the host", ErrPolicy)
+		}
+
+		if start < allowedStart || end > allowedEnd {
+			return fmt.Errorf("%w: host ids %v aren't in %v", ErrPolicy, fields[1], allowed)
+		}
+	}
+
+	return nil
+}
+
+// validateAllowRawIdmap checks the host ids pods may map, which must be an id range without root of the host
+func validateAllowRawIdmap(allowed string) error {
+	if allowed == "" {
+		return nil
+	}
+
+	start, _, err := idmapRange(allowed)
+	if err != nil {
+		return fmt.Errorf("%w: allowed host ids %v: %v", ErrInvalidIdmap, allowed, err)
+	}
+
+	if start == 0 {
+		return fmt.Errorf("%w: allowed host ids %v include root", ErrInvalidIdmap, allowed)
+	}
+
+	return nil
+}
+
+// idmapRange returns the first and last id of an id or id range like "1000" or "1000-1999"
+func idmapRange(r string) (uint64, uint64, error) {
+	parts := strings.SplitN(r, "-", 2) // nolint: gomnd
+
+	start, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(parts) == 1 {
+		return start, start, nil
+	}
+
+	end, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("%w: range %v ends before it starts", ErrInvalidIdmap, r)
+	}
+
+	return start, end, nil
+}
+
+// unifiedAllowed lists the cgroup v2 keys which can be set for containers
+var unifiedAllowed = map[string]bool{
+	"cpu.max":         true,
+	"cpu.weight":      true,
+	"cpuset.cpus":     true,
+	"cpuset.mems":     true,
+	"io.weight":       true,
+	"memory.high":     true,
+	"memory.low":      true,
+	"memory.max":      true,
+	"memory.min":      true,
+	"memory.swap.max": true,
+	"pids.max":        true,
+}
+
+// unifiedFromAnnotations collects the cgroup v2 keys from the annotations. The CRI version implemented doesn't provide
+// the unified resources of containers yet, so they are taken from the pod annotations meanwhile.
+func unifiedFromAnnotations(annotations map[string]string) map[string]string {
+	unified := map[string]string{}
+
+	for k, v := range annotations {
+		if strings.HasPrefix(k, annotationUnifiedPrefix) {
+			unified[strings.TrimPrefix(k, annotationUnifiedPrefix)] = v
+		}
+	}
+
+	return unified
+}
+
+// applyUnifiedResources translates raw cgroup v2 keys into lxc cgroup2 entries of the container. Keys which are not
+// allowed or values spanning multiple lines are refused.
+func applyUnifiedResources(c *lxf.Container, unified map[string]string) error {
+	keys := make([]string, 0, len(unified))
+
+	for k, v := range unified {
+		if !unifiedAllowed[k] {
+			return fmt.Errorf("%w: %v", ErrUnsupportedUnified, k)
+		}
+
+		if v == "" || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%w: %v has invalid value %q", ErrUnsupportedUnified, k, v)
+		}
+
+		keys = append(keys, k)
+	}
+
+	// keep the order stable, so the config doesn't change between applies
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		lxf.AppendIfSet(&c.Config, "raw.lxc", fmt.Sprintf("lxc.cgroup2.%s = %s", k, unified[k]))
+	}
+
+	return nil
+}
+
+// syscallsInterceptAllowed lists the syscalls lxd can intercept for unprivileged containers
+var syscallsInterceptAllowed = map[string]bool{
+	"mknod":    true,
+	"mount":    true,
+	"setxattr": true,
+}
+
+// applySyscallIntercepts sets lxd's security.syscalls.intercept options requested by the annotations. Unknown syscalls
+// and values other than booleans are refused.
+func applySyscallIntercepts(c *lxf.Container, annotations map[string]string) error {
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, annotationSyscallsInterceptPrefix) {
+			continue
+		}
+
+		syscall := strings.TrimPrefix(k, annotationSyscallsInterceptPrefix)
+		if !syscallsInterceptAllowed[syscall] {
+			return fmt.Errorf("%w: %v", ErrUnsupportedIntercept, syscall)
+		}
+
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%w: %v has invalid value %q", ErrUnsupportedIntercept, syscall, v)
+		}
+
+		c.Config["security.syscalls.intercept."+syscall] = strconv.FormatBool(enabled)
+	}
+
+	return nil
+}
+
+// applyNoNewPrivs prevents the processes of the container from gaining privileges, e.g. with setuid binaries. Privileged
+// containers can't be restricted this way, like kubernetes refuses allowPrivilegeEscalation=false for them.
+func applyNoNewPrivs(c *lxf.Container, noNewPrivs bool) error {
+	if !noNewPrivs {
+		return nil
+	}
+
+	if c.Privileged {
+		return fmt.Errorf("%w: no_new_privs can't be set for privileged containers", ErrPolicy)
+	}
+
+	lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.no_new_privs = 1")
+
+	return nil
+}
+
+// applyProcessLimit limits the number of processes of the container as fork bomb protection. The limit of the pod
+// annotation takes precedence over the configured default, and 0 means no limit.
+func (s RuntimeServer) applyProcessLimit(cfg *Config, c *lxf.Container, annotations map[string]string) error {
+	limit := int64(cfg.LXEDefaultProcessLimit)
+
+	if v, has := annotations[annotationProcessLimit]; has {
+		var err error
+
+		limit, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("%w: %q", ErrInvalidProcessLimit, v)
+		}
+	}
+
+	if limit > 0 {
+		c.Config[cfgLimitProcesses] = strconv.FormatInt(limit, 10)
+	}
+
+	return nil
+}
+
+// maxIOPriority is the highest disk io priority lxd accepts
+const maxIOPriority = 10
+
+// ioMaxKeys lists the throttles of cgroup v2 io.max
+var ioMaxKeys = map[string]bool{
+	"rbps":  true,
+	"wbps":  true,
+	"riops": true,
+	"wiops": true,
+}
+
+// ioDevicePattern matches a block device as major:minor
+var ioDevicePattern = regexp.MustCompile(`^[0-9]+:[0-9]+$`)
+
+// applyIOLimits sets the disk io priority and the io throttles per block device requested by the pod annotations. The
+// throttles are applied as raw.lxc lxc.cgroup2.io.max, so they only take effect on cgroup v2 hosts.
+func applyIOLimits(c *lxf.Container, annotations map[string]string) error {
+	if v, has := annotations[annotationIOPriority]; has {
+		priority, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || priority < 0 || priority > maxIOPriority {
+			return fmt.Errorf("%w: priority %q must be between 0 and %d", ErrInvalidIOLimit, v, maxIOPriority)
+		}
+
+		c.Config[cfgLimitDiskPriority] = strconv.Itoa(priority)
+	}
+
+	v, has := annotations[annotationIOMax]
+	if !has {
+		return nil
+	}
+
+	for _, entry := range strings.Split(v, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) < 2 || !ioDevicePattern.MatchString(fields[0]) {
+			return fmt.Errorf("%w: %q must be a device major:minor followed by throttles", ErrInvalidIOLimit, entry)
+		}
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || !ioMaxKeys[kv[0]] {
+				return fmt.Errorf("%w: unknown throttle %q of device %v", ErrInvalidIOLimit, field, fields[0])
+			}
+
+			if kv[1] == "max" {
+				continue
+			}
+
+			limit, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil || limit == 0 {
+				return fmt.Errorf("%w: throttle %q of device %v must be a positive number or max", ErrInvalidIOLimit, field, fields[0])
+			}
+		}
+
+		lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.cgroup2.io.max = "+strings.Join(fields, " "))
+	}
+
+	return nil
+}
+
+// ulimitsAllowed lists the resource names lxd can set with limits.kernel
+var ulimitsAllowed = map[string]bool{
+	"as":         true,
+	"core":       true,
+	"cpu":        true,
+	"data":       true,
+	"fsize":      true,
+	"locks":      true,
+	"memlock":    true,
+	"nice":       true,
+	"nofile":     true,
+	"nproc":      true,
+	"rtprio":     true,
+	"sigpending": true,
+}
+
+// ulimitUnlimited is the value lxd accepts for no limit
+const ulimitUnlimited = "unlimited"
+
+// applyUlimits sets the ulimits requested by the annotations as limits.kernel.<name>. The value is soft[:hard], where
+// hard defaults to soft, each a number or "unlimited". Unknown resource names and invalid values are refused.
+func applyUlimits(c *lxf.Container, annotations map[string]string) error {
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, annotationUlimitPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(k, annotationUlimitPrefix)
+		if !ulimitsAllowed[name] {
+			return fmt.Errorf("%w: unknown limit %v", ErrInvalidUlimit, name)
+		}
+
+		soft, hard, err := parseUlimit(name, v)
+		if err != nil {
+			return err
+		}
+
+		c.Config["limits.kernel."+name] = soft + ":" + hard
+	}
+
+	return nil
+}
+
+// parseUlimit parses soft[:hard] of the named limit and returns both limits, checking the soft limit doesn't exceed the
+// hard limit
+func parseUlimit(name, value string) (string, string, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("%w: %v has invalid value %q", ErrInvalidUlimit, name, value)
+	}
+
+	limits := make([]uint64, len(parts))
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == ulimitUnlimited {
+			limits[i] = math.MaxUint64
+			continue
+		}
+
+		l, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v has invalid value %q", ErrInvalidUlimit, name, value)
+		}
+
+		limits[i] = l
+	}
+
+	if len(limits) == 1 {
+		limits = append(limits, limits[0])
+	}
+
+	if limits[0] > limits[1] {
+		return "", "", fmt.Errorf("%w: %v has soft limit above hard limit in %q", ErrInvalidUlimit, name, value)
+	}
+
+	return formatUlimit(limits[0]), formatUlimit(limits[1]), nil
+}
+
+func formatUlimit(l uint64) string {
+	if l == math.MaxUint64 {
+		return ulimitUnlimited
+	}
+
+	return strconv.FormatUint(l, 10)
+}
+
+// applySharedPIDNamespace lets the container join the pid namespace of the oldest running container of the sandbox, if
+// the sandbox requests a pid namespace for the pod. There is no pause container holding the namespace, so the first
+// container started owns it.
+func applySharedPIDNamespace(c *lxf.Container, sb *lxf.Sandbox) error {
+	if sb.Config[cfgSandboxNamespacePID] != nameSpaceOptionToString(rtApi.NamespaceMode_POD) {
+		return nil
+	}
+
+	cl, err := sb.Containers()
+	if err != nil {
+		return err
+	}
+
+	owner := pidNamespaceOwner(cl)
+	if owner == nil {
+		return nil
+	}
+
+	lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.namespace.share.pid = "+owner.ID)
+
+	return nil
+}
+
+// hostNamespaces maps the namespaces which can be shared with the host to the sandbox config key of their mode
+var hostNamespaces = []struct{ name, key string }{
+	{"ipc", cfgSandboxNamespaceIPC},
+	{"pid", cfgSandboxNamespacePID},
+}
+
+// applyHostNamespaces lets the container join the ipc and pid namespaces of the host, if the sandbox requests them on
+// node level. Joining namespaces of the host needs a privileged container, so unprivileged containers are refused.
+func applyHostNamespaces(c *lxf.Container, sb *lxf.Sandbox) error {
+	for _, ns := range hostNamespaces {
+		if sb.Config[ns.key] != nameSpaceOptionToString(rtApi.NamespaceMode_NODE) {
+			continue
+		}
+
+		if !c.Privileged {
+			return fmt.Errorf("%w: host %v namespace requires a privileged container", ErrPolicy, ns.name)
+		}
+
+		lxf.AppendIfSet(&c.Config, "raw.lxc", fmt.Sprintf("lxc.namespace.share.%v = %v", ns.name, hostNamespacePID))
+	}
+
+	return nil
+}
+
+// checkContainerLimit refuses another container in the sandbox if it already has the maximum number of containers
+func (s RuntimeServer) checkContainerLimit(cfg *Config, sb *lxf.Sandbox) error {
+	max := cfg.LXEMaxContainersPerPod
+	if max > 0 && len(sb.UsedBy) >= max {
+		return fmt.Errorf("%w: %v has %v of at most %v", ErrTooManyContainers, sb.ID, len(sb.UsedBy), max)
+	}
+
+	return nil
+}
+
+// sandboxExecPid returns the init pid of the oldest running container of the sandbox with the id, whose network
+// namespace is the one of the pod. It returns 0 if exec into sandboxes isn't allowed or the id isn't a sandbox.
+func (s RuntimeServer) sandboxExecPid(cfg *Config, id string) (int64, error) {
+	if !cfg.LXEAllowSandboxExec {
+		return 0, nil
+	}
+
+	sb, err := s.lxf.GetSandbox(id)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	cl, err := sb.Containers()
+	if err != nil {
+		return 0, err
+	}
+
+	owner := pidNamespaceOwner(cl)
+	if owner == nil {
+		return 0, fmt.Errorf("%w: %v", ErrSandboxNotRunning, id)
+	}
+
+	st, err := owner.State()
+	if err != nil {
+		return 0, err
+	}
+
+	if st.Pid <= 0 {
+		return 0, fmt.Errorf("%w: %v", ErrSandboxNotRunning, id)
+	}
+
+	return st.Pid, nil
+}
+
+// sandboxExec runs the command of the host in the network namespace of the pid and returns its exit code. A timeout in
+// seconds of 0 means no timeout.
+func sandboxExec(pid int64, cmd []string, stdin io.Reader, stdout, stderr io.Writer, timeout int64) (int32, error) {
+	ctx := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+
+	args := append([]string{"--target", strconv.FormatInt(pid, 10), "--net", "--"}, cmd...)
+
+	command := exec.CommandContext(ctx, "nsenter", args...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	var exitErr *exec.ExitError
+
+	err := command.Run()
+	if errors.As(err, &exitErr) {
+		return int32(exitErr.ExitCode()), nil
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+// pidNamespaceOwner returns the oldest running container, nil if none is running
+func pidNamespaceOwner(cl []*lxf.Container) *lxf.Container {
+	var owner *lxf.Container
+
+	for _, c := range cl {
+		if c.StateName != lxf.ContainerStateRunning {
+			continue
+		}
+
+		if owner == nil || c.CreatedAt.Before(owner.CreatedAt) {
+			owner = c
+		}
+	}
+
+	return owner
+}
+
+// applyOOMScoreAdj sets the oom score adjustment of the container processes. Kubelet computes the adjustment from the
+// QoS class of the pod, so guaranteed pods are protected and best-effort pods are killed first. If kubelet doesn't
+// provide an adjustment, it is derived from the QoS class annotation of the pod instead.
+func applyOOMScoreAdj(c *lxf.Container, sb *lxf.Sandbox, adj int64) {
+	if adj == 0 {
+		switch strings.ToLower(sb.Annotations[annotationQOSClass]) {
+		case qosClassGuaranteed:
+			adj = oomScoreAdjGuaranteed
+		case qosClassBestEffort:
+			adj = oomScoreAdjBestEffort
+		default:
+			return
+		}
+	}
+
+	if adj < oomScoreAdjMin {
+		adj = oomScoreAdjMin
+	} else if adj > oomScoreAdjMax {
+		adj = oomScoreAdjMax
+	}
+
+	lxf.AppendIfSet(&c.Config, "raw.lxc", fmt.Sprintf("lxc.proc.oom_score_adj = %d", adj))
+}
+
+// defaultKeyRegex matches keys of default labels and annotations which are valid as part of lxd config keys, like
+// "team" or "example.com/cost-center"
+var defaultKeyRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// validateDefaultKeys checks the keys of the default labels or annotations. Keys of LXE's annotations are refused, so
+// defaults can't change how pods are run.
+func validateDefaultKeys(defaults map[string]string) error {
+	for key := range defaults {
+		if !defaultKeyRegex.MatchString(key) {
+			return fmt.Errorf("%w: %q", ErrInvalidDefaultKey, key)
+		}
+
+		if strings.HasPrefix(key, "x-lxe-") {
+			return fmt.Errorf("%w: %v is reserved for annotations of LXE", ErrInvalidDefaultKey, key)
+		}
+	}
+
+	return nil
+}
+
+// withDefaults returns a copy of values with the defaults added, values already given are kept
+func withDefaults(values, defaults map[string]string) map[string]string {
+	merged := make(map[string]string, len(values)+len(defaults))
+
+	for k, v := range defaults {
+		merged[k] = v
+	}
+
+	for k, v := range values {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// validateMountCollision checks the policy for mounts over files in the image
+func validateMountCollision(policy string) error {
+	switch policy {
+	case "", MountCollisionOverlay, MountCollisionError, MountCollisionSkip:
+		return nil
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownMountCollision, policy)
+	}
+}
+
+// handleMountCollisions looks for host path mounts of the new container whose path exists in its image with another
+// type than the source, a directory over a file or a file over a directory. LXD mounts over it anyway, which hides the
+// path of the image and fails confusingly. Files mounted over files, like kubelet does for /etc/hosts, aren't
+// collisions. Depending on the policy, such mounts are kept, refuse the container or are left out. Each collision is
+// logged.
+func (s RuntimeServer) handleMountCollisions(cfg *Config, c *lxf.Container) error {
+	policy := cfg.LXEMountCollision
+	kept := device.Devices{}
+
+	for _, dev := range c.Devices {
+		d, is := dev.(*device.Disk)
+		if !is || d.Source == "" || d.Pool != "" {
+			kept = append(kept, dev)
+			continue
+		}
+
+		fileType, err := c.PathType(d.Path)
+		if err != nil {
+			return err
+		}
+
+		if fileType == "" {
+			kept = append(kept, dev)
+			continue
+		}
+
+		info, err := os.Stat(d.Source)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() == (fileType == lxf.FileTypeDirectory) {
+			kept = append(kept, dev)
+			continue
+		}
+
+		sourceType := "file"
+		if info.IsDir() {
+			sourceType = lxf.FileTypeDirectory
+		}
+
+		switch policy {
+		case MountCollisionError:
+			return fmt.Errorf("%w: %v is a %v, but source %v is a %v", ErrMountCollision, d.Path, fileType, d.Source, sourceType)
+		case MountCollisionSkip:
+			logger.Warnf("Container %v: skipping mount of %v %v to %v, which is a %v in the image", c.ID, sourceType, d.Source, d.Path, fileType)
+			continue
+		default:
+			logger.Warnf("Container %v: mounting %v %v over %v, which is a %v in the image", c.ID, sourceType, d.Source, d.Path, fileType)
+		}
+
+		kept = append(kept, dev)
+	}
+
+	if len(kept) == len(c.Devices) {
+		return nil
+	}
+
+	c.Devices = kept
+
+	return c.Apply()
+}
+
+// parseConsoleBufferSize parses a size like 4MiB into bytes. An empty size returns 0.
+func parseConsoleBufferSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	n, err := sharedLXD.ParseByteSizeString(size)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidConsoleBuffer, err)
+	}
+
+	if n < consoleBufferSizeMin || n > consoleBufferSizeMax {
+		return 0, fmt.Errorf("%w: %v not between %v and %v bytes", ErrInvalidConsoleBuffer, size, consoleBufferSizeMin, consoleBufferSizeMax)
+	}
+
+	return n, nil
+}
+
+// containerNameData are the fields available in the container name template
+type containerNameData struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// parseContainerNameTemplate parses the template for the name prefix of new containers. It's executed once to catch
+// unknown fields early. An empty template returns nil.
+func parseContainerNameTemplate(tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+
+	t, err := template.New("container-name").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNameTemplate, err)
+	}
+
+	err = t.Execute(ioutil.Discard, containerNameData{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidNameTemplate, err)
+	}
+
+	return t, nil
+}
+
+// containerNamePrefix renders the name prefix of a new container from the configured template, empty if none is set
+func (s RuntimeServer) containerNamePrefix(meta *rtApi.PodSandboxMetadata, name string) string {
+	if s.containerName == nil {
+		return ""
+	}
+
+	b := &bytes.Buffer{}
+
+	err := s.containerName.Execute(b, containerNameData{
+		Namespace: meta.GetNamespace(),
+		Pod:       meta.GetName(),
+		Container: name,
+	})
+	if err != nil {
+		logger.Errorf("CreateContainer: ContainerName %v trying to render name template: %v", name, err)
+		return ""
+	}
+
+	return b.String()
+}
+
+// applyConsoleBufferSize sets the size of the console log ring buffer lxd keeps in memory for the container. Nothing
+// is set if size is 0.
+func applyConsoleBufferSize(c *lxf.Container, size int64) {
+	if size == 0 {
+		return
+	}
+
+	lxf.AppendIfSet(&c.Config, "raw.lxc", fmt.Sprintf("lxc.console.buffer.size = %d", size))
+}
+
+// applyConsoleLog keeps the console log of the container in a ring buffer of bufferSize bytes, unless the pod disables
+// it with the console log annotation for the container. Then lxc neither buffers the output nor writes it to lxd's
+// console log file, which saves the overhead for containers with a lot of output.
+func applyConsoleLog(c *lxf.Container, annotations map[string]string, bufferSize int64) error {
+	key := annotationConsoleLogPrefix + c.Metadata.Name
+
+	if value, has := annotations[key]; has {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("annotation %v: %w", key, err)
+		}
+
+		if !enabled {
+			lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.console.buffer.size = 0\nlxc.console.logfile =")
+			return nil
+		}
+	}
+
+	applyConsoleBufferSize(c, bufferSize)
+
+	return nil
+}
+
+// cfgBootAutostart is the lxd config key whether lxd starts the container when lxd starts
+const cfgBootAutostart = "boot.autostart"
+
+// applyAutostart disables starting the container when lxd restarts, as kubelet decides which containers run. Otherwise
+// containers of pods kubelet considers stopped would run again. The autostart annotation of the pod can enable it.
+func applyAutostart(c *lxf.Container, annotations map[string]string) error {
+	autostart := false
+
+	if value, has := annotations[annotationAutostart]; has {
+		var err error
+
+		autostart, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("annotation %v: %w", annotationAutostart, err)
+		}
+	}
+
+	c.Config[cfgBootAutostart] = strconv.FormatBool(autostart)
+
+	return nil
+}
+
+// applyNesting enables nested containers if the pod requests it with the nesting annotation and the operator allows it
+func (s RuntimeServer) applyNesting(cfg *Config, c *lxf.Container, annotations map[string]string) error {
+	value, has := annotations[annotationNesting]
+	if !has {
+		return nil
+	}
+
+	nesting, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("annotation %v: %w", annotationNesting, err)
+	}
+
+	if nesting && !cfg.LXEAllowNesting {
+		return fmt.Errorf("%w: annotation %v", ErrPolicy, annotationNesting)
+	}
+
+	c.Nesting = nesting
+
+	return nil
+}
+
+// resolveRestoreFrom returns the checkpoint archive with its symlinks resolved, if restores are allowed and it's in the
+// allowed directory. Resolving the symlinks first keeps them from pointing out of it.
+func resolveRestoreFrom(archive, allowedDir string) (string, error) {
+	if allowedDir == "" {
+		return "", fmt.Errorf("%w: annotation %v", ErrPolicy, annotationRestoreFromPrefix+"*")
+	}
+
+	if !filepath.IsAbs(archive) {
+		return "", fmt.Errorf("%w: checkpoint archive %v isn't absolute", lxf.ErrUsage, archive)
+	}
+
+	dir, err := filepath.EvalSymlinks(allowedDir)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(archive)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: checkpoint archive %v isn't in %v", ErrPolicy, archive, allowedDir)
+	}
+
+	return resolved, nil
+}
+
+// restorePolicyConfigPrefixes are the config keys of a checkpoint which affect the isolation of the container
+var restorePolicyConfigPrefixes = []string{"security.", "raw.", "linux."}
+
+// checkRestore refuses to restore the container from a checkpoint which carries config or devices the container created
+// for it doesn't get. The restored container starts with the config and devices of the checkpoint, before those of the
+// container are applied, so they would bypass the policy the container was checked against.
+func checkRestore(c *lxf.Container, sb *lxf.Sandbox) error {
+	info, err := lxf.ReadCheckpointInfo(c.RestoreFrom)
+	if err != nil {
+		return err
+	}
+
+	err = checkRestoreConfig(c, sb, info.Config)
+	if err != nil {
+		return err
+	}
+
+	return checkRestoreDevices(c, info.Devices)
+}
+
+// checkRestoreConfig refuses keys of the checkpoint config affecting isolation which the container doesn't set the same.
+// Unset and false values don't grant anything, and the lines of raw.lxc must all be set for the container or its pod.
+func checkRestoreConfig(c *lxf.Container, sb *lxf.Sandbox, config map[string]string) error {
+	sbPrivileged, _ := strconv.ParseBool(sb.Config["security.privileged"])
+
+	own := map[string]string{}
+	for k, v := range c.Config {
+		own[k] = v
+	}
+
+	own["security.privileged"] = strconv.FormatBool(c.Privileged || sbPrivileged)
+	own["security.nesting"] = strconv.FormatBool(c.Nesting)
+
+	rawLXC := map[string]bool{}
+	for _, line := range strings.Split(lxf.MergeRawLXC(sb.Config["raw.lxc"], c.Config["raw.lxc"]), "\n") {
+		rawLXC[line] = true
+	}
+
+	for k, v := range config {
+		if !hasAnyPrefix(k, restorePolicyConfigPrefixes) || v == "" || v == "false" {
+			continue
+		}
+
+		if k != "raw.lxc" {
+			if v != own[k] {
+				return fmt.Errorf("%w: checkpoint sets %v to %q", ErrPolicy, k, v)
+			}
+
+			continue
+		}
+
+		for _, line := range strings.Split(lxf.MergeRawLXC(v), "\n") {
+			if !rawLXC[line] {
+				return fmt.Errorf("%w: checkpoint sets raw.lxc %q", ErrPolicy, line)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRestoreDevices refuses devices of the checkpoint the container doesn't have. Disks need a disk of the container
+// with the same source, which is readonly if the container's is, devices of other types an identical one. Disks without
+// source and devices of type none don't grant access to the host.
+func checkRestoreDevices(c *lxf.Container, devices map[string]map[string]string) error {
+	own := []map[string]string{}
+	for _, d := range c.Devices {
+		_, options := d.ToMap()
+		own = append(own, options)
+	}
+
+	for name, options := range devices {
+		switch {
+		case options["type"] == device.NoneType:
+			continue
+		case options["type"] == device.DiskType && options["source"] == "":
+			continue
+		}
+
+		if !hasRestoreDevice(own, options) {
+			return fmt.Errorf("%w: checkpoint device %v %v isn't one of the container", ErrPolicy, name, options["source"])
+		}
+	}
+
+	return nil
+}
+
+// hasRestoreDevice returns whether the device options of the checkpoint match one of the devices of the container
+func hasRestoreDevice(own []map[string]string, options map[string]string) bool {
+	for _, o := range own {
+		if options["type"] != o["type"] {
+			continue
+		}
+
+		if options["type"] == device.DiskType {
+			if options["source"] == o["source"] && options["pool"] == o["pool"] && (o["readonly"] != "true" || options["readonly"] == "true") {
+				return true
+			}
+
+			continue
+		}
+
+		if equalNonEmpty(options, o) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equalNonEmpty returns whether a and b have the same values, ignoring empty ones
+func equalNonEmpty(a, b map[string]string) bool {
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			if a[k] != b[k] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// hasAnyPrefix returns whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// savePodIP saves the ip last reported for the sandbox of the container in the sandbox. Failures are only logged, as
+// it's only needed to tell ip changes.
+func (s RuntimeServer) savePodIP(c *lxf.Container) {
+	sb, err := c.Sandbox()
+	if err != nil {
+		logger.Warnf("unable to get sandbox of container %v to save its ip: %v", c.ID, err)
+		return
+	}
+
+	ip := s.podIPs.Last(sb.ID)
+	if ip == "" || ip == sb.LastIP {
+		return
+	}
+
+	sb.LastIP = ip
+
+	err = sb.Apply()
+	if err != nil {
+		logger.Warnf("unable to save ip %v of sandbox %v: %v", ip, sb.ID, err)
+	}
+}
+
+// checkPrivileged refuses a privileged pod or container unless privileged ones are allowed
+func (s RuntimeServer) checkPrivileged(cfg *Config, privileged bool, what string) error {
+	if privileged && !cfg.LXEAllowPrivileged {
+		return fmt.Errorf("%w: privileged %v", ErrPolicy, what)
+	}
+
+	return nil
+}
+
+// applySeccompProfile translates the seccomp profile of the container into lxc config. If the container doesn't define
+// a profile itself, the one of the sandbox is used. Localhost profiles must be in the lxc seccomp policy format.
+func (s RuntimeServer) applySeccompProfile(cfg *Config, c *lxf.Container, sb *lxf.Sandbox, profile string) error {
+	if profile == "" {
+		profile = sb.Config[cfgSandboxSeccompProfilePath]
+	}
+
+	switch {
+	case profile == "", profile == seccompProfileRuntimeDefault, profile == seccompProfileDockerDefault:
+		// lxd applies its default seccomp policy
+	case profile == seccompProfileUnconfined:
+		if !cfg.LXEAllowUnconfinedSeccomp {
+			return fmt.Errorf("%w: seccomp profile %v", ErrPolicy, profile)
+		}
+
+		logger.Warnf("Container %v of sandbox %v runs without seccomp filtering", c.Metadata.Name, sb.ID)
+		lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.seccomp.profile =")
+	case strings.HasPrefix(profile, seccompProfileLocalhostPrefix):
+		lxf.AppendIfSet(&c.Config, "raw.lxc", "lxc.seccomp.profile = "+strings.TrimPrefix(profile, seccompProfileLocalhostPrefix))
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownSeccompProfile, profile)
+	}
+
+	return nil
+}
+
+// stopContainers stops the containers of the sandbox within the grace period. The grace period is a budget shared by
+// the containers, not given to each, so stopping the sandbox doesn't take the sum of them: each container gets what's
+// left of it, and once it's used up the remaining containers are stopped immediately.
+func (s RuntimeServer) stopContainers(sb *lxf.Sandbox, gracePeriod time.Duration) error {
+	cl, err := sb.Containers()
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(gracePeriod)
+
+	for _, c := range stopOrder(cl, sb.Annotations) {
+		err := s.stopContainer(c, stopTimeout(deadline, time.Now()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopOrder returns the containers in the order they are stopped: the ones named in the stop order annotation first and
+// in its order, then the others in reverse start order, so e.g. a sidecar started before the app outlives it
+func stopOrder(cl []*lxf.Container, annotations map[string]string) []*lxf.Container {
+	rank := map[string]int{}
+
+	for _, name := range strings.Split(annotations[annotationStopOrder], ",") {
+		name = strings.TrimSpace(name)
+		if _, has := rank[name]; name != "" && !has {
+			rank[name] = len(rank)
+		}
+	}
+
+	ordered := append([]*lxf.Container{}, cl...)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ri, hasI := rank[ordered[i].Metadata.Name]
+		rj, hasJ := rank[ordered[j].Metadata.Name]
+
+		if hasI || hasJ {
+			return hasI && (!hasJ || ri < rj)
+		}
+
+		return ordered[i].StartedAt.After(ordered[j].StartedAt)
+	})
+
+	return ordered
+}
+
+// podGracePeriod returns the termination grace period of the sandbox as kubelet recorded it on its containers, or the
+// default if none did. CRI v1alpha2 doesn't tell it when stopping the sandbox.
+func podGracePeriod(sb *lxf.Sandbox) time.Duration {
+	cl, err := sb.Containers()
+	if err != nil {
+		return defaultTerminationGracePeriod
+	}
+
+	return containersGracePeriod(cl)
+}
+
+// containersGracePeriod returns the longest termination grace period recorded on the containers, or the default if none
+// is
+func containersGracePeriod(cl []*lxf.Container) time.Duration {
+	gracePeriod := time.Duration(-1)
+
+	for _, c := range cl {
+		seconds, err := strconv.ParseInt(c.Annotations[annotationTerminationGracePeriod], 10, 64)
+		if err != nil || seconds < 0 {
+			continue
+		}
+
+		if d := time.Duration(seconds) * time.Second; d > gracePeriod {
+			gracePeriod = d
+		}
+	}
+
+	if gracePeriod < 0 {
+		return defaultTerminationGracePeriod
+	}
+
+	return gracePeriod
+}
+
+// stopTimeout returns the whole seconds left until the deadline, rounded up, or 0 to stop immediately once it passed
+func stopTimeout(deadline, now time.Time) int {
+	left := deadline.Sub(now)
+	if left <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(left.Seconds()))
+}
+
+func (s RuntimeServer) stopContainer(c *lxf.Container, timeout int) error {
+	defer s.containers.Invalidate()
+
+	// if container is not running, no stopping needed
+	if c.StateName != lxf.ContainerStateRunning {
+		return nil
+	}
+
+	// frozen processes can't shut down gracefully
+	if c.Frozen {
+		err := c.Thaw()
+		if err != nil {
+			logger.Warnf("Unable to thaw container %v before stopping it: %v", c.ID, err)
+		}
+	}
+
+	err := c.Stop(timeout)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	// the stop is also posted when lxd reports it, whichever comes first
+	s.webhook.notify(webhookEventStopped, c)
+
+	return nil
+}
+
+func (s RuntimeServer) deleteContainers(ctx context.Context, sb *lxf.Sandbox) error {
+	cl, err := sb.Containers()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cl {
+		err = s.deleteContainer(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPostCreateHook refuses a post-create command of the pod unless the operator allows them, as it runs as root
+// regardless of the exec policy
+func (s RuntimeServer) checkPostCreateHook(cfg *Config, annotations map[string]string) error {
+	if annotations[annotationPostCreate] != "" && !cfg.LXEAllowPostCreate {
+		return fmt.Errorf("%w: annotation %v", ErrPolicy, annotationPostCreate)
+	}
+
+	return nil
+}
+
+// runPostCreateHook runs the post-create command of the pod in the container, which is started transiently for this.
+// If the command fails, the container is removed again.
+func (s RuntimeServer) runPostCreateHook(ctx context.Context, c *lxf.Container, annotations map[string]string) error {
+	hook := annotations[annotationPostCreate]
+	if hook == "" {
+		return nil
+	}
+
+	out, err := c.RunHook([]string{"/bin/sh", "-c", hook}, postCreateTimeout)
+	if err == nil {
+		return nil
+	}
+
+	if delErr := s.deleteContainer(ctx, c); delErr != nil {
+		logger.Errorf("unable to remove container %v after failed post-create hook: %v", c.ID, delErr)
+	}
+
+	return fmt.Errorf("%w, output: %s", err, out)
+}
+
+func (s RuntimeServer) deleteContainer(ctx context.Context, c *lxf.Container) error {
+	defer s.containers.Invalidate()
+
+	err := c.Delete()
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	// containers which fail to be created were never reported as created
+	if !c.Creating() {
+		s.webhook.notify(webhookEventDeleted, c)
+	}
+
+	sb, err := c.Sandbox()
+	if err != nil {
+		return err
+	}
+
+	// remove network, failures must not fail the removal
+	if sb.NetworkConfig.Mode != lxf.NetworkHost {
+		s.retryNetworkTeardown(ctx, "delete", c.ID, func(ctx context.Context) error {
+			podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+			if err != nil {
+				return err
+			}
+
+			contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
+			if err != nil {
+				return err
+			}
+
+			return contNet.WhenDeleted(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
+		})
+	}
+
+	return nil
+}
+
+// Timeout of a single network teardown attempt and the delay between the attempts
+const (
+	networkTeardownTimeout = 30 * time.Second
+	networkTeardownBackoff = time.Second
+)
+
+// retryNetworkTeardown calls teardown until it succeeds or the configured retries are exhausted. As stopping and
+// removing must be idempotent, the final failure is only logged, counted and tracked until a later teardown of the same
+// pod or container succeeds, as it may leak ip allocations.
+func (s RuntimeServer) retryNetworkTeardown(ctx context.Context, action, id string, teardown func(context.Context) error) {
+	var err error
+
+	retries := s.criConfig().LXENetworkTeardownRetries
+
+retry:
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				break retry
+			case <-time.After(time.Duration(attempt) * networkTeardownBackoff):
+			}
+		}
+
+		tctx, cancel := context.WithTimeout(ctx, networkTeardownTimeout)
+		err = teardown(tctx)
+
+		cancel()
+
+		if err == nil {
+			s.networkLeaks.Resolve(id)
+			return
+		}
+
+		logger.Warnf("Network %v of %v failed in attempt %d: %v", action, id, attempt+1, err)
+	}
+
+	metricNetworkTeardownFailures.Add(1)
+	s.networkLeaks.Record(id, action, err)
+	logger.Errorf("Network %v of %v failed, ip allocations may have leaked, see network.leaks in the verbose runtime status: %v", action, id, err)
+}
+
+// networkRetryMaxBackoff caps the exponential delay between the attempts of a network plugin call
+const networkRetryMaxBackoff = 10 * time.Second
+
+// permanentNetworkErrors are errors of the network plugins caused by their configuration, retrying won't help
+var permanentNetworkErrors = []error{
+	network.ErrNoop,
+	network.ErrNotBridge,
+	network.ErrIncompatibleNetwork,
+	network.ErrUnsupportedNicType,
+	network.ErrNicParent,
+	network.ErrInvalidMTU,
+	network.ErrPolicy,
+	network.ErrNotImplemented,
+	network.ErrNoNetworksFound,
+	network.ErrNoUpdateRuntimeConfig,
+}
+
+// isPermanentNetworkError returns whether a failed network plugin call must not be retried
+func isPermanentNetworkError(err error) bool {
+	for _, perm := range permanentNetworkErrors {
+		if errors.Is(err, perm) {
+			return true
+		}
+	}
+
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// networkRetryDelay returns the delay before the given retry, which doubles with each retry up to
+// networkRetryMaxBackoff. A random jitter of up to half the delay spreads retries of concurrent calls.
+func networkRetryDelay(base time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	delay := networkRetryMaxBackoff
+	if retry < 32 && base<<uint(retry-1) < networkRetryMaxBackoff {
+		delay = base << uint(retry-1)
+	}
+
+	return delay - time.Duration(rand.Int63n(int64(delay/2)+1)) // nolint: gosec
+}
+
+// retryNetwork calls the network plugin until it succeeds, fails permanently or the configured retries are
+// exhausted, and returns the last error.
+func (s RuntimeServer) retryNetwork(ctx context.Context, action, id string, call func(context.Context) error) error {
+	var err error
+
+	cfg := s.criConfig()
+
+	for attempt := 0; attempt <= cfg.LXENetworkRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(networkRetryDelay(cfg.LXENetworkRetryBackoff, attempt)):
+			}
+		}
+
+		err = call(ctx)
+		if err == nil || isPermanentNetworkError(err) {
+			return err
+		}
+
+		logger.Warnf("Network %v of %v failed in attempt %d: %v", action, id, attempt+1, err)
+	}
+
+	return err
+}
+
+// createSandboxNetwork creates and starts the network of the sandbox, unless it uses the host network
+func (s RuntimeServer) createSandboxNetwork(ctx context.Context, sb *lxf.Sandbox) error {
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		return nil
+	}
+
+	podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+	if err != nil {
+		err := errors.Wrap(err, fmt.Sprintf("can't enter sandbox %v network context", sb.ID))
+		logger.Error(err.Error())
+
+		return err
+	}
+
+	var res *network.Result
+
+	err = s.retryNetwork(ctx, "create", sb.ID, func(ctx context.Context) error {
+		res, err = podNet.WhenCreated(ctx, &network.Properties{})
+		return err
+	})
+	if err != nil {
+		err := errors.Wrap(err, fmt.Sprintf("can't create sandbox %v network context", sb.ID))
+		logger.Error(err.Error())
+
+		return err
+	}
+
+	err = s.handleNetworkResult(sb, res)
+	if err != nil {
+		err := errors.Wrap(err, fmt.Sprintf("can't save create sandbox %v network result", sb.ID))
+		logger.Error(err.Error())
+
+		return err
+	}
+
+	// Since a PodSandbox is created "started", also fire started network
+	err = s.retryNetwork(ctx, "start", sb.ID, func(ctx context.Context) error {
+		res, err = podNet.WhenStarted(ctx, &network.PropertiesRunning{
+			Properties: network.Properties{
+				Data: sb.NetworkConfig.ModeData,
+			},
+			Pid: 0, // if we had real 1:n pod:container we would add here the pid of the pod process
+		})
+
+		return err
+	})
+	if err != nil {
+		err := errors.Wrap(err, fmt.Sprintf("can't start sandbox %v network context", sb.ID))
+		logger.Error(err.Error())
+
+		return err
+	}
+
+	err = s.handleNetworkResult(sb, res)
+	if err != nil {
+		err := errors.Wrap(err, fmt.Sprintf("can't save start sandbox %v network result", sb.ID))
+		logger.Error(err.Error())
+
+		return err
+	}
+
+	return nil
+}
+
+// createContainerNetwork creates the network of the container, unless its sandbox uses the host network
+func (s RuntimeServer) createContainerNetwork(ctx context.Context, sb *lxf.Sandbox, c *lxf.Container) error {
+	if sb.NetworkConfig.Mode == lxf.NetworkHost {
+		return nil
+	}
+
+	podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+	if err != nil {
+		return err
+	}
+
+	contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
+	if err != nil {
+		return err
+	}
+
+	var res *network.Result
+
+	err = s.retryNetwork(ctx, "create", c.ID, func(ctx context.Context) error {
+		res, err = contNet.WhenCreated(ctx, &network.Properties{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.handleNetworkResult(sb, res)
+}
+
+// ContainerStarted implements lxf.EventHandler interface
+func (s RuntimeServer) ContainerStarted(ctx context.Context, c *lxf.Container) error {
+	logger.Infof("ContainerStarted called: ContainerName %v", c.ID)
+
+	defer s.containers.Invalidate()
+
+	sb, err := c.Sandbox()
+	if err != nil {
+		return err
+	}
+
+	if sb.NetworkConfig.Mode != lxf.NetworkHost { // nolint: nestif
+		st, err := c.State()
+		if err != nil {
+			return err
+		}
+
+		podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("can't enter sandbox %v network context", sb.ID))
+		}
+
+		contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("can't enter container %v network context", c.ID))
+		}
+
+		var res *network.Result
+
+		err = s.retryNetwork(ctx, "start", c.ID, func(ctx context.Context) error {
+			res, err = contNet.WhenStarted(ctx, &network.PropertiesRunning{
+				Properties: network.Properties{
+					Data: sb.NetworkConfig.ModeData,
+				},
+				Pid: st.Pid,
+			})
+
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("can't start container %v network", c.ID))
+		}
+
+		err = s.handleNetworkResult(sb, res)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("can't save start container %v network result", c.ID))
+		}
+	}
+
+	return nil
+}
+
+// ContainerStopped implements lxf.EventHandler interface
+func (s *RuntimeServer) ContainerStopped(ctx context.Context, c *lxf.Container) error {
+	defer s.containers.Invalidate()
+
+	sb, err := c.Sandbox()
+	if err != nil {
+		return err
+	}
+
+	// also containers exiting on their own are reported, stops by lxe aren't posted twice
+	s.webhook.notify(webhookEventStopped, c)
+
+	// stop network, failures must not fail the stop
+	if sb.NetworkConfig.Mode != lxf.NetworkHost {
+		s.retryNetworkTeardown(ctx, "stop", c.ID, func(ctx context.Context) error {
+			podNet, err := s.network.PodNetwork(sb.ID, sb.Annotations)
+			if err != nil {
+				return err
+			}
+
+			contNet, err := podNet.ContainerNetwork(c.ID, c.Annotations)
+			if err != nil {
+				return err
+			}
+
+			return contNet.WhenStopped(ctx, &network.Properties{Data: sb.NetworkConfig.ModeData})
+		})
+	}
+
 	return nil
 }
 
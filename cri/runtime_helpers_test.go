@@ -0,0 +1,1840 @@
+package cri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automaticserver/lxe/cri/crifakes"
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/lxf/device"
+	"github.com/automaticserver/lxe/network"
+	"github.com/automaticserver/lxe/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/logging"
+	opencontainers "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/server/streaming"
+)
+
+func testRuntimeServer() RuntimeServer {
+	return RuntimeServer{
+		config: newConfigHolder(&Config{}),
+	}
+}
+
+func testContainer() *lxf.Container {
+	c := &lxf.Container{}
+	c.Config = make(map[string]string)
+	c.Environment = make(map[string]string)
+
+	return c
+}
+
+func testSandbox() *lxf.Sandbox {
+	sb := &lxf.Sandbox{}
+	sb.Config = make(map[string]string)
+
+	return sb
+}
+
+func TestRuntimeServer_applySeccompProfile_Default(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	c := testContainer()
+
+	err := s.applySeccompProfile(s.criConfig(), c, testSandbox(), seccompProfileRuntimeDefault)
+	assert.NoError(t, err)
+	assert.NotContains(t, c.Config, "raw.lxc")
+}
+
+func TestRuntimeServer_applySeccompProfile_UnconfinedForbidden(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	c := testContainer()
+
+	err := s.applySeccompProfile(s.criConfig(), c, testSandbox(), seccompProfileUnconfined)
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.NotContains(t, c.Config, "raw.lxc")
+}
+
+func TestRuntimeServer_applySeccompProfile_UnconfinedOverridesSandbox(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	s.criConfig().LXEAllowUnconfinedSeccomp = true
+	c := testContainer()
+	sb := testSandbox()
+	sb.Config[cfgSandboxSeccompProfilePath] = "localhost/some/profile"
+
+	err := s.applySeccompProfile(s.criConfig(), c, sb, seccompProfileUnconfined)
+	assert.NoError(t, err)
+	assert.Equal(t, "lxc.seccomp.profile =", c.Config["raw.lxc"])
+}
+
+func TestRuntimeServer_applySeccompProfile_InheritSandbox(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	c := testContainer()
+	sb := testSandbox()
+	sb.Config[cfgSandboxSeccompProfilePath] = "localhost/some/profile"
+
+	err := s.applySeccompProfile(s.criConfig(), c, sb, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "lxc.seccomp.profile = some/profile", c.Config["raw.lxc"])
+}
+
+func TestRuntimeServer_applySeccompProfile_Unknown(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+
+	err := s.applySeccompProfile(s.criConfig(), testContainer(), testSandbox(), "foo")
+	assert.True(t, errors.Is(err, ErrUnknownSeccompProfile))
+}
+
+func TestToCriStatusResponse_VerboseLimits(t *testing.T) {
+	t.Parallel()
+
+	quota := int64(50000)
+	period := uint64(100000)
+	limit := int64(1024)
+
+	c := testContainer()
+	c.Config["limits.cpu.allowance"] = "50ms/100ms"
+	c.Config["limits.memory"] = "1024"
+	c.Config["security.nesting"] = "true"
+	c.Resources = &opencontainers.LinuxResources{
+		CPU:    &opencontainers.LinuxCPU{Quota: &quota, Period: &period},
+		Memory: &opencontainers.LinuxMemory{Limit: &limit},
+	}
+
+	assert.Empty(t, toCriStatusResponse(c, false).GetInfo())
+	assert.Equal(t, map[string]string{
+		"limits.cpu.allowance":   "50ms/100ms",
+		"limits.memory":          "1024",
+		"resources.cpu.quota":    "50000",
+		"resources.cpu.period":   "100000",
+		"resources.memory.limit": "1024",
+	}, toCriStatusResponse(c, true).GetInfo())
+}
+
+func TestToCriStatusResponse_Timestamps(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	restarted := created.Add(time.Hour)
+
+	// created, never started
+	c := testContainer()
+	c.CreatedAt = created
+
+	status := toCriStatusResponse(c, true)
+	assert.Equal(t, created.UnixNano(), status.GetStatus().GetCreatedAt())
+	assert.Equal(t, int64(0), status.GetStatus().GetStartedAt())
+	assert.Equal(t, int64(0), status.GetStatus().GetFinishedAt())
+	assert.NotContains(t, status.GetInfo(), "restart.last")
+
+	c.StartedAt = restarted
+	c.RestartedAt = restarted
+
+	status = toCriStatusResponse(c, true)
+	assert.Equal(t, created.UnixNano(), status.GetStatus().GetCreatedAt())
+	assert.Equal(t, restarted.UnixNano(), status.GetStatus().GetStartedAt())
+	assert.Equal(t, "2020-01-01T01:00:00Z", status.GetInfo()["restart.last"])
+}
+
+func TestToCriStatusResponse_Idmap(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+
+	status := toCriStatusResponse(c, true)
+	assert.NotContains(t, status.GetInfo(), "idmap")
+
+	c.Idmap = []lxf.IdmapEntry{
+		{UID: true, HostID: 1000000, ContainerID: 0, Range: 1000},
+		{UID: true, GID: true, HostID: 1000, ContainerID: 1000, Range: 1},
+		{GID: true, HostID: 1000000, ContainerID: 0, Range: 1000},
+	}
+
+	status = toCriStatusResponse(c, true)
+	assert.JSONEq(t, `[
+		{"type":"uid","host_id":1000000,"container_id":0,"range":1000},
+		{"type":"both","host_id":1000,"container_id":1000,"range":1},
+		{"type":"gid","host_id":1000000,"container_id":0,"range":1000}
+	]`, status.GetInfo()["idmap"])
+
+	status = toCriStatusResponse(c, false)
+	assert.NotContains(t, status.GetInfo(), "idmap")
+}
+
+func TestRemapMountPath(t *testing.T) {
+	t.Parallel()
+
+	annotations := map[string]string{annotationMountRemapExempt: "/var/run/docker.sock, /run/foo/"}
+
+	assert.Equal(t, "/mnt/secrets", remapMountPath("/var/run/secrets", nil))
+	assert.Equal(t, "/mnt/secrets", remapMountPath("/run/secrets", annotations))
+	assert.Equal(t, "/var/lib/foo", remapMountPath("/var/lib/foo", annotations))
+	assert.Equal(t, "/var/run/docker.sock", remapMountPath("/var/run/docker.sock", annotations))
+	assert.Equal(t, "/run/foo", remapMountPath("/run/foo", annotations))
+}
+
+func TestApplyOOMScoreAdj(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	applyOOMScoreAdj(c, testSandbox(), -998)
+	assert.Equal(t, "lxc.proc.oom_score_adj = -998", c.Config["raw.lxc"])
+
+	c = testContainer()
+	applyOOMScoreAdj(c, testSandbox(), 5000)
+	assert.Equal(t, "lxc.proc.oom_score_adj = 1000", c.Config["raw.lxc"])
+
+	c = testContainer()
+	applyOOMScoreAdj(c, testSandbox(), 0)
+	assert.NotContains(t, c.Config, "raw.lxc")
+
+	c = testContainer()
+	sb := testSandbox()
+	sb.Annotations = map[string]string{annotationQOSClass: "BestEffort"}
+	applyOOMScoreAdj(c, sb, 0)
+	assert.Equal(t, "lxc.proc.oom_score_adj = 1000", c.Config["raw.lxc"])
+}
+
+func TestParseExecUser(t *testing.T) {
+	t.Parallel()
+
+	user, err := parseExecUser("1000")
+	assert.NoError(t, err)
+	assert.Equal(t, &lxf.ExecUser{UID: 1000, GID: 0}, user)
+
+	user, err = parseExecUser("1000:100")
+	assert.NoError(t, err)
+	assert.Equal(t, &lxf.ExecUser{UID: 1000, GID: 100}, user)
+
+	for _, invalid := range []string{"nobody", "1000:", ":100", "1000:users", "-1", "4294967296"} {
+		_, err = parseExecUser(invalid)
+		assert.True(t, errors.Is(err, lxf.ErrUsage), invalid)
+	}
+}
+
+// testResources returns resources like kubelet translates them from requests and limits, 0 means not set
+func testResources(shares uint64, quota int64, memory int64) *opencontainers.LinuxResources {
+	period := uint64(100000)
+
+	return &opencontainers.LinuxResources{
+		CPU:    &opencontainers.LinuxCPU{Shares: &shares, Quota: &quota, Period: &period},
+		Memory: &opencontainers.LinuxMemory{Limit: &memory},
+	}
+}
+
+func TestContainerQOSClass(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, podQOSBestEffort, containerQOSClass(nil))
+	assert.Equal(t, podQOSBestEffort, containerQOSClass(&opencontainers.LinuxResources{}))
+	assert.Equal(t, podQOSBestEffort, containerQOSClass(testResources(2, 0, 0)))
+	// 500m cpu request and limit, 128Mi memory limit
+	assert.Equal(t, podQOSGuaranteed, containerQOSClass(testResources(512, 50000, 128*1024*1024)))
+	// 10m cpu, the minimal quota of kubelet
+	assert.Equal(t, podQOSGuaranteed, containerQOSClass(testResources(10, 1000, 128*1024*1024)))
+	// 100m cpu request lower than the limit
+	assert.Equal(t, podQOSBurstable, containerQOSClass(testResources(102, 50000, 128*1024*1024)))
+	// requests only
+	assert.Equal(t, podQOSBurstable, containerQOSClass(testResources(102, 0, 0)))
+	// no cpu limit
+	assert.Equal(t, podQOSBurstable, containerQOSClass(testResources(512, 0, 128*1024*1024)))
+	// no memory limit
+	assert.Equal(t, podQOSBurstable, containerQOSClass(testResources(512, 50000, 0)))
+}
+
+func TestPodQOSClass(t *testing.T) {
+	t.Parallel()
+
+	container := func(r *opencontainers.LinuxResources) *lxf.Container {
+		c := testContainer()
+		c.Resources = r
+
+		return c
+	}
+
+	guaranteed := container(testResources(512, 50000, 128*1024*1024))
+	burstable := container(testResources(102, 0, 0))
+	bestEffort := container(testResources(2, 0, 0))
+
+	assert.Equal(t, podQOSBestEffort, podQOSClass(nil))
+	assert.Equal(t, podQOSBestEffort, podQOSClass([]*lxf.Container{bestEffort, bestEffort}))
+	assert.Equal(t, podQOSGuaranteed, podQOSClass([]*lxf.Container{guaranteed, guaranteed}))
+	assert.Equal(t, podQOSBurstable, podQOSClass([]*lxf.Container{guaranteed, bestEffort}))
+	assert.Equal(t, podQOSBurstable, podQOSClass([]*lxf.Container{burstable}))
+}
+
+func TestSanitizeHostname(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "my-pod", sanitizeHostname("my-pod"))
+	assert.Equal(t, "mypod-1", sanitizeHostname("MyPod_1"))
+	assert.Equal(t, "web-server-x", sanitizeHostname("-Web.Server@x-"))
+	assert.Equal(t, "", sanitizeHostname("__"))
+	assert.Equal(t, strings.Repeat("a", 62), sanitizeHostname(strings.Repeat("a", 62)+"-bbb"))
+	assert.Len(t, sanitizeHostname(strings.Repeat("A", 100)), 63)
+}
+
+func TestPortForwardTarget(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "TCP4:10.0.0.2:8080,keepalive", portForwardTarget("10.0.0.2", 8080))
+	assert.Equal(t, "TCP6:[fd00::2]:8080,keepalive", portForwardTarget("fd00::2", 8080))
+}
+
+func TestGetNetNSPath_HostNetwork(t *testing.T) {
+	t.Parallel()
+
+	sb := testSandbox()
+	sb.NetworkConfig.Mode = lxf.NetworkHost
+
+	assert.Equal(t, "/proc/1/ns/net", getNetNSPath(sb))
+}
+
+func TestValidateRawIdmap(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateRawIdmap("both 1000 1000"))
+	assert.NoError(t, validateRawIdmap("uid 50-60 500-510\ngid 100000-110000 10000-20000\n"))
+
+	for _, idmap := range []string{
+		"both 1000",
+		"user 1000 1000",
+		"uid 50-60 500-520",
+		"gid 60-50 50-60",
+		"both -1 1000",
+		"both abc 1000",
+	} {
+		assert.True(t, errors.Is(validateRawIdmap(idmap), ErrInvalidIdmap), idmap)
+	}
+}
+
+func TestRuntimeServer_applyRawIdmap(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	cfg := &Config{LXEAllowRawIdmap: "1000-1999"}
+
+	c := testContainer()
+	sb := testSandbox()
+	assert.NoError(t, s.applyRawIdmap(cfg, c, sb))
+	assert.NotContains(t, c.Config, "raw.idmap")
+
+	sb.Annotations = map[string]string{annotationRawIdmap: "both 1000 1000"}
+	assert.NoError(t, s.applyRawIdmap(cfg, c, sb))
+	assert.Equal(t, "both 1000 1000", c.Config["raw.idmap"])
+
+	// refused unless the operator allows host ids
+	c = testContainer()
+	assert.True(t, errors.Is(s.applyRawIdmap(&Config{}, c, sb), ErrPolicy))
+	assert.NotContains(t, c.Config, "raw.idmap")
+
+	for _, idmap := range []string{"both 0 0", "uid 0-10 0-10", "both 2000 2000", "gid 1500-2500 0-1000"} {
+		sb.Annotations[annotationRawIdmap] = idmap
+		assert.True(t, errors.Is(s.applyRawIdmap(cfg, c, sb), ErrPolicy), idmap)
+		assert.NotContains(t, c.Config, "raw.idmap")
+	}
+
+	// root of the host is refused even if the allowed range includes it
+	sb.Annotations[annotationRawIdmap] = "both 0 0"
+	assert.True(t, errors.Is(s.applyRawIdmap(&Config{LXEAllowRawIdmap: "0-65535"}, c, sb), ErrPolicy))
+
+	// privileged containers don't use it, so it's refused instead of ignored
+	sb.Annotations[annotationRawIdmap] = "both 1000 1000"
+	c.Privileged = true
+	assert.True(t, errors.Is(s.applyRawIdmap(cfg, c, sb), ErrInvalidIdmap))
+	assert.NotContains(t, c.Config, "raw.idmap")
+
+	c.Privileged = false
+	sb.Config = map[string]string{"security.privileged": "true"}
+	assert.True(t, errors.Is(s.applyRawIdmap(cfg, c, sb), ErrInvalidIdmap))
+	assert.NotContains(t, c.Config, "raw.idmap")
+}
+
+func TestValidateAllowRawIdmap(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateAllowRawIdmap(""))
+	assert.NoError(t, validateAllowRawIdmap("100000-165535"))
+	assert.True(t, errors.Is(validateAllowRawIdmap("0-65535"), ErrInvalidIdmap))
+	assert.True(t, errors.Is(validateAllowRawIdmap("165535-100000"), ErrInvalidIdmap))
+	assert.True(t, errors.Is(validateAllowRawIdmap("many"), ErrInvalidIdmap))
+}
+
+func TestResolveRestoreFrom(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-restore")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	allowed := filepath.Join(dir, "checkpoints")
+	assert.NoError(t, os.Mkdir(allowed, 0700))
+
+	archive := filepath.Join(allowed, "app.tar.gz")
+	assert.NoError(t, ioutil.WriteFile(archive, nil, 0600))
+
+	outside := filepath.Join(dir, "secret.tar.gz")
+	assert.NoError(t, ioutil.WriteFile(outside, nil, 0600))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(allowed, "link.tar.gz")))
+
+	resolved, err := resolveRestoreFrom(archive, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, resolved)
+
+	// refused unless restores are allowed
+	_, err = resolveRestoreFrom(archive, "")
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	_, err = resolveRestoreFrom(outside, allowed)
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	_, err = resolveRestoreFrom(filepath.Join(allowed, "..", "secret.tar.gz"), allowed)
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	_, err = resolveRestoreFrom(filepath.Join(allowed, "link.tar.gz"), allowed)
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	_, err = resolveRestoreFrom("checkpoints/app.tar.gz", allowed)
+	assert.True(t, errors.Is(err, lxf.ErrUsage))
+}
+
+func TestCheckRestoreConfig(t *testing.T) {
+	t.Parallel()
+
+	sb := &lxf.Sandbox{}
+	sb.Config = map[string]string{"raw.lxc": "lxc.include = /etc/lxe/hostnetwork.conf"}
+
+	c := testContainer()
+	c.Config["raw.lxc"] = "lxc.no_new_privs = 1"
+	c.Config["raw.idmap"] = "both 1000 1000"
+
+	// unset, false and unrelated keys don't grant anything
+	assert.NoError(t, checkRestoreConfig(c, sb, map[string]string{
+		"security.privileged": "false",
+		"security.nesting":    "",
+		"limits.memory":       "1GB",
+		"raw.idmap":           "both 1000 1000",
+		"raw.lxc":             "lxc.no_new_privs = 1\nlxc.include = /etc/lxe/hostnetwork.conf",
+	}))
+
+	for _, refused := range []map[string]string{
+		{"security.privileged": "true"},
+		{"security.nesting": "true"},
+		{"security.syscalls.intercept.mknod": "true"},
+		{"raw.idmap": "both 0 0"},
+		{"raw.apparmor": "mount,"},
+		{"raw.lxc": "lxc.no_new_privs = 1\nlxc.cgroup2.devices.allow = a"},
+		{"linux.kernel_modules": "overlay"},
+	} {
+		assert.True(t, errors.Is(checkRestoreConfig(c, sb, refused), ErrPolicy), refused)
+	}
+
+	// as privileged as the container, or its pod
+	c.Nesting = true
+	assert.NoError(t, checkRestoreConfig(c, sb, map[string]string{"security.nesting": "true"}))
+
+	sb.Config["security.privileged"] = "true"
+	assert.NoError(t, checkRestoreConfig(c, sb, map[string]string{"security.privileged": "true"}))
+}
+
+func TestCheckRestoreDevices(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.Devices.Upsert(&device.Disk{Path: "/data", Source: "/srv/data"})
+	c.Devices.Upsert(&device.Disk{Path: "/config", Source: "/srv/config", Readonly: true})
+	c.Devices.Upsert(&device.Block{Path: "/dev/sdb", Source: "/dev/sdb"})
+
+	assert.NoError(t, checkRestoreDevices(c, map[string]map[string]string{
+		"root":   {"type": "disk", "path": "/", "pool": "default"},
+		"none":   {"type": "none"},
+		"data":   {"type": "disk", "path": "/mnt/data", "source": "/srv/data"},
+		"config": {"type": "disk", "path": "/config", "source": "/srv/config", "readonly": "true"},
+		"sdb":    {"type": "unix-block", "path": "/dev/sdb", "source": "/dev/sdb"},
+	}))
+
+	for _, refused := range []map[string]string{
+		{"type": "disk", "path": "/host", "source": "/"},
+		{"type": "disk", "path": "/config", "source": "/srv/config"},
+		{"type": "unix-block", "path": "/dev/sda", "source": "/dev/sda"},
+		{"type": "nic", "nictype": "macvlan", "parent": "eth0"},
+	} {
+		assert.True(t, errors.Is(checkRestoreDevices(c, map[string]map[string]string{"dev": refused}), ErrPolicy), refused)
+	}
+}
+
+func TestRuntimeServer_retryNetworkTeardown(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	s.criConfig().LXENetworkTeardownRetries = 1
+
+	calls := 0
+	s.retryNetworkTeardown(ctx, "delete", "foo", func(context.Context) error {
+		calls++
+		return nil
+	})
+	assert.Equal(t, 1, calls)
+
+	failures := metricNetworkTeardownFailures.Value()
+	calls = 0
+	s.retryNetworkTeardown(ctx, "delete", "foo", func(context.Context) error {
+		calls++
+		return errors.New("cni failed")
+	})
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, failures+1, metricNetworkTeardownFailures.Value())
+
+	// a cancelled request doesn't wait for the retries, but the failure is still counted
+	s.criConfig().LXENetworkTeardownRetries = 5
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	calls = 0
+	start := time.Now()
+	s.retryNetworkTeardown(cancelled, "delete", "foo", func(context.Context) error {
+		calls++
+		return errors.New("cni failed")
+	})
+	assert.Equal(t, 1, calls)
+	assert.Less(t, int64(time.Since(start)), int64(networkTeardownBackoff))
+	assert.Equal(t, failures+2, metricNetworkTeardownFailures.Value())
+}
+
+func TestRuntimeServer_retryNetwork(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	s.criConfig().LXENetworkRetries = 2
+
+	calls := 0
+	err := s.retryNetwork(ctx, "create", "foo", func(context.Context) error {
+		calls++
+		if calls < 2 {
+			return errors.New("cni busy")
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = s.retryNetwork(ctx, "create", "foo", func(context.Context) error {
+		calls++
+		return errors.New("cni busy")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = s.retryNetwork(ctx, "create", "foo", func(context.Context) error {
+		calls++
+		return fmt.Errorf("%w in /etc/cni/net.d", network.ErrNoNetworksFound)
+	})
+	assert.True(t, errors.Is(err, network.ErrNoNetworksFound))
+	assert.Equal(t, 1, calls)
+}
+
+func TestNetworkRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), networkRetryDelay(0, 1))
+
+	for retry, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: networkRetryMaxBackoff, 100: networkRetryMaxBackoff} {
+		delay := networkRetryDelay(time.Second, retry)
+		assert.LessOrEqual(t, int64(delay), int64(max), retry)
+		assert.GreaterOrEqual(t, int64(delay), int64(max/2), retry)
+	}
+}
+
+func TestApplyUnifiedResources(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	err := applyUnifiedResources(c, unifiedFromAnnotations(map[string]string{
+		"x-lxe-unified.memory.high": "512M",
+		"x-lxe-unified.pids.max":    "100",
+		"other":                     "ignored",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "lxc.cgroup2.memory.high = 512M\nlxc.cgroup2.pids.max = 100", c.Config["raw.lxc"])
+
+	err = applyUnifiedResources(testContainer(), map[string]string{"cgroup.procs": "1"})
+	assert.True(t, errors.Is(err, ErrUnsupportedUnified))
+
+	err = applyUnifiedResources(testContainer(), map[string]string{"memory.max": "1G\nlxc.apparmor.profile = unconfined"})
+	assert.True(t, errors.Is(err, ErrUnsupportedUnified))
+}
+
+func TestApplyIOLimits(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	assert.NoError(t, applyIOLimits(c, nil))
+	assert.NotContains(t, c.Config, cfgLimitDiskPriority)
+	assert.NotContains(t, c.Config, "raw.lxc")
+
+	c = testContainer()
+	err := applyIOLimits(c, map[string]string{
+		annotationIOPriority: "3",
+		annotationIOMax:      "8:0 rbps=1048576 wiops=100, 8:16  wbps=max",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "3", c.Config[cfgLimitDiskPriority])
+	assert.Equal(t, "lxc.cgroup2.io.max = 8:0 rbps=1048576 wiops=100\nlxc.cgroup2.io.max = 8:16 wbps=max", c.Config["raw.lxc"])
+
+	for _, annotations := range []map[string]string{
+		{annotationIOPriority: "11"},
+		{annotationIOPriority: "high"},
+		{annotationIOMax: "sda rbps=1"},
+		{annotationIOMax: "8:0"},
+		{annotationIOMax: "8:0 rbps=1 dbps=1"},
+		{annotationIOMax: "8:0 rbps=1M"},
+		{annotationIOMax: "8:0 rbps=0"},
+		{annotationIOMax: "8:0 rbps=1\nlxc.apparmor.profile = unconfined"},
+	} {
+		err = applyIOLimits(testContainer(), annotations)
+		assert.True(t, errors.Is(err, ErrInvalidIOLimit), annotations)
+	}
+}
+
+func TestToDiskDevices(t *testing.T) {
+	t.Parallel()
+
+	disks, err := toDiskDevices([]*rtApi.Mount{
+		{HostPath: "/a", ContainerPath: "/var/run/secrets"},
+		{HostPath: "/b", ContainerPath: "/data", Readonly: true},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, disks, 2)
+	assert.Equal(t, "/mnt/secrets", disks[0].Path)
+	assert.True(t, disks[1].Readonly)
+
+	_, err = toDiskDevices([]*rtApi.Mount{
+		{HostPath: "/a", ContainerPath: "/var/run/secrets"},
+		{HostPath: "/b", ContainerPath: "/run/secrets"},
+	}, nil)
+	assert.True(t, errors.Is(err, ErrDuplicateMount))
+	assert.Contains(t, err.Error(), "/a and /b")
+}
+
+func TestEnsureMountSources(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "lxe-mounts")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(root)
+
+	existing := filepath.Join(root, "existing")
+	assert.NoError(t, os.Mkdir(existing, 0755))
+
+	missing := filepath.Join(root, "missing")
+	dir := filepath.Join(root, "new", "dir")
+	file := filepath.Join(root, "new", "file")
+
+	annotations := map[string]string{
+		annotationMountDirectoryOrCreate: dir + "/",
+		annotationMountFileOrCreate:      file,
+	}
+
+	assert.NoError(t, ensureMountSources([]*rtApi.Mount{{HostPath: existing}}, annotations))
+
+	err = ensureMountSources([]*rtApi.Mount{{HostPath: existing}, {HostPath: missing}}, annotations)
+	assert.True(t, errors.Is(err, ErrMountSourceMissing))
+	assert.Contains(t, err.Error(), missing)
+
+	// created on demand
+	assert.NoError(t, ensureMountSources([]*rtApi.Mount{{HostPath: dir}, {HostPath: file}}, annotations))
+
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	info, err = os.Stat(file)
+	assert.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestParseConsoleBufferSize(t *testing.T) {
+	t.Parallel()
+
+	size, err := parseConsoleBufferSize("")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+
+	size, err = parseConsoleBufferSize("4MiB")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4*1024*1024), size)
+
+	for _, invalid := range []string{"foo", "1KiB", "1GiB"} {
+		_, err = parseConsoleBufferSize(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidConsoleBuffer), invalid)
+	}
+}
+
+func TestApplyConsoleBufferSize(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	applyConsoleBufferSize(c, 0)
+	assert.Empty(t, c.Config["raw.lxc"])
+
+	applyConsoleBufferSize(c, 65536)
+	assert.Equal(t, "lxc.console.buffer.size = 65536", c.Config["raw.lxc"])
+}
+
+func TestApplyConsoleLog(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.Metadata.Name = "app"
+	assert.NoError(t, applyConsoleLog(c, map[string]string{annotationConsoleLogPrefix + "other": "false"}, 65536))
+	assert.Equal(t, "lxc.console.buffer.size = 65536", c.Config["raw.lxc"])
+
+	c = testContainer()
+	c.Metadata.Name = "app"
+	assert.NoError(t, applyConsoleLog(c, map[string]string{annotationConsoleLogPrefix + "app": "false"}, 65536))
+	assert.Equal(t, "lxc.console.buffer.size = 0\nlxc.console.logfile =", c.Config["raw.lxc"])
+
+	c = testContainer()
+	c.Metadata.Name = "app"
+	assert.NoError(t, applyConsoleLog(c, map[string]string{annotationConsoleLogPrefix + "app": "true"}, 0))
+	assert.Empty(t, c.Config["raw.lxc"])
+
+	assert.Error(t, applyConsoleLog(c, map[string]string{annotationConsoleLogPrefix + "app": "quiet"}, 0))
+}
+
+func TestApplyAutostart(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	assert.NoError(t, applyAutostart(c, nil))
+	assert.Equal(t, "false", c.Config[cfgBootAutostart])
+
+	c = testContainer()
+	assert.NoError(t, applyAutostart(c, map[string]string{annotationAutostart: "true"}))
+	assert.Equal(t, "true", c.Config[cfgBootAutostart])
+
+	assert.Error(t, applyAutostart(testContainer(), map[string]string{annotationAutostart: "sometimes"}))
+}
+
+func TestRuntimeServer_ApplyNesting(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	c := testContainer()
+
+	err := s.applyNesting(s.criConfig(), c, map[string]string{})
+	assert.NoError(t, err)
+	assert.False(t, c.Nesting)
+
+	err = s.applyNesting(s.criConfig(), c, map[string]string{annotationNesting: "true"})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.False(t, c.Nesting)
+
+	err = s.applyNesting(s.criConfig(), c, map[string]string{annotationNesting: "maybe"})
+	assert.Error(t, err)
+
+	s.criConfig().LXEAllowNesting = true
+
+	err = s.applyNesting(s.criConfig(), c, map[string]string{annotationNesting: "true"})
+	assert.NoError(t, err)
+	assert.True(t, c.Nesting)
+}
+
+func TestApplySyscallIntercepts(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	err := applySyscallIntercepts(c, map[string]string{
+		annotationSyscallsInterceptPrefix + "mknod":    "true",
+		annotationSyscallsInterceptPrefix + "setxattr": "false",
+		"other": "true",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"security.syscalls.intercept.mknod":    "true",
+		"security.syscalls.intercept.setxattr": "false",
+	}, c.Config)
+
+	err = applySyscallIntercepts(testContainer(), map[string]string{annotationSyscallsInterceptPrefix + "reboot": "true"})
+	assert.True(t, errors.Is(err, ErrUnsupportedIntercept))
+
+	err = applySyscallIntercepts(testContainer(), map[string]string{annotationSyscallsInterceptPrefix + "mount": "yes please"})
+	assert.True(t, errors.Is(err, ErrUnsupportedIntercept))
+}
+
+func TestRuntimeServer_applyProcessLimit(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+
+	c := testContainer()
+	assert.NoError(t, s.applyProcessLimit(s.criConfig(), c, nil))
+	assert.NotContains(t, c.Config, cfgLimitProcesses)
+
+	s.criConfig().LXEDefaultProcessLimit = 1000
+
+	c = testContainer()
+	assert.NoError(t, s.applyProcessLimit(s.criConfig(), c, nil))
+	assert.Equal(t, "1000", c.Config[cfgLimitProcesses])
+	assert.Equal(t, "1000", toCriStatusInfo(c)[cfgLimitProcesses])
+
+	// the pod overrides the default
+	c = testContainer()
+	assert.NoError(t, s.applyProcessLimit(s.criConfig(), c, map[string]string{annotationProcessLimit: "5000"}))
+	assert.Equal(t, "5000", c.Config[cfgLimitProcesses])
+
+	c = testContainer()
+	assert.NoError(t, s.applyProcessLimit(s.criConfig(), c, map[string]string{annotationProcessLimit: "0"}))
+	assert.NotContains(t, c.Config, cfgLimitProcesses)
+
+	err := s.applyProcessLimit(s.criConfig(), testContainer(), map[string]string{annotationProcessLimit: "-1"})
+	assert.True(t, errors.Is(err, ErrInvalidProcessLimit))
+
+	err = s.applyProcessLimit(s.criConfig(), testContainer(), map[string]string{annotationProcessLimit: "lots"})
+	assert.True(t, errors.Is(err, ErrInvalidProcessLimit))
+}
+
+func TestApplyNoNewPrivs(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	assert.NoError(t, applyNoNewPrivs(c, false))
+	assert.Empty(t, c.Config["raw.lxc"])
+
+	assert.NoError(t, applyNoNewPrivs(c, true))
+	assert.Equal(t, "lxc.no_new_privs = 1", c.Config["raw.lxc"])
+
+	c = testContainer()
+	c.Privileged = true
+	err := applyNoNewPrivs(c, true)
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, c.Config["raw.lxc"])
+}
+
+func TestApplyUlimits(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	err := applyUlimits(c, map[string]string{
+		annotationUlimitPrefix + "nofile":  "65536",
+		annotationUlimitPrefix + "memlock": "1024:unlimited",
+		"other":                            "1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1024:unlimited", c.Config["limits.kernel.memlock"])
+	assert.Equal(t, "65536:65536", c.Config["limits.kernel.nofile"])
+	assert.Empty(t, c.Config["raw.lxc"])
+
+	err = applyUlimits(testContainer(), map[string]string{annotationUlimitPrefix + "openfiles": "1024"})
+	assert.True(t, errors.Is(err, ErrInvalidUlimit))
+
+	// lxc knows it, but lxd doesn't accept it as limits.kernel
+	err = applyUlimits(testContainer(), map[string]string{annotationUlimitPrefix + "stack": "1024"})
+	assert.True(t, errors.Is(err, ErrInvalidUlimit))
+
+	err = applyUlimits(testContainer(), map[string]string{annotationUlimitPrefix + "nofile": "many"})
+	assert.True(t, errors.Is(err, ErrInvalidUlimit))
+
+	err = applyUlimits(testContainer(), map[string]string{annotationUlimitPrefix + "nofile": "2048:1024"})
+	assert.True(t, errors.Is(err, ErrInvalidUlimit))
+}
+
+func TestRuntimeServer_ReadonlyRootDisk(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetRootPoolReturns("ssd", nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	disk, err := s.readonlyRootDisk(s.criConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, &device.Disk{Path: "/", Readonly: true, Pool: "ssd"}, disk)
+
+	s.criConfig().LXDStoragePool = "configured"
+
+	disk, err = s.readonlyRootDisk(s.criConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, "configured", disk.Pool)
+	assert.Equal(t, 1, fake.GetRootPoolCallCount())
+}
+
+func TestRuntimeServer_checkContainerLimit(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	sb := testSandbox()
+	sb.ID = "sb"
+	sb.UsedBy = []string{"a", "b"}
+
+	// no limit
+	assert.NoError(t, s.checkContainerLimit(s.criConfig(), sb))
+
+	s.criConfig().LXEMaxContainersPerPod = 3
+	assert.NoError(t, s.checkContainerLimit(s.criConfig(), sb))
+
+	s.criConfig().LXEMaxContainersPerPod = 2
+	err := s.checkContainerLimit(s.criConfig(), sb)
+	assert.True(t, errors.Is(err, ErrTooManyContainers))
+	assert.EqualError(t, err, "too many containers in sandbox: sb has 2 of at most 2")
+}
+
+func TestRuntimeServer_sandboxExecPid(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetSandboxReturns(nil, shared.NewErrNotFound())
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	// not allowed, so not even looked up
+	pid, err := s.sandboxExecPid(s.criConfig(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pid)
+	assert.Equal(t, 0, fake.GetSandboxCallCount())
+
+	// container ids aren't sandboxes
+	s.criConfig().LXEAllowSandboxExec = true
+
+	pid, err = s.sandboxExecPid(s.criConfig(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pid)
+	assert.Equal(t, "foo", fake.GetSandboxArgsForCall(0))
+}
+
+func TestRuntimeServer_ReadonlyRootDisk_WritableVolume(t *testing.T) {
+	t.Parallel()
+
+	fake := &crifakes.FakeClient{}
+	fake.GetRootPoolReturns("default", nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+	c := testContainer()
+
+	disks, err := toDiskDevices([]*rtApi.Mount{{HostPath: "/srv/data", ContainerPath: "/data"}}, nil)
+	assert.NoError(t, err)
+
+	for _, d := range disks {
+		c.Devices.Upsert(d)
+	}
+
+	disk, err := s.readonlyRootDisk(s.criConfig())
+	assert.NoError(t, err)
+	c.Devices.Upsert(disk)
+
+	// the volume is its own device on top of the readonly root and doesn't inherit readonly from it
+	readonly := map[string]string{}
+
+	for _, d := range c.Devices {
+		_, options := d.ToMap()
+		readonly[options["path"]] = options["readonly"]
+	}
+
+	assert.Equal(t, map[string]string{"/": "true", "/data": "false"}, readonly)
+	assert.Empty(t, c.Config["raw.lxc"])
+}
+
+func TestExecEnvFromAnnotations(t *testing.T) {
+	t.Parallel()
+
+	env := execEnvFromAnnotations(map[string]string{
+		annotationExecEnvPrefix + "DEBUG": "1",
+		annotationExecUser:                "1000",
+	})
+	assert.Equal(t, map[string]string{"DEBUG": "1"}, env)
+}
+
+func TestStreamingConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := streamingConfig(&Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, streaming.DefaultConfig.StreamIdleTimeout, cfg.StreamIdleTimeout)
+	assert.Equal(t, streaming.DefaultConfig.StreamCreationTimeout, cfg.StreamCreationTimeout)
+
+	cfg, err = streamingConfig(&Config{LXEStreamingIdleTimeout: time.Minute, LXEStreamingCreationTimeout: time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.StreamIdleTimeout)
+	assert.Equal(t, time.Second, cfg.StreamCreationTimeout)
+
+	_, err = streamingConfig(&Config{LXEStreamingIdleTimeout: -time.Minute})
+	assert.True(t, errors.Is(err, ErrInvalidTimeout))
+
+	_, err = streamingConfig(&Config{LXEStreamingCreationTimeout: -time.Second})
+	assert.True(t, errors.Is(err, ErrInvalidTimeout))
+}
+
+func TestPidNamespaceOwner(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	initC := &lxf.Container{StateName: lxf.ContainerStateExited, CRIObject: lxf.CRIObject{CreatedAt: now.Add(-time.Hour)}}
+	first := &lxf.Container{StateName: lxf.ContainerStateRunning, CRIObject: lxf.CRIObject{CreatedAt: now.Add(-time.Minute)}}
+	second := &lxf.Container{StateName: lxf.ContainerStateRunning, CRIObject: lxf.CRIObject{CreatedAt: now}}
+
+	assert.Nil(t, pidNamespaceOwner([]*lxf.Container{initC}))
+	assert.Equal(t, first, pidNamespaceOwner([]*lxf.Container{second, initC, first}))
+}
+
+func TestApplySharedPIDNamespace_NotShared(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	sb := testSandbox()
+	sb.Config[cfgSandboxNamespacePID] = nameSpaceOptionToString(rtApi.NamespaceMode_CONTAINER)
+
+	err := applySharedPIDNamespace(c, sb)
+	assert.NoError(t, err)
+	assert.Empty(t, c.Config["raw.lxc"])
+}
+
+func TestApplyHostNamespaces(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.Privileged = true
+	sb := testSandbox()
+	sb.Config[cfgSandboxNamespaceIPC] = nameSpaceOptionToString(rtApi.NamespaceMode_NODE)
+	sb.Config[cfgSandboxNamespacePID] = nameSpaceOptionToString(rtApi.NamespaceMode_NODE)
+
+	err := applyHostNamespaces(c, sb)
+	assert.NoError(t, err)
+	assert.Equal(t, "lxc.namespace.share.ipc = 1\nlxc.namespace.share.pid = 1", c.Config["raw.lxc"])
+
+	// only pid
+	c = testContainer()
+	c.Privileged = true
+	sb.Config[cfgSandboxNamespaceIPC] = nameSpaceOptionToString(rtApi.NamespaceMode_POD)
+
+	err = applyHostNamespaces(c, sb)
+	assert.NoError(t, err)
+	assert.Equal(t, "lxc.namespace.share.pid = 1", c.Config["raw.lxc"])
+}
+
+func TestApplyHostNamespaces_Unprivileged(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	sb := testSandbox()
+	sb.Config[cfgSandboxNamespacePID] = nameSpaceOptionToString(rtApi.NamespaceMode_NODE)
+
+	err := applyHostNamespaces(c, sb)
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, c.Config["raw.lxc"])
+
+	// pod and container level don't need privileges
+	sb.Config[cfgSandboxNamespacePID] = nameSpaceOptionToString(rtApi.NamespaceMode_POD)
+	sb.Config[cfgSandboxNamespaceIPC] = nameSpaceOptionToString(rtApi.NamespaceMode_CONTAINER)
+
+	err = applyHostNamespaces(c, sb)
+	assert.NoError(t, err)
+	assert.Empty(t, c.Config["raw.lxc"])
+}
+
+func TestMatchContainerStatsFilter(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.ID = "foo"
+	c.Profiles = []string{"default", "sandbox"}
+	c.Labels = map[string]string{"app": "web", "tier": "frontend"}
+
+	assert.True(t, matchContainerStatsFilter(c, nil))
+
+	// id only
+	assert.True(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{Id: "foo"}))
+	assert.False(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{Id: "bar"}))
+
+	// labels only
+	assert.True(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{LabelSelector: map[string]string{"app": "web"}}))
+	assert.False(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{LabelSelector: map[string]string{"app": "db"}}))
+
+	// combined
+	assert.True(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{Id: "foo", PodSandboxId: "sandbox", LabelSelector: map[string]string{"tier": "frontend"}}))
+	assert.False(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{Id: "foo", LabelSelector: map[string]string{"tier": "backend"}}))
+	assert.False(t, matchContainerStatsFilter(c, &rtApi.ContainerStatsFilter{Id: "foo", PodSandboxId: "other"}))
+}
+
+func TestToCriStatusResponse_CannotRun(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+	c.ExitReason = lxf.ReasonCannotRun
+	c.ExitMessage = "Failed to exec"
+
+	status := toCriStatusResponse(c, false).GetStatus()
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_EXITED, status.GetState())
+	assert.Equal(t, lxf.ReasonCannotRun, status.GetReason())
+	assert.Equal(t, "Failed to exec", status.GetMessage())
+	assert.Equal(t, int32(exitCodeCannotRun), status.GetExitCode())
+}
+
+func TestParsePodMounts(t *testing.T) {
+	t.Parallel()
+
+	mounts, err := parsePodMounts("/var/cache/shared:/cache, /etc/ssl/certs:/etc/ssl/certs:ro")
+	assert.NoError(t, err)
+	assert.Equal(t, []*rtApi.Mount{
+		{HostPath: "/var/cache/shared", ContainerPath: "/cache"},
+		{HostPath: "/etc/ssl/certs", ContainerPath: "/etc/ssl/certs", Readonly: true},
+	}, mounts)
+
+	for _, invalid := range []string{"/cache", "/a:/b:rw", "a:/b", "/a:b", "/a:/b:ro:x"} {
+		_, err = parsePodMounts(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidMount), invalid)
+	}
+}
+
+func TestRuntimeServer_PodMounts(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+
+	mounts, err := s.podMounts(s.criConfig(), map[string]string{})
+	assert.NoError(t, err)
+	assert.Empty(t, mounts)
+
+	_, err = s.podMounts(s.criConfig(), map[string]string{annotationPodMounts: "/a:/b"})
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	s.criConfig().LXEAllowPodMounts = true
+
+	mounts, err = s.podMounts(s.criConfig(), map[string]string{annotationPodMounts: "/a:/b"})
+	assert.NoError(t, err)
+	assert.Len(t, mounts, 1)
+}
+
+func TestMergePodMounts(t *testing.T) {
+	t.Parallel()
+
+	mounts := []*rtApi.Mount{{HostPath: "/own", ContainerPath: "/var/run/cache"}}
+	podMounts := []*rtApi.Mount{
+		{HostPath: "/shared", ContainerPath: "/run/cache"},
+		{HostPath: "/data", ContainerPath: "/data"},
+	}
+
+	// the container mount wins, even if the paths only match after remapping
+	merged := mergePodMounts(mounts, podMounts, nil)
+	assert.Equal(t, []*rtApi.Mount{mounts[0], podMounts[1]}, merged)
+
+	// conflicting pod mounts are refused
+	merged = mergePodMounts(nil, []*rtApi.Mount{
+		{HostPath: "/a", ContainerPath: "/data"},
+		{HostPath: "/b", ContainerPath: "/data"},
+	}, nil)
+	_, err := toDiskDevices(merged, nil)
+	assert.True(t, errors.Is(err, ErrDuplicateMount))
+}
+
+func TestRuntimeServer_containerNamePrefix(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	meta := &rtApi.PodSandboxMetadata{Namespace: "default", Name: "nginx-5d9f"}
+	assert.Equal(t, "", s.containerNamePrefix(meta, "web"))
+
+	var err error
+	s.containerName, err = parseContainerNameTemplate("{{.Namespace}}-{{.Pod}}-{{.Container}}")
+	assert.NoError(t, err)
+	assert.Equal(t, "default-nginx-5d9f-web", s.containerNamePrefix(meta, "web"))
+
+	_, err = parseContainerNameTemplate("{{.Unknown}}")
+	assert.True(t, errors.Is(err, ErrInvalidNameTemplate))
+	_, err = parseContainerNameTemplate("{{.Pod")
+	assert.True(t, errors.Is(err, ErrInvalidNameTemplate))
+}
+
+func TestSwapUsage_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Nil(t, swapUsage(c))
+}
+
+func TestHugepagesInfo_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Empty(t, hugepagesInfo(c))
+}
+
+func TestMemoryInfo_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Empty(t, memoryInfo(c))
+}
+
+func TestDiskIOInfo_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Empty(t, diskIOInfo(c))
+}
+
+func TestContainerCgroup_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Nil(t, containerCgroup(c))
+}
+
+func TestProcessesInfo_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.StateName = lxf.ContainerStateExited
+
+	assert.Empty(t, processesInfo(c))
+}
+
+func TestDriftInfo(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "[]", driftInfo(nil))
+	assert.Equal(t, `["sb: user.host_name is \"other\", expected \"web\""]`, driftInfo([]lxf.Drift{{Object: "sb", Key: "user.host_name", Expected: "web", Actual: "other"}}))
+}
+
+func TestProfileInfo(t *testing.T) {
+	t.Parallel()
+
+	info := profileInfo(&api.ProfilePut{
+		Config: map[string]string{
+			"limits.memory":      "1GB",
+			"environment.SECRET": "hunter2",
+			"user.user-data":     "#cloud-config\npassword: hunter2",
+			"user.annotations.kubectl.kubernetes.io/last-applied-configuration": "{}",
+			"user.annotations." + annotationExecEnvPrefix + "TOKEN":             "hunter2",
+		},
+		Devices: map[string]map[string]string{
+			"eth0": {"type": "nic", "nictype": "bridged", "parent": "lxebr0"},
+		},
+	})
+
+	assert.NotContains(t, info, "hunter2")
+	assert.Equal(t, `{"config":{"environment.SECRET":"REDACTED","limits.memory":"1GB",`+
+		`"user.annotations.kubectl.kubernetes.io/last-applied-configuration":"REDACTED",`+
+		`"user.annotations.x-lxe-exec-env.TOKEN":"REDACTED","user.user-data":"REDACTED"},`+
+		`"devices":{"eth0":{"nictype":"bridged","parent":"lxebr0","type":"nic"}}}`, info)
+}
+
+func TestRuntimeServer_checkHostPorts(t *testing.T) {
+	t.Parallel()
+
+	bridged := testSandbox()
+	bridged.ID = "bridged"
+	bridged.State = lxf.SandboxReady
+	bridged.NetworkConfig.Mode = lxf.NetworkBridged
+	bridged.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "0.0.0.0", Port: 8080},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "127.0.0.1", Port: 80},
+	})
+
+	host := testSandbox()
+	host.ID = "host"
+	host.State = lxf.SandboxReady
+	host.NetworkConfig.Mode = lxf.NetworkHost
+	host.Config[cfgSandboxHostPorts] = "tcp:10.0.0.1:9090,udp:0.0.0.0:53"
+
+	stopped := testSandbox()
+	stopped.ID = "stopped"
+	stopped.State = lxf.SandboxNotReady
+	stopped.NetworkConfig.Mode = lxf.NetworkHost
+	stopped.Config[cfgSandboxHostPorts] = "tcp:0.0.0.0:7070"
+
+	fake := &crifakes.FakeClient{}
+	fake.ListSandboxesReturns([]*lxf.Sandbox{bridged, host, stopped}, nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	ports, err := s.checkHostPorts([]*rtApi.PortMapping{
+		{HostPort: 8081},
+		{HostPort: 9090, HostIp: "10.0.0.2"},
+		{HostPort: 8080, Protocol: rtApi.Protocol_UDP},
+		{HostPort: 7070},
+		{ContainerPort: 80},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tcp:0.0.0.0:8081", "tcp:10.0.0.2:9090", "udp:0.0.0.0:8080", "tcp:0.0.0.0:7070"},
+		[]string{ports[0].String(), ports[1].String(), ports[2].String(), ports[3].String()})
+
+	_, err = s.checkHostPorts([]*rtApi.PortMapping{{HostPort: 8080, HostIp: "10.0.0.1"}})
+	assert.True(t, errors.Is(err, ErrHostPortInUse))
+	assert.Contains(t, err.Error(), "bridged")
+
+	_, err = s.checkHostPorts([]*rtApi.PortMapping{{HostPort: 9090}})
+	assert.True(t, errors.Is(err, ErrHostPortInUse))
+	assert.Contains(t, err.Error(), "host")
+
+	_, err = s.checkHostPorts([]*rtApi.PortMapping{{HostPort: 53, Protocol: rtApi.Protocol_UDP}})
+	assert.True(t, errors.Is(err, ErrHostPortInUse))
+
+	// nothing to check without host ports
+	ports, err = s.checkHostPorts([]*rtApi.PortMapping{{ContainerPort: 80}})
+	assert.NoError(t, err)
+	assert.Empty(t, ports)
+	assert.Equal(t, 4, fake.ListSandboxesCallCount())
+}
+
+func TestRuntimeServer_RunPodSandbox_Retry(t *testing.T) {
+	t.Parallel()
+
+	meta := &rtApi.PodSandboxMetadata{Name: "web", Namespace: "default", Uid: "abc", Attempt: 1}
+
+	other := testSandbox()
+	other.ID = "other"
+	other.Metadata = lxf.SandboxMetadata{Name: "web", Namespace: "default", UID: "abc", Attempt: 0}
+
+	created := testSandbox()
+	created.ID = "created"
+	created.State = lxf.SandboxReady
+	created.Metadata = lxf.SandboxMetadata{Name: "web", Namespace: "default", UID: "abc", Attempt: 1}
+
+	fake := &crifakes.FakeClient{}
+	fake.ListSandboxesReturns([]*lxf.Sandbox{other, created}, nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	// the sandbox created by the first call is returned instead of a new one
+	resp, err := s.RunPodSandbox(context.Background(), &rtApi.RunPodSandboxRequest{Config: &rtApi.PodSandboxConfig{Metadata: meta}})
+	assert.NoError(t, err)
+	assert.Equal(t, "created", resp.GetPodSandboxId())
+	assert.Equal(t, 0, fake.NewSandboxCallCount())
+
+	// another attempt of the pod is a new sandbox
+	sb, err := s.existingSandbox(&rtApi.PodSandboxMetadata{Name: "web", Namespace: "default", Uid: "abc", Attempt: 2})
+	assert.NoError(t, err)
+	assert.Nil(t, sb)
+
+	fake.ListSandboxesReturns(nil, errors.New("lxd unavailable"))
+
+	_, err = s.RunPodSandbox(context.Background(), &rtApi.RunPodSandboxRequest{Config: &rtApi.PodSandboxConfig{Metadata: meta}})
+	assert.Error(t, err)
+}
+
+func TestRuntimeServer_ListContainers_ByID(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+	c.ID = "foo"
+	c.Profiles = []string{"default", "sandbox"}
+
+	fake := &crifakes.FakeClient{}
+	fake.GetContainerReturnsOnCall(0, c, nil)
+	fake.GetContainerReturnsOnCall(1, nil, shared.NewErrNotFound())
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	resp, err := s.ListContainers(context.Background(), &rtApi.ListContainersRequest{Filter: &rtApi.ContainerFilter{Id: "foo"}})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetContainers(), 1)
+	assert.Equal(t, "foo", resp.GetContainers()[0].GetId())
+	assert.Equal(t, 0, fake.ListContainersCallCount())
+
+	// a container which doesn't exist isn't listed
+	resp, err = s.ListContainers(context.Background(), &rtApi.ListContainersRequest{Filter: &rtApi.ContainerFilter{Id: "bar"}})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.GetContainers())
+}
+
+func TestRuntimeServer_ListPodSandbox_ByID(t *testing.T) {
+	t.Parallel()
+
+	sb := testSandbox()
+	sb.ID = "foo"
+
+	fake := &crifakes.FakeClient{}
+	fake.GetSandboxReturnsOnCall(0, sb, nil)
+	fake.GetSandboxReturnsOnCall(1, nil, shared.NewErrNotFound())
+
+	s := testRuntimeServer()
+	s.lxf = fake
+
+	resp, err := s.ListPodSandbox(context.Background(), &rtApi.ListPodSandboxRequest{Filter: &rtApi.PodSandboxFilter{Id: "foo"}})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetItems(), 1)
+	assert.Equal(t, 0, fake.ListSandboxesCallCount())
+
+	resp, err = s.ListPodSandbox(context.Background(), &rtApi.ListPodSandboxRequest{Filter: &rtApi.PodSandboxFilter{Id: "bar"}})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.GetItems())
+}
+
+// benchmarkListSize is the number of pods and containers of a busy node
+const benchmarkListSize = 1000
+
+func benchmarkListServer() (RuntimeServer, func()) {
+	fake := &crifakes.FakeClient{}
+	sandboxes := make([]*lxf.Sandbox, 0, benchmarkListSize)
+	containers := make([]*lxf.Container, 0, benchmarkListSize)
+
+	for i := 0; i < benchmarkListSize; i++ {
+		sb := testSandbox()
+		sb.ID = fmt.Sprintf("sandbox-%d", i)
+		sb.State = lxf.SandboxReady
+		sb.Metadata = lxf.SandboxMetadata{Name: sb.ID, Namespace: "default", UID: sb.ID}
+		sb.Labels = map[string]string{"app": "web", "io.kubernetes.pod.name": sb.ID}
+		sb.Annotations = map[string]string{"kubernetes.io/config.source": "api"}
+		sandboxes = append(sandboxes, sb)
+
+		c := testContainer()
+		c.ID = fmt.Sprintf("container-%d", i)
+		c.Profiles = []string{"default", sb.ID}
+		c.StateName = lxf.ContainerStateRunning
+		c.Metadata = lxf.ContainerMetadata{Name: "web"}
+		c.Labels = sb.Labels
+		c.Annotations = sb.Annotations
+		containers = append(containers, c)
+	}
+
+	fake.ListSandboxesReturns(sandboxes, nil)
+	fake.ListContainersReturns(containers, nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+	s.containers = newContainerCache(fake, containerStatusCacheTTL)
+
+	// like in production, a logger is set which discards debug messages
+	prev := logger.Log
+	logger.Log, _ = logging.GetLogger("", "", false, false, nil)
+
+	return s, func() { logger.Log = prev }
+}
+
+func BenchmarkRuntimeServer_ListContainers(b *testing.B) {
+	s, done := benchmarkListServer()
+	defer done()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := s.ListContainers(context.Background(), &rtApi.ListContainersRequest{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRuntimeServer_ListPodSandbox(b *testing.B) {
+	s, done := benchmarkListServer()
+	defer done()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, err := s.ListPodSandbox(context.Background(), &rtApi.ListPodSandboxRequest{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRuntimeServer_prune_DryRun(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	ready := testSandbox()
+	ready.ID = "ready"
+	ready.State = lxf.SandboxReady
+	ready.CreatedAt = now.Add(-48 * time.Hour)
+
+	old := testSandbox()
+	old.ID = "old"
+	old.State = lxf.SandboxNotReady
+	old.CreatedAt = now.Add(-48 * time.Hour)
+
+	young := testSandbox()
+	young.ID = "young"
+	young.State = lxf.SandboxNotReady
+	young.CreatedAt = now.Add(-time.Hour)
+	young.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "0.0.0.0", Port: 8080},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "127.0.0.1", Port: 80},
+	})
+	young.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolUDP, Address: "0.0.0.0", Port: 53},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolUDP, Address: "127.0.0.1", Port: 53},
+	})
+
+	fake := &crifakes.FakeClient{}
+	fake.ListSandboxesReturns([]*lxf.Sandbox{ready, old, young}, nil)
+
+	s := testRuntimeServer()
+	s.lxf = fake
+	s.config = newConfigHolder(&Config{LXEPruneRetention: 24 * time.Hour, LXEPruneDryRun: true})
+
+	// the old sandbox without containers and both proxy devices of the young one, nothing is touched
+	assert.Equal(t, 3, s.prune(context.Background(), now))
+	assert.Len(t, young.Devices, 2)
+	assert.Equal(t, 0, fake.GetSandboxCallCount())
+
+	fake.ListSandboxesReturns(nil, errors.New("lxd unavailable"))
+	assert.Equal(t, 0, s.prune(context.Background(), now))
+}
+
+// testPruneSandbox runs a sandbox with a container which is stopped again, and returns both ids
+func testPruneSandbox(t *testing.T, s RuntimeServer, name string) (string, string) {
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Metadata.Name = name
+	sbReq.Config.Metadata.Uid = name
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	_, err = s.StopContainer(context.Background(), &rtApi.StopContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	return sbResp.GetPodSandboxId(), resp.GetContainerId()
+}
+
+func TestRuntimeServer_prune(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEPruneRetention: 24 * time.Hour})
+
+	readyID, readyCID := testPruneSandbox(t, s, "ready")
+	stoppedID, stoppedCID := testPruneSandbox(t, s, "stopped")
+
+	_, err := s.StopPodSandbox(context.Background(), &rtApi.StopPodSandboxRequest{PodSandboxId: stoppedID})
+	assert.NoError(t, err)
+
+	// within the retention nothing is removed
+	assert.Equal(t, 0, s.prune(context.Background(), time.Now()))
+	assert.ElementsMatch(t, []string{readyCID, stoppedCID}, srv.ContainerNames())
+
+	// the exited container and then its sandbox, the ready sandbox keeps its exited container for kubelet
+	assert.Equal(t, 2, s.prune(context.Background(), time.Now().Add(48*time.Hour)))
+	assert.Equal(t, []string{readyCID}, srv.ContainerNames())
+	assert.Equal(t, []string{readyID}, srv.ProfileNames())
+
+	_, err = s.lxf.GetSandbox(stoppedID)
+	assert.True(t, shared.IsErrNotFound(err))
+}
+
+func TestRuntimeServer_prune_ProxyDevices(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEPruneRetention: 24 * time.Hour})
+
+	id, _ := testPruneSandbox(t, s, "stopped")
+
+	_, err := s.StopPodSandbox(context.Background(), &rtApi.StopPodSandboxRequest{PodSandboxId: id})
+	assert.NoError(t, err)
+
+	sb, err := s.lxf.GetSandbox(id)
+	assert.NoError(t, err)
+
+	sb.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "0.0.0.0", Port: 8080},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "127.0.0.1", Port: 80},
+	})
+	assert.NoError(t, sb.Apply())
+
+	// the container exited recently, so the sandbox stays but loses its proxy devices
+	assert.Equal(t, 1, s.prune(context.Background(), time.Now()))
+
+	sb, err = s.lxf.GetSandbox(id)
+	assert.NoError(t, err)
+
+	for _, d := range sb.Devices {
+		_, is := d.(*device.Proxy)
+		assert.False(t, is)
+	}
+}
+
+func TestRuntimeServer_pruneProxyDevices(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := testLXDRuntimeServer()
+
+	resp, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.NoError(t, err)
+
+	sb, err := s.lxf.GetSandbox(resp.GetPodSandboxId())
+	assert.NoError(t, err)
+
+	// nothing to prune
+	assert.Equal(t, 0, s.pruneProxyDevices(false, sb))
+
+	devices := len(sb.Devices)
+
+	sb.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "0.0.0.0", Port: 8080},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolTCP, Address: "127.0.0.1", Port: 80},
+	})
+	sb.Devices.Upsert(&device.Proxy{
+		Listen:      &device.ProxyEndpoint{Protocol: device.ProtocolUDP, Address: "0.0.0.0", Port: 53},
+		Destination: &device.ProxyEndpoint{Protocol: device.ProtocolUDP, Address: "127.0.0.1", Port: 53},
+	})
+	assert.NoError(t, sb.Apply())
+
+	// dry run keeps them
+	assert.Equal(t, 2, s.pruneProxyDevices(true, sb))
+
+	sb, err = s.lxf.GetSandbox(resp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Len(t, sb.Devices, devices+2)
+
+	// the other devices are kept
+	assert.Equal(t, 2, s.pruneProxyDevices(false, sb))
+
+	sb, err = s.lxf.GetSandbox(resp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Len(t, sb.Devices, devices)
+}
+
+func TestRuntimeServer_pruneItem(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+	removed := 0
+	remove := func() error {
+		removed++
+		return nil
+	}
+
+	assert.True(t, s.pruneItem(true, "item %v", []interface{}{"foo"}, remove))
+	assert.Equal(t, 0, removed)
+
+	assert.True(t, s.pruneItem(false, "item %v", []interface{}{"foo"}, remove))
+	assert.Equal(t, 1, removed)
+
+	assert.False(t, s.pruneItem(false, "item %v", []interface{}{"foo"}, func() error {
+		return errors.New("in use")
+	}))
+}
+
+func TestContainersGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, defaultTerminationGracePeriod, containersGracePeriod(nil))
+
+	unset := testContainer()
+	invalid := testContainer()
+	invalid.Annotations = map[string]string{annotationTerminationGracePeriod: "soon"}
+	assert.Equal(t, defaultTerminationGracePeriod, containersGracePeriod([]*lxf.Container{unset, invalid}))
+
+	short := testContainer()
+	short.Annotations = map[string]string{annotationTerminationGracePeriod: "5"}
+	long := testContainer()
+	long.Annotations = map[string]string{annotationTerminationGracePeriod: "90"}
+	assert.Equal(t, 90*time.Second, containersGracePeriod([]*lxf.Container{short, unset, long}))
+
+	zero := testContainer()
+	zero.Annotations = map[string]string{annotationTerminationGracePeriod: "0"}
+	assert.Equal(t, time.Duration(0), containersGracePeriod([]*lxf.Container{zero}))
+}
+
+func TestStopOrder(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	container := func(name string, started time.Time) *lxf.Container {
+		c := testContainer()
+		c.Metadata.Name = name
+		c.StartedAt = started
+
+		return c
+	}
+
+	proxy := container("proxy", now.Add(-time.Minute))
+	app := container("app", now.Add(-time.Second))
+	logger := container("logger", now)
+	created := container("init", time.Time{})
+	cl := []*lxf.Container{app, created, proxy, logger}
+
+	// reverse start order
+	assert.Equal(t, []*lxf.Container{logger, app, proxy, created}, stopOrder(cl, nil))
+
+	// the named ones first, unknown names are ignored
+	assert.Equal(t, []*lxf.Container{proxy, app, logger, created}, stopOrder(cl, map[string]string{annotationStopOrder: "proxy, missing,app,proxy"}))
+	assert.Equal(t, []*lxf.Container{app, created, proxy, logger}, cl)
+}
+
+func TestValidateDefaultKeys(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateDefaultKeys(nil))
+	assert.NoError(t, validateDefaultKeys(map[string]string{"team": "infra", "example.com/cost-center": "42", "a": ""}))
+
+	for _, key := range []string{"", "-team", "team.", "my team", "team=infra", "x-lxe-exec-user"} {
+		assert.True(t, errors.Is(validateDefaultKeys(map[string]string{key: "infra"}), ErrInvalidDefaultKey), key)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	t.Parallel()
+
+	values := map[string]string{"team": "web"}
+
+	merged := withDefaults(values, map[string]string{"team": "infra", "cost-center": "42"})
+	assert.Equal(t, map[string]string{"team": "web", "cost-center": "42"}, merged)
+	assert.Equal(t, map[string]string{"team": "web"}, values)
+
+	assert.Equal(t, map[string]string{}, withDefaults(nil, nil))
+}
+
+func TestStopTimeout(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	deadline := now.Add(10 * time.Second)
+
+	// the containers share the budget, each gets what's left
+	assert.Equal(t, 10, stopTimeout(deadline, now))
+	assert.Equal(t, 4, stopTimeout(deadline, now.Add(6500*time.Millisecond)))
+	assert.Equal(t, 0, stopTimeout(deadline, deadline))
+	assert.Equal(t, 0, stopTimeout(deadline, now.Add(time.Minute)))
+}
+
+func TestStateContainerAsCri(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_CREATED, stateContainerAsCri(lxf.ContainerStateCreating))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_CREATED, stateContainerAsCri(lxf.ContainerStateCreated))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_RUNNING, stateContainerAsCri(lxf.ContainerStateRunning))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_EXITED, stateContainerAsCri(lxf.ContainerStateExited))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_UNKNOWN, stateContainerAsCri(lxf.ContainerStateUnknown))
+	// never created, which kubelet would start
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_UNKNOWN, stateContainerAsCri(""))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_UNKNOWN, stateContainerAsCri("broken"))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_UNKNOWN, stateContainerAsCri("Freezing"))
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_UNKNOWN, stateContainerAsCri("error"))
+}
+
+func TestStateSandboxAsCri(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_READY, stateSandboxAsCri(lxf.SandboxReady))
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_NOTREADY, stateSandboxAsCri(lxf.SandboxNotReady))
+	// never ready, which kubelet would keep using
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_NOTREADY, stateSandboxAsCri(""))
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_NOTREADY, stateSandboxAsCri("unknown"))
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_NOTREADY, stateSandboxAsCri("Error"))
+}
+
+func TestStateReason(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer()
+
+	c.StateName = lxf.ContainerStateCreating
+	assert.Equal(t, reasonContainerCreating, stateReason(c))
+
+	c.StateName = lxf.ContainerStateUnknown
+	assert.Equal(t, reasonContainerUnknown, stateReason(c))
+
+	c.StateName = lxf.ContainerStateCreated
+	assert.Empty(t, stateReason(c))
+
+	c.StateName = lxf.ContainerStateRunning
+	c.Frozen = true
+	assert.Equal(t, reasonContainerFrozen, stateReason(c))
+	c.Frozen = false
+
+	c.StateName = lxf.ContainerStateExited
+	c.ExitReason = lxf.ReasonCannotRun
+	assert.Equal(t, lxf.ReasonCannotRun, stateReason(c))
+}
+
+func TestRuntimeServer_checkExecCommand(t *testing.T) {
+	t.Parallel()
+
+	s := testRuntimeServer()
+
+	// no policy
+	assert.NoError(t, s.checkExecCommand(s.criConfig(), []string{"sh", "-c", "true"}))
+	assert.True(t, errors.Is(s.checkExecCommand(s.criConfig(), nil), ErrEmptyCommand))
+	assert.True(t, errors.Is(s.checkExecCommand(s.criConfig(), []string{}), ErrEmptyCommand))
+	assert.True(t, errors.Is(s.checkExecCommand(s.criConfig(), []string{"", "true"}), ErrEmptyCommand))
+
+	s.config = newConfigHolder(&Config{
+		LXEExecAllow: []string{"cat", "/usr/bin/curl", "sh"},
+		LXEExecDeny:  []string{"sh", "/bin/bash"},
+	})
+
+	for cmd, allowed := range map[string]bool{
+		"cat":           true,
+		"/bin/cat":      true,
+		"/usr/bin/curl": true,
+		"curl":          false,
+		"/opt/curl":     false,
+		"ls":            false,
+		// denied takes precedence
+		"sh":      false,
+		"/bin/sh": false,
+		"bash":    false,
+	} {
+		err := s.checkExecCommand(s.criConfig(), []string{cmd, "arg"})
+		if allowed {
+			assert.NoError(t, err, cmd)
+		} else {
+			assert.True(t, errors.Is(err, ErrPolicy), cmd)
+		}
+	}
+
+	assert.True(t, errors.Is(s.checkExecCommand(s.criConfig(), nil), ErrEmptyCommand))
+
+	// only denied
+	s.config = newConfigHolder(&Config{LXEExecDeny: []string{"bash"}})
+	assert.NoError(t, s.checkExecCommand(s.criConfig(), []string{"/bin/bash-completion"}))
+	assert.True(t, errors.Is(s.checkExecCommand(s.criConfig(), []string{"/usr/local/bin/bash"}), ErrPolicy))
+}
@@ -0,0 +1,1285 @@
+package cri
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/lxf/device"
+	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	"github.com/automaticserver/lxe/lxf/lxftest"
+	"github.com/automaticserver/lxe/lxf/lxo"
+	"github.com/automaticserver/lxe/network"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/stretchr/testify/assert"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+var (
+	errTestNetwork = errors.New("no more ips")
+	errTestImport  = errors.New("import failed")
+)
+
+// fakeNetwork is a network plugin whose creation and pod deletion can be failed and which counts the deletions
+type fakeNetwork struct {
+	mu                 sync.Mutex
+	podCreateErr       error
+	containerCreateErr error
+	podDeleteErr       error
+	podIP              net.IP
+	podDeletes         int
+	containerDeletes   int
+}
+
+func (f *fakeNetwork) PodNetwork(id string, annotations map[string]string) (network.PodNetwork, error) {
+	return &fakePodNetwork{f}, nil
+}
+
+func (f *fakeNetwork) Status() error {
+	return nil
+}
+
+func (f *fakeNetwork) UpdateRuntimeConfig(conf *rtApi.RuntimeConfig) error {
+	return nil
+}
+
+type fakePodNetwork struct {
+	f *fakeNetwork
+}
+
+func (p *fakePodNetwork) ContainerNetwork(id string, annotations map[string]string) (network.ContainerNetwork, error) {
+	return &fakeContainerNetwork{p.f}, nil
+}
+
+func (p *fakePodNetwork) Status(ctx context.Context, prop *network.PropertiesRunning) (*network.Status, error) {
+	p.f.mu.Lock()
+	defer p.f.mu.Unlock()
+
+	if p.f.podIP == nil {
+		return &network.Status{}, nil
+	}
+
+	return &network.Status{IPs: []net.IP{p.f.podIP}}, nil
+}
+
+func (p *fakePodNetwork) WhenCreated(ctx context.Context, prop *network.Properties) (*network.Result, error) {
+	return nil, p.f.podCreateErr
+}
+
+func (p *fakePodNetwork) WhenStarted(ctx context.Context, prop *network.PropertiesRunning) (*network.Result, error) {
+	return nil, nil
+}
+
+func (p *fakePodNetwork) WhenStopped(ctx context.Context, prop *network.Properties) error {
+	return nil
+}
+
+func (p *fakePodNetwork) WhenDeleted(ctx context.Context, prop *network.Properties) error {
+	p.f.mu.Lock()
+	defer p.f.mu.Unlock()
+
+	p.f.podDeletes++
+
+	return p.f.podDeleteErr
+}
+
+type fakeContainerNetwork struct {
+	f *fakeNetwork
+}
+
+func (c *fakeContainerNetwork) WhenCreated(ctx context.Context, prop *network.Properties) (*network.Result, error) {
+	return nil, c.f.containerCreateErr
+}
+
+func (c *fakeContainerNetwork) WhenStarted(ctx context.Context, prop *network.PropertiesRunning) (*network.Result, error) {
+	return nil, nil
+}
+
+func (c *fakeContainerNetwork) WhenStopped(ctx context.Context, prop *network.Properties) error {
+	return nil
+}
+
+func (c *fakeContainerNetwork) WhenDeleted(ctx context.Context, prop *network.Properties) error {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	c.f.containerDeletes++
+
+	return nil
+}
+
+// testLXDRuntimeServer returns a runtime server backed by an in-memory lxd and the fake network plugin
+func testLXDRuntimeServer() (RuntimeServer, *lxftest.Server, *fakeNetwork) {
+	srv := lxftest.NewServer()
+	srv.AddImage("local/busybox", "abc123")
+
+	netw := &fakeNetwork{}
+
+	s := testRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault})
+	s.lxf = lxf.NewClientWithServer(srv, lxo.Timeouts{})
+	s.network = netw
+	s.networkLeaks = newNetworkLeaks()
+	s.podIPs = newPodIPs()
+	// tests change containers behind the runtime's back
+	s.containers = newContainerCache(s.lxf, 0)
+
+	return s, srv, netw
+}
+
+func testRunPodSandboxRequest() *rtApi.RunPodSandboxRequest {
+	return &rtApi.RunPodSandboxRequest{Config: &rtApi.PodSandboxConfig{
+		Metadata: &rtApi.PodSandboxMetadata{Name: "web", Namespace: "default", Uid: "abc"},
+	}}
+}
+
+func TestRuntimeServer_RunPodSandbox_Ok(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+
+	resp, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{resp.GetPodSandboxId()}, srv.ProfileNames())
+
+	sb, err := s.lxf.GetSandbox(resp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Equal(t, lxf.SandboxReady, sb.State)
+	assert.Equal(t, "web", sb.Metadata.Name)
+}
+
+func TestRuntimeServer_RunPodSandbox_NetworkFailureRollsBack(t *testing.T) {
+	t.Parallel()
+
+	s, srv, netw := testLXDRuntimeServer()
+	netw.podCreateErr = errTestNetwork
+
+	_, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.True(t, errors.Is(err, errTestNetwork))
+	assert.Empty(t, srv.ProfileNames())
+	assert.Equal(t, 1, srv.DeleteProfileCallCount())
+	assert.Equal(t, 1, netw.podDeletes)
+}
+
+func TestRuntimeServer_RunPodSandbox_RollbackFailure(t *testing.T) {
+	t.Parallel()
+
+	s, srv, netw := testLXDRuntimeServer()
+	netw.podCreateErr = errTestNetwork
+	srv.DeleteProfileReturns(errors.New("lxd unavailable"))
+
+	// the error of the network is returned, not the one of the rollback
+	_, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.True(t, errors.Is(err, errTestNetwork))
+	assert.Len(t, srv.ProfileNames(), 1)
+}
+
+func TestRuntimeServer_CreateContainer_NetworkFailureRollsBack(t *testing.T) {
+	t.Parallel()
+
+	s, srv, netw := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	req := &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	}
+
+	resp, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{resp.GetContainerId()}, srv.ContainerNames())
+
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+	assert.Equal(t, lxf.ContainerStateCreated, c.StateName)
+
+	netw.containerCreateErr = errTestNetwork
+
+	_, err = s.CreateContainer(context.Background(), req)
+	assert.True(t, errors.Is(err, errTestNetwork))
+	assert.Equal(t, []string{resp.GetContainerId()}, srv.ContainerNames())
+	assert.Equal(t, 1, srv.DeleteContainerCallCount())
+	assert.Equal(t, 1, netw.containerDeletes)
+
+	// the sandbox can still be removed with the remaining container
+	_, err = s.RemovePodSandbox(context.Background(), &rtApi.RemovePodSandboxRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+	assert.NoError(t, err)
+	assert.Empty(t, srv.ContainerNames())
+	assert.Empty(t, srv.ProfileNames())
+}
+
+func TestRuntimeServer_StartContainer_Creating(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := testLXDRuntimeServer()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.NoError(t, err)
+
+	// as seen by kubelet while CreateContainer sets it up
+	c := s.lxf.NewContainer(sbResp.GetPodSandboxId())
+	c.Metadata.Name = "app"
+	c.Image = "busybox"
+	err = c.Apply()
+	assert.NoError(t, err)
+
+	status, err := s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_CREATED, status.GetStatus().GetState())
+	assert.Equal(t, reasonContainerCreating, status.GetStatus().GetReason())
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: c.ID})
+	assert.True(t, errors.Is(err, ErrContainerCreating))
+
+	err = c.FinishCreate()
+	assert.NoError(t, err)
+
+	status, err = s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_CREATED, status.GetStatus().GetState())
+	assert.Empty(t, status.GetStatus().GetReason())
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+
+	status, err = s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_RUNNING, status.GetStatus().GetState())
+}
+
+func TestRuntimeServer_FrozenContainer(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+
+	err = c.Freeze()
+	assert.NoError(t, err)
+
+	// still running for kubelet
+	status, err := s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_RUNNING, status.GetStatus().GetState())
+	assert.Equal(t, reasonContainerFrozen, status.GetStatus().GetReason())
+
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.ID, Cmd: []string{"true"}})
+	assert.True(t, errors.Is(err, ErrContainerFrozen))
+	assert.Equal(t, 0, srv.ExecContainerCallCount())
+
+	// it's thawed to shut down
+	_, err = s.StopContainer(context.Background(), &rtApi.StopContainerRequest{ContainerId: c.ID, Timeout: 10})
+	assert.NoError(t, err)
+
+	calls := srv.UpdateContainerStateCallCount()
+	_, thaw, _ := srv.UpdateContainerStateArgsForCall(calls - 2)
+	assert.Equal(t, "unfreeze", thaw.Action)
+
+	status, err = s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: c.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_EXITED, status.GetStatus().GetState())
+	assert.Empty(t, status.GetStatus().GetReason())
+}
+
+func TestRuntimeServer_StopPodSandbox_StopOrder(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Annotations = map[string]string{annotationStopOrder: "app"}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	ids := map[string]string{}
+
+	for _, name := range []string{"proxy", "app", "logger"} {
+		resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+			PodSandboxId: sbResp.GetPodSandboxId(),
+			Config: &rtApi.ContainerConfig{
+				Metadata: &rtApi.ContainerMetadata{Name: name},
+				Image:    &rtApi.ImageSpec{Image: "busybox"},
+			},
+			SandboxConfig: sbReq.GetConfig(),
+		})
+		assert.NoError(t, err)
+
+		_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: resp.GetContainerId()})
+		assert.NoError(t, err)
+
+		ids[resp.GetContainerId()] = name
+	}
+
+	calls := srv.UpdateContainerStateCallCount()
+
+	_, err = s.StopPodSandbox(context.Background(), &rtApi.StopPodSandboxRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+	assert.NoError(t, err)
+
+	stopped := []string{}
+
+	for i := calls; i < srv.UpdateContainerStateCallCount(); i++ {
+		id, put, _ := srv.UpdateContainerStateArgsForCall(i)
+		if put.Action == "stop" {
+			stopped = append(stopped, ids[id])
+		}
+	}
+
+	// the app first as annotated, then the others in reverse start order
+	assert.Equal(t, []string{"app", "logger", "proxy"}, stopped)
+}
+
+func TestRuntimeServer_CreateContainer_ForeignArchitecture(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	srv.AddImage("local/arm64", "def456")
+	srv.SetImageArchitecture("def456", "aarch64")
+
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "arm64"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, lxf.ErrArchitecture))
+	assert.Contains(t, err.Error(), "aarch64")
+	assert.Empty(t, srv.ContainerNames())
+}
+
+func TestRuntimeServer_DefaultLabelsAndAnnotations(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{
+		LXENetworkPlugin:      NetworkPluginDefault,
+		LXEDefaultLabels:      map[string]string{"team": "infra", "cost-center": "42"},
+		LXEDefaultAnnotations: map[string]string{"example.com/owner": "ops"},
+	})
+
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Labels = map[string]string{"team": "web"}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata:    &rtApi.ContainerMetadata{Name: "app"},
+			Image:       &rtApi.ImageSpec{Image: "busybox"},
+			Annotations: map[string]string{"example.com/owner": "dev"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	// the pod's values aren't clobbered
+	profile, _, err := srv.GetProfile(sbResp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Equal(t, "web", profile.Config["user.labels.team"])
+	assert.Equal(t, "42", profile.Config["user.labels.cost-center"])
+	assert.Equal(t, "ops", profile.Config["user.annotations.example.com/owner"])
+	assert.Equal(t, map[string]string{"team": "web"}, sbReq.GetConfig().GetLabels())
+
+	ct, _, err := srv.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+	assert.Equal(t, "infra", ct.Config["user.labels.team"])
+	assert.Equal(t, "42", ct.Config["user.labels.cost-center"])
+	assert.Equal(t, "dev", ct.Config["user.annotations.example.com/owner"])
+
+	list, err := s.ListPodSandbox(context.Background(), &rtApi.ListPodSandboxRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, list.GetItems(), 1)
+	assert.Equal(t, map[string]string{"team": "web", "cost-center": "42"}, list.GetItems()[0].GetLabels())
+}
+
+func TestRuntimeServer_RemovePodSandbox_NetworkLeak(t *testing.T) {
+	// not parallel, as it reads a global metric
+	s, srv, netw := testLXDRuntimeServer()
+	netw.podDeleteErr = errTestNetwork
+
+	leaked, err := s.RunPodSandbox(context.Background(), testRunPodSandboxRequest())
+	assert.NoError(t, err)
+
+	failures := metricNetworkTeardownFailures.Value()
+
+	// still removed, as kubelet would retry forever otherwise
+	_, err = s.RemovePodSandbox(context.Background(), &rtApi.RemovePodSandboxRequest{PodSandboxId: leaked.GetPodSandboxId()})
+	assert.NoError(t, err)
+	assert.NotContains(t, srv.ProfileNames(), leaked.GetPodSandboxId())
+	assert.Equal(t, failures+1, metricNetworkTeardownFailures.Value())
+
+	status, err := s.Status(context.Background(), &rtApi.StatusRequest{Verbose: true})
+	assert.NoError(t, err)
+
+	leaks := []networkLeak{}
+	assert.NoError(t, json.Unmarshal([]byte(status.GetInfo()["network.leaks"]), &leaks))
+	assert.Len(t, leaks, 1)
+	assert.Equal(t, leaked.GetPodSandboxId(), leaks[0].ID)
+	assert.Equal(t, "delete", leaks[0].Action)
+	assert.Equal(t, errTestNetwork.Error(), leaks[0].Error)
+
+	// other pods aren't tracked once their network is released
+	netw.mu.Lock()
+	netw.podDeleteErr = nil
+	netw.mu.Unlock()
+
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Metadata.Uid = "def"
+
+	released, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	_, err = s.RemovePodSandbox(context.Background(), &rtApi.RemovePodSandboxRequest{PodSandboxId: released.GetPodSandboxId()})
+	assert.NoError(t, err)
+	assert.Len(t, s.networkLeaks.List(), 1)
+}
+
+func TestRuntimeServer_CreateContainer_PostCreateRefused(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Annotations = map[string]string{annotationPostCreate: "touch /rendered"}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	// refused by default, before anything is created
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, srv.ContainerNames())
+}
+
+func TestRuntimeServer_CreateContainer_RestoreRefused(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Annotations = map[string]string{annotationRestoreFromPrefix + "app": "/tmp/app.tar.gz"}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Equal(t, 0, srv.CreateContainerFromBackupCallCount())
+}
+
+// writeTestCheckpoint writes a checkpoint archive of the container foo whose checkpoint has the config into dir and
+// returns its path
+func writeTestCheckpoint(t *testing.T, dir string, config string) string {
+	path := filepath.Join(dir, "app.tar")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+
+	tw := tar.NewWriter(f)
+
+	for _, file := range []struct{ name, content string }{
+		{"backup/index.yaml", "name: foo\nsnapshots:\n- checkpoint-1\n"},
+		{"backup/container/backup.yaml", "container:\n  architecture: x86_64\nsnapshots:\n- name: checkpoint-1\n  config:\n    " + config + "\n"},
+	} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0600, Size: int64(len(file.content))}))
+		_, err = tw.Write([]byte(file.content))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, f.Close())
+
+	return path
+}
+
+func TestRuntimeServer_CreateContainer_RestorePrivilegedCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-restore")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.Reload(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEAllowRestore: dir})
+
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Annotations = map[string]string{
+		annotationRestoreFromPrefix + "app": writeTestCheckpoint(t, dir, `security.privileged: "true"`),
+	}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	// the checkpoint would start privileged, while the container isn't
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Equal(t, 0, srv.CreateContainerFromBackupCallCount())
+
+	// an unprivileged checkpoint is imported
+	writeTestCheckpoint(t, dir, `security.privileged: "false"`)
+	srv.CreateContainerFromBackupReturns(nil, errTestImport)
+
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, errTestImport))
+	assert.Equal(t, 1, srv.CreateContainerFromBackupCallCount())
+}
+
+func TestRuntimeServer_PodSandboxStatus_IPChange(t *testing.T) {
+	// not parallel, as it reads a global metric
+	s, srv, netw := testLXDRuntimeServer()
+	netw.podIP = net.ParseIP("10.0.0.5")
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	sb, err := s.lxf.GetSandbox(sbResp.GetPodSandboxId())
+	assert.NoError(t, err)
+	sb.NetworkConfig.Mode = lxf.NetworkCNI
+	assert.NoError(t, sb.Apply())
+
+	changes := metricPodIPChanges.Value()
+	updates := srv.UpdateProfileCallCount()
+
+	status := func() string {
+		resp, err := s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+		assert.NoError(t, err)
+
+		return resp.GetStatus().GetNetwork().GetIp()
+	}
+
+	assert.Equal(t, "10.0.0.5", status())
+
+	netw.mu.Lock()
+	netw.podIP = net.ParseIP("10.0.0.6")
+	netw.mu.Unlock()
+
+	assert.Equal(t, "10.0.0.6", status())
+	assert.Equal(t, "10.0.0.6", status())
+	assert.Equal(t, changes+1, metricPodIPChanges.Value())
+
+	// the status is only read, the ip is saved when a container is started
+	assert.Equal(t, updates, srv.UpdateProfileCallCount())
+
+	c, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: c.GetContainerId()})
+	assert.NoError(t, err)
+
+	sb, err = s.lxf.GetSandbox(sbResp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.6", sb.LastIP)
+}
+
+func TestRuntimeServer_Privileged(t *testing.T) {
+	t.Parallel()
+
+	privileged := &rtApi.LinuxSandboxSecurityContext{Privileged: true}
+
+	// refused by the zero config, the flag allows it by default
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Linux = &rtApi.LinuxPodSandboxConfig{SecurityContext: privileged}
+
+	_, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, srv.ProfileNames())
+
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEAllowPrivileged: true})
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	sb, err := s.lxf.GetSandbox(sbResp.GetPodSandboxId())
+	assert.NoError(t, err)
+	assert.Equal(t, "true", sb.Config["security.privileged"])
+}
+
+func TestRuntimeServer_CreateContainer_Privileged(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	req := &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+			Linux: &rtApi.LinuxContainerConfig{
+				SecurityContext: &rtApi.LinuxContainerSecurityContext{Privileged: true},
+			},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	}
+
+	_, err = s.CreateContainer(context.Background(), req)
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, srv.ContainerNames())
+
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEAllowPrivileged: true})
+
+	resp, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+	assert.True(t, c.Privileged)
+}
+
+func TestRuntimeServer_CreateContainer_PrivilegedSandbox(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEAllowPrivileged: true})
+
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Linux = &rtApi.LinuxPodSandboxConfig{SecurityContext: &rtApi.LinuxSandboxSecurityContext{Privileged: true}}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	// reloaded meanwhile, the container itself doesn't ask to be privileged
+	s.Reload(&Config{LXENetworkPlugin: NetworkPluginDefault})
+
+	_, err = s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Empty(t, srv.ContainerNames())
+}
+
+func TestRuntimeServer_CreateContainer_MountCollision(t *testing.T) {
+	t.Parallel()
+
+	source, err := ioutil.TempDir("", "lxe-mount")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(source)
+
+	for _, tt := range []struct {
+		policy  string
+		err     error
+		devices int
+	}{
+		{policy: MountCollisionOverlay, devices: 1},
+		{policy: MountCollisionError, err: ErrMountCollision},
+		{policy: MountCollisionSkip, devices: 0},
+	} {
+		s, srv, _ := testLXDRuntimeServer()
+		s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEMountCollision: tt.policy})
+		// a file of the image is mounted over with a directory
+		srv.AddFile("/etc/app.conf", "file")
+
+		sbReq := testRunPodSandboxRequest()
+
+		sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+		assert.NoError(t, err)
+
+		resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+			PodSandboxId: sbResp.GetPodSandboxId(),
+			Config: &rtApi.ContainerConfig{
+				Metadata: &rtApi.ContainerMetadata{Name: "app"},
+				Image:    &rtApi.ImageSpec{Image: "busybox"},
+				Mounts:   []*rtApi.Mount{{HostPath: source, ContainerPath: "/etc/app.conf"}},
+			},
+			SandboxConfig: sbReq.GetConfig(),
+		})
+
+		if tt.err != nil {
+			assert.True(t, errors.Is(err, tt.err), tt.policy)
+			assert.Empty(t, srv.ContainerNames(), tt.policy)
+
+			continue
+		}
+
+		assert.NoError(t, err, tt.policy)
+
+		c, err := s.lxf.GetContainer(resp.GetContainerId())
+		assert.NoError(t, err)
+
+		disks := 0
+
+		for _, d := range c.Devices {
+			if disk, is := d.(*device.Disk); is && disk.Path == "/etc/app.conf" {
+				disks++
+			}
+		}
+
+		assert.Equal(t, tt.devices, disks, tt.policy)
+	}
+}
+
+func TestRuntimeServer_CreateContainer_MountCollision_SameType(t *testing.T) {
+	t.Parallel()
+
+	file, err := ioutil.TempFile("", "lxe-mount")
+	assert.NoError(t, err)
+	file.Close()
+
+	defer os.Remove(file.Name())
+
+	dir, err := ioutil.TempDir("", "lxe-mount")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEMountCollision: MountCollisionError})
+	srv.AddFile("/etc/hosts", "file")
+	srv.AddFile("/data", lxf.FileTypeDirectory)
+
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	req := &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+			// kubelet mounts its hosts file over the one of the image
+			Mounts: []*rtApi.Mount{
+				{HostPath: file.Name(), ContainerPath: "/etc/hosts"},
+				{HostPath: dir, ContainerPath: "/data"},
+			},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	}
+
+	resp, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+
+	paths := []string{}
+
+	for _, d := range c.Devices {
+		if disk, is := d.(*device.Disk); is && disk.Source != "" {
+			paths = append(paths, disk.Path)
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"/etc/hosts", "/data"}, paths)
+
+	// a file over a directory of the image is a collision
+	req.Config.Metadata.Name = "other"
+	req.Config.Mounts = []*rtApi.Mount{{HostPath: file.Name(), ContainerPath: "/data"}}
+
+	_, err = s.CreateContainer(context.Background(), req)
+	assert.True(t, errors.Is(err, ErrMountCollision))
+}
+
+func TestValidateMountCollision(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []string{"", MountCollisionOverlay, MountCollisionError, MountCollisionSkip} {
+		assert.NoError(t, validateMountCollision(policy))
+	}
+
+	assert.True(t, errors.Is(validateMountCollision("ignore"), ErrUnknownMountCollision))
+}
+
+func TestRuntimeServer_Status_Location(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	req := &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	}
+
+	app, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+
+	// not clustered
+	status, err := s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: app.GetContainerId(), Verbose: true})
+	assert.NoError(t, err)
+	assert.NotContains(t, status.GetInfo(), "lxd.location")
+
+	sbStatus, err := s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: sbResp.GetPodSandboxId(), Verbose: true})
+	assert.NoError(t, err)
+	assert.NotContains(t, sbStatus.GetInfo(), "lxd.locations")
+
+	req.Config.Metadata.Name = "sidecar"
+
+	sidecar, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+
+	srv.SetLocation(app.GetContainerId(), "node2")
+	srv.SetLocation(sidecar.GetContainerId(), "node1")
+
+	status, err = s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: app.GetContainerId(), Verbose: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "node2", status.GetInfo()["lxd.location"])
+
+	sbStatus, err = s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: sbResp.GetPodSandboxId(), Verbose: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `["node1","node2"]`, sbStatus.GetInfo()["lxd.locations"])
+}
+
+func TestRuntimeServer_PodSandboxStatus_NetworkMode(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		mode        string
+		plugin      string
+		hostNetwork bool
+	}{
+		{mode: "bridged", plugin: NetworkPluginDefault},
+		{mode: "cni", plugin: NetworkPluginCNI},
+		{mode: "host", plugin: NetworkPluginCNI, hostNetwork: true},
+		{mode: "none", plugin: NetworkPluginDefault},
+	} {
+		s, _, _ := testLXDRuntimeServer()
+		s.config = newConfigHolder(&Config{LXENetworkPlugin: tt.plugin})
+		req := testRunPodSandboxRequest()
+
+		if tt.hostNetwork {
+			req.Config.Linux = &rtApi.LinuxPodSandboxConfig{SecurityContext: &rtApi.LinuxSandboxSecurityContext{
+				NamespaceOptions: &rtApi.NamespaceOption{Network: rtApi.NamespaceMode_NODE},
+			}}
+		}
+
+		resp, err := s.RunPodSandbox(context.Background(), req)
+		assert.NoError(t, err, tt.mode)
+
+		// sandboxes whose network mode isn't known
+		if tt.mode == "none" {
+			sb, err := s.lxf.GetSandbox(resp.GetPodSandboxId())
+			assert.NoError(t, err, tt.mode)
+			sb.NetworkConfig.Mode = lxf.NetworkNone
+			assert.NoError(t, sb.Apply(), tt.mode)
+		}
+
+		status, err := s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: resp.GetPodSandboxId(), Verbose: true})
+		assert.NoError(t, err, tt.mode)
+		assert.Equal(t, tt.mode, status.GetInfo()["networkMode"], tt.mode)
+	}
+}
+
+func TestRuntimeServer_PodSandboxStatus_QOSClass(t *testing.T) {
+	t.Parallel()
+
+	s, _, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	qosClass := func() string {
+		status, err := s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: sbResp.GetPodSandboxId(), Verbose: true})
+		assert.NoError(t, err)
+
+		return status.GetInfo()["qosClass"]
+	}
+
+	create := func(name string, resources *rtApi.LinuxContainerResources) {
+		_, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+			PodSandboxId: sbResp.GetPodSandboxId(),
+			Config: &rtApi.ContainerConfig{
+				Metadata: &rtApi.ContainerMetadata{Name: name},
+				Image:    &rtApi.ImageSpec{Image: "busybox"},
+				Linux:    &rtApi.LinuxContainerConfig{Resources: resources},
+			},
+			SandboxConfig: sbReq.GetConfig(),
+		})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, "BestEffort", qosClass())
+
+	// cpu: 500m and memory: 128Mi as requests and limits
+	create("app", &rtApi.LinuxContainerResources{CpuShares: 512, CpuQuota: 50000, CpuPeriod: 100000, MemoryLimitInBytes: 128 * 1024 * 1024})
+	assert.Equal(t, "Guaranteed", qosClass())
+
+	// neither requests nor limits
+	create("sidecar", &rtApi.LinuxContainerResources{CpuShares: 2, CpuPeriod: 100000})
+	assert.Equal(t, "Burstable", qosClass())
+}
+
+func TestRuntimeServer_ExecSync_Policy(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.config = newConfigHolder(&Config{LXENetworkPlugin: NetworkPluginDefault, LXEExecDeny: []string{"sh"}})
+	s.execSyncs = newExecSyncCache(0)
+
+	_, err := s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: "app", Cmd: []string{"/bin/sh", "-c", "id"}})
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: "app"})
+	assert.True(t, errors.Is(err, ErrEmptyCommand))
+
+	_, err = s.Exec(context.Background(), &rtApi.ExecRequest{ContainerId: "app", Cmd: []string{"sh"}, Tty: true})
+	assert.True(t, errors.Is(err, ErrPolicy))
+
+	assert.Equal(t, 0, srv.ExecContainerCallCount())
+}
+
+func TestRuntimeServer_ExecSync_Cwd(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.execSyncs = newExecSyncCache(0)
+	srv.ExecContainerStub = func(name string, req api.ContainerExecPost, args *lxd.ContainerExecArgs) (lxd.Operation, error) {
+		close(args.DataDone)
+
+		op := &lxdfakes.FakeOperation{}
+		op.GetReturns(api.Operation{Metadata: map[string]interface{}{"return": float64(0)}})
+
+		return op, nil
+	}
+	srv.RawOperationStub = func(method, path string, data interface{}, etag string) (lxd.Operation, string, error) {
+		op := &lxdfakes.FakeOperation{}
+		op.GetReturns(api.Operation{Metadata: map[string]interface{}{"return": float64(0)}})
+
+		return op, "", nil
+	}
+
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	c, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	// without the annotation the command runs in the default directory
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.GetContainerId(), Cmd: []string{"pwd"}})
+	assert.NoError(t, err)
+
+	_, req, _ := srv.ExecContainerArgsForCall(0)
+	assert.Equal(t, []string{"pwd"}, req.Command)
+	assert.Equal(t, 0, srv.RawOperationCallCount())
+
+	sb, err := s.lxf.GetSandbox(sbResp.GetPodSandboxId())
+	assert.NoError(t, err)
+	sb.Annotations[annotationExecCwd] = "/srv/app"
+	assert.NoError(t, sb.Apply())
+
+	// older lxd servers can't exec in another directory
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.GetContainerId(), Cmd: []string{"pwd"}})
+	assert.True(t, errors.Is(err, lxf.ErrUsage))
+	assert.Equal(t, 0, srv.RawOperationCallCount())
+
+	srv.HasExtensionReturns(true)
+
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.GetContainerId(), Cmd: []string{"pwd"}})
+	assert.NoError(t, err)
+
+	// the directory is passed to lxd, the command is left as is
+	assert.Equal(t, 1, srv.RawOperationCallCount())
+
+	_, _, data, _ := srv.RawOperationArgsForCall(0)
+	b, err := json.Marshal(data)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"command":["pwd"]`)
+	assert.Contains(t, string(b), `"cwd":"/srv/app"`)
+
+	sb.Annotations[annotationExecCwd] = "srv/app"
+	assert.NoError(t, sb.Apply())
+
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.GetContainerId(), Cmd: []string{"pwd"}})
+	assert.True(t, errors.Is(err, lxf.ErrUsage))
+	assert.Equal(t, 1, srv.ExecContainerCallCount())
+	assert.Equal(t, 1, srv.RawOperationCallCount())
+}
+
+func TestRuntimeServer_ExecSync_User(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	s.execSyncs = newExecSyncCache(0)
+	srv.HasExtensionReturns(true)
+	srv.RawOperationStub = func(method, path string, data interface{}, etag string) (lxd.Operation, string, error) {
+		op := &lxdfakes.FakeOperation{}
+		op.GetReturns(api.Operation{Metadata: map[string]interface{}{"return": float64(0)}})
+
+		return op, "", nil
+	}
+
+	sbReq := testRunPodSandboxRequest()
+	sbReq.Config.Annotations = map[string]string{annotationExecUser: "1000:100"}
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	c, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.ExecSync(context.Background(), &rtApi.ExecSyncRequest{ContainerId: c.GetContainerId(), Cmd: []string{"id"}})
+	assert.NoError(t, err)
+
+	// the user is passed to lxd instead of wrapping the command, and without checking it first
+	assert.Equal(t, 0, srv.ExecContainerCallCount())
+	assert.Equal(t, 1, srv.RawOperationCallCount())
+
+	_, _, data, _ := srv.RawOperationArgsForCall(0)
+	b, err := json.Marshal(data)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"command":["id"]`)
+	assert.Contains(t, string(b), `"user":1000,"group":100`)
+}
+
+func TestRuntimeServer_ExitThenRecreate(t *testing.T) {
+	t.Parallel()
+
+	s, srv, _ := testLXDRuntimeServer()
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	createReq := func(attempt uint32) *rtApi.CreateContainerRequest {
+		return &rtApi.CreateContainerRequest{
+			PodSandboxId: sbResp.GetPodSandboxId(),
+			Config: &rtApi.ContainerConfig{
+				Metadata: &rtApi.ContainerMetadata{Name: "job", Attempt: attempt},
+				Image:    &rtApi.ImageSpec{Image: "busybox"},
+			},
+			SandboxConfig: sbReq.GetConfig(),
+		}
+	}
+
+	first, err := s.CreateContainer(context.Background(), createReq(0))
+	assert.NoError(t, err)
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: first.GetContainerId()})
+	assert.NoError(t, err)
+
+	// the container shuts down on its own
+	_, err = srv.UpdateContainerState(first.GetContainerId(), api.ContainerStatePut{Action: "stop"}, "")
+	assert.NoError(t, err)
+
+	updates := srv.UpdateContainerCallCount()
+
+	status, err := s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: first.GetContainerId()})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_EXITED, status.GetStatus().GetState())
+	assert.Equal(t, int32(0), status.GetStatus().GetExitCode())
+	// reading the status doesn't write to lxd
+	assert.Equal(t, updates, srv.UpdateContainerCallCount())
+
+	// kubelet restarts it as the next attempt, while the exited one is kept until garbage collection
+	second, err := s.CreateContainer(context.Background(), createReq(1))
+	assert.NoError(t, err)
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: second.GetContainerId()})
+	assert.NoError(t, err)
+
+	list, err := s.ListContainers(context.Background(), &rtApi.ListContainersRequest{Filter: &rtApi.ContainerFilter{PodSandboxId: sbResp.GetPodSandboxId()}})
+	assert.NoError(t, err)
+
+	states := map[uint32]rtApi.ContainerState{}
+	for _, c := range list.GetContainers() {
+		states[c.GetMetadata().GetAttempt()] = c.GetState()
+	}
+
+	assert.Equal(t, map[uint32]rtApi.ContainerState{
+		0: rtApi.ContainerState_CONTAINER_EXITED,
+		1: rtApi.ContainerState_CONTAINER_RUNNING,
+	}, states)
+
+	_, err = s.RemoveContainer(context.Background(), &rtApi.RemoveContainerRequest{ContainerId: first.GetContainerId()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{second.GetContainerId()}, srv.ContainerNames())
+
+	status, err = s.ContainerStatus(context.Background(), &rtApi.ContainerStatusRequest{ContainerId: second.GetContainerId()})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.ContainerState_CONTAINER_RUNNING, status.GetStatus().GetState())
+	assert.Equal(t, uint32(1), status.GetStatus().GetMetadata().GetAttempt())
+
+	// with restart policy Never, the pod stays until it's removed with its exited container
+	_, err = srv.UpdateContainerState(second.GetContainerId(), api.ContainerStatePut{Action: "stop"}, "")
+	assert.NoError(t, err)
+
+	sbStatus, err := s.PodSandboxStatus(context.Background(), &rtApi.PodSandboxStatusRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+	assert.NoError(t, err)
+	assert.Equal(t, rtApi.PodSandboxState_SANDBOX_READY, sbStatus.GetStatus().GetState())
+
+	_, err = s.StopPodSandbox(context.Background(), &rtApi.StopPodSandboxRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+	assert.NoError(t, err)
+	_, err = s.RemovePodSandbox(context.Background(), &rtApi.RemovePodSandboxRequest{PodSandboxId: sbResp.GetPodSandboxId()})
+	assert.NoError(t, err)
+	assert.Empty(t, srv.ContainerNames())
+	assert.Empty(t, srv.ProfileNames())
+}
+
+func TestRuntimeServer_RunPodSandbox_DNSPolicy(t *testing.T) {
+	t.Parallel()
+
+	// as kubelet resolves each policy into the dns config
+	for _, tt := range []struct {
+		policy      string
+		dns         *rtApi.DNSConfig
+		hostNetwork bool
+		nameservers []string
+		searches    []string
+	}{
+		{
+			policy:      "ClusterFirst",
+			dns:         &rtApi.DNSConfig{Servers: []string{"10.96.0.10"}, Searches: []string{"default.svc.cluster.local", "svc.cluster.local"}, Options: []string{"ndots:5"}},
+			nameservers: []string{"10.96.0.10"},
+			searches:    []string{"default.svc.cluster.local", "svc.cluster.local"},
+		},
+		{
+			policy:      "Default",
+			dns:         &rtApi.DNSConfig{Servers: []string{"127.0.0.53", "192.168.1.1"}, Searches: []string{"lan"}},
+			nameservers: []string{"192.168.1.1"},
+			searches:    []string{"lan"},
+		},
+		{
+			policy:      "Default with host network",
+			dns:         &rtApi.DNSConfig{Servers: []string{"127.0.0.53"}},
+			hostNetwork: true,
+			nameservers: []string{"127.0.0.53"},
+		},
+		{
+			policy:      "None",
+			dns:         &rtApi.DNSConfig{Servers: []string{"1.1.1.1"}},
+			nameservers: []string{"1.1.1.1"},
+		},
+		{
+			policy: "Default without resolv.conf",
+			dns:    &rtApi.DNSConfig{},
+		},
+	} {
+		s, srv, _ := testLXDRuntimeServer()
+		req := testRunPodSandboxRequest()
+		req.Config.DnsConfig = tt.dns
+
+		if tt.hostNetwork {
+			req.Config.Linux = &rtApi.LinuxPodSandboxConfig{SecurityContext: &rtApi.LinuxSandboxSecurityContext{
+				NamespaceOptions: &rtApi.NamespaceOption{Network: rtApi.NamespaceMode_NODE},
+			}}
+		}
+
+		resp, err := s.RunPodSandbox(context.Background(), req)
+		assert.NoError(t, err, tt.policy)
+
+		sb, err := s.lxf.GetSandbox(resp.GetPodSandboxId())
+		assert.NoError(t, err, tt.policy)
+		assert.Equal(t, tt.nameservers, sb.NetworkConfig.Nameservers, tt.policy)
+		assert.Equal(t, tt.searches, sb.NetworkConfig.Searches, tt.policy)
+
+		profile, _, err := srv.GetProfile(resp.GetPodSandboxId())
+		assert.NoError(t, err, tt.policy)
+
+		networkConfig := profile.Config["user.network-config"]
+		if len(tt.nameservers) == 0 {
+			assert.NotContains(t, networkConfig, "nameserver", tt.policy)
+		}
+
+		for _, server := range tt.nameservers {
+			assert.Contains(t, networkConfig, server, tt.policy)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/automaticserver/lxe/lxf"
+	"github.com/automaticserver/lxe/lxf/lxo"
 	"github.com/automaticserver/lxe/network"
 	"github.com/automaticserver/lxe/shared"
 	"github.com/lxc/lxd/shared/logger"
@@ -21,11 +22,24 @@ const (
 	NetworkPluginCNI     = "cni"
 )
 
+// MountCollision defines what happens to a mount whose container path exists in the image with another type, a
+// directory over a file or a file over a directory.
+// MountCollisionOverlay mounts over it like lxd does, which is also used if it's not set
+// MountCollisionError refuses to create the container
+// MountCollisionSkip creates the container without the mount
+const (
+	MountCollisionOverlay = "overlay"
+	MountCollisionError   = "error"
+	MountCollisionSkip    = "skip"
+)
+
 // Server implements the kubernetes CRI interface specification
 type Server struct {
 	server    *grpc.Server
 	sock      net.Listener
 	criConfig *Config
+	drain     *drainMode
+	runtime   *RuntimeServer
 }
 
 // NewServer creates the CRI server
@@ -36,7 +50,10 @@ func NewServer(criConfig *Config) *Server {
 		os.Exit(shared.ExitCodeUnspecified)
 	}
 
-	client, err := lxf.NewClient(criConfig.LXDSocket, configPath)
+	client, err := lxf.NewClient(criConfig.LXDSocket, configPath, lxo.Timeouts{
+		Operation:     criConfig.LXDOperationTimeout,
+		LongOperation: criConfig.LXDLongOperationTimeout,
+	})
 	if err != nil {
 		logger.Critf("Unable to initialize lxe facade: %v", err)
 		os.Exit(shared.ExitCodeUnspecified)
@@ -64,10 +81,12 @@ func NewServer(criConfig *Config) *Server {
 		})
 	case NetworkPluginDefault:
 		netPlugin, err = network.InitPluginLXDBridge(client.GetServer(), network.ConfLXDBridge{
-			LXDBridge:  criConfig.LXEBridgeName,
-			Cidr:       criConfig.LXEBridgeDHCPRange,
-			Nat:        true,
-			CreateOnly: true,
+			LXDBridge:         criConfig.LXEBridgeName,
+			Cidr:              criConfig.LXEBridgeDHCPRange,
+			Nat:               true,
+			CreateOnly:        true,
+			AllowedNetworks:   criConfig.LXEAllowLXDNetworks,
+			AllowedNicParents: criConfig.LXEAllowNicParents,
 		})
 	default:
 		err = fmt.Errorf("%w: %s", ErrUnknownNetworkPlugin, criConfig.LXENetworkPlugin)
@@ -78,8 +97,6 @@ func NewServer(criConfig *Config) *Server {
 		os.Exit(shared.ExitCodeUnspecified)
 	}
 
-	grpcServer := grpc.NewServer()
-
 	// for now we bind the http on every interface
 	runtimeServer, err := NewRuntimeServer(criConfig, client, netPlugin)
 	if err != nil {
@@ -87,6 +104,8 @@ func NewServer(criConfig *Config) *Server {
 		os.Exit(shared.ExitCodeUnspecified)
 	}
 
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(runtimeServer.cluster.Intercept))
+
 	client.SetEventHandler(runtimeServer)
 
 	imageServer, err := NewImageServer(runtimeServer, client)
@@ -101,6 +120,8 @@ func NewServer(criConfig *Config) *Server {
 	return &Server{
 		server:    grpcServer,
 		criConfig: criConfig,
+		drain:     runtimeServer.drain,
+		runtime:   runtimeServer,
 	}
 }
 
@@ -134,9 +155,24 @@ func (c *Server) Serve() error {
 	return c.server.Serve(c.sock)
 }
 
-// Stop stops the cri socket
+// ToggleDrain switches refusing new pods and containers on or off and returns whether it's on now
+func (c *Server) ToggleDrain() bool {
+	return c.drain.Toggle()
+}
+
+// Reload applies the settings of next which can be changed at runtime, and warns about the ones which need a restart
+func (c *Server) Reload(next *Config) {
+	for _, name := range c.runtime.Reload(next) {
+		logger.Warnf("Config %v can't be changed without restart, keeping the current value", name)
+	}
+
+	logger.Info("Reloaded config")
+}
+
+// Stop stops the cri socket and kills the remaining port forwarding processes
 func (c *Server) Stop() error {
 	c.server.Stop()
+	c.runtime.stream.forwards.KillAll()
 
 	err := c.sock.Close()
 	if err != nil {
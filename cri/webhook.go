@@ -0,0 +1,203 @@
+package cri
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/automaticserver/lxe/lxf"
+	"github.com/lxc/lxd/shared/logger"
+)
+
+// Container lifecycle events posted to the webhook
+const (
+	webhookEventCreated = "created"
+	webhookEventStarted = "started"
+	webhookEventStopped = "stopped"
+	webhookEventDeleted = "deleted"
+)
+
+// Labels kubelet sets on each container to tell the pod it belongs to
+const (
+	labelPodName      = "io.kubernetes.pod.name"
+	labelPodNamespace = "io.kubernetes.pod.namespace"
+	labelPodUID       = "io.kubernetes.pod.uid"
+)
+
+// webhookQueueSize is how many events wait to be posted before further ones are dropped
+const webhookQueueSize = 1024
+
+// webhookTimeout is how long a single post to the webhook may take
+const webhookTimeout = 10 * time.Second
+
+// webhookEvent is the payload posted to the webhook
+type webhookEvent struct {
+	Event     string           `json:"event"`
+	Timestamp time.Time        `json:"timestamp"`
+	Container webhookContainer `json:"container"`
+	Pod       webhookPod       `json:"pod"`
+}
+
+type webhookContainer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Attempt uint32 `json:"attempt"`
+}
+
+type webhookPod struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+}
+
+// webhookNotifier posts container lifecycle events to a webhook. Events are queued and posted one after the other in
+// the background, so a slow webhook doesn't hold up the CRI requests. Delivery is best effort: events are dropped if
+// the queue is full or the webhook still fails after the retries.
+type webhookNotifier struct {
+	url     string
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	events  chan webhookEvent
+
+	// last is the last event posted per container, so stops reported by lxe and lxd are only posted once
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// newWebhookNotifier returns a notifier posting to the url and starts posting, nil if the url is empty
+func newWebhookNotifier(webhookURL string, retries int, backoff time.Duration) (*webhookNotifier, error) {
+	if webhookURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhookURL, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %v isn't an http or https url", ErrInvalidWebhookURL, webhookURL)
+	}
+
+	w := &webhookNotifier{
+		url:     webhookURL,
+		retries: retries,
+		backoff: backoff,
+		client:  &http.Client{Timeout: webhookTimeout},
+		events:  make(chan webhookEvent, webhookQueueSize),
+		last:    map[string]string{},
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// notify queues the event of the container without waiting. It does nothing if no webhook is configured.
+func (w *webhookNotifier) notify(event string, c *lxf.Container) {
+	if w == nil || w.duplicate(event, c.ID) {
+		return
+	}
+
+	e := webhookEvent{
+		Event:     event,
+		Timestamp: time.Now(),
+		Container: webhookContainer{ID: c.ID, Name: c.Metadata.Name, Attempt: c.Metadata.Attempt},
+		Pod: webhookPod{
+			ID:        c.SandboxID(),
+			Name:      c.Labels[labelPodName],
+			Namespace: c.Labels[labelPodNamespace],
+			UID:       c.Labels[labelPodUID],
+		},
+	}
+
+	select {
+	case w.events <- e:
+	default:
+		metricWebhookDropped.Add(1)
+		logger.Warnf("Webhook queue is full, dropping event %v of container %v", event, c.ID)
+	}
+}
+
+// duplicate returns whether the container was already reported as stopped, and else records the event
+func (w *webhookNotifier) duplicate(event, id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if event == webhookEventStopped && w.last[id] == webhookEventStopped {
+		return true
+	}
+
+	if event == webhookEventDeleted {
+		delete(w.last, id)
+	} else {
+		w.last[id] = event
+	}
+
+	return false
+}
+
+// run posts the queued events
+func (w *webhookNotifier) run() {
+	for e := range w.events {
+		err := w.post(e)
+		if err != nil {
+			metricWebhookDropped.Add(1)
+			logger.Errorf("Webhook: giving up on event %v of container %v: %v", e.Event, e.Container.ID, err)
+		}
+	}
+}
+
+// post posts the event until the webhook accepts it or the retries are exhausted, and returns the last error
+func (w *webhookNotifier) post(e webhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(networkRetryDelay(w.backoff, attempt))
+		}
+
+		err = w.postOnce(body)
+		if err == nil {
+			return nil
+		}
+
+		logger.Warnf("Webhook: posting event %v of container %v failed in attempt %d: %v", e.Event, e.Container.ID, attempt+1, err)
+	}
+
+	return err
+}
+
+func (w *webhookNotifier) postOnce(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %v", ErrWebhookRejected, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// testWebhook is a webhook which records the events it accepted and rejects the first ones as told
+type testWebhook struct {
+	mu       sync.Mutex
+	reject   int
+	attempts int
+	events   []webhookEvent
+}
+
+func (h *testWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.attempts++
+
+	if h.reject > 0 {
+		h.reject--
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	e := webhookEvent{}
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.events = append(h.events, e)
+}
+
+func (h *testWebhook) Events() []webhookEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]webhookEvent{}, h.events...)
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	t.Parallel()
+
+	w, err := newWebhookNotifier("", 3, time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, w)
+
+	// does nothing without webhook
+	w.notify(webhookEventCreated, testContainer())
+
+	for _, invalid := range []string{"ftp://example.com/events", "/events", "http://", ":"} {
+		_, err = newWebhookNotifier(invalid, 3, time.Second)
+		assert.True(t, errors.Is(err, ErrInvalidWebhookURL), invalid)
+	}
+}
+
+func TestWebhookNotifier_Retry(t *testing.T) {
+	t.Parallel()
+
+	hook := &testWebhook{reject: 2}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	w, err := newWebhookNotifier(srv.URL, 2, time.Millisecond)
+	assert.NoError(t, err)
+
+	c := testContainer()
+	c.ID = "abc"
+	c.Metadata.Name = "app"
+	c.Metadata.Attempt = 1
+	c.Profiles = []string{"default", "sandbox"}
+	c.Labels = map[string]string{labelPodName: "web", labelPodNamespace: "default", labelPodUID: "123"}
+
+	w.notify(webhookEventStarted, c)
+
+	assert.Eventually(t, func() bool { return len(hook.Events()) == 1 }, 5*time.Second, time.Millisecond)
+
+	e := hook.Events()[0]
+	assert.Equal(t, webhookEventStarted, e.Event)
+	assert.False(t, e.Timestamp.IsZero())
+	assert.Equal(t, webhookContainer{ID: "abc", Name: "app", Attempt: 1}, e.Container)
+	assert.Equal(t, webhookPod{ID: "sandbox", Name: "web", Namespace: "default", UID: "123"}, e.Pod)
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	assert.Equal(t, 3, hook.attempts)
+}
+
+func TestWebhookNotifier_QueueFull(t *testing.T) {
+	// not parallel, as it reads a global metric
+	w := &webhookNotifier{events: make(chan webhookEvent, 1), last: map[string]string{}}
+	dropped := metricWebhookDropped.Value()
+	c := testContainer()
+	c.Profiles = []string{"sandbox"}
+
+	// nobody posts, so the second event doesn't fit and mustn't block
+	w.notify(webhookEventCreated, c)
+	w.notify(webhookEventStarted, c)
+
+	assert.Len(t, w.events, 1)
+	assert.Equal(t, dropped+1, metricWebhookDropped.Value())
+}
+
+func TestRuntimeServer_Webhook(t *testing.T) {
+	t.Parallel()
+
+	hook := &testWebhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	s, _, _ := testLXDRuntimeServer()
+
+	var err error
+	s.webhook, err = newWebhookNotifier(srv.URL, 0, 0)
+	assert.NoError(t, err)
+
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	resp, err := s.CreateContainer(context.Background(), &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+			Labels:   map[string]string{labelPodName: "web", labelPodNamespace: "default", labelPodUID: "abc"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	_, err = s.StopContainer(context.Background(), &rtApi.StopContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	// lxd reports the stop by lxe as well, which mustn't be posted again
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+	assert.NoError(t, s.ContainerStopped(context.Background(), c))
+
+	_, err = s.RemoveContainer(context.Background(), &rtApi.RemoveContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(hook.Events()) == 4 }, 5*time.Second, time.Millisecond)
+
+	events := []string{}
+
+	for _, e := range hook.Events() {
+		events = append(events, e.Event)
+
+		assert.Equal(t, resp.GetContainerId(), e.Container.ID)
+		assert.Equal(t, webhookPod{ID: sbResp.GetPodSandboxId(), Name: "web", Namespace: "default", UID: "abc"}, e.Pod)
+	}
+
+	assert.Equal(t, []string{webhookEventCreated, webhookEventStarted, webhookEventStopped, webhookEventDeleted}, events)
+}
+
+func TestRuntimeServer_WebhookExitAndFailedCreate(t *testing.T) {
+	t.Parallel()
+
+	hook := &testWebhook{}
+	srv := httptest.NewServer(hook)
+	defer srv.Close()
+
+	s, _, netw := testLXDRuntimeServer()
+
+	var err error
+	s.webhook, err = newWebhookNotifier(srv.URL, 0, 0)
+	assert.NoError(t, err)
+
+	sbReq := testRunPodSandboxRequest()
+
+	sbResp, err := s.RunPodSandbox(context.Background(), sbReq)
+	assert.NoError(t, err)
+
+	req := &rtApi.CreateContainerRequest{
+		PodSandboxId: sbResp.GetPodSandboxId(),
+		Config: &rtApi.ContainerConfig{
+			Metadata: &rtApi.ContainerMetadata{Name: "app"},
+			Image:    &rtApi.ImageSpec{Image: "busybox"},
+		},
+		SandboxConfig: sbReq.GetConfig(),
+	}
+
+	// the container which failed to be created is deleted again, but was never reported
+	netw.containerCreateErr = errTestNetwork
+
+	_, err = s.CreateContainer(context.Background(), req)
+	assert.True(t, errors.Is(err, errTestNetwork))
+
+	netw.containerCreateErr = nil
+
+	resp, err := s.CreateContainer(context.Background(), req)
+	assert.NoError(t, err)
+
+	_, err = s.StartContainer(context.Background(), &rtApi.StartContainerRequest{ContainerId: resp.GetContainerId()})
+	assert.NoError(t, err)
+
+	// the container exits on its own, which only lxd reports
+	c, err := s.lxf.GetContainer(resp.GetContainerId())
+	assert.NoError(t, err)
+	assert.NoError(t, s.ContainerStopped(context.Background(), c))
+
+	assert.Eventually(t, func() bool { return len(hook.Events()) == 3 }, 5*time.Second, time.Millisecond)
+
+	events := []string{}
+
+	for _, e := range hook.Events() {
+		events = append(events, e.Event)
+
+		assert.Equal(t, resp.GetContainerId(), e.Container.ID)
+	}
+
+	assert.Equal(t, []string{webhookEventCreated, webhookEventStarted, webhookEventStopped}, events)
+}
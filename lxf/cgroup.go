@@ -0,0 +1,470 @@
+package lxf
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Paths where the proc and cgroup filesystems of the host are mounted
+var (
+	procPath   = "/proc"
+	cgroupPath = "/sys/fs/cgroup"
+)
+
+// cgroupInitScope is the cgroup systemd moves itself into within the container's cgroup on the unified hierarchy
+const cgroupInitScope = "/init.scope"
+
+// readCgroupStats reads the cpu and memory usage of the container with the given init pid directly from its cgroups,
+// for when lxd can't provide them. The cpu usage is in nanoseconds and the memory usage in bytes.
+func readCgroupStats(pid int64) (ContainerStats, error) {
+	stats := ContainerStats{}
+
+	cgroups, err := readProcCgroups(pid)
+	if err != nil {
+		return stats, err
+	}
+
+	// cgroup v2, all controllers are in the same hierarchy
+	if p, is := cgroups[""]; is && len(cgroups) == 1 {
+		p = filepath.Join(cgroupPath, strings.TrimSuffix(p, cgroupInitScope))
+
+		usec, err := readCgroupKey(filepath.Join(p, "cpu.stat"), "usage_usec")
+		if err != nil {
+			return stats, err
+		}
+
+		stats.CPUUsage = usec * 1000
+
+		stats.MemoryUsage, err = readCgroupUint(filepath.Join(p, "memory.current"))
+		if err != nil {
+			return stats, err
+		}
+
+		// only exists if swap is enabled
+		swap, err := readCgroupUint(filepath.Join(p, "memory.swap.current"))
+		if err == nil && hostSwapEnabled() {
+			stats.SwapUsage = &swap
+		}
+
+		return stats, nil
+	}
+
+	cpuacct, is := cgroups["cpuacct"]
+	if !is {
+		return stats, fmt.Errorf("%w: no cpuacct cgroup for pid %v", ErrParse, pid)
+	}
+
+	stats.CPUUsage, err = readCgroupUint(filepath.Join(cgroupPath, "cpuacct", cpuacct, "cpuacct.usage"))
+	if err != nil {
+		return stats, err
+	}
+
+	memory, is := cgroups["memory"]
+	if !is {
+		return stats, fmt.Errorf("%w: no memory cgroup for pid %v", ErrParse, pid)
+	}
+
+	stats.MemoryUsage, err = readCgroupUint(filepath.Join(cgroupPath, "memory", memory, "memory.usage_in_bytes"))
+	if err != nil {
+		return stats, err
+	}
+
+	// only exists with swap accounting, and includes the memory usage
+	memsw, err := readCgroupUint(filepath.Join(cgroupPath, "memory", memory, "memory.memsw.usage_in_bytes"))
+	if err == nil && memsw >= stats.MemoryUsage && hostSwapEnabled() {
+		swap := memsw - stats.MemoryUsage
+		stats.SwapUsage = &swap
+	}
+
+	return stats, nil
+}
+
+// memoryStatKeys are the keys of memory.stat for the breakdown of the memory usage
+type memoryStatKeys struct {
+	rss, cache, inactiveFile, pageFaults, majorPageFaults string
+}
+
+var (
+	// on cgroup v1 the total_ keys include the descendant cgroups of the container
+	memoryStatKeysV1 = memoryStatKeys{
+		rss: "total_rss", cache: "total_cache", inactiveFile: "total_inactive_file",
+		pageFaults: "total_pgfault", majorPageFaults: "total_pgmajfault",
+	}
+	memoryStatKeysV2 = memoryStatKeys{
+		rss: "anon", cache: "file", inactiveFile: "inactive_file",
+		pageFaults: "pgfault", majorPageFaults: "pgmajfault",
+	}
+)
+
+// memoryUnlimited is the smallest value cgroup v1 reports as memory limit if it's not limited, the largest int64
+// rounded down to the page size
+const memoryUnlimited = 1 << 62
+
+// readCgroupMemory reads the memory usage of the container with the given init pid and breaks it down from memory.stat
+func readCgroupMemory(pid int64) (*MemoryUsage, error) {
+	cgroups, err := readProcCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		dir, usageFile, limitFile string
+		keys                      memoryStatKeys
+	)
+
+	if p, is := cgroups[""]; is && len(cgroups) == 1 {
+		dir, keys = filepath.Join(cgroupPath, strings.TrimSuffix(p, cgroupInitScope)), memoryStatKeysV2
+		usageFile, limitFile = "memory.current", "memory.max"
+	} else if p, is := cgroups["memory"]; is {
+		dir, keys = filepath.Join(cgroupPath, "memory", p), memoryStatKeysV1
+		usageFile, limitFile = "memory.usage_in_bytes", "memory.limit_in_bytes"
+	} else {
+		return nil, fmt.Errorf("%w: no memory cgroup for pid %v", ErrParse, pid)
+	}
+
+	usage, err := readCgroupUint(filepath.Join(dir, usageFile))
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := readCgroupKeys(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	mem := &MemoryUsage{
+		Usage:           usage,
+		WorkingSet:      usage,
+		RSS:             stat[keys.rss],
+		Cache:           stat[keys.cache],
+		PageFaults:      stat[keys.pageFaults],
+		MajorPageFaults: stat[keys.majorPageFaults],
+	}
+
+	if inactiveFile := stat[keys.inactiveFile]; inactiveFile != nil {
+		mem.WorkingSet = workingSetBytes(usage, *inactiveFile)
+	}
+
+	// cgroup v2 reports "max" if unlimited, which fails to parse
+	limit, err := readCgroupUint(filepath.Join(dir, limitFile))
+	if err == nil && limit < memoryUnlimited {
+		available := uint64(0)
+		if limit > mem.WorkingSet {
+			available = limit - mem.WorkingSet
+		}
+
+		mem.Available = &available
+	}
+
+	return mem, nil
+}
+
+// workingSetBytes returns the usage without the inactive file cache like cAdvisor, which kubelet uses for evictions.
+// The cache can exceed the usage as it's charged lazily, then it's 0.
+func workingSetBytes(usage, inactiveFile uint64) uint64 {
+	if inactiveFile >= usage {
+		return 0
+	}
+
+	return usage - inactiveFile
+}
+
+// readCgroupLocation returns the cgroup of the container with the given init pid. On cgroup v1, lxc uses the same path
+// for all controllers, so the one of the memory controller is returned.
+func readCgroupLocation(pid int64) (ContainerCgroup, error) {
+	cgroups, err := readProcCgroups(pid)
+	if err != nil {
+		return ContainerCgroup{}, err
+	}
+
+	if p, is := cgroups[""]; is && len(cgroups) == 1 {
+		return ContainerCgroup{Path: strings.TrimSuffix(p, cgroupInitScope), Version: 2}, nil
+	}
+
+	for _, controller := range []string{"memory", "cpuacct"} {
+		if p, is := cgroups[controller]; is {
+			return ContainerCgroup{Path: p, Version: 1}, nil
+		}
+	}
+
+	return ContainerCgroup{}, fmt.Errorf("%w: no memory cgroup for pid %v", ErrParse, pid)
+}
+
+// hugetlbUnlimited is the smallest value cgroup v1 reports as hugetlb limit if it's not limited, which is the largest
+// int64 rounded down to the page size
+const hugetlbUnlimited = 1 << 62
+
+// readCgroupHugepages reads the hugepages usage and limit of the container with the given init pid for each page size.
+// Page sizes without usage and limit are omitted, and nil is returned if there are none or the hugetlb controller isn't
+// available.
+func readCgroupHugepages(pid int64) (map[string]HugepagesUsage, error) {
+	cgroups, err := readProcCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir, usageSuffix, limitSuffix string
+
+	if p, is := cgroups[""]; is && len(cgroups) == 1 {
+		dir, usageSuffix, limitSuffix = filepath.Join(cgroupPath, strings.TrimSuffix(p, cgroupInitScope)), ".current", ".max"
+	} else if p, is := cgroups["hugetlb"]; is {
+		dir, usageSuffix, limitSuffix = filepath.Join(cgroupPath, "hugetlb", p), ".usage_in_bytes", ".limit_in_bytes"
+	} else {
+		return nil, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "hugetlb.*"+usageSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	hugepages := make(map[string]HugepagesUsage)
+
+	for _, file := range files {
+		size := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "hugetlb."), usageSuffix)
+		// skip the reservation accounting like hugetlb.2MB.rsvd.current
+		if strings.Contains(size, ".") {
+			continue
+		}
+
+		usage, err := readCgroupUint(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// cgroup v2 reports "max" if unlimited, which is treated like a missing limit
+		limit, err := readCgroupUint(filepath.Join(dir, "hugetlb."+size+limitSuffix))
+		if err != nil || limit >= hugetlbUnlimited {
+			limit = 0
+		}
+
+		if usage == 0 && limit == 0 {
+			continue
+		}
+
+		hugepages[size] = HugepagesUsage{Usage: usage, Limit: limit}
+	}
+
+	if len(hugepages) == 0 {
+		return nil, nil
+	}
+
+	return hugepages, nil
+}
+
+// readCgroupDiskIO reads the disk io of the container with the given init pid for each block device. Devices without io
+// are omitted, and nil is returned if there are none or the io controller isn't available.
+func readCgroupDiskIO(pid int64) (map[string]DiskIOUsage, error) {
+	cgroups, err := readProcCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	diskIO := make(map[string]DiskIOUsage)
+
+	if p, is := cgroups[""]; is && len(cgroups) == 1 {
+		err = readIOStat(filepath.Join(cgroupPath, strings.TrimSuffix(p, cgroupInitScope), "io.stat"), diskIO)
+	} else if p, is := cgroups["blkio"]; is {
+		dir := filepath.Join(cgroupPath, "blkio", p)
+
+		err = readBlkioStat(filepath.Join(dir, "blkio.throttle.io_service_bytes"), diskIO, false)
+		if err == nil {
+			err = readBlkioStat(filepath.Join(dir, "blkio.throttle.io_serviced"), diskIO, true)
+		}
+	} else {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for device, usage := range diskIO {
+		if usage == (DiskIOUsage{}) {
+			delete(diskIO, device)
+		}
+	}
+
+	if len(diskIO) == 0 {
+		return nil, nil
+	}
+
+	return diskIO, nil
+}
+
+// readIOStat reads the cgroup v2 io.stat with lines of "major:minor key=value ..." into diskIO
+func readIOStat(file string, diskIO map[string]DiskIOUsage) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		usage := diskIO[fields[0]]
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %v in %v: %v", ErrParse, field, file, err)
+			}
+
+			switch kv[0] {
+			case "rbytes":
+				usage.ReadBytes = value
+			case "wbytes":
+				usage.WriteBytes = value
+			case "rios":
+				usage.ReadOps = value
+			case "wios":
+				usage.WriteOps = value
+			}
+		}
+
+		diskIO[fields[0]] = usage
+	}
+
+	return nil
+}
+
+// readBlkioStat reads a cgroup v1 blkio file with lines of "major:minor operation value" into diskIO, either as
+// bytes or as number of operations
+func readBlkioStat(file string, diskIO map[string]DiskIOUsage, ops bool) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// the last line is "Total value"
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %v in %v: %v", ErrParse, line, file, err)
+		}
+
+		usage := diskIO[fields[0]]
+
+		switch {
+		case fields[1] == "Read" && ops:
+			usage.ReadOps = value
+		case fields[1] == "Read":
+			usage.ReadBytes = value
+		case fields[1] == "Write" && ops:
+			usage.WriteOps = value
+		case fields[1] == "Write":
+			usage.WriteBytes = value
+		}
+
+		diskIO[fields[0]] = usage
+	}
+
+	return nil
+}
+
+// hostSwapEnabled returns whether the host has any swap space
+func hostSwapEnabled() bool {
+	total, err := readCgroupKey(filepath.Join(procPath, "meminfo"), "SwapTotal:")
+	if err != nil {
+		return false
+	}
+
+	return total > 0
+}
+
+// readProcCgroups returns the cgroup path of the pid for each controller. The unified hierarchy has the controller ""
+func readProcCgroups(pid int64) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(procPath, strconv.FormatInt(pid, 10), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cgroups := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// format is hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		for _, controller := range strings.Split(fields[1], ",") {
+			cgroups[controller] = fields[2]
+		}
+	}
+
+	return cgroups, scanner.Err()
+}
+
+// readCgroupUint reads a cgroup file containing a single unsigned number
+func readCgroupUint(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// readCgroupKeys reads all values of a cgroup file with lines of "key value", like memory.stat
+func readCgroupKeys(file string) (map[string]*uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]*uint64)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v in %v: %v", ErrParse, line, file, err)
+		}
+
+		values[fields[0]] = &value
+	}
+
+	return values, nil
+}
+
+// readCgroupKey reads the value of the key from a cgroup or proc file with lines of "key value [unit]"
+func readCgroupKey(file, key string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("%w: key %v not found in %v", ErrParse, key, file)
+}
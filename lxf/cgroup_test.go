@@ -0,0 +1,254 @@
+package lxf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHostFS creates the given files below a temporary root and points the proc and cgroup paths to it
+func fakeHostFS(t *testing.T, files map[string]string) func() {
+	root, err := ioutil.TempDir("", "lxf-cgroup")
+	assert.NoError(t, err)
+
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+
+	oldProc, oldCgroup := procPath, cgroupPath
+	procPath, cgroupPath = filepath.Join(root, "proc"), filepath.Join(root, "cgroup")
+
+	return func() {
+		procPath, cgroupPath = oldProc, oldCgroup
+		os.RemoveAll(root)
+	}
+}
+
+func TestReadCgroupStats_V1(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "12:memory:/lxc.payload/foo\n4:cpu,cpuacct:/lxc.payload/foo\n1:name=systemd:/lxc.payload/foo/init.scope\n0::/lxc.payload/foo\n",
+		"cgroup/cpuacct/lxc.payload/foo/cpuacct.usage":              "123456789\n",
+		"cgroup/memory/lxc.payload/foo/memory.usage_in_bytes":       "1048576\n",
+		"cgroup/memory/lxc.payload/foo/memory.memsw.usage_in_bytes": "1572864\n",
+		"proc/meminfo": "MemTotal:       16318000 kB\nSwapTotal:       2097148 kB\n",
+	})()
+
+	stats, err := readCgroupStats(42)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(123456789), stats.CPUUsage)
+	assert.Equal(t, uint64(1048576), stats.MemoryUsage)
+	assert.Equal(t, uint64(524288), *stats.SwapUsage)
+}
+
+func TestReadCgroupStats_V2(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                        "0::/lxc.payload.foo/init.scope\n",
+		"cgroup/lxc.payload.foo/cpu.stat":       "usage_usec 2000\nuser_usec 1500\nsystem_usec 500\n",
+		"cgroup/lxc.payload.foo/memory.current": "2097152\n",
+	})()
+
+	stats, err := readCgroupStats(42)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2000000), stats.CPUUsage)
+	assert.Equal(t, uint64(2097152), stats.MemoryUsage)
+	assert.Nil(t, stats.SwapUsage)
+}
+
+func TestReadCgroupStats_V2_SwapDisabled(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                             "0::/lxc.payload.foo\n",
+		"proc/meminfo":                               "MemTotal:       16318000 kB\nSwapTotal:             0 kB\n",
+		"cgroup/lxc.payload.foo/cpu.stat":            "usage_usec 2000\n",
+		"cgroup/lxc.payload.foo/memory.current":      "2097152\n",
+		"cgroup/lxc.payload.foo/memory.swap.current": "0\n",
+	})()
+
+	stats, err := readCgroupStats(42)
+	assert.NoError(t, err)
+	assert.Nil(t, stats.SwapUsage)
+}
+
+func TestReadCgroupStats_NoProcess(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{})()
+
+	_, err := readCgroupStats(42)
+	assert.Error(t, err)
+}
+
+func TestWorkingSetBytes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		usage, inactiveFile, want uint64
+	}{
+		{usage: 1000, inactiveFile: 0, want: 1000},
+		{usage: 1000, inactiveFile: 300, want: 700},
+		{usage: 1000, inactiveFile: 1000, want: 0},
+		// the cache is charged lazily and can exceed the usage
+		{usage: 1000, inactiveFile: 1200, want: 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, workingSetBytes(tt.usage, tt.inactiveFile), "usage %v, inactive file %v", tt.usage, tt.inactiveFile)
+	}
+}
+
+func TestReadCgroupMemory_V1(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "12:memory:/lxc.payload/foo\n4:cpu,cpuacct:/lxc.payload/foo\n",
+		"cgroup/memory/lxc.payload/foo/memory.usage_in_bytes": "1048576\n",
+		"cgroup/memory/lxc.payload/foo/memory.limit_in_bytes": "9223372036854771712\n",
+		"cgroup/memory/lxc.payload/foo/memory.stat":           "cache 1\nrss 2\ntotal_cache 524288\ntotal_rss 262144\ntotal_inactive_file 131072\ntotal_pgfault 100\ntotal_pgmajfault 3\n",
+	})()
+
+	mem, err := readCgroupMemory(42)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1048576), mem.Usage)
+	assert.Equal(t, uint64(917504), mem.WorkingSet)
+	assert.Equal(t, uint64(262144), *mem.RSS)
+	assert.Equal(t, uint64(524288), *mem.Cache)
+	assert.Equal(t, uint64(100), *mem.PageFaults)
+	assert.Equal(t, uint64(3), *mem.MajorPageFaults)
+	assert.Nil(t, mem.Available)
+}
+
+func TestReadCgroupMemory_V2(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                        "0::/lxc.payload.foo/init.scope\n",
+		"cgroup/lxc.payload.foo/memory.current": "2097152\n",
+		"cgroup/lxc.payload.foo/memory.max":     "4194304\n",
+		"cgroup/lxc.payload.foo/memory.stat":    "anon 1048576\nfile 1048576\ninactive_file 524288\n",
+		"proc/43/cgroup":                        "0::/lxc.payload.bar\n",
+		"cgroup/lxc.payload.bar/memory.current": "2097152\n",
+		"cgroup/lxc.payload.bar/memory.max":     "max\n",
+		"cgroup/lxc.payload.bar/memory.stat":    "anon 1048576\n",
+	})()
+
+	mem, err := readCgroupMemory(42)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1572864), mem.WorkingSet)
+	assert.Equal(t, uint64(1048576), *mem.RSS)
+	assert.Equal(t, uint64(1048576), *mem.Cache)
+	assert.Equal(t, uint64(2621440), *mem.Available)
+	// missing from memory.stat
+	assert.Nil(t, mem.PageFaults)
+
+	// without inactive file cache and limit
+	mem, err = readCgroupMemory(43)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2097152), mem.WorkingSet)
+	assert.Nil(t, mem.Cache)
+	assert.Nil(t, mem.Available)
+}
+
+func TestReadCgroupHugepages_V1(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "9:hugetlb:/lxc.payload/foo\n12:memory:/lxc.payload/foo\n",
+		"cgroup/hugetlb/lxc.payload/foo/hugetlb.2MB.usage_in_bytes": "4194304\n",
+		"cgroup/hugetlb/lxc.payload/foo/hugetlb.2MB.limit_in_bytes": "9223372036852678656\n",
+		"cgroup/hugetlb/lxc.payload/foo/hugetlb.1GB.usage_in_bytes": "0\n",
+		"cgroup/hugetlb/lxc.payload/foo/hugetlb.1GB.limit_in_bytes": "9223372035781033984\n",
+	})()
+
+	hugepages, err := readCgroupHugepages(42)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]HugepagesUsage{"2MB": {Usage: 4194304}}, hugepages)
+}
+
+func TestReadCgroupHugepages_V2(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "0::/lxc.payload.foo/init.scope\n",
+		"cgroup/lxc.payload.foo/hugetlb.2MB.current":      "2097152\n",
+		"cgroup/lxc.payload.foo/hugetlb.2MB.max":          "8388608\n",
+		"cgroup/lxc.payload.foo/hugetlb.2MB.rsvd.current": "2097152\n",
+		"cgroup/lxc.payload.foo/hugetlb.1GB.current":      "0\n",
+		"cgroup/lxc.payload.foo/hugetlb.1GB.max":          "max\n",
+	})()
+
+	hugepages, err := readCgroupHugepages(42)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]HugepagesUsage{"2MB": {Usage: 2097152, Limit: 8388608}}, hugepages)
+}
+
+func TestReadCgroupHugepages_NotUsed(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "0::/lxc.payload.foo\n",
+		"cgroup/lxc.payload.foo/hugetlb.2MB.current": "0\n",
+		"cgroup/lxc.payload.foo/hugetlb.2MB.max":     "max\n",
+		"proc/43/cgroup":                             "12:memory:/lxc.payload/foo\n",
+	})()
+
+	hugepages, err := readCgroupHugepages(42)
+	assert.NoError(t, err)
+	assert.Nil(t, hugepages)
+
+	// no hugetlb controller
+	hugepages, err = readCgroupHugepages(43)
+	assert.NoError(t, err)
+	assert.Nil(t, hugepages)
+}
+
+func TestReadCgroupDiskIO_V1(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "7:blkio:/lxc.payload/foo\n12:memory:/lxc.payload/foo\n",
+		"cgroup/blkio/lxc.payload/foo/blkio.throttle.io_service_bytes": "8:0 Read 4096\n8:0 Write 8192\n8:0 Sync 0\n8:0 Async 12288\n8:0 Total 12288\n8:16 Read 0\n8:16 Write 0\nTotal 12288\n",
+		"cgroup/blkio/lxc.payload/foo/blkio.throttle.io_serviced":      "8:0 Read 1\n8:0 Write 2\n8:0 Total 3\n8:16 Read 0\n8:16 Write 0\nTotal 3\n",
+	})()
+
+	diskIO, err := readCgroupDiskIO(42)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DiskIOUsage{"8:0": {ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2}}, diskIO)
+}
+
+func TestReadCgroupDiskIO_V2(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                 "0::/lxc.payload.foo/init.scope\n",
+		"cgroup/lxc.payload.foo/io.stat": "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n",
+	})()
+
+	diskIO, err := readCgroupDiskIO(42)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DiskIOUsage{"8:0": {ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2}}, diskIO)
+}
+
+func TestReadCgroupDiskIO_NoIO(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                 "0::/lxc.payload.foo\n",
+		"cgroup/lxc.payload.foo/io.stat": "",
+		"proc/43/cgroup":                 "12:memory:/lxc.payload/foo\n",
+	})()
+
+	diskIO, err := readCgroupDiskIO(42)
+	assert.NoError(t, err)
+	assert.Nil(t, diskIO)
+
+	// no blkio controller
+	diskIO, err = readCgroupDiskIO(43)
+	assert.NoError(t, err)
+	assert.Nil(t, diskIO)
+}
+
+func TestReadCgroupLocation(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup": "0::/lxc.payload.foo/init.scope\n",
+		"proc/43/cgroup": "12:memory:/lxc.payload/foo\n4:cpu,cpuacct:/lxc.payload/foo\n1:name=systemd:/lxc.payload/foo/init.scope\n0::/lxc.payload/foo\n",
+		"proc/44/cgroup": "1:name=systemd:/lxc.payload/foo\n",
+	})()
+
+	cg, err := readCgroupLocation(42)
+	assert.NoError(t, err)
+	assert.Equal(t, ContainerCgroup{Path: "/lxc.payload.foo", Version: 2}, cg)
+
+	// hybrid hierarchy
+	cg, err = readCgroupLocation(43)
+	assert.NoError(t, err)
+	assert.Equal(t, ContainerCgroup{Path: "/lxc.payload/foo", Version: 1}, cg)
+
+	_, err = readCgroupLocation(44)
+	assert.Error(t, err)
+}
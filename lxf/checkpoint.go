@@ -0,0 +1,388 @@
+package lxf
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/automaticserver/lxe/shared"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/lxc/lxd/shared/logger"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrCRIUUnavailable    = errors.New("criu is not available")
+	ErrCheckpointMismatch = errors.New("checkpoint doesn't match host")
+	ErrCheckpointConflict = errors.New("checkpointed container still exists")
+	ErrNoCheckpoint       = errors.New("no checkpoint found")
+)
+
+const (
+	// checkpointPrefix is the name prefix of stateful snapshots created as checkpoint
+	checkpointPrefix = "checkpoint-"
+	// cfgCheckpointKernelVersion records the kernel version of the host the checkpoint was created on
+	cfgCheckpointKernelVersion = "user.checkpoint.kernel_version"
+	// cfgRestoreCheckpoint holds the stateful snapshot the container is restored from instead of being started
+	cfgRestoreCheckpoint = "user.checkpoint.restore"
+	// paths of the metadata files within a lxd backup archive
+	backupIndexFile     = "backup/index.yaml"
+	backupContainerFile = "backup/container/backup.yaml"
+)
+
+// CheckpointInfo describes a checkpoint archive exported by Checkpoint
+type CheckpointInfo struct {
+	// Name of the checkpointed container
+	Name string
+	// Architecture of the checkpointed container
+	Architecture string
+	// KernelVersion of the host the checkpoint was created on
+	KernelVersion string
+	// Snapshots of the container in the archive
+	Snapshots []string
+	// Config of the latest checkpoint, which the container is started with when restored
+	Config map[string]string
+	// Devices of the latest checkpoint, which the container is started with when restored
+	Devices map[string]map[string]string
+}
+
+// LatestCheckpoint returns the name of the most recent checkpoint snapshot. Empty string if there is none.
+func (i *CheckpointInfo) LatestCheckpoint() string {
+	checkpoints := []string{}
+
+	for _, s := range i.Snapshots {
+		if strings.HasPrefix(s, checkpointPrefix) {
+			checkpoints = append(checkpoints, s)
+		}
+	}
+
+	if len(checkpoints) == 0 {
+		return ""
+	}
+
+	sort.Strings(checkpoints)
+
+	return checkpoints[len(checkpoints)-1]
+}
+
+// Checkpoint creates a stateful snapshot of the running container using criu, which is kept with the container. If a
+// location is provided, the container including the snapshot is exported as backup archive to that path. Returns the
+// name of the snapshot.
+func (c *Container) Checkpoint(location string) (string, error) {
+	name := checkpointPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	err := c.client.opwait.CreateContainerSnapshot(c.ID, api.ContainerSnapshotsPost{
+		Name:     name,
+		Stateful: true,
+	})
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return "", fmt.Errorf("container %w: %s", shared.NewErrNotFound(), c.ID)
+		}
+
+		if strings.Contains(strings.ToLower(err.Error()), "criu") {
+			return "", fmt.Errorf("%w: %v", ErrCRIUUnavailable, err)
+		}
+
+		return "", err
+	}
+
+	if location == "" {
+		return name, nil
+	}
+
+	// only archives can be restored elsewhere, which needs the kernel version to verify the host
+	err = c.recordKernelVersion()
+	if err != nil {
+		return "", err
+	}
+
+	return name, c.exportBackup(name, location)
+}
+
+// recordKernelVersion saves the kernel version of the host in the container config, so a restore can verify it
+func (c *Container) recordKernelVersion() error {
+	server, _, err := c.client.server.GetServer()
+	if err != nil {
+		return err
+	}
+
+	ct, etag, err := c.client.server.GetContainer(c.ID)
+	if err != nil {
+		return err
+	}
+
+	put := ct.Writable()
+	if put.Config == nil {
+		put.Config = make(map[string]string)
+	}
+
+	put.Config[cfgCheckpointKernelVersion] = server.Environment.KernelVersion
+
+	return c.client.opwait.UpdateContainer(c.ID, put, etag)
+}
+
+// exportBackup writes a temporary backup of the container to the location
+func (c *Container) exportBackup(name, location string) error {
+	err := c.client.opwait.CreateContainerBackup(c.ID, api.ContainerBackupsPost{
+		Name:       name,
+		ExpiryDate: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := c.client.opwait.DeleteContainerBackup(c.ID, name)
+		if err != nil {
+			logger.Errorf("unable to delete backup %v of container %v: %v", name, c.ID, err)
+		}
+	}()
+
+	f, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = c.client.server.GetContainerBackupFile(c.ID, name, &lxd.BackupFileRequest{BackupFile: f})
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// importCheckpoint creates the container from the checkpoint archive in RestoreFrom, after verifying it matches the
+// host. LXD imports it under the name it had, so it's renamed to a new id, and the update following the import assigns
+// the profiles of the new sandbox. The LXD client can't import it under another name, so it's refused while a container
+// of that name exists. The container is restored from the latest checkpoint when it gets started.
+func (c *Container) importCheckpoint() error {
+	info, err := ReadCheckpointInfo(c.RestoreFrom)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := info.LatestCheckpoint()
+	if checkpoint == "" {
+		return fmt.Errorf("%w: in %v", ErrNoCheckpoint, c.RestoreFrom)
+	}
+
+	server, _, err := c.client.server.GetServer()
+	if err != nil {
+		return err
+	}
+
+	err = verifyCheckpoint(info, &server.Environment)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.client.server.GetContainer(info.Name)
+	if err == nil {
+		return fmt.Errorf("%w: %v", ErrCheckpointConflict, info.Name)
+	}
+
+	if !shared.IsErrNotFound(err) {
+		return err
+	}
+
+	f, err := os.Open(c.RestoreFrom)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	op, err := c.client.server.CreateContainerFromBackup(lxd.ContainerBackupArgs{BackupFile: f})
+	if err != nil {
+		return err
+	}
+
+	err = op.Wait()
+	if err != nil {
+		return err
+	}
+
+	id := c.CreateID()
+
+	err = c.client.opwait.RenameContainer(info.Name, api.ContainerPost{Name: id})
+	if err != nil {
+		c.deleteImported(info.Name)
+		return err
+	}
+
+	_, etag, err := c.client.server.GetContainer(id)
+	if err != nil {
+		return err
+	}
+
+	c.ID = id
+	c.ETag = etag
+	c.RestoreCheckpoint = checkpoint
+	c.Config[cfgState] = ContainerStateCreating.String()
+	c.CreatedAt = time.Now()
+
+	return nil
+}
+
+// deleteImported removes an imported container which couldn't be taken over, so it doesn't block further restores
+func (c *Container) deleteImported(name string) {
+	err := c.client.opwait.DeleteContainer(name)
+	if err != nil {
+		logger.Errorf("unable to delete imported container %v: %v", name, err)
+	}
+}
+
+// restoreCheckpoint starts the container by restoring its checkpoint statefully. The restore also reverts the config,
+// so the current config is applied again afterwards.
+func (c *Container) restoreCheckpoint() error {
+	err := c.client.opwait.UpdateContainer(c.ID, api.ContainerPut{
+		Restore:  c.RestoreCheckpoint,
+		Stateful: true,
+	}, "")
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "criu") {
+			return fmt.Errorf("%w: %v", ErrCRIUUnavailable, err)
+		}
+
+		return err
+	}
+
+	_, etag, err := c.client.server.GetContainer(c.ID)
+	if err != nil {
+		return err
+	}
+
+	c.ETag = etag
+	c.RestoreCheckpoint = ""
+
+	return nil
+}
+
+// verifyCheckpoint checks the checkpoint can be restored on the host, as criu requires the same architecture and
+// kernel
+func verifyCheckpoint(info *CheckpointInfo, env *api.ServerEnvironment) error {
+	supported := false
+
+	for _, arch := range env.Architectures {
+		if arch == info.Architecture {
+			supported = true
+			break
+		}
+	}
+
+	if !supported {
+		return fmt.Errorf("%w: architecture %v not in %v", ErrCheckpointMismatch, info.Architecture, env.Architectures)
+	}
+
+	if info.KernelVersion != env.KernelVersion {
+		return fmt.Errorf("%w: kernel %v differs from %v", ErrCheckpointMismatch, info.KernelVersion, env.KernelVersion)
+	}
+
+	return nil
+}
+
+// ReadCheckpointInfo reads the metadata of a checkpoint archive, which is a lxd backup either uncompressed or gzipped
+func ReadCheckpointInfo(path string) (*CheckpointInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	var r io.Reader = br
+
+	magic, err := br.Peek(2) // nolint: gomnd
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %v %w: %v", path, ErrParse, err)
+	}
+
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %v %w: %v", path, ErrParse, err)
+		}
+	}
+
+	return readCheckpointInfo(tar.NewReader(r))
+}
+
+func readCheckpointInfo(tr *tar.Reader) (*CheckpointInfo, error) {
+	var (
+		index struct {
+			Name      string   `yaml:"name"`
+			Snapshots []string `yaml:"snapshots"`
+		}
+		backup struct {
+			Container api.Container           `yaml:"container"`
+			Snapshots []api.ContainerSnapshot `yaml:"snapshots"`
+		}
+		foundIndex, foundBackup bool
+	)
+
+	for !foundIndex || !foundBackup {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("checkpoint %w: missing %v or %v", ErrParse, backupIndexFile, backupContainerFile)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %w: %v", ErrParse, err)
+		}
+
+		var target interface{}
+
+		switch strings.TrimPrefix(hdr.Name, "./") {
+		case backupIndexFile:
+			target, foundIndex = &index, true
+		case backupContainerFile:
+			target, foundBackup = &backup, true
+		default:
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.Unmarshal(data, target)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %v %w: %v", hdr.Name, ErrParse, err)
+		}
+	}
+
+	info := &CheckpointInfo{
+		Name:          index.Name,
+		Architecture:  backup.Container.Architecture,
+		KernelVersion: backup.Container.Config[cfgCheckpointKernelVersion],
+		Snapshots:     index.Snapshots,
+		Config:        backup.Container.Config,
+		Devices:       backup.Container.Devices,
+	}
+
+	// the restore reverts to the config and devices of the snapshot, which may be named with the container as prefix
+	checkpoint := info.LatestCheckpoint()
+
+	for _, s := range backup.Snapshots {
+		if checkpoint != "" && s.Name[strings.LastIndex(s.Name, "/")+1:] == checkpoint {
+			info.Config = s.Config
+			info.Devices = s.Devices
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,235 @@
+package lxf
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	"github.com/automaticserver/lxe/shared"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCheckpointContainer() (*Container, *lxdfakes.FakeContainerServer, *lxdfakes.FakeOperation) {
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.GetServerReturns(&api.Server{ServerUntrusted: api.ServerUntrusted{}, Environment: api.ServerEnvironment{KernelVersion: "5.4.0"}}, "", nil)
+	fake.GetContainerReturns(&api.Container{}, "etag", nil)
+	fake.UpdateContainerReturns(fakeOp, nil)
+	fake.CreateContainerSnapshotReturns(fakeOp, nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	return c, fake, fakeOp
+}
+
+func TestContainer_Checkpoint_Export(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-checkpoint")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	c, fake, fakeOp := testCheckpointContainer()
+	fake.CreateContainerBackupReturns(fakeOp, nil)
+	fake.DeleteContainerBackupReturns(fakeOp, nil)
+
+	name, err := c.Checkpoint(filepath.Join(dir, "checkpoint.tar.gz"))
+	assert.NoError(t, err)
+
+	// the archive records the kernel version to verify the host it's restored on
+	_, put, _ := fake.UpdateContainerArgsForCall(0)
+	assert.Equal(t, "5.4.0", put.Config[cfgCheckpointKernelVersion])
+
+	id, backup := fake.CreateContainerBackupArgsForCall(0)
+	assert.Equal(t, "foo", id)
+	assert.Equal(t, name, backup.Name)
+	assert.Equal(t, 1, fake.DeleteContainerBackupCallCount())
+}
+
+func TestContainer_importCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-checkpoint")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	c, fake, fakeOp := testCheckpointContainer()
+	fake.GetServerReturns(&api.Server{Environment: api.ServerEnvironment{Architectures: []string{"x86_64"}, KernelVersion: "5.4.0"}}, "", nil)
+	fake.CreateContainerFromBackupReturns(fakeOp, nil)
+	fake.RenameContainerReturns(fakeOp, nil)
+	fake.GetContainerReturnsOnCall(0, nil, "", shared.NewErrNotFound())
+
+	c.ID = ""
+	c.Metadata.Name = "app"
+	c.Config = map[string]string{}
+	c.RestoreFrom = writeTestCheckpoint(t, dir)
+
+	assert.NoError(t, c.importCheckpoint())
+
+	// the container gets a new id instead of the one it was checkpointed with
+	from, post := fake.RenameContainerArgsForCall(0)
+	assert.Equal(t, "foo", from)
+	assert.Equal(t, c.ID, post.Name)
+	assert.NotEqual(t, "foo", c.ID)
+	// the name of the checkpointed container is looked up first
+	assert.Equal(t, "foo", fake.GetContainerArgsForCall(0))
+	assert.Equal(t, c.ID, fake.GetContainerArgsForCall(1))
+	assert.Equal(t, "checkpoint-1", c.RestoreCheckpoint)
+	assert.Equal(t, ContainerStateCreating.String(), c.Config[cfgState])
+}
+
+func TestContainer_importCheckpoint_RenameFails(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-checkpoint")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	c, fake, fakeOp := testCheckpointContainer()
+	fake.GetServerReturns(&api.Server{Environment: api.ServerEnvironment{Architectures: []string{"x86_64"}, KernelVersion: "5.4.0"}}, "", nil)
+	fake.CreateContainerFromBackupReturns(fakeOp, nil)
+	fake.RenameContainerReturns(nil, errors.New("name in use"))
+	fake.DeleteContainerReturns(fakeOp, nil)
+	fake.GetContainerReturnsOnCall(0, nil, "", shared.NewErrNotFound())
+
+	c.ID = ""
+	c.Metadata.Name = "app"
+	c.Config = map[string]string{}
+	c.RestoreFrom = writeTestCheckpoint(t, dir)
+
+	assert.Error(t, c.importCheckpoint())
+
+	// the imported container is removed again, so it doesn't block another restore
+	assert.Equal(t, "foo", fake.DeleteContainerArgsForCall(0))
+	assert.Equal(t, "", c.ID)
+}
+
+func TestContainer_importCheckpoint_Conflict(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-checkpoint")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	c, fake, fakeOp := testCheckpointContainer()
+	fake.GetServerReturns(&api.Server{Environment: api.ServerEnvironment{Architectures: []string{"x86_64"}, KernelVersion: "5.4.0"}}, "", nil)
+	fake.CreateContainerFromBackupReturns(fakeOp, nil)
+
+	c.ID = ""
+	c.Metadata.Name = "app"
+	c.Config = map[string]string{}
+	c.RestoreFrom = writeTestCheckpoint(t, dir)
+
+	// the checkpointed container still exists, e.g. on the host it was checkpointed on
+	err = c.importCheckpoint()
+	assert.True(t, errors.Is(err, ErrCheckpointConflict))
+	assert.Equal(t, "foo", fake.GetContainerArgsForCall(0))
+	assert.Equal(t, 0, fake.CreateContainerFromBackupCallCount())
+	assert.Equal(t, 0, fake.DeleteContainerCallCount())
+
+	fake.GetContainerReturns(nil, "", errors.New("connection refused"))
+
+	assert.Error(t, c.importCheckpoint())
+	assert.Equal(t, 0, fake.CreateContainerFromBackupCallCount())
+}
+
+func TestCheckpointInfo_LatestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	info := &CheckpointInfo{Snapshots: []string{"checkpoint-2", "snap0", "checkpoint-3", "checkpoint-1"}}
+	assert.Equal(t, "checkpoint-3", info.LatestCheckpoint())
+
+	info = &CheckpointInfo{Snapshots: []string{"snap0"}}
+	assert.Equal(t, "", info.LatestCheckpoint())
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	env := &api.ServerEnvironment{Architectures: []string{"x86_64", "i686"}, KernelVersion: "5.4.0"}
+
+	assert.NoError(t, verifyCheckpoint(&CheckpointInfo{Architecture: "x86_64", KernelVersion: "5.4.0"}, env))
+	assert.True(t, errors.Is(verifyCheckpoint(&CheckpointInfo{Architecture: "aarch64", KernelVersion: "5.4.0"}, env), ErrCheckpointMismatch))
+	assert.True(t, errors.Is(verifyCheckpoint(&CheckpointInfo{Architecture: "x86_64", KernelVersion: "4.15.0"}, env), ErrCheckpointMismatch))
+}
+
+// testCheckpointBackup is the backup.yaml of the test checkpoint, whose checkpoint differs from the container
+const testCheckpointBackup = `container:
+  architecture: x86_64
+  config:
+    user.checkpoint.kernel_version: 5.4.0
+    security.privileged: "false"
+snapshots:
+- name: snap0
+  config:
+    security.privileged: "true"
+- name: checkpoint-1
+  config:
+    security.privileged: "true"
+    user.checkpoint.kernel_version: 5.4.0
+  devices:
+    data:
+      type: disk
+      path: /data
+      source: /srv/data
+`
+
+// writeTestCheckpoint writes a gzipped checkpoint archive of the container foo into dir and returns its path
+func writeTestCheckpoint(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "checkpoint.tar.gz")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range map[string]string{
+		"backup/index.yaml":            "name: foo\nbackend: dir\nsnapshots:\n- checkpoint-1\n",
+		"backup/container/backup.yaml": testCheckpointBackup,
+		"backup/container/rootfs/foo":  "bar",
+	} {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}))
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, f.Close())
+
+	return path
+}
+
+func TestReadCheckpointInfo(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "lxe-checkpoint")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	info, err := ReadCheckpointInfo(writeTestCheckpoint(t, dir))
+	assert.NoError(t, err)
+	assert.Equal(t, &CheckpointInfo{
+		Name:          "foo",
+		Architecture:  "x86_64",
+		KernelVersion: "5.4.0",
+		Snapshots:     []string{"checkpoint-1"},
+		// of the checkpoint, not of the container
+		Config:  map[string]string{"security.privileged": "true", "user.checkpoint.kernel_version": "5.4.0"},
+		Devices: map[string]map[string]string{"data": {"type": "disk", "path": "/data", "source": "/srv/data"}},
+	}, info)
+}
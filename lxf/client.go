@@ -17,10 +17,15 @@ import (
 )
 
 var (
-	ErrMissingETag = errors.New("missing ETag")
-	ErrConvert     = errors.New("convert error")
-	ErrParse       = errors.New("parse error")
-	ErrUsage       = errors.New("usage error")
+	ErrMissingETag   = errors.New("missing ETag")
+	ErrConvert       = errors.New("convert error")
+	ErrParse         = errors.New("parse error")
+	ErrUsage         = errors.New("usage error")
+	ErrHookFailed    = errors.New("hook failed")
+	ErrCannotRun     = errors.New("container cannot run")
+	ErrNotRunning    = errors.New("container not running")
+	ErrArchitecture  = errors.New("architecture not runnable")
+	ErrInvalidConfig = errors.New("invalid config")
 )
 
 // Client is a facade to thin the interface to map the cri logic to lxd.
@@ -34,8 +39,8 @@ type Client interface {
 	// SetEventHandler for container's starting and stopping events
 	SetEventHandler(eh EventHandler)
 
-	// PullImage copies the given image from the remote server
-	PullImage(name string) (string, error)
+	// PullImage copies the given image from the remote server. If progress is set, it's called with the download progress
+	PullImage(name string, progress func(string)) (string, error)
 	// RemoveImage will remove the given image
 	RemoveImage(name string) error
 	// ListImages will list all local images from the lxd server
@@ -44,6 +49,8 @@ type Client interface {
 	GetImage(name string) (*Image, error)
 	// GetFSPoolUsage returns a list of usage information about the used storage pools
 	GetFSPoolUsage() ([]FSPoolUsage, error)
+	// GetRootPool returns the storage pool the root disk of containers is on
+	GetRootPool() (string, error)
 
 	// NewSandbox creates a local representation of a sandbox
 	NewSandbox() *Sandbox
@@ -60,8 +67,10 @@ type Client interface {
 	ListContainers() ([]*Container, error)
 
 	// Exec will start a command on the server and attach the provided streams. It will block till the command terminated
-	// AND all data was written to stdout/stdin. The caller is responsible to provide a sink which doesn't block.
-	Exec(cid string, cmd []string, stdin io.ReadCloser, stdout, stderr io.WriteCloser, interactive, tty bool, timeout int64, resize <-chan remotecommand.TerminalSize) (int32, error)
+	// AND all data was written to stdout/stdin. The caller is responsible to provide a sink which doesn't block. The env
+	// is set in addition to the environment of the container. The command runs as root unless a user is given, and in the
+	// default directory of LXD exec unless cwd is given.
+	Exec(cid string, cmd []string, env map[string]string, user *ExecUser, cwd string, stdin io.ReadCloser, stdout, stderr io.WriteCloser, interactive, tty bool, timeout int64, resize <-chan remotecommand.TerminalSize) (int32, error)
 }
 
 var (
@@ -70,22 +79,24 @@ var (
 
 type client struct {
 	server       lxd.ContainerServer
-	config       *config.Config
+	config       *remoteConfig
 	opwait       *lxo.LXO
 	eventHandler EventHandler
 	socket       string
+	timeouts     lxo.Timeouts
 }
 
-// NewClient will set up a connection and return the client
-func NewClient(socket string, configPath string) (Client, error) {
+// NewClient will set up a connection and return the client. Waiting for lxd operations is limited by timeouts
+func NewClient(socket string, configPath string, timeouts lxo.Timeouts) (Client, error) {
 	config, err := config.LoadConfig(configPath)
 	if err != nil {
 		return nil, err
 	}
 
 	cl := &client{
-		config: config,
-		socket: socket,
+		config:   newRemoteConfig(config),
+		socket:   socket,
+		timeouts: timeouts,
 	}
 
 	err = cl.connect()
@@ -98,6 +109,25 @@ func NewClient(socket string, configPath string) (Client, error) {
 	return cl, nil
 }
 
+// NewClientWithServer returns a client using the given lxd server instead of connecting to lxd, e.g. the in-memory lxd
+// of lxftest. It neither registers for lifecycle events nor reconnects.
+func NewClientWithServer(server lxd.ContainerServer, timeouts lxo.Timeouts) Client {
+	// as if no config file exists, without sharing the remotes of the default
+	cfg := config.DefaultConfig
+	cfg.Remotes = make(map[string]config.Remote, len(config.DefaultConfig.Remotes))
+
+	for name, remote := range config.DefaultConfig.Remotes {
+		cfg.Remotes[name] = remote
+	}
+
+	return &client{
+		server:   server,
+		config:   newRemoteConfig(&cfg),
+		opwait:   lxo.NewClient(server).WithTimeouts(timeouts),
+		timeouts: timeouts,
+	}
+}
+
 // GetServer returns the lxd ContainerServer. TODO: since it created it and others want to access lxd too (lxdbridge
 // network plugin) either return it here, or extract creation of the connection outside and pass server into
 // NewClient(), but that makes the initialisation NewClient() pretty unnecessary
@@ -113,6 +143,8 @@ func (l *client) SetEventHandler(eh EventHandler) {
 type RuntimeInfo struct {
 	// API version of the container runtime. The string must be semver-compatible.
 	Version string
+	// Clustered is whether LXD is a member of a cluster
+	Clustered bool
 }
 
 // GetRuntimeInfo returns informations about the runtime
@@ -124,7 +156,8 @@ func (l *client) GetRuntimeInfo() (*RuntimeInfo, error) {
 
 	return &RuntimeInfo{
 		// api version is only X.X, so need to add .0 for semver requirement
-		Version: fmt.Sprintf("%s.0", server.APIVersion),
+		Version:   fmt.Sprintf("%s.0", server.APIVersion),
+		Clustered: server.Environment.ServerClustered,
 	}, nil
 }
 
@@ -210,7 +243,10 @@ func (l *client) connect() error {
 	}
 
 	l.server = server
-	l.opwait = lxo.NewClient(server)
+	l.opwait = lxo.NewClient(server).WithTimeouts(l.timeouts)
+
+	// stops while the events weren't connected went unnoticed
+	l.recordMissedExits()
 
 	return nil
 }
@@ -1,10 +1,13 @@
 package lxf
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"testing"
 
 	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	"github.com/automaticserver/lxe/lxf/lxftest"
 	"github.com/automaticserver/lxe/lxf/lxo"
 	"github.com/lxc/lxd/lxc/config"
 	"github.com/lxc/lxd/shared/api"
@@ -16,7 +19,7 @@ func testClient() (*client, *lxdfakes.FakeContainerServer) {
 
 	return &client{
 		server: fake,
-		config: &config.Config{},
+		config: newRemoteConfig(&config.Config{}),
 		opwait: lxo.NewClient(fake),
 	}, fake
 }
@@ -33,6 +36,23 @@ func TestClient_GetRuntimeInfo_Ok(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, fake.GetServerCallCount())
 	assert.Exactly(t, "a.b.0", info.Version)
+	assert.False(t, info.Clustered)
+}
+
+func TestClient_GetRuntimeInfo_Clustered(t *testing.T) {
+	client, fake := testClient()
+	fake.GetServerReturns(&api.Server{
+		ServerUntrusted: api.ServerUntrusted{
+			APIVersion: "a.b",
+		},
+		Environment: api.ServerEnvironment{
+			ServerClustered: true,
+		},
+	}, "", nil)
+
+	info, err := client.GetRuntimeInfo()
+	assert.NoError(t, err)
+	assert.True(t, info.Clustered)
 }
 
 func TestClient_GetRuntimeInfo_Error(t *testing.T) {
@@ -44,6 +64,131 @@ func TestClient_GetRuntimeInfo_Error(t *testing.T) {
 	assert.Equal(t, 1, fake.GetServerCallCount())
 }
 
+func TestNewClientWithServer(t *testing.T) {
+	srv := lxftest.NewServer()
+	srv.AddImage("local/busybox", "abc123")
+
+	client := NewClientWithServer(srv, lxo.Timeouts{})
+
+	s := client.NewSandbox()
+	s.Metadata.Name = "web"
+	err := s.Apply()
+	assert.NoError(t, err)
+
+	c := client.NewContainer(s.ID)
+	c.Metadata.Name = "app"
+	c.Image = "busybox"
+	err = c.Apply()
+	assert.NoError(t, err)
+
+	s, err = client.GetSandbox(s.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{c.ID}, s.UsedBy)
+
+	// a sandbox in use can't be deleted
+	assert.True(t, errors.Is(s.Delete(), lxftest.ErrProfileInUse))
+
+	assert.NoError(t, c.Delete())
+	assert.NoError(t, s.Delete())
+	assert.Empty(t, srv.ProfileNames())
+	assert.Empty(t, srv.ContainerNames())
+}
+
+func TestClient_recordMissedExits(t *testing.T) {
+	srv := lxftest.NewServer()
+	srv.AddImage("local/busybox", "abc123")
+
+	client := NewClientWithServer(srv, lxo.Timeouts{}).(*client)
+
+	s := client.NewSandbox()
+	s.Metadata.Name = "job"
+	assert.NoError(t, s.Apply())
+
+	c := client.NewContainer(s.ID)
+	c.Metadata.Name = "job"
+	c.Image = "busybox"
+	assert.NoError(t, c.Apply())
+	assert.NoError(t, c.Start())
+
+	// the container shuts down on its own while no events are received
+	_, err := srv.UpdateContainerState(c.ID, api.ContainerStatePut{Action: "stop"}, "")
+	assert.NoError(t, err)
+
+	c, err = client.GetContainer(c.ID)
+	assert.NoError(t, err)
+	assert.True(t, c.FinishedAt.Before(c.StartedAt))
+
+	client.recordMissedExits()
+
+	// kubelet backs off restarts from the finish time
+	c, err = client.GetContainer(c.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, ContainerStateExited, c.StateName)
+	assert.False(t, c.FinishedAt.Before(c.StartedAt))
+
+	// recorded once only
+	updates := srv.UpdateContainerCallCount()
+
+	client.recordMissedExits()
+	assert.Equal(t, updates, srv.UpdateContainerCallCount())
+}
+
+// recordingEventHandler records the ids of the containers it gets start and stop events for
+type recordingEventHandler struct {
+	started []string
+	stopped []string
+}
+
+func (h *recordingEventHandler) ContainerStarted(ctx context.Context, c *Container) error {
+	h.started = append(h.started, c.ID)
+	return nil
+}
+
+func (h *recordingEventHandler) ContainerStopped(ctx context.Context, c *Container) error {
+	h.stopped = append(h.stopped, c.ID)
+	return nil
+}
+
+func testLifecycleEvent(t *testing.T, action, id string) api.Event {
+	metadata, err := json.Marshal(api.EventLifecycle{Action: action, Source: "/1.0/containers/" + id})
+	assert.NoError(t, err)
+
+	return api.Event{Type: "lifecycle", Metadata: metadata}
+}
+
+func TestClient_lifecycleEventHandler_Creating(t *testing.T) {
+	t.Parallel()
+
+	srv := lxftest.NewServer()
+	srv.AddImage("local/busybox", "abc123")
+
+	client := NewClientWithServer(srv, lxo.Timeouts{}).(*client)
+	handler := &recordingEventHandler{}
+	client.SetEventHandler(handler)
+
+	s := client.NewSandbox()
+	s.Metadata.Name = "web"
+	assert.NoError(t, s.Apply())
+
+	c := client.NewContainer(s.ID)
+	c.Metadata.Name = "app"
+	c.Image = "busybox"
+	assert.NoError(t, c.Apply())
+
+	// the transient start and stop of a post-create hook don't set up and tear down the network
+	client.lifecycleEventHandler(testLifecycleEvent(t, "container-started", c.ID))
+	client.lifecycleEventHandler(testLifecycleEvent(t, "container-stopped", c.ID))
+	assert.Empty(t, handler.started)
+	assert.Empty(t, handler.stopped)
+
+	assert.NoError(t, c.FinishCreate())
+
+	client.lifecycleEventHandler(testLifecycleEvent(t, "container-started", c.ID))
+	client.lifecycleEventHandler(testLifecycleEvent(t, "container-stopped", c.ID))
+	assert.Equal(t, []string{c.ID}, handler.started)
+	assert.Equal(t, []string{c.ID}, handler.stopped)
+}
+
 // func TestConnection(t *testing.T) {
 // 	_, err := lxf.NewClient("", os.Getenv("HOME")+"/.config/lxc/config.yml")
 // 	if err != nil {
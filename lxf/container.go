@@ -4,13 +4,16 @@ import (
 	"crypto/md5" // nolint: gosec
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/automaticserver/lxe/shared"
+	sharedLXD "github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
+	"github.com/lxc/lxd/shared/osarch"
 	opencontainers "github.com/opencontainers/runtime-spec/specs-go"
 	"k8s.io/apimachinery/pkg/util/uuid"
 )
@@ -18,9 +21,14 @@ import (
 const (
 	cfgLogPath              = "user.log_path"
 	cfgSecurityPrivileged   = "security.privileged"
+	cfgSecurityNesting      = "security.nesting"
 	cfgVolatileBaseImage    = cfgVolatile + ".base_image"
+	cfgVolatileIdmap        = cfgVolatile + ".idmap.current"
 	cfgStartedAt            = "user.started_at"
 	cfgFinishedAt           = "user.finished_at"
+	cfgRestartedAt          = "user.restarted_at"
+	cfgExitReason           = "user.exit_reason"
+	cfgExitMessage          = "user.exit_message"
 	cfgCloudInitUserData    = "user.user-data"
 	cfgCloudInitMetaData    = "user.meta-data"
 	cfgEnvironmentPrefix    = "environment"
@@ -32,6 +40,33 @@ const (
 	cfgResourcesMemoryLimit = cfgResourcesPrefix + ".memory.limit"
 	cfgLimitCPUAllowance    = "limits.cpu.allowance"
 	cfgLimitMemory          = "limits.memory"
+	cfgRawLXC               = "raw.lxc"
+)
+
+// ReasonCannotRun is the exit reason of containers whose init can't be executed
+const ReasonCannotRun = "ContainerCannotRun"
+
+// cannotRunErrors are parts of lxc start errors which indicate the init of the container can't be executed. Errors like
+// "no such file or directory" alone also occur for other reasons, e.g. a missing mount source, so they only count when
+// lxc failed to exec the init.
+var cannotRunErrors = []string{
+	"failed to exec",
+	"exec format error",
+}
+
+// hookStopTimeout is how many seconds a container may take to shut down after running a hook
+const hookStopTimeout = 10
+
+// nameHashLength is how many characters of the hash keep prefixed container ids unique
+const nameHashLength = 10
+
+// waitRunningInterval is how often the state is polled while waiting for a started container to run
+const waitRunningInterval = 50 * time.Millisecond
+
+// cpu period bounds of the cgroup cpu controller in microseconds
+const (
+	cpuPeriodMin = 1000
+	cpuPeriodMax = 1000000
 )
 
 var (
@@ -39,11 +74,18 @@ var (
 		append([]string{
 			cfgLogPath,
 			cfgSecurityPrivileged,
+			cfgSecurityNesting,
 			cfgStartedAt,
 			cfgFinishedAt,
+			cfgRestartedAt,
+			cfgExitReason,
+			cfgExitMessage,
 			cfgCloudInitUserData,
 			cfgCloudInitMetaData,
 			cfgCloudInitNetworkConfig,
+			cfgCloudInitVendorData,
+			cfgCheckpointKernelVersion,
+			cfgRestoreCheckpoint,
 		}, reservedConfigCRI...,
 		)...,
 	).WithReservedPrefixes(
@@ -66,6 +108,8 @@ type Container struct {
 	Image string
 	// Privileged defines if the container is run privileged
 	Privileged bool
+	// Nesting allows to run containers inside the container
+	Nesting bool
 	// Environment specifies to the container exported environment variables
 	Environment map[string]string
 
@@ -77,17 +121,44 @@ type Container struct {
 	StartedAt time.Time
 	// FinishedAt is when the container was exited
 	FinishedAt time.Time
+	// RestartedAt is when the container was started again after it ran before, zero if it was started once at most
+	RestartedAt time.Time
 	// StateName of the current container
 	StateName ContainerStateName
+	// Frozen is set for running containers whose processes are frozen (or being frozen) by the cgroup freezer
+	Frozen bool
+	// Idmap is the id mapping lxd applied when the container was started, empty for privileged containers and containers
+	// which were never started. It's only read.
+	Idmap []IdmapEntry
+	// ExitReason is a brief reason why the container has exited, e.g. ReasonCannotRun
+	ExitReason string
+	// ExitMessage is a human readable message why the container has exited
+	ExitMessage string
 	// LogPath TODO, to be implemented?
 	LogPath string
 	// CloudInit fields
 	CloudInitUserData      string
 	CloudInitMetaData      string
 	CloudInitNetworkConfig string
+	// CloudInitVendorData replaces the vendor data of the sandbox, which sets the hostname
+	CloudInitVendorData string
 	// Resources contain cgroup information for handling resource constraints for the container
 	Resources *opencontainers.LinuxResources
-
+	// InstanceType is a lxd instance type preset of limits, only applied when the container is created. Explicit limits
+	// take precedence
+	InstanceType string
+	// NamePrefix makes the id of a new container recognisable, e.g. in lxc list. It's sanitized to lxd's naming rules
+	NamePrefix string
+	// RestoreFrom is the path to a checkpoint archive the container is created from, instead of its image
+	RestoreFrom string
+	// Architecture the image must be built for, e.g. arm64, only checked when the container is created. Empty accepts any
+	// architecture the host can run
+	Architecture string
+	// RestoreCheckpoint is the stateful snapshot the container is restored from when it is started the first time
+	RestoreCheckpoint string
+
+	// location is the cluster member the container is on, empty if lxd isn't clustered
+	location string
 	// sandbox is the parent sandbox of this container
 	sandbox *Sandbox
 	// State contains the current additional state info of this container
@@ -111,6 +182,8 @@ type ContainerState struct {
 type ContainerStateName string
 
 const (
+	// ContainerStateCreating it's there but still being set up, it can't be started yet
+	ContainerStateCreating ContainerStateName = "creating"
 	// ContainerStateCreated it's there but not started yet
 	ContainerStateCreated ContainerStateName = "created"
 	// ContainerStateRunning it's there and running
@@ -130,6 +203,56 @@ type ContainerStats struct {
 	MemoryUsage     uint64
 	CPUUsage        uint64
 	FilesystemUsage uint64
+	// SwapUsage in bytes, nil if swap isn't enabled on the host
+	SwapUsage *uint64
+}
+
+// MemoryUsage of a container broken down like cAdvisor does, in bytes. Fields which the cgroup doesn't provide are nil.
+type MemoryUsage struct {
+	// Usage includes the page cache
+	Usage uint64
+	// WorkingSet is the usage without the inactive file cache, which the kernel can reclaim without swapping
+	WorkingSet      uint64
+	RSS             *uint64
+	Cache           *uint64
+	PageFaults      *uint64
+	MajorPageFaults *uint64
+	// Available is the limit minus the working set, nil if the memory isn't limited
+	Available *uint64
+}
+
+// HugepagesUsage of a container for one page size, in bytes
+type HugepagesUsage struct {
+	Usage uint64
+	// Limit is 0 if the usage isn't limited
+	Limit uint64
+}
+
+// DiskIOUsage of a container for one block device, counted since the container started
+type DiskIOUsage struct {
+	ReadBytes  uint64
+	WriteBytes uint64
+	// ReadOps and WriteOps are the number of read and write operations
+	ReadOps  uint64
+	WriteOps uint64
+}
+
+// ContainerCgroup locates the cgroup of a container on the host
+type ContainerCgroup struct {
+	// Path of the cgroup below the mount of the hierarchy, for cgroup v1 below the mount of each controller
+	Path string
+	// Version of the cgroup hierarchy, 1 or 2
+	Version int
+}
+
+// IdmapEntry maps a range of uids or gids, or both, of a container to the host. The json keys are the ones lxd uses in
+// its volatile config.
+type IdmapEntry struct {
+	UID         bool  `json:"Isuid"`
+	GID         bool  `json:"Isgid"`
+	HostID      int64 `json:"Hostid"`
+	ContainerID int64 `json:"Nsid"`
+	Range       int64 `json:"Maprange"`
 }
 
 // ContainerMetadata has the metadata neede by a container
@@ -197,14 +320,132 @@ func (c *Container) getState() (*ContainerState, error) {
 	cs.Pid = state.Pid
 	cs.Network = state.Network
 	cs.Stats = ContainerStats{
-		CPUUsage:        uint64(state.CPU.Usage),
-		MemoryUsage:     uint64(state.Memory.Usage),
 		FilesystemUsage: uint64(state.Disk[lxdInitDefaultDiskName].Usage),
 	}
 
+	// lxd reports a negative cpu usage and no memory usage if it can't read the cgroups
+	if state.CPU.Usage > 0 && state.Memory.Usage > 0 {
+		cs.Stats.CPUUsage = uint64(state.CPU.Usage)
+		cs.Stats.MemoryUsage = uint64(state.Memory.Usage)
+
+		if hostSwapEnabled() && state.Memory.SwapUsage >= 0 {
+			swap := uint64(state.Memory.SwapUsage)
+			cs.Stats.SwapUsage = &swap
+		}
+	} else if state.StatusCode == api.Running && state.Pid > 0 && c.isLocal() {
+		stats, err := readCgroupStats(state.Pid)
+		if err != nil {
+			logger.Warnf("unable to read cgroup stats of container %v: %v", c.ID, err)
+		} else {
+			cs.Stats.CPUUsage = stats.CPUUsage
+			cs.Stats.MemoryUsage = stats.MemoryUsage
+			cs.Stats.SwapUsage = stats.SwapUsage
+		}
+	}
+
 	return cs, nil
 }
 
+// Hugepages returns the hugepages usage of the running container by page size, e.g. "2MB". It is nil if the container
+// uses no hugepages and has no hugepages limit, or isn't on this host. LXD doesn't report hugepages, so they are read
+// from the cgroups.
+func (c *Container) Hugepages() (map[string]HugepagesUsage, error) {
+	st, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Pid <= 0 || !c.isLocal() {
+		return nil, nil
+	}
+
+	return readCgroupHugepages(st.Pid)
+}
+
+// Memory returns the memory usage of the running container broken down into rss, cache and working set. It is nil if
+// the container isn't on this host. LXD only reports the total usage, so the breakdown is read from the cgroups.
+func (c *Container) Memory() (*MemoryUsage, error) {
+	st, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Pid <= 0 || !c.isLocal() {
+		return nil, nil
+	}
+
+	return readCgroupMemory(st.Pid)
+}
+
+// DiskIO returns the disk io of the running container by block device, e.g. "8:0". It is nil if the container did no
+// io, or isn't on this host. LXD doesn't report disk io, so it's read from the cgroups.
+func (c *Container) DiskIO() (map[string]DiskIOUsage, error) {
+	st, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Pid <= 0 || !c.isLocal() {
+		return nil, nil
+	}
+
+	return readCgroupDiskIO(st.Pid)
+}
+
+// Cgroup returns the cgroup of the running container, nil if it isn't running or isn't on this host. It's read from the
+// init process, so it's where lxd actually placed the container.
+func (c *Container) Cgroup() (*ContainerCgroup, error) {
+	st, err := c.State()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.Pid <= 0 || !c.isLocal() {
+		return nil, nil
+	}
+
+	cg, err := readCgroupLocation(st.Pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cg, nil
+}
+
+// Processes lists at most max processes of the running container and returns the total number of its processes. It
+// returns nothing if the container isn't running or isn't on this host.
+func (c *Container) Processes(max int) ([]Process, int, error) {
+	st, err := c.State()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if st.Pid <= 0 || !c.isLocal() {
+		return nil, 0, nil
+	}
+
+	return readProcesses(st.Pid, max)
+}
+
+// Location returns the cluster member the container is on, empty if lxd isn't clustered
+func (c *Container) Location() string {
+	return c.location
+}
+
+// isLocal returns whether the container is on this host, so its processes are visible here
+func (c *Container) isLocal() bool {
+	if c.location == "" {
+		return true
+	}
+
+	server, _, err := c.client.server.GetServer()
+	if err != nil {
+		return false
+	}
+
+	return server.Environment.ServerName == c.location
+}
+
 // refresh loads the container again from LXD to obtain new ETag
 // Will not load new data!
 func (c *Container) refresh() error {
@@ -233,14 +474,115 @@ func (c *Container) Apply() error {
 	return c.refresh()
 }
 
+// Freeze freezes the processes of the running container, e.g. for debugging. It keeps running as far as kubelet is
+// concerned.
+func (c *Container) Freeze() error {
+	if c.StateName != ContainerStateRunning {
+		return fmt.Errorf("%w: %v", ErrNotRunning, c.ID)
+	}
+
+	err := c.client.opwait.FreezeContainer(c.ID)
+	if err != nil {
+		return err
+	}
+
+	c.Frozen = true
+
+	return c.refresh()
+}
+
+// Thaw thaws the processes of the frozen container
+func (c *Container) Thaw() error {
+	err := c.client.opwait.UnfreezeContainer(c.ID)
+	if err != nil {
+		return err
+	}
+
+	c.Frozen = false
+
+	return c.refresh()
+}
+
+// FileTypeDirectory is the type PathType returns for directories. Other types are e.g. "file" and "symlink".
+const FileTypeDirectory = "directory"
+
+// PathType returns the lxd file type of the path in the container's filesystem, empty if it doesn't exist. It also
+// works for containers which were never started, so the paths of the image can be checked.
+func (c *Container) PathType(path string) (string, error) {
+	content, resp, err := c.client.server.GetContainerFile(c.ID, path)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	// directories are listed in the response, files are streamed
+	if content != nil {
+		content.Close()
+	}
+
+	return resp.Type, nil
+}
+
+// Creating returns whether the container is still being set up, also before it was loaded again after its creation
+func (c *Container) Creating() bool {
+	return c.StateName == ContainerStateCreating || c.Config[cfgState] == ContainerStateCreating.String()
+}
+
+// FinishCreate marks the container as created once it's set up, so it can be started. If saving fails, it's still
+// being created.
+func (c *Container) FinishCreate() error {
+	if !c.Creating() {
+		return nil
+	}
+
+	c.Config[cfgState] = ContainerStateCreated.String()
+	c.StateName = ContainerStateCreated
+
+	err := c.Apply()
+	if err != nil {
+		c.Config[cfgState] = ContainerStateCreating.String()
+		c.StateName = ContainerStateCreating
+
+		return err
+	}
+
+	return nil
+}
+
 // Start the container
 func (c *Container) Start() error {
+	if c.RestoreCheckpoint != "" {
+		err := c.restoreCheckpoint()
+		if err != nil {
+			return err
+		}
+
+		delete(c.Config, cfgState)
+		c.markStarted(time.Now())
+
+		return c.Apply()
+	}
+
 	err := c.client.opwait.StartContainer(c.ID)
 	if err != nil {
 		if shared.IsErrNotFound(err) {
 			return fmt.Errorf("container %w: %s", shared.NewErrNotFound(), c.ID)
 		}
 
+		if isCannotRun(err) {
+			c.markCannotRun(err)
+
+			applyErr := c.Apply()
+			if applyErr != nil {
+				logger.Errorf("unable to mark container %v as unable to run: %v", c.ID, applyErr)
+			}
+
+			return fmt.Errorf("%w: %v", ErrCannotRun, err)
+		}
+
 		return err
 	}
 
@@ -252,11 +594,108 @@ func (c *Container) Start() error {
 
 	// delete created mark if exists, so next stopping state can be exited
 	delete(c.Config, cfgState)
-	c.StartedAt = time.Now()
+	c.markStarted(time.Now())
+	c.ExitReason = ""
+	c.ExitMessage = ""
 
 	return c.Apply()
 }
 
+// markStarted records the start of the container. Starting it again after it ran before is a restart, which keeps the
+// creation time.
+func (c *Container) markStarted(t time.Time) {
+	if !c.StartedAt.IsZero() {
+		c.RestartedAt = t
+	}
+
+	c.StartedAt = t
+}
+
+// recordExit records when the container exited on its own, as only stops by lxe are recorded otherwise. Returns
+// whether the container was changed.
+func (c *Container) recordExit(t time.Time) bool {
+	if c.StartedAt.IsZero() || !c.FinishedAt.Before(c.StartedAt) {
+		return false
+	}
+
+	c.FinishedAt = t
+
+	return true
+}
+
+// WaitRunning waits until lxd reports the init process of the container, so it can be used right after it was started.
+// Returns an error if it's not running within timeout.
+func (c *Container) WaitRunning(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		state, _, err := c.client.server.GetContainerState(c.ID)
+		if err != nil {
+			return err
+		}
+
+		if state.StatusCode == api.Running && state.Pid > 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: no init process after %v", ErrNotRunning, timeout)
+		}
+
+		time.Sleep(waitRunningInterval)
+	}
+}
+
+// RunHook starts the container transiently to run cmd in it and stops it again. The container keeps its created state.
+// Returns the combined output of cmd, and an error if cmd fails or doesn't complete within timeout seconds.
+func (c *Container) RunHook(cmd []string, timeout int64) ([]byte, error) {
+	err := c.client.opwait.StartContainer(c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	out, code, err := ExecCombined(c.client, c.ID, cmd, timeout)
+
+	stopErr := c.client.opwait.StopContainer(c.ID, hookStopTimeout, 1)
+	if stopErr != nil {
+		return out, stopErr
+	}
+
+	if err != nil {
+		return out, fmt.Errorf("%w: %v", ErrHookFailed, err)
+	}
+
+	if code != CodeExecOk {
+		return out, fmt.Errorf("%w: exit code %d", ErrHookFailed, code)
+	}
+
+	// when changing state of container, need to refresh ETag
+	return out, c.refresh()
+}
+
+// isCannotRun checks whether the start failed because the init of the container can't be executed
+func isCannotRun(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	for _, s := range cannotRunErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markCannotRun sets the container as exited immediately, with the reason it couldn't be started
+func (c *Container) markCannotRun(err error) {
+	delete(c.Config, cfgState)
+
+	c.StartedAt = time.Now()
+	c.FinishedAt = c.StartedAt
+	c.ExitReason = ReasonCannotRun
+	c.ExitMessage = err.Error()
+}
+
 // Stop will try to stop the container, returns nil when container is already stopped or
 // got stopped in the meantime, otherwise it will return an error.
 func (c *Container) Stop(timeout int) error {
@@ -277,7 +716,18 @@ func (c *Container) Stop(timeout int) error {
 
 	c.FinishedAt = time.Now()
 
-	return c.Apply()
+	err = c.Apply()
+	if err != nil {
+		// the exit may have been recorded meanwhile by the stopped event, which changed the ETag
+		err = c.refresh()
+		if err != nil {
+			return err
+		}
+
+		return c.Apply()
+	}
+
+	return nil
 }
 
 // Delete the container, returns nil when container is already deleted or
@@ -297,6 +747,11 @@ func (c *Container) Delete() error {
 
 // validate checks for misconfigurations
 func (c *Container) validate() error {
+	err := c.validateResources()
+	if err != nil {
+		return err
+	}
+
 	s, err := c.Sandbox()
 	if err != nil {
 		return err
@@ -312,9 +767,79 @@ func (c *Container) validate() error {
 	return nil
 }
 
+// validateResources checks the cpu quota and period. A quota of 0 or less means unlimited, otherwise the period must be
+// within the bounds of the cgroup cpu controller.
+func (c *Container) validateResources() error {
+	if c.Resources == nil || c.Resources.CPU == nil {
+		return nil
+	}
+
+	cpu := c.Resources.CPU
+	if cpu.Quota == nil || *cpu.Quota <= 0 {
+		return nil
+	}
+
+	if cpu.Period == nil || *cpu.Period < cpuPeriodMin || *cpu.Period > cpuPeriodMax {
+		return fmt.Errorf("%w: cpu quota %v requires a cpu period between %vus and %vus", ErrUsage, *cpu.Quota, cpuPeriodMin, cpuPeriodMax)
+	}
+
+	return nil
+}
+
+// checkArchitecture checks the image is built for the requested architecture, if any, and the host can run it
+func (c *Container) checkArchitecture(hash string) error {
+	img, _, err := c.client.server.GetImage(hash)
+	if err != nil {
+		return err
+	}
+
+	server, _, err := c.client.server.GetServer()
+	if err != nil {
+		return err
+	}
+
+	return verifyArchitecture(c.Architecture, img.Architecture, server.Environment.Architectures)
+}
+
+// verifyArchitecture returns an error if the image architecture isn't the requested one, unless none is requested, or
+// none of the host architectures. Lxd runs containers natively, so it can't emulate foreign architectures like virtual
+// machines can.
+func verifyArchitecture(requested, image string, host []string) error {
+	imgID, imgErr := osarch.ArchitectureId(image)
+
+	if requested != "" {
+		reqID, err := osarch.ArchitectureId(requested)
+		if err != nil {
+			return fmt.Errorf("%w: unknown architecture %v", ErrArchitecture, requested)
+		}
+
+		if imgErr != nil || imgID != reqID {
+			return fmt.Errorf("%w: image is built for %v, not %v", ErrArchitecture, image, requested)
+		}
+	} else if imgErr != nil {
+		return fmt.Errorf("%w: image is built for unknown architecture %v", ErrArchitecture, image)
+	}
+
+	for _, arch := range host {
+		hostID, err := osarch.ArchitectureId(arch)
+		if err == nil && hostID == imgID {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: image is built for %v, which this host can't run, it supports %v", ErrArchitecture, image, strings.Join(host, ", "))
+}
+
 // apply saves the changes to LXD
 // Will not obtain the new ETag!
 func (c *Container) apply() error {
+	if c.ID == "" && c.RestoreFrom != "" {
+		err := c.importCheckpoint()
+		if err != nil {
+			return err
+		}
+	}
+
 	// TODO: can't this be done easier?
 	imageID, err := c.client.parseImage(c.Image)
 	if err != nil {
@@ -330,6 +855,14 @@ func (c *Container) apply() error {
 		return fmt.Errorf("image %w on local remote: %s", shared.NewErrNotFound(), c.Image)
 	}
 
+	// for all new containers, so an image the host can't run fails with an error telling the architectures
+	if c.ID == "" {
+		err = c.checkArchitecture(hash)
+		if err != nil {
+			return err
+		}
+	}
+
 	config := makeContainerConfig(c)
 
 	devices := make(map[string]map[string]string)
@@ -347,6 +880,35 @@ func (c *Container) apply() error {
 		}
 	}
 
+	// vendor-data of the container shadows the one of the sandbox profile, which sets the hostname
+	if c.CloudInitVendorData != "" {
+		s, err := c.Sandbox()
+		if err != nil {
+			return err
+		}
+
+		config[cfgCloudInitVendorData], err = composeVendorData(s.Hostname, c.CloudInitVendorData)
+		if err != nil {
+			return err
+		}
+	}
+
+	// raw.lxc of the container shadows the one of the sandbox profile, so they need to be merged
+	if raw, has := config[cfgRawLXC]; has {
+		s, err := c.Sandbox()
+		if err != nil {
+			return err
+		}
+
+		config[cfgRawLXC] = MergeRawLXC(s.Config[cfgRawLXC], raw)
+	}
+
+	// lxd's errors of invalid values don't name the key, which makes them hard to trace back
+	err = validateConfig(config)
+	if err != nil {
+		return err
+	}
+
 	config[cfgSchema] = SchemaVersionContainer
 	contPut := api.ContainerPut{
 		Profiles: c.Profiles,
@@ -358,14 +920,20 @@ func (c *Container) apply() error {
 		// container has to be created
 		c.ID = c.CreateID()
 
-		return c.client.opwait.CreateContainer(api.ContainersPost{
+		err = c.client.opwait.CreateContainer(api.ContainersPost{
 			Name:         c.ID,
 			ContainerPut: contPut,
+			InstanceType: c.InstanceType,
 			Source: api.ContainerSource{
 				Fingerprint: hash,
 				Type:        "image",
 			},
 		})
+		if err != nil && c.InstanceType != "" {
+			return fmt.Errorf("unable to create container with instance type %v: %w", c.InstanceType, err)
+		}
+
+		return err
 	}
 	// else container has to be updated
 	if c.ETag == "" {
@@ -384,14 +952,22 @@ func (c *Container) apply() error {
 	return nil
 }
 
-// CreateID creates a unique container id
+// CreateID creates a unique container id. If a NamePrefix is set, the id is the sanitized prefix followed by a short
+// hash.
 func (c *Container) CreateID() string {
 	bin := md5.Sum([]byte(uuid.NewUUID())) // nolint: gosec
-	return string(c.Metadata.Name[0]) + b32lowerEncoder.EncodeToString(bin[:])[:15]
+	hash := b32lowerEncoder.EncodeToString(bin[:])
+
+	prefix := SanitizeName(c.NamePrefix, maxNameLength-nameHashLength-1)
+	if prefix == "" {
+		return string(c.Metadata.Name[0]) + hash[:15]
+	}
+
+	return prefix + "-" + hash[:nameHashLength]
 }
 
-// GetInetAddress returns the IPv4 address of the first matching interface in the parameter list
-// empty string if nothing was found
+// GetInetAddress returns the IPv4 address of the first matching interface in the parameter list. If none matches, the
+// global IPv4 address of the first non-loopback interface by name is returned. Empty string if nothing was found
 func (c *Container) GetInetAddress(ifs []string) string {
 	st, err := c.State()
 	if err != nil {
@@ -408,13 +984,58 @@ func (c *Container) GetInetAddress(ifs []string) string {
 		}
 	}
 
+	names := make([]string, 0, len(st.Network))
+	for name := range st.Network {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		netif := st.Network[name]
+		if netif.Type == "loopback" {
+			continue
+		}
+
+		for _, addr := range netif.Addresses {
+			if addr.Family == "inet" && addr.Scope == "global" {
+				return addr.Address
+			}
+		}
+	}
+
 	return ""
 }
 
+// validateConfig checks the values of the config keys lxd knows with lxd's own checks, and returns an error naming the
+// first invalid key. Keys unknown to this client are left for lxd to check, as lxd may be newer.
+func validateConfig(config map[string]string) error {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		check, known := sharedLXD.KnownContainerConfigKeys[k]
+		if !known {
+			continue
+		}
+
+		err := check(config[k])
+		if err != nil {
+			return fmt.Errorf("%w: config key %v: %v", ErrInvalidConfig, k, err)
+		}
+	}
+
+	return nil
+}
+
 func makeContainerConfig(c *Container) map[string]string { // nolint: gocognit
-	// default values for new containers
+	// default values for new containers, they are marked created once set up
 	if c.ID == "" {
-		c.Config[cfgState] = ContainerStateCreated.String()
+		c.Config[cfgState] = ContainerStateCreating.String()
 		c.CreatedAt = time.Now()
 	}
 
@@ -432,7 +1053,11 @@ func makeContainerConfig(c *Container) map[string]string { // nolint: gocognit
 	config[cfgCreatedAt] = strconv.FormatInt(c.CreatedAt.UnixNano(), 10)
 	config[cfgStartedAt] = strconv.FormatInt(c.StartedAt.UnixNano(), 10)
 	config[cfgFinishedAt] = strconv.FormatInt(c.FinishedAt.UnixNano(), 10)
+	config[cfgRestartedAt] = strconv.FormatInt(c.RestartedAt.UnixNano(), 10)
+	config[cfgExitReason] = c.ExitReason
+	config[cfgExitMessage] = c.ExitMessage
 	config[cfgSecurityPrivileged] = strconv.FormatBool(c.Privileged)
+	config[cfgSecurityNesting] = strconv.FormatBool(c.Nesting)
 	config[cfgLogPath] = c.LogPath
 	config[cfgIsCRI] = strconv.FormatBool(true)
 	config[cfgMetaName] = c.Metadata.Name
@@ -457,6 +1082,10 @@ func makeContainerConfig(c *Container) map[string]string { // nolint: gocognit
 		config[cfgCloudInitNetworkConfig] = c.CloudInitNetworkConfig
 	}
 
+	if c.RestoreCheckpoint != "" {
+		config[cfgRestoreCheckpoint] = c.RestoreCheckpoint
+	}
+
 	if c.Resources != nil { // nolint: nestif
 		if c.Resources.CPU != nil {
 			if c.Resources.CPU.Shares != nil {
@@ -471,17 +1100,14 @@ func makeContainerConfig(c *Container) map[string]string { // nolint: gocognit
 				config[cfgResourcesCPUPeriod] = strconv.FormatUint(*c.Resources.CPU.Period, 10)
 			}
 
-			if c.Resources.CPU.Quota != nil && *c.Resources.CPU.Quota > 0 && c.Resources.CPU.Period != nil && *c.Resources.CPU.Period > 0 {
-				// nolint:gomnd
-				config[cfgLimitCPUAllowance] = fmt.Sprintf("%dms/%dms",
-					int(math.Ceil(float64(*c.Resources.CPU.Quota)/1000)),
-					int(math.Ceil(float64(*c.Resources.CPU.Period)/1000)),
-				)
+			if c.Resources.CPU.Quota != nil && c.Resources.CPU.Period != nil {
+				SetIfSet(&config, cfgLimitCPUAllowance, cpuAllowance(*c.Resources.CPU.Quota, *c.Resources.CPU.Period))
 			}
 		}
 
 		if c.Resources.Memory != nil {
 			if c.Resources.Memory.Limit != nil && *c.Resources.Memory.Limit > 0 {
+				config[cfgResourcesMemoryLimit] = strconv.FormatInt(*c.Resources.Memory.Limit, 10)
 				config[cfgLimitMemory] = strconv.FormatInt(*c.Resources.Memory.Limit, 10)
 			}
 		}
@@ -490,6 +1116,21 @@ func makeContainerConfig(c *Container) map[string]string { // nolint: gocognit
 	return config
 }
 
+// cpuAllowance translates the cpu quota and period in microseconds into lxd's time chunk allowance, as lxd doesn't take
+// them directly. E.g. 1.5 cores are a quota of 150000us per 100000us period and result to "150ms/100ms". Returns empty
+// string if the quota means unlimited.
+func cpuAllowance(quota int64, period uint64) string {
+	if quota <= 0 || period == 0 {
+		return ""
+	}
+
+	// nolint:gomnd
+	return fmt.Sprintf("%dms/%dms",
+		int(math.Ceil(float64(quota)/1000)),
+		int(math.Ceil(float64(period)/1000)),
+	)
+}
+
 // extractEnvVars extracts all the config options that start with "environment."
 // and returns the environment variables + values
 func extractEnvVars(config map[string]string) map[string]string {
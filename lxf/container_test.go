@@ -0,0 +1,462 @@
+package lxf
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	lxd "github.com/lxc/lxd/client"
+	lxdApi "github.com/lxc/lxd/shared/api"
+	opencontainers "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUAllowance(t *testing.T) {
+	t.Parallel()
+
+	// 50m
+	assert.Equal(t, "5ms/100ms", cpuAllowance(5000, 100000))
+	// 1.5 cores
+	assert.Equal(t, "150ms/100ms", cpuAllowance(150000, 100000))
+	// 1m is rounded up to the smallest time chunk
+	assert.Equal(t, "1ms/100ms", cpuAllowance(100, 100000))
+	// unlimited
+	assert.Equal(t, "", cpuAllowance(-1, 100000))
+	assert.Equal(t, "", cpuAllowance(0, 100000))
+	assert.Equal(t, "", cpuAllowance(5000, 0))
+}
+
+func TestContainer_validateResources(t *testing.T) {
+	t.Parallel()
+
+	cpu := func(quota int64, period uint64) *Container {
+		return &Container{Resources: &opencontainers.LinuxResources{CPU: &opencontainers.LinuxCPU{Quota: &quota, Period: &period}}}
+	}
+
+	assert.NoError(t, (&Container{}).validateResources())
+	assert.NoError(t, cpu(5000, 100000).validateResources())
+	assert.NoError(t, cpu(-1, 0).validateResources())
+	assert.NoError(t, cpu(0, 0).validateResources())
+	assert.True(t, errors.Is(cpu(5000, 0).validateResources(), ErrUsage))
+	assert.True(t, errors.Is(cpu(5000, 999).validateResources(), ErrUsage))
+	assert.True(t, errors.Is(cpu(5000, 1000001).validateResources(), ErrUsage))
+}
+
+func TestContainer_Checkpoint(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fake.CreateContainerSnapshotReturns(fakeOp, nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	name, err := c.Checkpoint("")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(name, checkpointPrefix))
+
+	id, snapshot := fake.CreateContainerSnapshotArgsForCall(0)
+	assert.Equal(t, "foo", id)
+	assert.True(t, snapshot.Stateful)
+	assert.Equal(t, 0, fake.CreateContainerBackupCallCount())
+}
+
+func TestContainer_Checkpoint_NoCRIU(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fake.CreateContainerSnapshotReturns(fakeOp, nil)
+	fakeOp.WaitReturns(errors.New("Unable to create a container snapshot: CRIU isn't installed"))
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	_, err := c.Checkpoint("")
+	assert.True(t, errors.Is(err, ErrCRIUUnavailable))
+}
+
+func TestVerifyArchitecture(t *testing.T) {
+	t.Parallel()
+
+	host := []string{"x86_64", "i686"}
+
+	assert.NoError(t, verifyArchitecture("x86_64", "x86_64", host))
+	// aliases are understood
+	assert.NoError(t, verifyArchitecture("amd64", "x86_64", host))
+	assert.NoError(t, verifyArchitecture("i386", "i686", host))
+	// the image must match the request
+	assert.True(t, errors.Is(verifyArchitecture("arm64", "x86_64", host), ErrArchitecture))
+	// foreign architectures can't be emulated
+	assert.True(t, errors.Is(verifyArchitecture("arm64", "aarch64", host), ErrArchitecture))
+	assert.True(t, errors.Is(verifyArchitecture("mips", "mips", host), ErrArchitecture))
+	// without request the image must only be runnable
+	assert.NoError(t, verifyArchitecture("", "i686", host))
+	assert.True(t, errors.Is(verifyArchitecture("", "aarch64", host), ErrArchitecture))
+	assert.True(t, errors.Is(verifyArchitecture("", "", host), ErrArchitecture))
+}
+
+func TestContainer_checkArchitecture(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetImageReturns(&lxdApi.Image{Architecture: "aarch64"}, "", nil)
+	fake.GetServerReturns(&lxdApi.Server{Environment: lxdApi.ServerEnvironment{Architectures: []string{"aarch64", "armv7l"}}}, "", nil)
+
+	c := &Container{Architecture: "arm64"}
+	c.client = client
+
+	assert.NoError(t, c.checkArchitecture("abc"))
+	assert.Equal(t, "abc", fake.GetImageArgsForCall(0))
+
+	c.Architecture = "armhf"
+	assert.True(t, errors.Is(c.checkArchitecture("abc"), ErrArchitecture))
+}
+
+func TestContainer_markStarted(t *testing.T) {
+	t.Parallel()
+
+	created := time.Now().Add(-time.Hour)
+	first := created.Add(time.Minute)
+	second := first.Add(time.Minute)
+
+	c := &Container{}
+	c.CreatedAt = created
+
+	c.markStarted(first)
+	assert.Equal(t, first, c.StartedAt)
+	assert.True(t, c.RestartedAt.IsZero())
+
+	// a restart keeps the creation time
+	c.markStarted(second)
+	assert.Equal(t, created, c.CreatedAt)
+	assert.Equal(t, second, c.StartedAt)
+	assert.Equal(t, second, c.RestartedAt)
+}
+
+func TestContainer_recordExit(t *testing.T) {
+	t.Parallel()
+
+	started := time.Now().Add(-time.Hour)
+	exited := started.Add(time.Minute)
+
+	// never started, e.g. after a hook
+	c := &Container{}
+	assert.False(t, c.recordExit(exited))
+
+	// exited on its own
+	c.StartedAt = started
+	assert.True(t, c.recordExit(exited))
+	assert.Equal(t, exited, c.FinishedAt)
+
+	// already recorded, e.g. by lxe stopping it
+	assert.False(t, c.recordExit(exited.Add(time.Second)))
+	assert.Equal(t, exited, c.FinishedAt)
+}
+
+func TestContainer_RunHook_Fails(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fake.UpdateContainerStateReturns(fakeOp, nil)
+	fake.ExecContainerCalls(func(arg1 string, arg2 lxdApi.ContainerExecPost, arg3 *lxd.ContainerExecArgs) (lxd.Operation, error) {
+		go sendDataDone(arg3, 0)
+
+		return fakeOp, nil
+	})
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(2),
+		},
+	})
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	_, err := c.RunHook([]string{"/bin/sh", "-c", "exit 2"}, 10)
+	assert.True(t, errors.Is(err, ErrHookFailed))
+
+	// started and stopped again
+	assert.Equal(t, 2, fake.UpdateContainerStateCallCount())
+	_, start, _ := fake.UpdateContainerStateArgsForCall(0)
+	assert.Equal(t, "start", start.Action)
+	_, stop, _ := fake.UpdateContainerStateArgsForCall(1)
+	assert.Equal(t, "stop", stop.Action)
+}
+
+func TestIsCannotRun(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isCannotRun(errors.New(`Failed to run: lxc start: Failed to exec "/sbin/init": No such file or directory`)))
+	assert.True(t, isCannotRun(errors.New("exec format error")))
+	assert.False(t, isCannotRun(errors.New("Common start logic: storage pool is full")))
+
+	// other start failures with the same errno aren't about the init and may be fixed, so they mustn't exit the container
+	assert.False(t, isCannotRun(errors.New(`Failed to run: lxc start: Failed to mount "/srv/data" onto "/usr/lib/x86_64-linux-gnu/lxc/rootfs/data": No such file or directory`)))
+	assert.False(t, isCannotRun(errors.New(`Failed to run: lxc start: Failed to load AppArmor profile: Permission denied`)))
+}
+
+func TestContainer_markCannotRun(t *testing.T) {
+	t.Parallel()
+
+	c := &Container{}
+	c.Config = map[string]string{cfgState: ContainerStateCreated.String()}
+
+	c.markCannotRun(errors.New(`Failed to exec "/sbin/init": No such file or directory`))
+
+	assert.NotContains(t, c.Config, cfgState)
+	assert.Equal(t, ReasonCannotRun, c.ExitReason)
+	assert.Contains(t, c.ExitMessage, "/sbin/init")
+	assert.Equal(t, c.StartedAt, c.FinishedAt)
+}
+
+func TestContainer_Start_CannotRun(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fake.UpdateContainerStateReturns(fakeOp, nil)
+	fakeOp.WaitReturns(errors.New(`Failed to run: lxc start: Failed to exec "/bin/nonexistent": No such file or directory`))
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+	c.Config = map[string]string{cfgState: ContainerStateCreated.String()}
+
+	err := c.Start()
+	assert.True(t, errors.Is(err, ErrCannotRun))
+	assert.Equal(t, ReasonCannotRun, c.ExitReason)
+}
+
+func TestContainer_State_CgroupFallback(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":                        "0::/lxc.payload.foo\n",
+		"cgroup/lxc.payload.foo/cpu.stat":       "usage_usec 2000\n",
+		"cgroup/lxc.payload.foo/memory.current": "2097152\n",
+	})()
+
+	client, fake := testClient()
+	// lxd couldn't read the cgroups
+	fake.GetContainerStateReturns(&lxdApi.ContainerState{
+		StatusCode: lxdApi.Running,
+		Pid:        42,
+		CPU:        lxdApi.ContainerStateCPU{Usage: -1},
+	}, "", nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	st, err := c.State()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2000000), st.Stats.CPUUsage)
+	assert.Equal(t, uint64(2097152), st.Stats.MemoryUsage)
+
+	// containers on other cluster members aren't visible here
+	fake.GetServerReturns(&lxdApi.Server{Environment: lxdApi.ServerEnvironment{ServerName: "node1"}}, "", nil)
+
+	c = &Container{location: "node2"}
+	c.client = client
+	c.ID = "foo"
+
+	st, err = c.State()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), st.Stats.CPUUsage)
+	assert.Equal(t, uint64(0), st.Stats.MemoryUsage)
+}
+
+func TestContainer_State_SwapUsage(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/meminfo": "SwapTotal:       2097148 kB\n",
+	})()
+
+	client, fake := testClient()
+	fake.GetContainerStateReturns(&lxdApi.ContainerState{
+		StatusCode: lxdApi.Running,
+		Pid:        42,
+		CPU:        lxdApi.ContainerStateCPU{Usage: 1000},
+		Memory:     lxdApi.ContainerStateMemory{Usage: 2048, SwapUsage: 512},
+	}, "", nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	st, err := c.State()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2048), st.Stats.MemoryUsage)
+	assert.Equal(t, uint64(512), *st.Stats.SwapUsage)
+}
+
+func TestContainer_WaitRunning(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetContainerStateReturnsOnCall(0, &lxdApi.ContainerState{StatusCode: lxdApi.Running}, "", nil)
+	fake.GetContainerStateReturnsOnCall(1, &lxdApi.ContainerState{StatusCode: lxdApi.Running, Pid: 42}, "", nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	assert.NoError(t, c.WaitRunning(time.Second))
+	assert.Equal(t, 2, fake.GetContainerStateCallCount())
+}
+
+func TestContainer_WaitRunning_Timeout(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetContainerStateReturns(&lxdApi.ContainerState{StatusCode: lxdApi.Stopped}, "", nil)
+
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	err := c.WaitRunning(100 * time.Millisecond)
+	assert.True(t, errors.Is(err, ErrNotRunning))
+}
+
+func TestContainer_GetInetAddress(t *testing.T) {
+	t.Parallel()
+
+	c := &Container{state: &ContainerState{Network: map[string]lxdApi.ContainerStateNetwork{
+		"lo": {Type: "loopback", Addresses: []lxdApi.ContainerStateNetworkAddress{
+			{Family: "inet", Address: "127.0.0.1", Scope: "local"},
+		}},
+		"enp5s0": {Type: "broadcast", Addresses: []lxdApi.ContainerStateNetworkAddress{
+			{Family: "inet6", Address: "fe80::1", Scope: "link"},
+			{Family: "inet", Address: "10.0.0.5", Scope: "global"},
+		}},
+		"net1": {Type: "broadcast", Addresses: []lxdApi.ContainerStateNetworkAddress{
+			{Family: "inet", Address: "10.1.0.5", Scope: "global"},
+		}},
+	}}}
+
+	assert.Equal(t, "10.1.0.5", c.GetInetAddress([]string{"eth0", "net1", "enp5s0"}))
+	// no configured interface exists
+	assert.Equal(t, "10.0.0.5", c.GetInetAddress([]string{"eth0"}))
+
+	c.state.Network = map[string]lxdApi.ContainerStateNetwork{
+		"lo": {Type: "loopback", Addresses: []lxdApi.ContainerStateNetworkAddress{
+			{Family: "inet", Address: "127.0.0.1", Scope: "global"},
+		}},
+	}
+	assert.Equal(t, "", c.GetInetAddress([]string{"eth0"}))
+}
+
+func TestContainer_CreateID(t *testing.T) {
+	t.Parallel()
+
+	c := &Container{Metadata: ContainerMetadata{Name: "web"}}
+	assert.Regexp(t, "^w[a-z2-7]{15}$", c.CreateID())
+
+	c.NamePrefix = "kube-system_CoreDNS"
+	id := c.CreateID()
+	assert.Regexp(t, "^kube-system-coredns-[a-z2-7]{10}$", id)
+	assert.NotEqual(t, id, c.CreateID())
+
+	c.NamePrefix = strings.Repeat("a", 100)
+	assert.Len(t, c.CreateID(), maxNameLength)
+}
+
+func TestContainer_PathType(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	c := &Container{}
+	c.client = client
+	c.ID = "foo"
+
+	fake.GetContainerFileReturnsOnCall(0, ioutil.NopCloser(strings.NewReader("content")), &lxd.ContainerFileResponse{Type: "file"}, nil)
+	fake.GetContainerFileReturnsOnCall(1, nil, &lxd.ContainerFileResponse{Type: FileTypeDirectory, Entries: []string{"hosts"}}, nil)
+	fake.GetContainerFileReturnsOnCall(2, nil, nil, errors.New("not found"))
+	fake.GetContainerFileReturnsOnCall(3, nil, nil, errors.New("permission denied"))
+
+	fileType, err := c.PathType("/etc/hosts")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", fileType)
+
+	fileType, err = c.PathType("/etc")
+	assert.NoError(t, err)
+	assert.Equal(t, FileTypeDirectory, fileType)
+
+	fileType, err = c.PathType("/missing")
+	assert.NoError(t, err)
+	assert.Empty(t, fileType)
+
+	_, err = c.PathType("/root")
+	assert.Error(t, err)
+
+	name, path := fake.GetContainerFileArgsForCall(0)
+	assert.Equal(t, "foo", name)
+	assert.Equal(t, "/etc/hosts", path)
+}
+
+func TestComposeVendorData(t *testing.T) {
+	t.Parallel()
+
+	// the hostname of the sandbox is added
+	vd, err := composeVendorData("web", "#cloud-config\npackages: [nginx]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nhostname: web\nmanage_etc_hosts: true\npackages:\n- nginx\n", vd)
+
+	// unless the vendor data sets it itself
+	vd, err = composeVendorData("web", "#cloud-config\nhostname: other\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nhostname: other\n", vd)
+
+	// other formats can't be merged
+	vd, err = composeVendorData("web", "#!/bin/sh\necho hi\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho hi\n", vd)
+
+	vd, err = composeVendorData("", "#cloud-config\npackages: [nginx]\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "#cloud-config\npackages: [nginx]\n", vd)
+
+	_, err = composeVendorData("web", "#cloud-config\npackages: [nginx\n")
+	assert.True(t, errors.Is(err, ErrParse))
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateConfig(map[string]string{
+		"limits.memory":           "512MB",
+		"limits.processes":        "100",
+		"security.privileged":     "false",
+		"user.anything":           "goes",
+		"environment.FOO":         "bar",
+		"some.future.lxd.key":     "left for lxd",
+		"limits.disk.priority":    "5",
+		"security.nesting":        "true",
+		"limits.cpu.allowance":    "50ms/100ms",
+		"boot.autostart":          "false",
+		"raw.lxc":                 "lxc.no_new_privs = 1",
+		"limits.kernel.nofile":    "1024",
+		"volatile.eth0.hwaddr":    "00:16:3e:00:00:00",
+		"image.description":       "ubuntu",
+		"linux.kernel_modules":    "overlay",
+		"security.idmap.isolated": "false",
+	}))
+
+	for key, value := range map[string]string{
+		"limits.memory":        "lots",
+		"limits.processes":     "-",
+		"security.privileged":  "maybe",
+		"limits.disk.priority": "11",
+	} {
+		err := validateConfig(map[string]string{"user.valid": "1", key: value})
+		assert.True(t, errors.Is(err, ErrInvalidConfig), key)
+		assert.Contains(t, err.Error(), "config key "+key+":")
+	}
+}
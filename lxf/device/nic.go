@@ -14,7 +14,10 @@ type Nic struct {
 	Name        string
 	NicType     string
 	Parent      string
+	Network     string
 	IPv4Address string
+	// MTU of the nic, empty to use the mtu of the parent
+	MTU string
 }
 
 func (d *Nic) getName() string {
@@ -32,13 +35,25 @@ func (d *Nic) getName() string {
 
 // ToMap returns assigned name or if unset the type specific unique name and serializes the options into a lxd device map
 func (d *Nic) ToMap() (string, map[string]string) {
-	return d.getName(), map[string]string{
+	options := map[string]string{
 		"type":         NicType,
 		"name":         d.Name,
-		"nictype":      d.NicType,
-		"parent":       d.Parent,
 		"ipv4.address": d.IPv4Address,
 	}
+
+	// A nic attached to a managed network gets nictype and parent from the network, lxd refuses them if set
+	if d.Network != "" {
+		options["network"] = d.Network
+	} else {
+		options["nictype"] = d.NicType
+		options["parent"] = d.Parent
+	}
+
+	if d.MTU != "" {
+		options["mtu"] = d.MTU
+	}
+
+	return d.getName(), options
 }
 
 // FromMap loads assigned name (can be empty) and options
@@ -47,6 +62,8 @@ func (d *Nic) FromMap(name string, options map[string]string) error {
 	d.Name = options["name"]
 	d.NicType = options["nictype"]
 	d.Parent = options["parent"]
+	d.Network = options["network"]
+	d.MTU = options["mtu"]
 	d.IPv4Address = options["ipv4.address"]
 
 	return nil
@@ -47,3 +47,25 @@ func TestNic_FromMap(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Exactly(t, exp, d)
 }
+
+func TestNic_ToMap_Network(t *testing.T) {
+	t.Parallel()
+
+	d := &Nic{KeyName: "foo", Name: "ethX", NicType: "bridged", Parent: "brX", Network: "ovn0"}
+	exp := map[string]string{"type": NicType, "name": "ethX", "network": "ovn0", "ipv4.address": ""}
+	n, m := d.ToMap()
+	assert.Equal(t, "foo", n)
+	assert.Equal(t, exp, m)
+}
+
+func TestNic_ToMap_MTU(t *testing.T) {
+	t.Parallel()
+
+	d := &Nic{Name: "ethX", NicType: "bridged", Parent: "brX", MTU: "1450"}
+	_, m := d.ToMap()
+	assert.Equal(t, "1450", m["mtu"])
+
+	loaded := &Nic{}
+	assert.NoError(t, loaded.FromMap("", m))
+	assert.Equal(t, d, loaded)
+}
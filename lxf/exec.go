@@ -1,15 +1,21 @@
 package lxf
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	lxd "github.com/lxc/lxd/client"
+	sharedLXD "github.com/lxc/lxd/shared"
 	lxdApi "github.com/lxc/lxd/shared/api"
 	"github.com/lxc/lxd/shared/logger"
 	"golang.org/x/sys/unix"
@@ -19,12 +25,34 @@ import (
 const (
 	WindowHeightDefault = 24
 	WindowWidthDefault  = 80
+	// termDefault is the TERM of tty sessions if the caller doesn't set one
+	termDefault = "xterm"
 )
 
+// execUserExtension is the api extension of LXD servers which can exec as another uid and gid and in another
+// working directory
+const execUserExtension = "container_exec_user_group_cwd"
+
+// ExecUser is the uid and gid a command is executed as
+type ExecUser struct {
+	UID uint32
+	GID uint32
+}
+
+// execUserPost is the exec request with the uid, gid and working directory, which the vendored LXD client predates
+type execUserPost struct {
+	lxdApi.ContainerExecPost
+	User  uint32 `json:"user"`
+	Group uint32 `json:"group"`
+	Cwd   string `json:"cwd"`
+}
+
 var (
 	ErrExecTimeout     = errors.New("timeout reached")
 	ErrNoControlSocket = errors.New("no control socket found")
 
+	envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 	cancelSignal = unix.SIGTERM
 
 	CodeExecOk      int32 = 0
@@ -33,15 +61,22 @@ var (
 )
 
 // Exec will start a command on the server and attach the provided streams. It will block till the command terminated
-// AND all data was written to stdout/stdin. The caller is responsible to provide a sink which doesn't block.
-func (l *client) Exec(cid string, cmd []string, stdin io.ReadCloser, stdout, stderr io.WriteCloser, interactive, tty bool, timeout int64, resize <-chan remotecommand.TerminalSize) (int32, error) {
-	ses := &session{resize: resize}
+// AND all data was written to stdout/stdin. The caller is responsible to provide a sink which doesn't block. The env
+// is set in addition to the environment of the container. The command runs as root unless a user is given, and in the
+// default directory of LXD exec unless cwd is given.
+func (l *client) Exec(cid string, cmd []string, env map[string]string, user *ExecUser, cwd string, stdin io.ReadCloser, stdout, stderr io.WriteCloser, interactive, tty bool, timeout int64, resize <-chan remotecommand.TerminalSize) (int32, error) {
+	ses := &session{resize: resize, tty: tty}
+
+	environment, err := execEnvironment(env, tty)
+	if err != nil {
+		return CodeExecError, err
+	}
 
 	req := lxdApi.ContainerExecPost{
 		Command:      cmd,
 		WaitForWS:    true,
 		Interactive:  interactive,
-		Environment:  map[string]string{"TERM": "xterm"},
+		Environment:  environment,
 		Width:        WindowWidthDefault,
 		Height:       WindowHeightDefault,
 		RecordOutput: false,
@@ -54,7 +89,7 @@ func (l *client) Exec(cid string, cmd []string, stdin io.ReadCloser, stdout, std
 		DataDone: make(chan bool),
 	}
 
-	op, err := l.server.ExecContainer(cid, req, args)
+	op, err := l.execContainer(cid, req, user, cwd, args)
 	if err != nil {
 		return CodeExecError, err
 	}
@@ -94,8 +129,186 @@ func (l *client) Exec(cid string, cmd []string, stdin io.ReadCloser, stdout, std
 	return int32(exitCode), nil
 }
 
+// execContainer starts the exec and attaches the streams, as user and in the directory cwd if given
+func (l *client) execContainer(cid string, req lxdApi.ContainerExecPost, user *ExecUser, cwd string, args *lxd.ContainerExecArgs) (lxd.Operation, error) {
+	if user == nil && cwd == "" {
+		return l.server.ExecContainer(cid, req, args)
+	}
+
+	if !l.server.HasExtension(execUserExtension) {
+		return nil, fmt.Errorf("%w: lxd server is missing the api extension %v to exec as another user or in another directory", ErrUsage, execUserExtension)
+	}
+
+	post := execUserPost{
+		ContainerExecPost: req,
+		Cwd:               cwd,
+	}
+
+	if user != nil {
+		post.User = user.UID
+		post.Group = user.GID
+	}
+
+	op, _, err := l.server.RawOperation("POST", fmt.Sprintf("/containers/%s/exec", url.PathEscape(cid)), post, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.attachExec(op, req.Interactive, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// attachExec attaches the streams to the websockets of the exec operation, like the LXD client does for its own exec
+// requests. DataDone is closed when all output was received.
+func (l *client) attachExec(op lxd.Operation, interactive bool, args *lxd.ContainerExecArgs) error {
+	opAPI := op.Get()
+	fds := map[string]string{}
+
+	if values, ok := opAPI.Metadata["fds"].(map[string]interface{}); ok {
+		for k, v := range values {
+			fds[k], _ = v.(string)
+		}
+	}
+
+	if args.Control != nil && fds["control"] != "" {
+		conn, err := l.server.GetOperationWebsocket(opAPI.ID, fds["control"])
+		if err != nil {
+			return err
+		}
+
+		go args.Control(conn)
+	}
+
+	if interactive {
+		conn, err := l.server.GetOperationWebsocket(opAPI.ID, fds["0"])
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			sharedLXD.WebsocketSendStream(conn, args.Stdin, -1)
+			<-sharedLXD.WebsocketRecvStream(args.Stdout, conn)
+			conn.Close()
+			close(args.DataDone)
+		}()
+
+		return nil
+	}
+
+	conns := []*websocket.Conn{}
+	dones := map[string]chan bool{}
+
+	for _, fd := range []string{"0", "1", "2"} {
+		if fds[fd] == "" {
+			continue
+		}
+
+		conn, err := l.server.GetOperationWebsocket(opAPI.ID, fds[fd])
+		if err != nil {
+			return err
+		}
+
+		conns = append(conns, conn)
+
+		switch fd {
+		case "0":
+			dones[fd] = sharedLXD.WebsocketSendStream(conn, args.Stdin, -1)
+		case "1":
+			dones[fd] = sharedLXD.WebsocketRecvStream(args.Stdout, conn)
+		case "2":
+			dones[fd] = sharedLXD.WebsocketRecvStream(args.Stderr, conn)
+		}
+	}
+
+	go func() {
+		for fd, done := range dones {
+			if fd != "0" {
+				<-done
+			}
+		}
+
+		// stdin may be stuck in a read, so it's closed instead of waited for
+		if done, has := dones["0"]; has {
+			args.Stdin.Close()
+
+			go func() { <-done }()
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		close(args.DataDone)
+	}()
+
+	return nil
+}
+
+// execEnvironment validates the names of the variables in env and returns a copy of it. A tty gets TERM set if env
+// doesn't define it.
+func execEnvironment(env map[string]string, tty bool) (map[string]string, error) {
+	environment := map[string]string{}
+
+	for k, v := range env {
+		if !envKeyRegex.MatchString(k) {
+			return nil, fmt.Errorf("%w: invalid environment variable name %q", ErrUsage, k)
+		}
+
+		environment[k] = v
+	}
+
+	if _, has := environment["TERM"]; tty && !has {
+		environment["TERM"] = termDefault
+	}
+
+	return environment, nil
+}
+
+// ExecCombined runs a command without tty and returns stdout and stderr combined in a single buffer in the order the
+// output was received
+func ExecCombined(l Client, cid string, cmd []string, timeout int64) ([]byte, int32, error) {
+	out := &CombinedOutput{}
+	stdin := ioutil.NopCloser(bytes.NewReader(nil))
+
+	code, err := l.Exec(cid, cmd, nil, nil, "", stdin, out, out, false, false, timeout, nil)
+
+	return out.Bytes(), code, err
+}
+
+// CombinedOutput is a sink for both stdout and stderr of an exec, which preserves the order of the writes
+type CombinedOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends p to the output
+func (o *CombinedOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.buf.Write(p)
+}
+
+// Close does nothing, as the output must be readable after the exec
+func (o *CombinedOutput) Close() error {
+	return nil
+}
+
+// Bytes returns the output written so far
+func (o *CombinedOutput) Bytes() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]byte{}, o.buf.Bytes()...)
+}
+
 type session struct {
 	resize  <-chan remotecommand.TerminalSize
+	tty     bool
 	control *websocket.Conn
 }
 
@@ -107,13 +320,24 @@ func (s *session) controlHandler(control *websocket.Conn) {
 
 func (s *session) listenResize() {
 	for r := range s.resize {
-		err := s.sendResize(r)
+		err := s.handleResize(r)
 		if err != nil {
 			logger.Errorf("session control failed: %v", err)
 		}
 	}
 }
 
+// handleResize sends the window size if the exec has a tty. Misbehaving clients may send resizes without a tty, which
+// are drained but ignored, as there's no window to resize.
+func (s *session) handleResize(r remotecommand.TerminalSize) error {
+	if !s.tty {
+		logger.Debugf("session control ignores window size %vx%v without tty", r.Width, r.Height)
+		return nil
+	}
+
+	return s.sendResize(r)
+}
+
 func (s *session) sendResize(r remotecommand.TerminalSize) error {
 	width := strconv.FormatUint(uint64(r.Width), 10)
 	height := strconv.FormatUint(uint64(r.Height), 10)
@@ -1,6 +1,7 @@
 package lxf
 
 import (
+	"errors"
 	"strconv"
 	"sync"
 	"testing"
@@ -33,11 +34,127 @@ func TestClient_Exec_BasicOk(t *testing.T) {
 		},
 	})
 
-	exitCode, err := client.Exec("", nil, nil, nil, nil, false, false, 0, nil)
+	exitCode, err := client.Exec("", nil, nil, nil, "", nil, nil, nil, false, false, 0, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, CodeExecError, exitCode)
 }
 
+func TestClient_Exec_Environment(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.ExecContainerCalls(func(arg1 string, arg2 lxdApi.ContainerExecPost, arg3 *lxd.ContainerExecArgs) (lxd.Operation, error) {
+		go sendDataDone(arg3, 0)
+
+		return fakeOp, nil
+	})
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(CodeExecOk),
+		},
+	})
+
+	_, err := client.Exec("", nil, map[string]string{"DEBUG": "1"}, nil, "", nil, nil, nil, false, true, 0, nil)
+	assert.NoError(t, err)
+
+	_, req, _ := fake.ExecContainerArgsForCall(0)
+	assert.Equal(t, map[string]string{"DEBUG": "1", "TERM": "xterm"}, req.Environment)
+
+	_, err = client.Exec("", nil, map[string]string{"TERM": "vt100"}, nil, "", nil, nil, nil, false, true, 0, nil)
+	assert.NoError(t, err)
+
+	_, req, _ = fake.ExecContainerArgsForCall(1)
+	assert.Equal(t, map[string]string{"TERM": "vt100"}, req.Environment)
+}
+
+func TestClient_Exec_User(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(CodeExecOk),
+		},
+	})
+
+	fake.RawOperationReturns(fakeOp, "", nil)
+
+	// older lxd servers can't exec as another user
+	exitCode, err := client.Exec("foo", []string{"id"}, nil, &ExecUser{UID: 1000, GID: 100}, "", nil, nil, nil, false, false, 0, nil)
+	assert.True(t, errors.Is(err, ErrUsage))
+	assert.Equal(t, CodeExecError, exitCode)
+	assert.Equal(t, 0, fake.RawOperationCallCount())
+
+	fake.HasExtensionReturns(true)
+
+	exitCode, err = client.Exec("foo", []string{"id"}, nil, &ExecUser{UID: 1000, GID: 100}, "", nil, nil, nil, false, false, 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, CodeExecOk, exitCode)
+	assert.Equal(t, 0, fake.ExecContainerCallCount())
+
+	method, path, data, _ := fake.RawOperationArgsForCall(0)
+	assert.Equal(t, "POST", method)
+	assert.Equal(t, "/containers/foo/exec", path)
+
+	req, ok := data.(execUserPost)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"id"}, req.Command)
+	assert.Equal(t, uint32(1000), req.User)
+	assert.Equal(t, uint32(100), req.Group)
+	assert.Equal(t, "", req.Cwd)
+}
+
+func TestClient_Exec_Cwd(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(CodeExecOk),
+		},
+	})
+
+	fake.RawOperationReturns(fakeOp, "", nil)
+
+	// older lxd servers can't exec in another directory
+	exitCode, err := client.Exec("foo", []string{"pwd"}, nil, nil, "/srv/app", nil, nil, nil, false, false, 0, nil)
+	assert.True(t, errors.Is(err, ErrUsage))
+	assert.Equal(t, CodeExecError, exitCode)
+	assert.Equal(t, 0, fake.RawOperationCallCount())
+
+	fake.HasExtensionReturns(true)
+
+	exitCode, err = client.Exec("foo", []string{"pwd"}, nil, nil, "/srv/app", nil, nil, nil, false, false, 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, CodeExecOk, exitCode)
+	assert.Equal(t, 0, fake.ExecContainerCallCount())
+
+	_, _, data, _ := fake.RawOperationArgsForCall(0)
+
+	req, ok := data.(execUserPost)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"pwd"}, req.Command)
+	assert.Equal(t, "/srv/app", req.Cwd)
+	// root, as without a user
+	assert.Equal(t, uint32(0), req.User)
+	assert.Equal(t, uint32(0), req.Group)
+}
+
+func TestClient_Exec_InvalidEnvironment(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+
+	exitCode, err := client.Exec("", nil, map[string]string{"NOT-VALID": "1"}, nil, "", nil, nil, nil, false, false, 0, nil)
+	assert.True(t, errors.Is(err, ErrUsage))
+	assert.Equal(t, CodeExecError, exitCode)
+	assert.Equal(t, 0, fake.ExecContainerCallCount())
+}
+
 func TestClient_Exec_Timeout(t *testing.T) {
 	t.Parallel()
 
@@ -62,7 +179,7 @@ func TestClient_Exec_Timeout(t *testing.T) {
 		},
 	})
 
-	exitCode, err := client.Exec("", nil, nil, nil, nil, false, false, 1, nil)
+	exitCode, err := client.Exec("", nil, nil, nil, "", nil, nil, nil, false, false, 1, nil)
 	assert.Error(t, err)
 	assert.Exactly(t, ErrExecTimeout, err)
 	assert.Equal(t, CodeExecTimeout, exitCode)
@@ -95,7 +212,7 @@ func TestClient_Exec_Resize(t *testing.T) {
 		},
 	})
 
-	exitCode, err := client.Exec("", nil, nil, nil, nil, false, false, 0, fakeSes.resize)
+	exitCode, err := client.Exec("", nil, nil, nil, "", nil, nil, nil, false, false, 0, fakeSes.resize)
 	assert.NoError(t, err)
 	assert.Equal(t, CodeExecOk, exitCode)
 
@@ -109,6 +226,46 @@ func TestClient_Exec_Resize(t *testing.T) {
 	<-resizeConsumed
 }
 
+func TestClient_Exec_ResizeWithoutTTY(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+	resize := make(chan remotecommand.TerminalSize)
+
+	fake.ExecContainerCalls(func(arg1 string, arg2 lxdApi.ContainerExecPost, arg3 *lxd.ContainerExecArgs) (lxd.Operation, error) {
+		// the session of the exec itself listens for resizes, without a control socket
+		arg3.Control(nil)
+		go sendDataDone(arg3, 0)
+
+		return fakeOp, nil
+	})
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(0),
+		},
+	})
+
+	exitCode, err := client.Exec("", nil, nil, nil, "", nil, nil, nil, false, false, 0, resize)
+	assert.NoError(t, err)
+	assert.Equal(t, CodeExecOk, exitCode)
+
+	// a stray resize is still drained, so the client isn't blocked
+	resize <- remotecommand.TerminalSize{Width: 60, Height: 40}
+
+	close(resize)
+}
+
+func TestSession_handleResize(t *testing.T) {
+	t.Parallel()
+
+	size := remotecommand.TerminalSize{Width: 60, Height: 40}
+
+	// nothing is sent without tty, so the missing control socket doesn't matter
+	assert.NoError(t, (&session{}).handleResize(size))
+	assert.Exactly(t, ErrNoControlSocket, (&session{tty: true}).handleResize(size))
+}
+
 func TestClient_Exec_Parallel(t *testing.T) {
 	t.Parallel()
 
@@ -138,7 +295,7 @@ func TestClient_Exec_Parallel(t *testing.T) {
 
 	for i := 0; i < n; i++ {
 		go func(i int) {
-			exitCode, err := client.Exec("", []string{strconv.Itoa(i)}, nil, nil, nil, false, false, 0, nil)
+			exitCode, err := client.Exec("", []string{strconv.Itoa(i)}, nil, nil, "", nil, nil, nil, false, false, 0, nil)
 			assert.NoError(t, err)
 			assert.Equal(t, int32(i), exitCode)
 			wg.Done()
@@ -287,3 +444,32 @@ func sendDataDone(args *lxd.ContainerExecArgs, sleep time.Duration) {
 	}
 	args.DataDone <- true
 }
+
+func TestExecCombined(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.ExecContainerCalls(func(arg1 string, arg2 lxdApi.ContainerExecPost, arg3 *lxd.ContainerExecArgs) (lxd.Operation, error) {
+		_, _ = arg3.Stdout.Write([]byte("out1\n"))
+		_, _ = arg3.Stderr.Write([]byte("err1\n"))
+		_, _ = arg3.Stdout.Write([]byte("out2\n"))
+
+		go sendDataDone(arg3, 0)
+
+		return fakeOp, nil
+	})
+	fakeOp.WaitReturns(nil)
+
+	fakeOp.GetReturns(lxdApi.Operation{
+		Metadata: map[string]interface{}{
+			"return": float64(CodeExecOk),
+		},
+	})
+
+	out, exitCode, err := ExecCombined(client, "foo", []string{"true"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, CodeExecOk, exitCode)
+	assert.Equal(t, "out1\nerr1\nout2\n", string(out))
+}
@@ -18,8 +18,8 @@ type Image struct {
 	Size    int64
 }
 
-// PullImage copies the given image from the remote server
-func (l *client) PullImage(name string) (string, error) {
+// PullImage copies the given image from the remote server. If progress is set, it's called with the download progress
+func (l *client) PullImage(name string, progress func(string)) (string, error) {
 	imageID, err := l.parseImage(name)
 	if err != nil {
 		return "", err
@@ -45,7 +45,7 @@ func (l *client) PullImage(name string) (string, error) {
 		AutoUpdate:  true,  // Maybe bug: currently NOT a technical requirement to know where the source is
 	}
 
-	err = l.opwait.CopyImage(imgServer, *image, &args)
+	err = l.opwait.CopyImage(imgServer, *image, &args, progress)
 	if err != nil {
 		return "", fmt.Errorf("unable to pull requested image %v from server %v, %w",
 			image, imageID.Remote, err)
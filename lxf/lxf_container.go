@@ -53,7 +53,7 @@ func (l *client) ListContainers() ([]*Container, error) {
 		return nil, err
 	}
 
-	var cl = []*Container{}
+	var cl = make([]*Container, 0, len(cts))
 
 	for _, ct := range cts {
 		ct := ct // pin!
@@ -72,6 +72,28 @@ func (l *client) ListContainers() ([]*Container, error) {
 	return cl, nil
 }
 
+// parseIdmap parses the idmap lxd stores as json. It's only informative, so an invalid one is logged and ignored rather
+// than failing to read the container.
+func parseIdmap(id, idmap string) []IdmapEntry {
+	if idmap == "" {
+		return nil
+	}
+
+	entries := []IdmapEntry{}
+
+	err := json.Unmarshal([]byte(idmap), &entries)
+	if err != nil {
+		logger.Warnf("container %v has an invalid idmap %q: %v", id, idmap, err)
+		return nil
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return entries
+}
+
 // toContainer will convert an lxd container to lxf format
 func (l *client) toContainer(ct *api.Container, etag string) (*Container, error) { // nolint: gocognit
 	var err error
@@ -92,28 +114,32 @@ func (l *client) toContainer(ct *api.Container, etag string) (*Container, error)
 		}
 	}
 
-	createdAt := time.Time{}.UnixNano()
-	if createdAtS, is := ct.Config[cfgCreatedAt]; is {
-		createdAt, err = strconv.ParseInt(createdAtS, 10, 64)
+	var nesting bool
+	if nestingS, is := ct.Config[cfgSecurityNesting]; is {
+		nesting, err = strconv.ParseBool(nestingS)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	startedAt := time.Time{}.UnixNano()
-	if startedAtS, is := ct.Config[cfgStartedAt]; is {
-		startedAt, err = strconv.ParseInt(startedAtS, 10, 64)
-		if err != nil {
-			return nil, err
-		}
+	createdAt, err := parseTimestamp(ct.Config[cfgCreatedAt])
+	if err != nil {
+		return nil, err
 	}
 
-	finishedAt := time.Time{}.UnixNano()
-	if finishedAtS, is := ct.Config[cfgFinishedAt]; is {
-		finishedAt, err = strconv.ParseInt(finishedAtS, 10, 64)
-		if err != nil {
-			return nil, err
-		}
+	startedAt, err := parseTimestamp(ct.Config[cfgStartedAt])
+	if err != nil {
+		return nil, err
+	}
+
+	finishedAt, err := parseTimestamp(ct.Config[cfgFinishedAt])
+	if err != nil {
+		return nil, err
+	}
+
+	restartedAt, err := parseTimestamp(ct.Config[cfgRestartedAt])
+	if err != nil {
+		return nil, err
 	}
 
 	c := &Container{}
@@ -121,6 +147,7 @@ func (l *client) toContainer(ct *api.Container, etag string) (*Container, error)
 
 	c.ID = ct.Name
 	c.ETag = etag
+	c.location = ct.Location
 	c.Image = ct.Config[cfgVolatileBaseImage]
 	c.Metadata = ContainerMetadata{
 		Name:    ct.Config[cfgMetaName],
@@ -131,15 +158,25 @@ func (l *client) toContainer(ct *api.Container, etag string) (*Container, error)
 	c.Config = containerConfigStore.UnreservedMap(ct.Config)
 	c.LogPath = ct.Config[cfgLogPath]
 
-	c.CreatedAt = time.Unix(0, createdAt)
-	c.StartedAt = time.Unix(0, startedAt)
-	c.FinishedAt = time.Unix(0, finishedAt)
+	c.CreatedAt = createdAt
+	c.StartedAt = startedAt
+	c.FinishedAt = finishedAt
+	c.RestartedAt = restartedAt
 
 	c.Environment = extractEnvVars(ct.Config)
 	c.Privileged = privileged
+	c.Nesting = nesting
 	c.CloudInitUserData = ct.Config[cfgCloudInitUserData]
 	c.CloudInitMetaData = ct.Config[cfgCloudInitMetaData]
 	c.CloudInitNetworkConfig = ct.Config[cfgCloudInitNetworkConfig]
+	c.CloudInitVendorData = ct.Config[cfgCloudInitVendorData]
+	c.RestoreCheckpoint = ct.Config[cfgRestoreCheckpoint]
+	c.ExitReason = ct.Config[cfgExitReason]
+	c.ExitMessage = ct.Config[cfgExitMessage]
+
+	if !privileged {
+		c.Idmap = parseIdmap(c.ID, ct.Config[cfgVolatileIdmap])
+	}
 
 	// get devices
 	for name, options := range ct.Devices {
@@ -198,10 +235,17 @@ func (l *client) toContainer(ct *api.Container, etag string) (*Container, error)
 	}
 
 	// Map status code of LXD to CRI
-	switch ct.StatusCode { // nolint: exhaustive
-	case api.Running:
+	switch {
+	case c.Config[cfgState] == string(ContainerStateCreating):
+		// still being set up, it may run transiently for hooks meanwhile
+		c.StateName = ContainerStateCreating
+	case ct.StatusCode == api.Running, ct.StatusCode == api.Thawed:
 		c.StateName = ContainerStateRunning
-	case api.Stopped, api.Aborting, api.Stopping:
+	case ct.StatusCode == api.Frozen, ct.StatusCode == api.Freezing:
+		// the processes are still there, only frozen
+		c.StateName = ContainerStateRunning
+		c.Frozen = true
+	case ct.StatusCode == api.Stopped, ct.StatusCode == api.Aborting, ct.StatusCode == api.Stopping:
 		// we have to differentiate between stopped and created. If "user.state" exists, then it must be created, otherwise
 		// its exited
 		if state, has := c.Config[cfgState]; has && state == string(ContainerStateCreated) {
@@ -210,12 +254,57 @@ func (l *client) toContainer(ct *api.Container, etag string) (*Container, error)
 			c.StateName = ContainerStateExited
 		}
 	default:
+		logger.Warnf("container %v has unexpected lxd status %v, reporting it as unknown", c.ID, ct.StatusCode)
 		c.StateName = ContainerStateUnknown
 	}
 
+	// lxd records each start, so starts without lxe, like lxc restart, are noticed as well. Created containers may have
+	// been started transiently for hooks only.
+	if c.StateName != ContainerStateCreating && c.StateName != ContainerStateCreated && ct.LastUsedAt.After(c.StartedAt) {
+		c.markStarted(ct.LastUsedAt)
+	}
+
 	return c, nil
 }
 
+// recordMissedExits records the exits of containers which stopped on their own while no events were received, e.g. as
+// lxe wasn't running, at the time they are noticed. It runs whenever the events are connected, so reading the status of
+// a container never writes to lxd. Failures are only logged.
+func (l *client) recordMissedExits() {
+	cl, err := l.ListContainers()
+	if err != nil {
+		logger.Errorf("unable to list containers to record missed exits: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, c := range cl {
+		if c.StateName != ContainerStateExited || !c.recordExit(now) {
+			continue
+		}
+
+		// listed containers have no etag, so it's loaded again to be updated
+		fresh, err := l.GetContainer(c.ID)
+		if err != nil {
+			logger.Errorf("ContainerID %v trying to get container to record missed exit: %v", c.ID, err)
+			continue
+		}
+
+		if fresh.StateName != ContainerStateExited || !fresh.recordExit(now) {
+			continue
+		}
+
+		err = fresh.Apply()
+		if err != nil {
+			logger.Errorf("ContainerID %v trying to record missed exit: %v", c.ID, err)
+			continue
+		}
+
+		logger.Infof("ContainerID %v exited unnoticed, recorded the exit at %v", c.ID, now)
+	}
+}
+
 type EventHandler interface {
 	ContainerStarted(ctx context.Context, c *Container) error
 	ContainerStopped(ctx context.Context, c *Container) error
@@ -256,6 +345,12 @@ func (l *client) lifecycleEventHandler(event api.Event) {
 		return
 	}
 
+	// the container is only started transiently to run its post-create hook, which isn't a start for the runtime
+	if c.Creating() {
+		logger.Debugf("lifecycle: ignoring event %v of container %v being created", eventLifecycle.Action, containerID)
+		return
+	}
+
 	logger.Infof("EventHandler: Type %v ContainerID %v", event.Type, containerID)
 
 	switch eventLifecycle.Action {
@@ -266,6 +361,13 @@ func (l *client) lifecycleEventHandler(event api.Event) {
 			return
 		}
 	case "container-stopped":
+		if c.recordExit(time.Now()) {
+			err := c.Apply()
+			if err != nil {
+				logger.Warnf("lifecycle: ContainerID %v trying to record exit: %v", containerID, err)
+			}
+		}
+
 		err := l.eventHandler.ContainerStopped(context.TODO(), c)
 		if err != nil {
 			logger.Errorf("lifecycle: handling event %v for container %v failed: %v", eventLifecycle.Action, containerID, err)
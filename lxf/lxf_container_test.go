@@ -163,6 +163,7 @@ func TestClient_toContainer_AllFieldsSuccessful(t *testing.T) {
 				cfgFinishedAt:                    strconv.FormatInt(future.UnixNano(), 10),
 				cfgEnvironmentPrefix + ".data":   "content",
 				cfgSecurityPrivileged:            "true",
+				cfgSecurityNesting:               "true",
 				cfgCloudInitUserData:             "userData",
 				cfgCloudInitMetaData:             "metaData",
 				cfgCloudInitNetworkConfig:        "networkConfig",
@@ -192,6 +193,7 @@ func TestClient_toContainer_AllFieldsSuccessful(t *testing.T) {
 	exp.Profiles = []string{"profile"}
 	exp.Image = "image"
 	exp.Privileged = true
+	exp.Nesting = true
 	exp.Environment = map[string]string{"data": "content"}
 	exp.Labels = map[string]string{"alabel": "aLabel"}
 	exp.Annotations = map[string]string{"anannotation": "anAnnotation"}
@@ -228,3 +230,112 @@ func TestClient_toContainer_AllFieldsSuccessful(t *testing.T) {
 }
 
 // TODO lifecycle event handler, but first network modes need an interface
+
+func TestClient_toContainer_StartedWithoutLXE(t *testing.T) {
+	t.Parallel()
+
+	client, _ := testClient()
+
+	created := time.Unix(0, time.Now().Add(-2*time.Hour).UnixNano())
+	started := created.Add(time.Minute)
+	restarted := started.Add(time.Hour)
+
+	ct := basicContainer("foo", "bar")
+	ct.Config[cfgCreatedAt] = strconv.FormatInt(created.UnixNano(), 10)
+	ct.Config[cfgStartedAt] = strconv.FormatInt(started.UnixNano(), 10)
+	ct.StatusCode = api.Running
+	ct.LastUsedAt = restarted
+
+	// restarted with lxc restart
+	c, err := client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.Equal(t, created, c.CreatedAt)
+	assert.Equal(t, restarted, c.StartedAt)
+	assert.Equal(t, restarted, c.RestartedAt)
+
+	// lxe records the start after lxd
+	ct.LastUsedAt = started.Add(-time.Second)
+
+	c, err = client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.Equal(t, started, c.StartedAt)
+	assert.True(t, c.RestartedAt.IsZero())
+
+	// hooks start created containers transiently
+	ct.StatusCode = api.Stopped
+	ct.Config[cfgState] = string(ContainerStateCreated)
+	ct.Config[cfgStartedAt] = strconv.FormatInt(time.Time{}.UnixNano(), 10)
+	ct.LastUsedAt = restarted
+
+	c, err = client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.True(t, c.StartedAt.IsZero())
+}
+
+func TestClient_toContainer_Idmap(t *testing.T) {
+	t.Parallel()
+
+	client, _ := testClient()
+
+	ct := basicContainer("foo", "bar")
+	ct.Config[cfgVolatileIdmap] = `[{"Isuid":true,"Isgid":false,"Hostid":1000000,"Nsid":0,"Maprange":65536},` +
+		`{"Isuid":false,"Isgid":true,"Hostid":1000000,"Nsid":0,"Maprange":65536}]`
+
+	c, err := client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []IdmapEntry{
+		{UID: true, HostID: 1000000, ContainerID: 0, Range: 65536},
+		{GID: true, HostID: 1000000, ContainerID: 0, Range: 65536},
+	}, c.Idmap)
+	assert.NotContains(t, c.Config, cfgVolatileIdmap)
+
+	// lxd keeps an empty idmap for privileged containers
+	ct.Config[cfgSecurityPrivileged] = "true"
+
+	c, err = client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.Nil(t, c.Idmap)
+
+	// an invalid idmap doesn't make the container unreadable
+	ct.Config[cfgSecurityPrivileged] = "false"
+	ct.Config[cfgVolatileIdmap] = "[{"
+
+	c, err = client.toContainer(ct, "")
+	assert.NoError(t, err)
+	assert.Nil(t, c.Idmap)
+}
+
+func TestClient_toContainer_State(t *testing.T) {
+	t.Parallel()
+
+	client, _ := testClient()
+
+	tests := []struct {
+		status api.StatusCode
+		state  string
+		exp    ContainerStateName
+		frozen bool
+	}{
+		{api.Stopped, string(ContainerStateCreating), ContainerStateCreating, false},
+		// hooks run while it's set up
+		{api.Running, string(ContainerStateCreating), ContainerStateCreating, false},
+		{api.Stopped, string(ContainerStateCreated), ContainerStateCreated, false},
+		{api.Stopped, "", ContainerStateExited, false},
+		{api.Running, "", ContainerStateRunning, false},
+		{api.Error, "", ContainerStateUnknown, false},
+		{api.Frozen, "", ContainerStateRunning, true},
+		{api.Freezing, "", ContainerStateRunning, true},
+		{api.Thawed, "", ContainerStateRunning, false},
+	}
+
+	for _, tt := range tests {
+		ct := basicContainer("foo", "bar")
+		ct.StatusCode = tt.status
+		ct.Config[cfgState] = tt.state
+
+		c, err := client.toContainer(ct, "")
+		assert.NoError(t, err)
+		assert.Equal(t, tt.exp, c.StateName, "status %v with state %q", tt.status, tt.state)
+		assert.Equal(t, tt.frozen, c.Frozen, "status %v with state %q", tt.status, tt.state)
+	}
+}
@@ -47,7 +47,7 @@ func (l *client) ListSandboxes() ([]*Sandbox, error) {
 		return nil, err
 	}
 
-	var sl = []*Sandbox{}
+	var sl = make([]*Sandbox, 0, len(ps))
 
 	for _, p := range ps {
 		p := p // pin!
@@ -92,6 +92,13 @@ func (l *client) toSandbox(p *api.Profile, etag string) (*Sandbox, error) {
 	s.ID = p.Name
 	s.ETag = etag
 	s.Hostname = p.Config[cfgHostname]
+	s.LastIP = p.Config[cfgLastIP]
+
+	s.RuntimeHandler = p.Config[cfgRuntimeHandler]
+	if s.RuntimeHandler == "" {
+		s.RuntimeHandler = DefaultRuntimeHandler
+	}
+
 	s.LogDirectory = p.Config[cfgLogDirectory]
 	s.Metadata = SandboxMetadata{
 		Attempt:   uint32(attempt),
@@ -100,8 +107,8 @@ func (l *client) toSandbox(p *api.Profile, etag string) (*Sandbox, error) {
 		UID:       p.Config[cfgMetaUID],
 	}
 	s.NetworkConfig = NetworkConfig{
-		Nameservers: strings.Split(p.Config[cfgNetworkConfigNameservers], ","),
-		Searches:    strings.Split(p.Config[cfgNetworkConfigSearches], ","),
+		Nameservers: splitList(p.Config[cfgNetworkConfigNameservers]),
+		Searches:    splitList(p.Config[cfgNetworkConfigSearches]),
 		Mode:        getNetworkMode(p.Config[cfgNetworkConfigMode]),
 		ModeData:    make(map[string]string),
 	}
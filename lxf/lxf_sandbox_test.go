@@ -45,6 +45,7 @@ func TestClient_GetSandbox_Minimal(t *testing.T) {
 	s, err := client.GetSandbox("foo")
 	assert.NoError(t, err)
 	assert.Equal(t, "foo", s.ID)
+	assert.Equal(t, DefaultRuntimeHandler, s.RuntimeHandler)
 	assert.Equal(t, "foo", fake.GetProfileArgsForCall(0))
 	assert.Equal(t, 1, fake.GetProfileCallCount())
 }
@@ -150,6 +151,8 @@ func TestClient_toSandbox_AllFieldsSuccessful(t *testing.T) {
 				cfgMetaAttempt:                   "1",
 				cfgCreatedAt:                     strconv.FormatInt(now.UnixNano(), 10),
 				cfgHostname:                      "hostname",
+				cfgLastIP:                        "10.0.0.5",
+				cfgRuntimeHandler:                "vm",
 				cfgLogDirectory:                  "logDirectory",
 				cfgNetworkConfigNameservers:      "1.2.3.4,5.6.7.8",
 				cfgNetworkConfigSearches:         "svc.local,local",
@@ -186,6 +189,8 @@ func TestClient_toSandbox_AllFieldsSuccessful(t *testing.T) {
 	exp.Metadata.Namespace = "metaNamespace"
 	exp.Metadata.UID = "metaUID"
 	exp.Hostname = "hostname"
+	exp.LastIP = "10.0.0.5"
+	exp.RuntimeHandler = "vm"
 	exp.NetworkConfig.Nameservers = []string{"1.2.3.4", "5.6.7.8"}
 	exp.NetworkConfig.Searches = []string{"svc.local", "local"}
 	exp.NetworkConfig.Mode = NetworkNone
@@ -197,3 +202,60 @@ func TestClient_toSandbox_AllFieldsSuccessful(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Exactly(t, exp, s)
 }
+
+func TestClient_toSandbox_NoDNS(t *testing.T) {
+	t.Parallel()
+
+	client, _ := testClient()
+
+	s, err := client.toSandbox(basicProfile("foo"), "")
+	assert.NoError(t, err)
+	assert.Nil(t, s.NetworkConfig.Nameservers)
+	assert.Nil(t, s.NetworkConfig.Searches)
+
+	// saving it again doesn't add an empty nameserver
+	put, err := s.makeProfile()
+	assert.NoError(t, err)
+	assert.NotContains(t, put.Config[cfgCloudInitNetworkConfig], "nameserver")
+}
+
+func TestSandbox_makeProfile_DNS(t *testing.T) {
+	t.Parallel()
+
+	client, _ := testClient()
+
+	s := client.NewSandbox()
+	s.NetworkConfig.Nameservers = []string{"1.1.1.1"}
+
+	put, err := s.makeProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, `config:
+- address:
+  - 1.1.1.1
+  type: nameserver
+version: 1
+`, put.Config[cfgCloudInitNetworkConfig])
+
+	s.NetworkConfig.Nameservers = nil
+	s.NetworkConfig.Searches = []string{"example.com"}
+
+	put, err = s.makeProfile()
+	assert.NoError(t, err)
+	assert.Equal(t, `config:
+- search:
+  - example.com
+  type: nameserver
+version: 1
+`, put.Config[cfgCloudInitNetworkConfig])
+}
+
+func TestGetSandboxState(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, SandboxReady, getSandboxState("ready"))
+	assert.Equal(t, SandboxNotReady, getSandboxState("notready"))
+	// recorded by older versions
+	assert.Equal(t, SandboxReady, getSandboxState(""))
+	// unexpected states are kept
+	assert.Equal(t, SandboxState("broken"), getSandboxState("broken"))
+}
@@ -0,0 +1,438 @@
+// Package lxftest provides an in-memory lxd, so code using lxf can be tested without a live lxd
+package lxftest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	"github.com/automaticserver/lxe/shared"
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/shared/api"
+)
+
+// ErrProfileInUse is returned when deleting a profile which containers still use, as lxd refuses that
+var ErrProfileInUse = errors.New("profile is currently in use")
+
+// Server is an lxd which keeps profiles, containers and image aliases in memory. It builds on the fake of lxdfakes, so
+// all calls are recorded and each method can be programmed with Returns or Stub like any fake, which replaces the
+// in-memory behaviour of that method. Methods without in-memory behaviour return zero values. ETags aren't checked.
+type Server struct {
+	*lxdfakes.FakeContainerServer
+
+	mu         sync.Mutex
+	profiles   map[string]api.Profile
+	containers map[string]api.Container
+	aliases    map[string]string
+	archs      map[string]string
+	files      map[string]string
+	etag       int
+}
+
+// NewServer returns an empty in-memory lxd
+func NewServer() *Server {
+	s := &Server{
+		FakeContainerServer: &lxdfakes.FakeContainerServer{},
+		profiles:            map[string]api.Profile{},
+		containers:          map[string]api.Container{},
+		aliases:             map[string]string{},
+		archs:               map[string]string{},
+		files:               map[string]string{},
+	}
+
+	s.GetServerStub = s.getServer
+	s.GetProfileStub = s.getProfile
+	s.GetProfilesStub = s.getProfiles
+	s.CreateProfileStub = s.createProfile
+	s.UpdateProfileStub = s.updateProfile
+	s.DeleteProfileStub = s.deleteProfile
+	s.GetContainerStub = s.getContainer
+	s.GetContainersStub = s.getContainers
+	s.GetContainerStateStub = s.getContainerState
+	s.CreateContainerStub = s.createContainer
+	s.UpdateContainerStub = s.updateContainer
+	s.UpdateContainerStateStub = s.updateContainerState
+	s.DeleteContainerStub = s.deleteContainer
+	s.GetContainerFileStub = s.getContainerFile
+	s.GetImageAliasStub = s.getImageAlias
+	s.GetImageStub = s.getImage
+
+	return s
+}
+
+// AddImage makes the image available locally under the alias, which includes the remote it was pulled from like
+// "local/busybox"
+func (s *Server) AddImage(alias, fingerprint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aliases[alias] = fingerprint
+}
+
+// SetImageArchitecture sets the architecture the image is built for, which is x86_64 like the server otherwise
+func (s *Server) SetImageArchitecture(fingerprint, arch string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.archs[fingerprint] = arch
+}
+
+// AddFile makes the path exist in the filesystem of all containers, with the lxd file type like "file" or "directory"
+func (s *Server) AddFile(path, fileType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[path] = fileType
+}
+
+// SetLocation places the container on the cluster member, as lxd reports it if clustered
+func (s *Server) SetLocation(name, member string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct := s.containers[name]
+	ct.Location = member
+	s.containers[name] = ct
+}
+
+// ProfileNames returns the names of all profiles, sorted
+func (s *Server) ProfileNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ContainerNames returns the names of all containers, sorted
+func (s *Server) ContainerNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.containers))
+	for name := range s.containers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *Server) nextETag() string {
+	s.etag++
+
+	return strconv.Itoa(s.etag)
+}
+
+func (s *Server) getServer() (*api.Server, string, error) {
+	return &api.Server{
+		ServerUntrusted: api.ServerUntrusted{APIVersion: "1.0"},
+		Environment:     api.ServerEnvironment{Architectures: []string{"x86_64"}, Server: "lxd", ServerVersion: "3.0.0"},
+	}, "", nil
+}
+
+func (s *Server) getProfile(name string) (*api.Profile, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, has := s.profiles[name]
+	if !has {
+		return nil, "", shared.NewErrNotFound()
+	}
+
+	p.UsedBy = s.usedBy(name)
+
+	return &p, s.nextETag(), nil
+}
+
+func (s *Server) getProfiles() ([]api.Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := make([]api.Profile, 0, len(s.profiles))
+	for name, p := range s.profiles {
+		p.UsedBy = s.usedBy(name)
+		ps = append(ps, p)
+	}
+
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name < ps[j].Name })
+
+	return ps, nil
+}
+
+// usedBy lists the containers using the profile like lxd does
+func (s *Server) usedBy(profile string) []string {
+	used := []string{}
+
+	for name, ct := range s.containers {
+		for _, p := range ct.Profiles {
+			if p == profile {
+				used = append(used, "/1.0/containers/"+name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(used)
+
+	return used
+}
+
+func (s *Server) createProfile(post api.ProfilesPost) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.profiles[post.Name]; has {
+		return fmt.Errorf("profile %v already exists", post.Name)
+	}
+
+	s.profiles[post.Name] = api.Profile{Name: post.Name, ProfilePut: copyProfilePut(post.ProfilePut)}
+
+	return nil
+}
+
+func (s *Server) updateProfile(name string, put api.ProfilePut, etag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.profiles[name]; !has {
+		return shared.NewErrNotFound()
+	}
+
+	s.profiles[name] = api.Profile{Name: name, ProfilePut: copyProfilePut(put)}
+
+	return nil
+}
+
+func (s *Server) deleteProfile(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.profiles[name]; !has {
+		return shared.NewErrNotFound()
+	}
+
+	if len(s.usedBy(name)) > 0 {
+		return fmt.Errorf("%w: %v", ErrProfileInUse, name)
+	}
+
+	delete(s.profiles, name)
+
+	return nil
+}
+
+func (s *Server) getContainer(name string) (*api.Container, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct, has := s.containers[name]
+	if !has {
+		return nil, "", shared.NewErrNotFound()
+	}
+
+	return &ct, s.nextETag(), nil
+}
+
+func (s *Server) getContainers() ([]api.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cts := make([]api.Container, 0, len(s.containers))
+	for _, ct := range s.containers {
+		cts = append(cts, ct)
+	}
+
+	sort.Slice(cts, func(i, j int) bool { return cts[i].Name < cts[j].Name })
+
+	return cts, nil
+}
+
+func (s *Server) getContainerState(name string) (*api.ContainerState, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct, has := s.containers[name]
+	if !has {
+		return nil, "", shared.NewErrNotFound()
+	}
+
+	return &api.ContainerState{Status: ct.Status, StatusCode: ct.StatusCode}, s.nextETag(), nil
+}
+
+func (s *Server) createContainer(post api.ContainersPost) (lxd.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.containers[post.Name]; has {
+		return nil, fmt.Errorf("container %v already exists", post.Name)
+	}
+
+	for _, p := range post.Profiles {
+		if _, has := s.profiles[p]; !has {
+			return nil, fmt.Errorf("profile %w: %v", shared.NewErrNotFound(), p)
+		}
+	}
+
+	s.containers[post.Name] = api.Container{
+		Name:         post.Name,
+		ContainerPut: copyContainerPut(post.ContainerPut),
+		CreatedAt:    time.Now(),
+		Status:       api.Stopped.String(),
+		StatusCode:   api.Stopped,
+	}
+
+	return &lxdfakes.FakeOperation{}, nil
+}
+
+func (s *Server) updateContainer(name string, put api.ContainerPut, etag string) (lxd.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct, has := s.containers[name]
+	if !has {
+		return nil, shared.NewErrNotFound()
+	}
+
+	ct.ContainerPut = copyContainerPut(put)
+	s.containers[name] = ct
+
+	return &lxdfakes.FakeOperation{}, nil
+}
+
+func (s *Server) updateContainerState(name string, put api.ContainerStatePut, etag string) (lxd.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct, has := s.containers[name]
+	if !has {
+		return nil, shared.NewErrNotFound()
+	}
+
+	switch put.Action {
+	case "start", "unfreeze":
+		ct.StatusCode = api.Running
+	case "stop":
+		ct.StatusCode = api.Stopped
+	case "freeze":
+		ct.StatusCode = api.Frozen
+	}
+
+	ct.Status = ct.StatusCode.String()
+	s.containers[name] = ct
+
+	return &lxdfakes.FakeOperation{}, nil
+}
+
+func (s *Server) deleteContainer(name string) (lxd.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.containers[name]; !has {
+		return nil, shared.NewErrNotFound()
+	}
+
+	delete(s.containers, name)
+
+	return &lxdfakes.FakeOperation{}, nil
+}
+
+func (s *Server) getContainerFile(name, path string) (io.ReadCloser, *lxd.ContainerFileResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, has := s.containers[name]; !has {
+		return nil, nil, shared.NewErrNotFound()
+	}
+
+	fileType, has := s.files[path]
+	if !has {
+		return nil, nil, shared.NewErrNotFound()
+	}
+
+	return ioutil.NopCloser(strings.NewReader("")), &lxd.ContainerFileResponse{Type: fileType}, nil
+}
+
+func (s *Server) getImageAlias(name string) (*api.ImageAliasesEntry, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fingerprint, has := s.aliases[name]
+	if !has {
+		return nil, "", shared.NewErrNotFound()
+	}
+
+	return &api.ImageAliasesEntry{Name: name, ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: fingerprint}}, "", nil
+}
+
+func (s *Server) getImage(fingerprint string) (*api.Image, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for alias, f := range s.aliases {
+		if f == fingerprint {
+			arch, has := s.archs[fingerprint]
+			if !has {
+				arch = "x86_64"
+			}
+
+			return &api.Image{
+				Fingerprint:  fingerprint,
+				Architecture: arch,
+				Aliases:      []api.ImageAlias{{Name: alias}},
+			}, "", nil
+		}
+	}
+
+	return nil, "", shared.NewErrNotFound()
+}
+
+// copyProfilePut copies the maps, so callers can't change what's stored
+func copyProfilePut(put api.ProfilePut) api.ProfilePut {
+	return api.ProfilePut{
+		Config:      copyConfig(put.Config),
+		Description: put.Description,
+		Devices:     copyDevices(put.Devices),
+	}
+}
+
+// copyContainerPut copies the maps and slices, so callers can't change what's stored
+func copyContainerPut(put api.ContainerPut) api.ContainerPut {
+	cp := put
+	cp.Config = copyConfig(put.Config)
+	cp.Devices = copyDevices(put.Devices)
+	cp.Profiles = append([]string{}, put.Profiles...)
+
+	return cp
+}
+
+func copyConfig(config map[string]string) map[string]string {
+	cp := make(map[string]string, len(config))
+	for k, v := range config {
+		cp[k] = v
+	}
+
+	return cp
+}
+
+func copyDevices(devices map[string]map[string]string) map[string]map[string]string {
+	cp := make(map[string]map[string]string, len(devices))
+	for name, options := range devices {
+		cp[name] = copyConfig(options)
+	}
+
+	return cp
+}
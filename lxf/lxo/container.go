@@ -1,6 +1,8 @@
 package lxo
 
 import (
+	"time"
+
 	lxd "github.com/lxc/lxd/client"
 	"github.com/lxc/lxd/shared/api"
 )
@@ -13,6 +15,12 @@ func (l *LXO) StopContainer(id string, timeout, retries int) error {
 		etag string
 	)
 
+	// the container has up to timeout seconds to shut down before the operation completes
+	opTimeout := l.timeouts.Operation
+	if opTimeout > 0 && timeout > 0 {
+		opTimeout += time.Duration(timeout) * time.Second
+	}
+
 	for i := 0; i <= retries; i++ {
 		lxdReq := api.ContainerStatePut{
 			Action:  "stop",
@@ -27,7 +35,7 @@ func (l *LXO) StopContainer(id string, timeout, retries int) error {
 			return err
 		}
 
-		err = op.Wait()
+		err = wait(op, op.Cancel, opTimeout)
 		if err != nil {
 			if err.Error() == "The container is already stopped" {
 				return nil
@@ -54,7 +62,26 @@ func (l *LXO) StartContainer(id string) error {
 		return err
 	}
 
-	return op.Wait()
+	return l.waitOperation(op)
+}
+
+// FreezeContainer will freeze the processes of the container and wait till operation is done or return an error
+func (l *LXO) FreezeContainer(id string) error {
+	return l.updateContainerState(id, "freeze")
+}
+
+// UnfreezeContainer will thaw the processes of the container and wait till operation is done or return an error
+func (l *LXO) UnfreezeContainer(id string) error {
+	return l.updateContainerState(id, "unfreeze")
+}
+
+func (l *LXO) updateContainerState(id, action string) error {
+	op, err := l.server.UpdateContainerState(id, api.ContainerStatePut{Action: action, Timeout: -1}, "")
+	if err != nil {
+		return err
+	}
+
+	return l.waitOperation(op)
 }
 
 // CreateContainer will create the container and wait till operation is done or
@@ -65,7 +92,7 @@ func (l *LXO) CreateContainer(container api.ContainersPost) error {
 		return err
 	}
 
-	return op.Wait()
+	return l.waitOperation(op)
 }
 
 // UpdateContainer will create the container and wait till operation is done or
@@ -76,7 +103,18 @@ func (l *LXO) UpdateContainer(id string, container api.ContainerPut, etag string
 		return err
 	}
 
-	return op.Wait()
+	return l.waitOperation(op)
+}
+
+// RenameContainer will rename the container and wait till operation is done or
+// return an error
+func (l *LXO) RenameContainer(id string, container api.ContainerPost) error {
+	op, err := l.server.RenameContainer(id, container)
+	if err != nil {
+		return err
+	}
+
+	return l.waitOperation(op)
 }
 
 // DeleteContainer will delete the container and wait till operation is done or
@@ -87,5 +125,38 @@ func (l *LXO) DeleteContainer(id string) error {
 		return err
 	}
 
-	return op.Wait()
+	return l.waitOperation(op)
+}
+
+// CreateContainerSnapshot will create a snapshot of the container and wait till operation is done or
+// return an error
+func (l *LXO) CreateContainerSnapshot(id string, snapshot api.ContainerSnapshotsPost) error {
+	op, err := l.server.CreateContainerSnapshot(id, snapshot)
+	if err != nil {
+		return err
+	}
+
+	return l.waitLongOperation(op)
+}
+
+// CreateContainerBackup will create a backup of the container and wait till operation is done or
+// return an error
+func (l *LXO) CreateContainerBackup(id string, backup api.ContainerBackupsPost) error {
+	op, err := l.server.CreateContainerBackup(id, backup)
+	if err != nil {
+		return err
+	}
+
+	return l.waitLongOperation(op)
+}
+
+// DeleteContainerBackup will delete the backup of the container and wait till operation is done or
+// return an error
+func (l *LXO) DeleteContainerBackup(id string, name string) error {
+	op, err := l.server.DeleteContainerBackup(id, name)
+	if err != nil {
+		return err
+	}
+
+	return l.waitOperation(op)
 }
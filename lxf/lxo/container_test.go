@@ -183,6 +183,37 @@ func TestLXO_UpdateContainer_Error(t *testing.T) {
 	assert.Equal(t, 0, fakeOp.WaitCallCount())
 }
 
+func TestLXO_RenameContainer_Simple(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.RenameContainerReturns(fakeOp, nil)
+	fakeOp.WaitReturns(nil)
+
+	err := lxo.RenameContainer("foo", api.ContainerPost{Name: "bar"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fake.RenameContainerCallCount())
+	assert.Equal(t, 1, fakeOp.WaitCallCount())
+}
+
+func TestLXO_RenameContainer_Error(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.RenameContainerReturns(fakeOp, errors.New("something failed"))
+
+	err := lxo.RenameContainer("foo", api.ContainerPost{Name: "bar"})
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, fake.RenameContainerCallCount())
+	assert.Equal(t, 0, fakeOp.WaitCallCount())
+}
+
 func TestLXO_DeleteContainer_Simple(t *testing.T) {
 	t.Parallel()
 
@@ -213,3 +244,124 @@ func TestLXO_DeleteContainer_Error(t *testing.T) {
 	assert.Equal(t, 1, fake.DeleteContainerCallCount())
 	assert.Equal(t, 0, fakeOp.WaitCallCount())
 }
+
+func TestLXO_CreateContainerSnapshot_Simple(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.CreateContainerSnapshotReturns(fakeOp, nil)
+	fakeOp.WaitReturns(nil)
+
+	err := lxo.CreateContainerSnapshot("foo", api.ContainerSnapshotsPost{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fake.CreateContainerSnapshotCallCount())
+	assert.Equal(t, 1, fakeOp.WaitCallCount())
+}
+
+func TestLXO_CreateContainerSnapshot_Error(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.CreateContainerSnapshotReturns(fakeOp, errors.New("something failed"))
+
+	err := lxo.CreateContainerSnapshot("foo", api.ContainerSnapshotsPost{})
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, fake.CreateContainerSnapshotCallCount())
+	assert.Equal(t, 0, fakeOp.WaitCallCount())
+}
+
+func TestLXO_CreateContainerBackup_Simple(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.CreateContainerBackupReturns(fakeOp, nil)
+	fakeOp.WaitReturns(nil)
+
+	err := lxo.CreateContainerBackup("foo", api.ContainerBackupsPost{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fake.CreateContainerBackupCallCount())
+	assert.Equal(t, 1, fakeOp.WaitCallCount())
+}
+
+func TestLXO_CreateContainerBackup_Error(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.CreateContainerBackupReturns(fakeOp, errors.New("something failed"))
+
+	err := lxo.CreateContainerBackup("foo", api.ContainerBackupsPost{})
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, fake.CreateContainerBackupCallCount())
+	assert.Equal(t, 0, fakeOp.WaitCallCount())
+}
+
+func TestLXO_DeleteContainerBackup_Simple(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.DeleteContainerBackupReturns(fakeOp, nil)
+	fakeOp.WaitReturns(nil)
+
+	err := lxo.DeleteContainerBackup("foo", "bar")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fake.DeleteContainerBackupCallCount())
+	assert.Equal(t, 1, fakeOp.WaitCallCount())
+}
+
+func TestLXO_DeleteContainerBackup_Error(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.DeleteContainerBackupReturns(fakeOp, errors.New("something failed"))
+
+	err := lxo.DeleteContainerBackup("foo", "bar")
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, fake.DeleteContainerBackupCallCount())
+	assert.Equal(t, 0, fakeOp.WaitCallCount())
+}
+
+func TestLXO_FreezeContainer(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeOperation{}
+
+	fake.UpdateContainerStateReturns(fakeOp, nil)
+
+	err := lxo.FreezeContainer("foo")
+	assert.NoError(t, err)
+
+	err = lxo.UnfreezeContainer("foo")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, fakeOp.WaitCallCount())
+
+	_, freeze, _ := fake.UpdateContainerStateArgsForCall(0)
+	assert.Equal(t, "freeze", freeze.Action)
+
+	_, unfreeze, _ := fake.UpdateContainerStateArgsForCall(1)
+	assert.Equal(t, "unfreeze", unfreeze.Action)
+
+	fake.UpdateContainerStateReturns(nil, errors.New("something missing"))
+
+	err = lxo.FreezeContainer("foo")
+	assert.Error(t, err)
+}
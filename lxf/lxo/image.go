@@ -5,17 +5,27 @@ import (
 	"github.com/lxc/lxd/shared/api"
 )
 
+// metaDownloadProgress is the metadata key of operations in which lxd reports the progress of image downloads
+const metaDownloadProgress = "download_progress"
+
 // CopyImage copies an image from the specified server and wait till operation is done or
-// return an error
-func (l *LXO) CopyImage(source lxd.ImageServer, image api.Image, args *lxd.ImageCopyArgs) error {
+// return an error. If progress is set, it's called with lxd's download progress, e.g. "45% (12.3MB/s)"
+func (l *LXO) CopyImage(source lxd.ImageServer, image api.Image, args *lxd.ImageCopyArgs, progress func(string)) error {
 	op, err := l.server.CopyImage(source, image, args)
 	if err != nil {
 		return err
 	}
 
-	err = op.Wait()
+	if progress != nil {
+		// progress is only informational, the copy isn't affected if it can't be followed
+		_, _ = op.AddHandler(func(o api.Operation) {
+			if text, has := o.Metadata[metaDownloadProgress].(string); has && text != "" {
+				progress(text)
+			}
+		})
+	}
 
-	return err
+	return wait(op, op.CancelTarget, l.timeouts.LongOperation)
 }
 
 // DeleteImage deletes an image and wait till operation is done or
@@ -26,5 +36,5 @@ func (l *LXO) DeleteImage(hash string) error {
 		return err
 	}
 
-	return op.Wait()
+	return l.waitOperation(op)
 }
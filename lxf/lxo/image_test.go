@@ -19,13 +19,38 @@ func TestLXO_CopyImage_Simple(t *testing.T) {
 	fake.CopyImageReturns(fakeOp, nil)
 	fakeOp.WaitReturns(nil)
 
-	err := lxo.CopyImage(sourceFake, api.Image{}, nil)
+	err := lxo.CopyImage(sourceFake, api.Image{}, nil, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, 1, fake.CopyImageCallCount())
 	assert.Equal(t, 1, fakeOp.WaitCallCount())
 }
 
+func TestLXO_CopyImage_Progress(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	fakeOp := &lxdfakes.FakeRemoteOperation{}
+	sourceFake := &lxdfakes.FakeImageServer{}
+
+	fake.CopyImageReturns(fakeOp, nil)
+	fakeOp.WaitReturns(nil)
+
+	progress := []string{}
+
+	err := lxo.CopyImage(sourceFake, api.Image{}, nil, func(text string) {
+		progress = append(progress, text)
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fakeOp.AddHandlerCallCount())
+
+	handler := fakeOp.AddHandlerArgsForCall(0)
+	handler(api.Operation{Metadata: map[string]interface{}{}})
+	handler(api.Operation{Metadata: map[string]interface{}{"download_progress": "45% (12.3MB/s)"}})
+	assert.Equal(t, []string{"45% (12.3MB/s)"}, progress)
+}
+
 func TestLXO_CopyImage_Error(t *testing.T) {
 	t.Parallel()
 
@@ -35,7 +60,7 @@ func TestLXO_CopyImage_Error(t *testing.T) {
 
 	fake.CopyImageReturns(fakeOp, errors.New("something failed"))
 
-	err := lxo.CopyImage(sourceFake, api.Image{}, nil)
+	err := lxo.CopyImage(sourceFake, api.Image{}, nil, nil)
 	assert.Error(t, err)
 
 	assert.Equal(t, 1, fake.CopyImageCallCount())
@@ -1,13 +1,29 @@
 package lxo
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	lxd "github.com/lxc/lxd/client"
 )
 
+var ErrTimeout = errors.New("lxd operation timed out")
+
 // LXO abstracts some of the lxd calls with additional functionality like retrying, idempotency
 // and some level of error recovery. Usage stays the same as lxd.ContainerServer
 type LXO struct {
-	server lxd.ContainerServer
+	server   lxd.ContainerServer
+	timeouts Timeouts
+}
+
+// Timeouts limit how long is waited for lxd operations to complete. 0 waits without limit
+type Timeouts struct {
+	// Operation is the timeout of quick operations like starting or updating containers
+	Operation time.Duration
+	// LongOperation is the timeout of long running operations like pulling images or creating backups
+	LongOperation time.Duration
 }
 
 // New creates LXO
@@ -16,3 +32,52 @@ func NewClient(server lxd.ContainerServer) *LXO {
 		server: server,
 	}
 }
+
+// WithTimeouts sets the timeouts of waiting for operations
+func (l *LXO) WithTimeouts(timeouts Timeouts) *LXO {
+	l.timeouts = timeouts
+
+	return l
+}
+
+// waiter is an lxd operation which can be waited for, either a local or a remote one
+type waiter interface {
+	Wait() error
+}
+
+// wait waits for the operation to complete. If it doesn't complete within timeout, cancel is called and ErrTimeout is
+// returned. A timeout of 0 waits without limit.
+func wait(op waiter, cancel func() error, timeout time.Duration) error {
+	if timeout <= 0 {
+		return op.Wait()
+	}
+
+	ctx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- op.Wait()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		// not all operations can be cancelled, they are abandoned then
+		_ = cancel()
+
+		return fmt.Errorf("%w after %v", ErrTimeout, timeout)
+	}
+}
+
+// waitOperation waits for a quick operation
+func (l *LXO) waitOperation(op lxd.Operation) error {
+	return wait(op, op.Cancel, l.timeouts.Operation)
+}
+
+// waitLongOperation waits for a long running operation
+func (l *LXO) waitLongOperation(op lxd.Operation) error {
+	return wait(op, op.Cancel, l.timeouts.LongOperation)
+}
@@ -1,9 +1,12 @@
 package lxo
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/automaticserver/lxe/lxf/lxdfakes"
+	"github.com/lxc/lxd/shared/api"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,3 +28,33 @@ func TestNewClient(t *testing.T) {
 
 	assert.Exactly(t, fake, lxo.server)
 }
+
+func TestLXO_Timeout(t *testing.T) {
+	t.Parallel()
+
+	lxo, fake := newFakeClient()
+	lxo.WithTimeouts(Timeouts{Operation: 10 * time.Millisecond, LongOperation: time.Second})
+
+	release := make(chan struct{})
+	defer close(release)
+
+	fakeOp := &lxdfakes.FakeOperation{}
+	fakeOp.WaitStub = func() error {
+		<-release
+		return nil
+	}
+	fake.UpdateContainerStateReturns(fakeOp, nil)
+
+	err := lxo.StartContainer("foo")
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, 1, fakeOp.CancelCallCount())
+
+	// completed operations return their result
+	fakeOp = &lxdfakes.FakeOperation{}
+	fakeOp.WaitReturns(errors.New("something failed"))
+	fake.CreateContainerBackupReturns(fakeOp, nil)
+
+	err = lxo.CreateContainerBackup("foo", api.ContainerBackupsPost{})
+	assert.EqualError(t, err, "something failed")
+	assert.Equal(t, 0, fakeOp.CancelCallCount())
+}
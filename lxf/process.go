@@ -0,0 +1,119 @@
+package lxf
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxProcessCommandLength is the longest command line reported for a process
+const maxProcessCommandLength = 256
+
+// Process running in a container
+type Process struct {
+	// PID as seen within the container
+	PID int64
+	// Command line of the process
+	Command string
+}
+
+// readProcesses lists the processes in the cgroup of the container with the given init pid, ordered by their pid in
+// the container. At most max processes are returned, but the total number is counted.
+func readProcesses(initPid int64, max int) ([]Process, int, error) {
+	cg, err := readCgroupLocation(initPid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	procs := []Process{}
+
+	for _, entry := range entries {
+		pid, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// processes may exit meanwhile
+		cgroups, err := readProcCgroups(pid)
+		if err != nil || !inCgroup(cgroups, cg) {
+			continue
+		}
+
+		procs = append(procs, Process{PID: readNSPid(pid), Command: readCommand(pid)})
+	}
+
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+
+	total := len(procs)
+	if total > max {
+		procs = procs[:max]
+	}
+
+	return procs, total, nil
+}
+
+// inCgroup returns whether a process with the cgroups is in the cgroup of the container or below
+func inCgroup(cgroups map[string]string, cg ContainerCgroup) bool {
+	controller := "memory"
+	if cg.Version == 2 {
+		controller = ""
+	}
+
+	p, has := cgroups[controller]
+	if !has && cg.Version == 1 {
+		p, has = cgroups["cpuacct"]
+	}
+
+	return has && (p == cg.Path || strings.HasPrefix(p, cg.Path+"/"))
+}
+
+// readNSPid returns the pid of the process in its innermost pid namespace, or the pid if it's unknown
+func readNSPid(pid int64) int64 {
+	content, err := ioutil.ReadFile(filepath.Join(procPath, strconv.FormatInt(pid, 10), "status"))
+	if err != nil {
+		return pid
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "NSpid:" {
+			continue
+		}
+
+		nsPid, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err == nil {
+			return nsPid
+		}
+	}
+
+	return pid
+}
+
+// readCommand returns the command line of the process, or its name in brackets if it has none
+func readCommand(pid int64) string {
+	dir := filepath.Join(procPath, strconv.FormatInt(pid, 10))
+
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err == nil && len(cmdline) > 0 {
+		cmd := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		if len(cmd) > maxProcessCommandLength {
+			cmd = cmd[:maxProcessCommandLength]
+		}
+
+		return cmd
+	}
+
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return "[" + strings.TrimSpace(string(comm)) + "]"
+}
@@ -0,0 +1,55 @@
+package lxf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProcesses(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/meminfo":    "SwapTotal: 0 kB\n",
+		"proc/42/cgroup":  "0::/lxc.payload.foo/init.scope\n",
+		"proc/42/status":  "Name:\tsystemd\nNSpid:\t42\t1\n",
+		"proc/42/cmdline": "/sbin/init\x00",
+		"proc/50/cgroup":  "0::/lxc.payload.foo/system.slice/nginx.service\n",
+		"proc/50/status":  "Name:\tnginx\nNSpid:\t50\t120\n",
+		"proc/50/cmdline": "nginx: master process\x00-g\x00daemon off;\x00",
+		"proc/51/cgroup":  "0::/lxc.payload.foo/system.slice/kworker\n",
+		"proc/51/status":  "Name:\tkworker\nNSpid:\t51\t7\n",
+		"proc/51/comm":    "kworker\n",
+		"proc/60/cgroup":  "0::/lxc.payload.foobar\n",
+		"proc/60/cmdline": "other\x00",
+		"proc/61/cgroup":  "0::/system.slice/lxd.service\n",
+		"proc/61/cmdline": "lxd\x00",
+	})()
+
+	procs, total, err := readProcesses(42, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []Process{
+		{PID: 1, Command: "/sbin/init"},
+		{PID: 7, Command: "[kworker]"},
+		{PID: 120, Command: "nginx: master process -g daemon off;"},
+	}, procs)
+
+	// bounded, but counted
+	procs, total, err = readProcesses(42, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Len(t, procs, 2)
+}
+
+func TestReadProcesses_V1(t *testing.T) {
+	defer fakeHostFS(t, map[string]string{
+		"proc/42/cgroup":  "12:memory:/lxc.payload/foo\n1:name=systemd:/lxc.payload/foo/init.scope\n",
+		"proc/42/cmdline": "/sbin/init\x00",
+		"proc/43/cgroup":  "12:memory:/lxc.payload/bar\n",
+		"proc/43/cmdline": "other\x00",
+	})()
+
+	procs, total, err := readProcesses(42, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []Process{{PID: 42, Command: "/sbin/init"}}, procs)
+}
@@ -0,0 +1,49 @@
+package lxf
+
+import (
+	"sync"
+
+	lxd "github.com/lxc/lxd/client"
+	"github.com/lxc/lxd/lxc/config"
+)
+
+// remoteConfig serializes the access to the lxd remote config, so remotes can be added while images are pulled. Reads
+// need the lock as well, since the config keeps a cookie jar per remote which is created when connecting to it.
+type remoteConfig struct {
+	mu     sync.Mutex
+	config *config.Config
+}
+
+func newRemoteConfig(c *config.Config) *remoteConfig {
+	return &remoteConfig{
+		config: c,
+	}
+}
+
+// GetImageServer returns a connection to the image server of the remote
+func (rc *remoteConfig) GetImageServer(name string) (lxd.ImageServer, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.config.GetImageServer(name)
+}
+
+// ParseRemote splits raw into the remote and the resource name
+func (rc *remoteConfig) ParseRemote(raw string) (string, string, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.config.ParseRemote(raw)
+}
+
+// SetRemote adds the remote or replaces the existing one with this name
+func (rc *remoteConfig) SetRemote(name string, remote config.Remote) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.config.Remotes == nil {
+		rc.config.Remotes = make(map[string]config.Remote)
+	}
+
+	rc.config.Remotes[name] = remote
+}
@@ -0,0 +1,41 @@
+package lxf
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lxc/lxd/lxc/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteConfig_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	rc := newRemoteConfig(&config.Config{DefaultRemote: "local"})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			rc.SetRemote("remote"+strconv.Itoa(i), config.Remote{Addr: "https://remote" + strconv.Itoa(i), Protocol: "simplestreams", Public: true})
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			_, _, err := rc.ParseRemote("image")
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	remote, name, err := rc.ParseRemote("remote3:image")
+	assert.NoError(t, err)
+	assert.Equal(t, "remote3", remote)
+	assert.Equal(t, "image", name)
+}
@@ -23,7 +23,9 @@ const (
 	lxdInitDefaultNicName = "eth0"
 
 	cfgHostname                 = "user.host_name"
+	cfgLastIP                   = "user.last_ip"
 	cfgLogDirectory             = "user.log_directory"
+	cfgRuntimeHandler           = "user.runtime_handler"
 	cfgCreatedAt                = "user.created_at"
 	cfgNetworkConfig            = "user.networkconfig"
 	cfgNetworkConfigNameservers = cfgNetworkConfig + ".nameservers"
@@ -40,6 +42,8 @@ var (
 			cfgLogDirectory,
 			cfgState,
 			cfgHostname,
+			cfgLastIP,
+			cfgRuntimeHandler,
 			cfgCloudInitNetworkConfig,
 			cfgCloudInitVendorData,
 		}, reservedConfigCRI...,
@@ -66,6 +70,10 @@ type Sandbox struct {
 	Metadata SandboxMetadata
 	// Hostname to be set for containers if defined
 	Hostname string
+	// LastIP is the ip last reported for the sandbox, to detect when it changes
+	LastIP string
+	// RuntimeHandler is the runtime handler the sandbox was created with
+	RuntimeHandler string
 	// NetworkConfig to be applied for the sandbox and it's containers
 	NetworkConfig NetworkConfig
 	// State contains the current state of this sandbox
@@ -89,6 +97,10 @@ const (
 	SandboxReady    SandboxState = "ready"
 )
 
+// DefaultRuntimeHandler is the runtime handler of sandboxes which didn't request one, or were created before it was
+// saved
+const DefaultRuntimeHandler = "container"
+
 // SandboxMetadata contains common metadata values
 type SandboxMetadata struct {
 	Attempt   uint32
@@ -98,7 +110,7 @@ type SandboxMetadata struct {
 }
 
 // NetworkConfig contains the network config
-// searches and nameservers must not be empty to be valid
+// nameservers and searches are written to the cloud-init network config if any is set, exactly as given
 type NetworkConfig struct {
 	Nameservers []string
 	Searches    []string
@@ -139,12 +151,15 @@ func (s SandboxState) String() string {
 	return string(s)
 }
 
+// getSandboxState returns the recorded state. Profiles of older versions which recorded none are ready, any other
+// unexpected state is kept, so it's neither taken for ready nor lost.
 func getSandboxState(str string) SandboxState {
-	if str == string(SandboxNotReady) {
-		return SandboxNotReady
+	switch str {
+	case "", string(SandboxReady):
+		return SandboxReady
+	default:
+		return SandboxState(str)
 	}
-
-	return SandboxReady
 }
 
 // Containers looks up all assigned containers
@@ -177,6 +192,16 @@ func (s *Sandbox) getContainers() ([]*Container, error) {
 	return cl, nil
 }
 
+// Profile returns the config and devices of the sandbox profile as lxd currently has them
+func (s *Sandbox) Profile() (*api.ProfilePut, error) {
+	p, _, err := s.client.server.GetProfile(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p.ProfilePut, nil
+}
+
 // refresh loads the profile again from LXD to obtain new ETag
 // Will not load new data!
 func (s *Sandbox) refresh() error {
@@ -235,6 +260,38 @@ func (s *Sandbox) Delete() error {
 
 // apply saves the changes to LXD
 func (s *Sandbox) apply() error {
+	profile, err := s.makeProfile()
+	if err != nil {
+		return err
+	}
+
+	if s.ID == "" { // profile has to be created
+		s.ID = s.CreateID()
+
+		return s.client.server.CreateProfile(api.ProfilesPost{
+			Name:       s.ID,
+			ProfilePut: profile,
+		})
+	}
+	// else profile has to be updated
+	if s.ETag == "" {
+		return fmt.Errorf("update profile not allowed: %w", ErrMissingETag)
+	}
+
+	err = s.client.server.UpdateProfile(s.ID, profile, s.ETag)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return fmt.Errorf("sandbox %w: %s", shared.NewErrNotFound(), s.ID)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// makeProfile renders the sandbox as lxd profile
+func (s *Sandbox) makeProfile() (api.ProfilePut, error) {
 	config := map[string]string{
 		cfgState:                    s.State.String(),
 		cfgIsCRI:                    strconv.FormatBool(true),
@@ -244,6 +301,8 @@ func (s *Sandbox) apply() error {
 		cfgMetaNamespace:            s.Metadata.Namespace,
 		cfgMetaUID:                  s.Metadata.UID,
 		cfgHostname:                 s.Hostname,
+		cfgLastIP:                   s.LastIP,
+		cfgRuntimeHandler:           s.RuntimeHandler,
 		cfgLogDirectory:             s.LogDirectory,
 		cfgNetworkConfigNameservers: strings.Join(s.NetworkConfig.Nameservers, ","),
 		cfgNetworkConfigSearches:    strings.Join(s.NetworkConfig.Searches, ","),
@@ -253,7 +312,7 @@ func (s *Sandbox) apply() error {
 	// write NetworkConfigData as yaml
 	yml, err := yaml.Marshal(s.NetworkConfig.ModeData)
 	if err != nil {
-		return err
+		return api.ProfilePut{}, err
 	}
 
 	config[cfgNetworkConfigModeData] = string(yml)
@@ -281,8 +340,8 @@ func (s *Sandbox) apply() error {
 		Config:  []interface{}{},
 	}
 
-	if len(s.NetworkConfig.Nameservers) > 0 &&
-		len(s.NetworkConfig.Searches) > 0 {
+	// either may be empty, e.g. if the pod's dns config only has nameservers
+	if len(s.NetworkConfig.Nameservers) > 0 || len(s.NetworkConfig.Searches) > 0 {
 		data.Config = append(data.Config, cloudinit.NetworkConfigEntryNameserver{
 			NetworkConfigEntry: cloudinit.NetworkConfigEntry{
 				Type: "nameserver",
@@ -298,17 +357,14 @@ func (s *Sandbox) apply() error {
 
 	yml, err = yaml.Marshal(data)
 	if err != nil {
-		return err
+		return api.ProfilePut{}, err
 	}
 
 	config[cfgCloudInitNetworkConfig] = string(yml)
 
 	// write cloud-init vendor data if we have hostname and search
 	if s.Hostname != "" {
-		config[cfgCloudInitVendorData] = fmt.Sprintf(`#cloud-config
-hostname: %s
-manage_etc_hosts: true
-`, s.Hostname)
+		config[cfgCloudInitVendorData] = hostnameVendorData(s.Hostname)
 	}
 
 	devices := make(map[string]map[string]string)
@@ -319,34 +375,55 @@ manage_etc_hosts: true
 	}
 
 	config[cfgSchema] = SchemaVersionProfile
-	profile := api.ProfilePut{
+
+	return api.ProfilePut{
 		Config:  config,
 		Devices: devices,
+	}, nil
+}
+
+// cloudConfigHeader starts cloud-init data in cloud-config format
+const cloudConfigHeader = "#cloud-config"
+
+// hostnameVendorData returns the cloud-init vendor data which sets the hostname
+func hostnameVendorData(hostname string) string {
+	return fmt.Sprintf(`%s
+hostname: %s
+manage_etc_hosts: true
+`, cloudConfigHeader, hostname)
+}
+
+// composeVendorData returns the vendor data of a container, which replaces the one of its sandbox setting the hostname.
+// So the hostname is added to vendor data in cloud-config format, unless it sets the hostname itself. Other formats,
+// like scripts, can't be merged and are used as they are.
+func composeVendorData(hostname, vendorData string) (string, error) {
+	if hostname == "" || !strings.HasPrefix(vendorData, cloudConfigHeader) {
+		return vendorData, nil
 	}
 
-	if s.ID == "" { // profile has to be created
-		s.ID = s.CreateID()
+	data := map[string]interface{}{}
 
-		return s.client.server.CreateProfile(api.ProfilesPost{
-			Name:       s.ID,
-			ProfilePut: profile,
-		})
+	err := yaml.Unmarshal([]byte(vendorData), &data)
+	if err != nil {
+		return "", fmt.Errorf("%w: vendor-data: %v", ErrParse, err)
 	}
-	// else profile has to be updated
-	if s.ETag == "" {
-		return fmt.Errorf("update profile not allowed: %w", ErrMissingETag)
+
+	if _, has := data["hostname"]; has {
+		return vendorData, nil
 	}
 
-	err = s.client.server.UpdateProfile(s.ID, profile, s.ETag)
-	if err != nil {
-		if shared.IsErrNotFound(err) {
-			return fmt.Errorf("sandbox %w: %s", shared.NewErrNotFound(), s.ID)
-		}
+	data["hostname"] = hostname
 
-		return err
+	if _, has := data["manage_etc_hosts"]; !has {
+		data["manage_etc_hosts"] = true
 	}
 
-	return nil
+	yml, err := yaml.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return cloudConfigHeader + "\n" + string(yml), nil
 }
 
 // CreateID creates a unique profile id
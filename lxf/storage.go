@@ -0,0 +1,37 @@
+package lxf
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNoStoragePool = errors.New("no storage pool")
+
+// defaultProfile is the lxd profile every container has unless configured otherwise
+const defaultProfile = "default"
+
+// GetRootPool returns the storage pool the root disk of containers is on. This is the pool of the root disk in the
+// default profile, or the only storage pool if the default profile has no root disk.
+func (l *client) GetRootPool() (string, error) {
+	p, _, err := l.server.GetProfile(defaultProfile)
+	if err != nil {
+		return "", err
+	}
+
+	for _, d := range p.Devices {
+		if d["type"] == "disk" && d["path"] == "/" && d["pool"] != "" {
+			return d["pool"], nil
+		}
+	}
+
+	pools, err := l.server.GetStoragePoolNames()
+	if err != nil {
+		return "", err
+	}
+
+	if len(pools) != 1 {
+		return "", fmt.Errorf("%w: unable to choose from %v storage pools, add a root disk to the profile %v or configure the storage pool", ErrNoStoragePool, len(pools), defaultProfile)
+	}
+
+	return pools[0], nil
+}
@@ -0,0 +1,46 @@
+package lxf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lxc/lxd/shared/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_GetRootPool_Profile(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetProfileReturns(&api.Profile{ProfilePut: api.ProfilePut{Devices: map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "ssd"},
+	}}}, "", nil)
+
+	pool, err := client.GetRootPool()
+	assert.NoError(t, err)
+	assert.Equal(t, "ssd", pool)
+	assert.Equal(t, 0, fake.GetStoragePoolNamesCallCount())
+}
+
+func TestClient_GetRootPool_Single(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetProfileReturns(&api.Profile{}, "", nil)
+	fake.GetStoragePoolNamesReturns([]string{"data"}, nil)
+
+	pool, err := client.GetRootPool()
+	assert.NoError(t, err)
+	assert.Equal(t, "data", pool)
+}
+
+func TestClient_GetRootPool_Ambiguous(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+	fake.GetProfileReturns(&api.Profile{}, "", nil)
+	fake.GetStoragePoolNamesReturns([]string{"data", "ssd"}, nil)
+
+	_, err := client.GetRootPool()
+	assert.True(t, errors.Is(err, ErrNoStoragePool))
+}
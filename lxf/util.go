@@ -2,12 +2,18 @@ package lxf
 
 import (
 	"encoding/base32"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	b32lowerEncoder = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567")
 )
 
+// maxNameLength is the longest name lxd accepts for containers, as it's used as hostname
+const maxNameLength = 63
+
 // SetIfSet sets a key in a map[string]string with the value, if the value is not empty
 func SetIfSet(s *map[string]string, key, value string) {
 	if value != "" {
@@ -26,3 +32,84 @@ func AppendIfSet(s *map[string]string, key, value string) {
 		}
 	}
 }
+
+// splitList splits a comma separated list as saved in the config. An empty list returns nil, not an empty entry.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// parseTimestamp parses nanoseconds since the epoch as saved in the config. Missing timestamps and the ones saved for
+// the zero time, which are negative, return the zero time.
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	nsec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if nsec <= 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(0, nsec), nil
+}
+
+// MergeRawLXC combines multiple raw.lxc values line by line in the given order. Empty and repeated lines are omitted.
+func MergeRawLXC(raws ...string) string {
+	lines := []string{}
+	seen := make(map[string]bool)
+
+	for _, raw := range raws {
+		for _, line := range strings.Split(raw, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || seen[line] {
+				continue
+			}
+
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// SanitizeName turns s into a name lxd accepts of at most max characters: lowercase letters, digits and single hyphens,
+// starting with a letter and not ending with a hyphen. Returns an empty string if nothing of s remains.
+func SanitizeName(s string, max int) string {
+	b := strings.Builder{}
+
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9' || r == '-':
+			// names must start with a letter
+			if b.Len() > 0 {
+				b.WriteRune(r)
+			}
+		default:
+			if b.Len() > 0 {
+				b.WriteRune('-')
+			}
+		}
+	}
+
+	name := b.String()
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+
+	if len(name) > max {
+		name = name[:max]
+	}
+
+	return strings.TrimRight(name, "-")
+}
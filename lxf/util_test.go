@@ -0,0 +1,47 @@
+package lxf
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRawLXC(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "", MergeRawLXC())
+	assert.Equal(t, "", MergeRawLXC("", "\n"))
+	assert.Equal(t, "lxc.include = /a\nlxc.seccomp.profile =", MergeRawLXC("lxc.include = /a\n", "lxc.include = /a\nlxc.seccomp.profile ="))
+}
+
+func TestSanitizeName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "default-nginx-web", SanitizeName("default-nginx-web", 63))
+	assert.Equal(t, "kube-system-coredns-abc", SanitizeName("kube-system_CoreDNS.abc", 63))
+	assert.Equal(t, "a-b", SanitizeName("--1a--b--", 63))
+	assert.Equal(t, "abc", SanitizeName("abc-def", 4))
+	assert.Equal(t, "", SanitizeName("123_-", 63))
+}
+
+func TestParseTimestamp(t *testing.T) {
+	t.Parallel()
+
+	ts, err := parseTimestamp("")
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+
+	// the zero time as saved by earlier versions
+	ts, err = parseTimestamp(strconv.FormatInt(time.Time{}.UnixNano(), 10))
+	assert.NoError(t, err)
+	assert.True(t, ts.IsZero())
+
+	ts, err = parseTimestamp("1600000000000000000")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(0, 1600000000000000000), ts)
+
+	_, err = parseTimestamp("yesterday")
+	assert.Error(t, err)
+}
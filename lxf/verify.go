@@ -0,0 +1,209 @@
+package lxf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/lxc/lxd/shared/api"
+)
+
+// Drift is a difference between what lxe expects of a sandbox and what lxd has, e.g. after a manual edit
+type Drift struct {
+	// Object is the profile or container name which drifted
+	Object string
+	// Key is the config key or device name which drifted
+	Key string
+	// Expected is what lxe expects, empty if the key is unexpected
+	Expected string
+	// Actual is what lxd has, empty if the key is missing
+	Actual string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%v: %v is %q, expected %q", d.Object, d.Key, d.Actual, d.Expected)
+}
+
+// verifyIgnoredKeys are written by lxe but not read back, so they can't be compared
+var verifyIgnoredKeys = map[string]bool{
+	cfgCloudInitNetworkConfig: true,
+}
+
+// Verify compares the sandbox with its profile and containers in lxd and returns all differences. The config keys
+// managed by lxe and the devices of the profile must match the sandbox, the network devices must match its network
+// mode and its containers must not override the profile.
+func (s *Sandbox) Verify() ([]Drift, error) {
+	expected, err := s.makeProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	p, _, err := s.client.server.GetProfile(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []Drift{}
+
+	for _, key := range configKeys(expected.Config, p.Config) {
+		if !sandboxConfigStore.IsReserved(key) || verifyIgnoredKeys[key] {
+			continue
+		}
+
+		// sandboxes created before the runtime handler was saved get the default
+		if key == cfgRuntimeHandler && p.Config[key] == "" {
+			continue
+		}
+
+		if expected.Config[key] != p.Config[key] {
+			drifts = append(drifts, Drift{Object: s.ID, Key: key, Expected: expected.Config[key], Actual: p.Config[key]})
+		}
+	}
+
+	drifts = append(drifts, diffDevices(s.ID, expected.Devices, p.Devices)...)
+	drifts = append(drifts, s.verifyNetworkMode(p)...)
+
+	for _, name := range s.UsedBy {
+		ct, _, err := s.client.server.GetContainer(name)
+		if err != nil {
+			return nil, err
+		}
+
+		drifts = append(drifts, verifyContainerProfile(ct, p)...)
+	}
+
+	return drifts, nil
+}
+
+// verifyNetworkMode checks that only bridged sandboxes have a nic device, as the other modes set up their network
+// without lxd
+func (s *Sandbox) verifyNetworkMode(p *api.Profile) []Drift {
+	drifts := []Drift{}
+
+	for _, name := range deviceKeys(p.Devices, nil) {
+		isNic := p.Devices[name]["type"] == "nic"
+		if isNic && s.NetworkConfig.Mode != NetworkBridged {
+			drifts = append(drifts, Drift{Object: s.ID, Key: "devices." + name, Actual: "nic", Expected: "no nic in network mode " + s.NetworkConfig.Mode.String()})
+		}
+	}
+
+	return drifts
+}
+
+// verifyContainerProfile checks the container uses the profile last, and doesn't override its config or devices. Keys
+// which are set per object by lxe are skipped.
+func verifyContainerProfile(ct *api.Container, p *api.Profile) []Drift {
+	drifts := []Drift{}
+
+	if len(ct.Profiles) == 0 || ct.Profiles[len(ct.Profiles)-1] != p.Name {
+		drifts = append(drifts, Drift{Object: ct.Name, Key: "profiles", Actual: fmt.Sprint(ct.Profiles), Expected: p.Name + " last"})
+	}
+
+	for _, key := range configKeys(p.Config, nil) {
+		// the cri keys are set per object
+		if sandboxConfigStore.IsReserved(key) || containerConfigStore.IsReserved(key) {
+			continue
+		}
+
+		val, has := ct.Config[key]
+		if !has {
+			continue
+		}
+
+		// the container merges the raw.lxc of the profile with its own
+		if key == cfgRawLXC {
+			if MergeRawLXC(val, p.Config[key]) != MergeRawLXC(val) {
+				drifts = append(drifts, Drift{Object: ct.Name, Key: key, Expected: "to contain " + p.Config[key], Actual: val})
+			}
+
+			continue
+		}
+
+		if val != p.Config[key] {
+			drifts = append(drifts, Drift{Object: ct.Name, Key: key, Expected: p.Config[key], Actual: val})
+		}
+	}
+
+	for _, name := range deviceKeys(p.Devices, nil) {
+		if dev, has := ct.Devices[name]; has && !reflect.DeepEqual(nonEmpty(dev), nonEmpty(p.Devices[name])) {
+			drifts = append(drifts, Drift{Object: ct.Name, Key: "devices." + name, Expected: fmt.Sprint(p.Devices[name]), Actual: fmt.Sprint(dev)})
+		}
+	}
+
+	return drifts
+}
+
+// diffDevices compares the devices, ignoring empty options
+func diffDevices(object string, expected, actual map[string]map[string]string) []Drift {
+	drifts := []Drift{}
+
+	for _, name := range deviceKeys(expected, actual) {
+		exp, act := nonEmpty(expected[name]), nonEmpty(actual[name])
+		if reflect.DeepEqual(exp, act) {
+			continue
+		}
+
+		d := Drift{Object: object, Key: "devices." + name}
+		if expected[name] != nil {
+			d.Expected = fmt.Sprint(exp)
+		}
+
+		if actual[name] != nil {
+			d.Actual = fmt.Sprint(act)
+		}
+
+		drifts = append(drifts, d)
+	}
+
+	return drifts
+}
+
+// nonEmpty returns the options without empty values, as lxd treats them like unset ones
+func nonEmpty(options map[string]string) map[string]string {
+	m := map[string]string{}
+
+	for k, v := range options {
+		if v != "" {
+			m[k] = v
+		}
+	}
+
+	return m
+}
+
+// configKeys returns the sorted keys of both configs
+func configKeys(a, b map[string]string) []string {
+	seen := map[string]bool{}
+
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			seen[k] = true
+		}
+	}
+
+	return sortedSet(seen)
+}
+
+// deviceKeys returns the sorted names of the devices of both
+func deviceKeys(a, b map[string]map[string]string) []string {
+	seen := map[string]bool{}
+
+	for _, m := range []map[string]map[string]string{a, b} {
+		for k := range m {
+			seen[k] = true
+		}
+	}
+
+	return sortedSet(seen)
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,86 @@
+package lxf
+
+import (
+	"testing"
+
+	"github.com/automaticserver/lxe/lxf/device"
+	"github.com/lxc/lxd/shared/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandbox_Verify(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+
+	s := client.NewSandbox()
+	s.ID = "sb"
+	s.Hostname = "web"
+	s.Config["raw.lxc"] = "lxc.include = /a"
+	s.Devices.Upsert(&device.None{KeyName: lxdInitDefaultNicName})
+	s.UsedBy = []string{"ct"}
+
+	put, err := s.makeProfile()
+	assert.NoError(t, err)
+
+	// manually edited and added a nic, while the sandbox isn't bridged
+	put.Config[cfgHostname] = "other"
+	put.Devices["nic-eth0"] = map[string]string{"type": "nic", "name": "eth0", "nictype": "bridged", "parent": "lxdbr0"}
+	fake.GetProfileReturns(&api.Profile{Name: "sb", ProfilePut: put}, "", nil)
+
+	fake.GetContainerReturns(&api.Container{
+		Name: "ct",
+		ContainerPut: api.ContainerPut{
+			Profiles: []string{"sb", "default"},
+			Config: map[string]string{
+				cfgHostname: "ct",
+				"raw.lxc":   "lxc.include = /b",
+			},
+			Devices: map[string]map[string]string{
+				lxdInitDefaultNicName: {"type": "nic", "name": "eth0"},
+			},
+		},
+	}, "", nil)
+
+	drifts, err := s.Verify()
+	assert.NoError(t, err)
+
+	keys := []string{}
+	for _, d := range drifts {
+		keys = append(keys, d.Object+" "+d.Key)
+	}
+
+	assert.Equal(t, []string{
+		"sb " + cfgHostname,
+		"sb devices.nic-eth0",
+		"sb devices.nic-eth0",
+		"ct profiles",
+		"ct raw.lxc",
+		"ct devices.eth0",
+	}, keys)
+	assert.Equal(t, `sb: user.host_name is "other", expected "web"`, drifts[0].String())
+}
+
+func TestSandbox_Verify_NoDrift(t *testing.T) {
+	t.Parallel()
+
+	client, fake := testClient()
+
+	s := client.NewSandbox()
+	s.ID = "sb"
+	s.Devices.Upsert(&device.None{KeyName: lxdInitDefaultNicName})
+
+	put, err := s.makeProfile()
+	assert.NoError(t, err)
+
+	// lxd drops empty options
+	put.Devices[lxdInitDefaultNicName] = map[string]string{"type": "none"}
+	// profiles created before the runtime handler was saved
+	s.RuntimeHandler = DefaultRuntimeHandler
+	delete(put.Config, cfgRuntimeHandler)
+	fake.GetProfileReturns(&api.Profile{Name: "sb", ProfilePut: put}, "", nil)
+
+	drifts, err := s.Verify()
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
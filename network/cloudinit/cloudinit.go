@@ -14,8 +14,8 @@ type NetworkConfigEntry struct {
 // NetworkConfigEntryNameserver is a nameserver entry
 type NetworkConfigEntryNameserver struct {
 	NetworkConfigEntry
-	Address []string `json:"address"`
-	Search  []string `json:"search"`
+	Address []string `json:"address,omitempty"`
+	Search  []string `json:"search,omitempty"`
 }
 
 // NetworkConfigEntryPhysical is a nameserver entry
@@ -6,29 +6,64 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/automaticserver/lxe/lxf/device"
 	"github.com/automaticserver/lxe/network/cloudinit"
 	"github.com/automaticserver/lxe/shared"
 	lxd "github.com/lxc/lxd/client"
+	sharedLXD "github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/api"
 	rtApi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 )
 
 const (
 	DefaultLXDBridge = "lxebr0"
+	// annotationLXDNetwork names the lxd managed network to attach the pod to instead of the bridge of lxe
+	annotationLXDNetwork = "x-lxe-lxd-network"
+	// annotationNicType selects the nictype of the pod's nic, bridged by default
+	annotationNicType = "x-lxe-nic-type"
+	// annotationNicParent names the host interface the nic of nictype macvlan or physical uses
+	annotationNicParent = "x-lxe-nic-parent"
+	// annotationMTU sets the mtu of the pod's nic, by default it's the mtu of its parent
+	annotationMTU = "x-lxe-mtu"
+
+	// minMTU is the smallest mtu every ipv4 host must accept, maxMTU the largest of common jumbo frames
+	minMTU = 576
+	maxMTU = 9216
+
+	nicTypeBridged  = "bridged"
+	nicTypeMacvlan  = "macvlan"
+	nicTypePhysical = "physical"
 )
 
 var (
-	ErrNotBridge = errors.New("not a bridge")
+	ErrNotBridge           = errors.New("not a bridge")
+	ErrIncompatibleNetwork = errors.New("incompatible lxd network")
+	ErrUnsupportedNicType  = errors.New("unsupported nic type")
+	ErrNicParent           = errors.New("invalid nic parent")
+	ErrInvalidMTU          = errors.New("invalid mtu")
+	ErrPolicy              = errors.New("not allowed by policy")
 )
 
+// attachableNetworkTypes are the types of lxd managed networks a pod can be attached to by name
+var attachableNetworkTypes = map[string]bool{
+	"bridge":  true,
+	"macvlan": true,
+	"sriov":   true,
+	"ovn":     true,
+}
+
 // ConfLXDBridge are configuration options for the LXDBridge plugin. All properties are optional and get a default value
 type ConfLXDBridge struct {
 	LXDBridge  string
 	Cidr       string
 	Nat        bool
 	CreateOnly bool
+	// AllowedNetworks are the lxd managed networks pods may be attached to with the lxd network annotation
+	AllowedNetworks []string
+	// AllowedNicParents are the host interfaces pods may attach nics of type macvlan or physical to
+	AllowedNicParents []string
 }
 
 func (c *ConfLXDBridge) setDefaults() {
@@ -207,6 +242,55 @@ func (s *lxdBridgePodNetwork) Status(ctx context.Context, prop *PropertiesRunnin
 
 // WhenCreated is called when the pod is created.
 func (s *lxdBridgePodNetwork) WhenCreated(ctx context.Context, prop *Properties) (*Result, error) {
+	nicType := s.annotations[annotationNicType]
+	name := s.annotations[annotationLXDNetwork]
+
+	mtu, err := nicMTU(s.annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	var r *Result
+
+	switch {
+	case name != "" && nicType != "":
+		return nil, fmt.Errorf("%w: %v can't be combined with %v", ErrUnsupportedNicType, annotationNicType, annotationLXDNetwork)
+	case name != "":
+		r, err = s.attachNetwork(name)
+	case nicType != "" && nicType != nicTypeBridged:
+		r, err = s.attachParent(nicType, s.annotations[annotationNicParent])
+	default:
+		r, err = s.attachBridge()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range r.Nics {
+		r.Nics[i].MTU = mtu
+	}
+
+	return r, nil
+}
+
+// nicMTU returns the mtu of the pod's nic requested by the annotation, empty to keep the mtu of the parent
+func nicMTU(annotations map[string]string) (string, error) {
+	v, has := annotations[annotationMTU]
+	if !has {
+		return "", nil
+	}
+
+	mtu, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || mtu < minMTU || mtu > maxMTU {
+		return "", fmt.Errorf("%w: %q must be between %d and %d", ErrInvalidMTU, v, minMTU, maxMTU)
+	}
+
+	return strconv.Itoa(mtu), nil
+}
+
+// attachBridge attaches the pod to the bridge of lxe with a free address of its range
+func (s *lxdBridgePodNetwork) attachBridge() (*Result, error) {
 	// default is to use the predefined lxd bridge managed by lxe
 	randIP, err := s.plugin.findFreeIP()
 	if err != nil {
@@ -245,6 +329,108 @@ func (s *lxdBridgePodNetwork) WhenCreated(ctx context.Context, prop *Properties)
 	return r, nil
 }
 
+// attachNetwork attaches the pod to the named lxd managed network, if the operator allows it. The address is assigned by
+// the network, so it's looked up in the container later
+func (s *lxdBridgePodNetwork) attachNetwork(name string) (*Result, error) {
+	if !sharedLXD.StringInSlice(name, s.plugin.conf.AllowedNetworks) {
+		return nil, fmt.Errorf("%w: lxd network %v", ErrPolicy, name)
+	}
+
+	network, _, err := s.plugin.server.GetNetwork(name)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil, fmt.Errorf("%w: %v doesn't exist", ErrIncompatibleNetwork, name)
+		}
+
+		return nil, err
+	}
+
+	if !network.Managed || !attachableNetworkTypes[network.Type] {
+		return nil, fmt.Errorf("%w: %v is of type %v, managed %v", ErrIncompatibleNetwork, name, network.Type, network.Managed)
+	}
+
+	r := &Result{}
+	r.Nics = []device.Nic{
+		{
+			Name:    DefaultInterface,
+			Network: name,
+		},
+	}
+	r.NetworkConfigEntries = []cloudinit.NetworkConfigEntryPhysical{
+		{
+			NetworkConfigEntry: cloudinit.NetworkConfigEntry{
+				Type: "physical",
+			},
+			Name: DefaultInterface,
+			Subnets: []cloudinit.NetworkConfigEntryPhysicalSubnet{
+				{
+					Type: "dhcp",
+				},
+			},
+		},
+	}
+
+	return r, nil
+}
+
+// attachParent attaches the pod with a nic of the given type to the host interface parent, if the operator allows it.
+// Macvlan nics share the interface, physical nics move it into the pod exclusively. The address is assigned by the network of the interface
+func (s *lxdBridgePodNetwork) attachParent(nicType, parent string) (*Result, error) {
+	if nicType != nicTypeMacvlan && nicType != nicTypePhysical {
+		return nil, fmt.Errorf("%w: %v, must be one of %v, %v or %v", ErrUnsupportedNicType, nicType, nicTypeBridged, nicTypeMacvlan, nicTypePhysical)
+	}
+
+	if parent == "" {
+		return nil, fmt.Errorf("%w: nic type %v requires %v", ErrNicParent, nicType, annotationNicParent)
+	}
+
+	if !sharedLXD.StringInSlice(parent, s.plugin.conf.AllowedNicParents) {
+		return nil, fmt.Errorf("%w: nic parent %v", ErrPolicy, parent)
+	}
+
+	// lxd lists the interfaces of the host as unmanaged networks
+	network, _, err := s.plugin.server.GetNetwork(parent)
+	if err != nil {
+		if shared.IsErrNotFound(err) {
+			return nil, fmt.Errorf("%w: host interface %v doesn't exist", ErrNicParent, parent)
+		}
+
+		return nil, err
+	}
+
+	if network.Type == "loopback" {
+		return nil, fmt.Errorf("%w: host interface %v is a loopback", ErrNicParent, parent)
+	}
+
+	if nicType == nicTypePhysical && len(network.UsedBy) > 0 {
+		return nil, fmt.Errorf("%w: host interface %v is already used by %v", ErrNicParent, parent, network.UsedBy)
+	}
+
+	r := &Result{}
+	r.Nics = []device.Nic{
+		{
+			Name:    DefaultInterface,
+			NicType: nicType,
+			Parent:  parent,
+		},
+	}
+	r.NetworkConfigEntries = []cloudinit.NetworkConfigEntryPhysical{
+		{
+			NetworkConfigEntry: cloudinit.NetworkConfigEntry{
+				Type: "physical",
+			},
+			Name: DefaultInterface,
+			Subnets: []cloudinit.NetworkConfigEntryPhysicalSubnet{
+				{
+					Type: "dhcp",
+				},
+			},
+		},
+	}
+
+	return r, nil
+}
+
 // lxdBridgeContainerNetwork is a container network environment context
 type lxdBridgeContainerNetwork struct {
 	noopContainerNetwork // every method not implemented is noop
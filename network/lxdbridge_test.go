@@ -1,6 +1,7 @@
 package network
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/automaticserver/lxe/lxf/lxdfakes"
@@ -321,3 +322,164 @@ func Test_lxdBridgePodNetwork_WhenCreated_Simple(t *testing.T) {
 	assert.NotEmpty(t, res.Data["interface-address"])
 	assert.NotEmpty(t, res.Nics[0].IPv4Address)
 }
+
+func Test_lxdBridgePodNetwork_WhenCreated_LXDNetwork(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNetworks = []string{"ovn0"}
+	podNet.annotations = map[string]string{annotationLXDNetwork: "ovn0"}
+
+	fake.GetNetworkReturns(&lxdApi.Network{Type: "ovn", Name: "ovn0", Managed: true}, "", nil)
+
+	res, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ovn0", fake.GetNetworkArgsForCall(0))
+	assert.Equal(t, 0, fake.GetNetworkLeasesCallCount())
+	assert.Empty(t, res.Data)
+	assert.Len(t, res.Nics, 1)
+	assert.Equal(t, "ovn0", res.Nics[0].Network)
+	assert.Empty(t, res.Nics[0].Parent)
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_LXDNetworkIncompatible(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNetworks = []string{"eth1"}
+	podNet.annotations = map[string]string{annotationLXDNetwork: "eth1"}
+
+	fake.GetNetworkReturns(&lxdApi.Network{Type: "physical", Name: "eth1", Managed: false}, "", nil)
+
+	_, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.True(t, errors.Is(err, ErrIncompatibleNetwork))
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_LXDNetworkRefused(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNetworks = []string{"ovn1"}
+	podNet.annotations = map[string]string{annotationLXDNetwork: "ovn0"}
+
+	_, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.True(t, errors.Is(err, ErrPolicy))
+	assert.Equal(t, 0, fake.GetNetworkCallCount())
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_LXDNetworkMissing(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNetworks = []string{"ovn0"}
+	podNet.annotations = map[string]string{annotationLXDNetwork: "ovn0"}
+
+	fake.GetNetworkReturns(nil, "", shared.NewErrNotFound())
+
+	_, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.True(t, errors.Is(err, ErrIncompatibleNetwork))
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_Macvlan(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNicParents = []string{"eth1"}
+	podNet.annotations = map[string]string{annotationNicType: "macvlan", annotationNicParent: "eth1"}
+
+	fake.GetNetworkReturns(&lxdApi.Network{Type: "physical", Name: "eth1", UsedBy: []string{"/1.0/containers/other"}}, "", nil)
+
+	res, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.NoError(t, err)
+	assert.Equal(t, "eth1", fake.GetNetworkArgsForCall(0))
+	assert.Len(t, res.Nics, 1)
+	assert.Equal(t, "macvlan", res.Nics[0].NicType)
+	assert.Equal(t, "eth1", res.Nics[0].Parent)
+	assert.Empty(t, res.Nics[0].IPv4Address)
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_NicParentRefused(t *testing.T) {
+	t.Parallel()
+
+	for _, nicType := range []string{"macvlan", "physical"} {
+		podNet, fake := testLXDBridgePodNetwork()
+		podNet.plugin.conf.AllowedNicParents = []string{"eth1"}
+		podNet.annotations = map[string]string{annotationNicType: nicType, annotationNicParent: "eth0"}
+
+		_, err := podNet.WhenCreated(ctx, &Properties{})
+		assert.True(t, errors.Is(err, ErrPolicy), nicType)
+		assert.Equal(t, 0, fake.GetNetworkCallCount())
+	}
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_PhysicalInUse(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.plugin.conf.AllowedNicParents = []string{"eth1"}
+	podNet.annotations = map[string]string{annotationNicType: "physical", annotationNicParent: "eth1"}
+
+	fake.GetNetworkReturns(&lxdApi.Network{Type: "physical", Name: "eth1", UsedBy: []string{"/1.0/containers/other"}}, "", nil)
+
+	_, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.True(t, errors.Is(err, ErrNicParent))
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_NicTypeInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, annotations := range []map[string]string{
+		{annotationNicType: "sriov", annotationNicParent: "eth1"},
+		{annotationNicType: "macvlan", annotationLXDNetwork: "ovn0"},
+	} {
+		podNet, fake := testLXDBridgePodNetwork()
+		podNet.annotations = annotations
+
+		_, err := podNet.WhenCreated(ctx, &Properties{})
+		assert.True(t, errors.Is(err, ErrUnsupportedNicType), annotations)
+		assert.Equal(t, 0, fake.GetNetworkCallCount())
+	}
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.annotations = map[string]string{annotationNicType: "macvlan"}
+
+	_, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.True(t, errors.Is(err, ErrNicParent))
+	assert.Equal(t, 0, fake.GetNetworkCallCount())
+}
+
+func Test_lxdBridgePodNetwork_WhenCreated_MTU(t *testing.T) {
+	t.Parallel()
+
+	podNet, fake := testLXDBridgePodNetwork()
+	podNet.annotations = map[string]string{annotationMTU: "1450"}
+
+	fake.GetNetworkReturns(&lxdApi.Network{
+		Type: "bridge",
+		Name: testLXDBridge,
+		NetworkPut: lxdApi.NetworkPut{
+			Config: map[string]string{
+				"ipv4.address": "192.168.224.1/30",
+			},
+		},
+	}, "", nil)
+	fake.GetNetworkLeasesReturns([]lxdApi.NetworkLease{}, nil)
+
+	res, err := podNet.WhenCreated(ctx, &Properties{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1450", res.Nics[0].MTU)
+	_, m := res.Nics[0].ToMap()
+	assert.Equal(t, "1450", m["mtu"])
+
+	// the parent's mtu is kept by default
+	podNet.annotations = nil
+	res, err = podNet.WhenCreated(ctx, &Properties{})
+	assert.NoError(t, err)
+	assert.Empty(t, res.Nics[0].MTU)
+
+	for _, mtu := range []string{"575", "9217", "jumbo"} {
+		podNet.annotations = map[string]string{annotationMTU: mtu}
+		_, err = podNet.WhenCreated(ctx, &Properties{})
+		assert.True(t, errors.Is(err, ErrInvalidMTU), mtu)
+	}
+}
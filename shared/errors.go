@@ -2,6 +2,7 @@ package shared
 
 import (
 	"errors"
+	"strings"
 )
 
 // ExitCodeUnspecified is used for unspecified and unrecoverable errors
@@ -32,3 +33,38 @@ func IsErrNotFound(err error) bool {
 func NewErrNotFound() error {
 	return errLXDNotFound
 }
+
+// lxdClusterUnavailable are error strings LXD returns when the cluster database has no quorum or no leader
+var lxdClusterUnavailable = []string{
+	"no available dqlite leader server found",
+	"failed to begin transaction",
+	"cluster database is unavailable",
+}
+
+// lxdNotLeader is the error of a cluster member asked for the database while it isn't the leader. It's matched
+// exactly, as "not leader" also occurs in other errors.
+const lxdNotLeader = "503 not leader"
+
+// IsErrClusterUnavailable checks whether the LXD error indicates a degraded cluster, which can't process requests
+// until it is back in quorum. Only errors of a clustered LXD can mean that, as the strings also occur in errors of a
+// single LXD.
+func IsErrClusterUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	// the error may be wrapped, so it's the last part of the message
+	if msg == lxdNotLeader || strings.HasSuffix(msg, ": "+lxdNotLeader) {
+		return true
+	}
+
+	for _, s := range lxdClusterUnavailable {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}